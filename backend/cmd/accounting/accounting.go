@@ -0,0 +1,103 @@
+// Package accounting meters terminal commands and builds: how much CPU,
+// memory, wall time and output each run used, and who it belongs to.
+package accounting
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"muhammadyasir-dev/cmd/logging"
+	"muhammadyasir-dev/cmd/models"
+	"muhammadyasir-dev/cmd/ratelimit"
+	"muhammadyasir-dev/cmd/repository"
+)
+
+var accountingLog = logging.New("accounting")
+
+// Stats is what one run consumed. CPUMeasured is false when the CPU time
+// could not be read, in which case CPUSeconds is zero.
+type Stats struct {
+	CPUSeconds      float64
+	CPUMeasured     bool
+	MemoryPeakBytes int64
+	Wall            time.Duration
+	OutputBytes     int64
+}
+
+// Run identifies who a run belongs to and how it ended
+type Run struct {
+	UserID   *uint // nil for anonymous runs
+	Subject  string
+	Project  string
+	Language string
+	ExitCode int
+}
+
+// RunFor attributes a run to the client behind r, keyed as
+// ratelimit.ClientKey keys it so records line up with quota counters
+func RunFor(r *http.Request, secret []byte, project, language string, exitCode int) Run {
+	run := Run{
+		Subject:  ratelimit.ClientKey(secret)(r),
+		Project:  project,
+		Language: language,
+		ExitCode: exitCode,
+	}
+	if id, ok := ratelimit.UserID(r, secret); ok {
+		run.UserID = &id
+	}
+	return run
+}
+
+// Recorder stores usage records and charges them to the caller's quota
+type Recorder struct {
+	usage repository.UsageRepository
+}
+
+// NewRecorder returns a Recorder writing to usage, which may be nil to only charge quotas
+func NewRecorder(usage repository.UsageRepository) *Recorder {
+	return &Recorder{usage: usage}
+}
+
+// Record meters one finished run. Measured CPU time is charged to the quota
+// of the request in ctx; otherwise the quota falls back to wall time.
+// Failing to store the record is logged rather than returned: metering must
+// not fail the user's run.
+func (r *Recorder) Record(ctx context.Context, run Run, stats Stats) {
+	if stats.CPUMeasured {
+		ratelimit.Charge(ctx, stats.CPUSeconds)
+	}
+	if r == nil || r.usage == nil {
+		return
+	}
+
+	record := models.UsageRecord{
+		UserID:          run.UserID,
+		Subject:         run.Subject,
+		Project:         run.Project,
+		Language:        run.Language,
+		ExitCode:        run.ExitCode,
+		CPUSeconds:      stats.CPUSeconds,
+		MemoryPeakBytes: stats.MemoryPeakBytes,
+		WallMs:          stats.Wall.Milliseconds(),
+		OutputBytes:     stats.OutputBytes,
+		CreatedAt:       time.Now(),
+	}
+	if err := r.usage.Create(context.WithoutCancel(ctx), &record); err != nil {
+		accountingLog.ErrorContext(ctx, "failed to store usage record",
+			"subject", run.Subject, "project", run.Project, "error", err)
+	}
+}
+
+// Add sums two runs, such as a build and the run that follows it. The peak
+// memory is the larger of the two, and CPU counts as measured only when
+// both were.
+func (s Stats) Add(o Stats) Stats {
+	return Stats{
+		CPUSeconds:      s.CPUSeconds + o.CPUSeconds,
+		CPUMeasured:     s.CPUMeasured && o.CPUMeasured,
+		MemoryPeakBytes: max(s.MemoryPeakBytes, o.MemoryPeakBytes),
+		Wall:            s.Wall + o.Wall,
+		OutputBytes:     s.OutputBytes + o.OutputBytes,
+	}
+}
@@ -0,0 +1,63 @@
+package accounting
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"muhammadyasir-dev/cmd/repository"
+)
+
+func TestParseCgroup(t *testing.T) {
+	out := []byte("usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\nmemory_peak 73400320\n")
+	sample, err := ParseCgroup(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sample.CPUUsec != 2500000 || sample.MemoryPeakBytes != 73400320 {
+		t.Errorf("unexpected sample %+v", sample)
+	}
+
+	stats := Between(CgroupSample{CPUUsec: 1000000}, sample)
+	if stats.CPUSeconds != 1.5 || !stats.CPUMeasured {
+		t.Errorf("expected 1.5 measured CPU seconds, got %+v", stats)
+	}
+
+	// cgroup v1 hosts have no cpu.stat with usage_usec
+	if _, err := ParseCgroup([]byte("memory_peak 0\n")); err == nil {
+		t.Error("expected an error without usage_usec")
+	}
+}
+
+func TestFromProcess(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "i=0; while [ $i -lt 20000 ]; do i=$((i+1)); done")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("sh unavailable: %v", err)
+	}
+	stats := FromProcess(cmd.ProcessState)
+	if !stats.CPUMeasured || stats.CPUSeconds <= 0 || stats.MemoryPeakBytes <= 0 {
+		t.Errorf("expected CPU and memory to be measured, got %+v", stats)
+	}
+}
+
+func TestRecordStoresUsage(t *testing.T) {
+	store := repository.NewMemory()
+	recorder := NewRecorder(store.Usage)
+
+	userID := uint(3)
+	run := Run{UserID: &userID, Subject: "user:3", Project: "demo", Language: "rust", ExitCode: 1}
+	recorder.Record(context.Background(), run, Stats{CPUSeconds: 0.25, CPUMeasured: true, Wall: 1500 * time.Millisecond, OutputBytes: 42})
+
+	records, err := store.Usage.List(context.Background(), repository.UsageFilter{UserID: &userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	if rec.Project != "demo" || rec.ExitCode != 1 || rec.CPUSeconds != 0.25 || rec.WallMs != 1500 || rec.OutputBytes != 42 {
+		t.Errorf("record does not match the run: %+v", rec)
+	}
+}
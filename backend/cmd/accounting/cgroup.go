@@ -0,0 +1,75 @@
+package accounting
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CgroupScript prints the cgroup v2 counters of the cgroup it runs in.
+// Executed inside a container, which has its own cgroup namespace, it
+// reports the whole container. memory.peak needs Linux 5.19; older kernels
+// fall back to the current usage.
+const CgroupScript = `cat /sys/fs/cgroup/cpu.stat 2>/dev/null; ` +
+	`echo "memory_peak $(cat /sys/fs/cgroup/memory.peak 2>/dev/null || cat /sys/fs/cgroup/memory.current 2>/dev/null || echo 0)"`
+
+// CgroupSample is one reading of CgroupScript
+type CgroupSample struct {
+	CPUUsec         int64
+	MemoryPeakBytes int64
+}
+
+// ParseCgroup parses the output of CgroupScript
+func ParseCgroup(out []byte) (CgroupSample, error) {
+	var sample CgroupSample
+	var sawCPU bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "usage_usec":
+			sample.CPUUsec, sawCPU = n, true
+		case "memory_peak":
+			sample.MemoryPeakBytes = n
+		}
+	}
+	if !sawCPU {
+		return sample, fmt.Errorf("no cgroup v2 cpu.stat in output")
+	}
+	return sample, nil
+}
+
+// Between returns the CPU used from before to after and the peak memory at
+// after. Both are the cgroup's, not one process's: everything running in it
+// meanwhile is counted, and the peak is the highest since the cgroup began.
+func Between(before, after CgroupSample) Stats {
+	return Stats{
+		CPUSeconds:      float64(max(after.CPUUsec-before.CPUUsec, 0)) / 1e6,
+		CPUMeasured:     true,
+		MemoryPeakBytes: after.MemoryPeakBytes,
+	}
+}
+
+// FromProcess returns the CPU time and peak resident memory of a finished
+// process and every descendant it waited for
+func FromProcess(ps *os.ProcessState) Stats {
+	if ps == nil {
+		return Stats{}
+	}
+	return Stats{
+		CPUSeconds:      (ps.UserTime() + ps.SystemTime()).Seconds(),
+		CPUMeasured:     true,
+		MemoryPeakBytes: maxRSS(ps),
+	}
+}
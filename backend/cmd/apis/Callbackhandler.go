@@ -286,30 +286,22 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 package apis
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
-	"gorm.io/gorm"
 	"io/ioutil"
+	"muhammadyasir-dev/cmd/models"
+	"muhammadyasir-dev/cmd/repository"
 	"net/http"
 	"time"
 )
 
-// User represents the user model in the database
-type User struct {
-	ID       uint   `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name     string `gorm:"column:name" json:"name"`
-	Email    string `gorm:"column:email;uniqueIndex" json:"email"`
-	Password string `gorm:"column:password;default:''" json:"password,omitempty"`
-	Picture  string `gorm:"column:picture" json:"picture,omitempty"`
-	GoogleID string `gorm:"column:google_id" json:"google_id,omitempty"`
-	// You can add more fields as needed
-}
-
 // UserInfo represents the user information from Google
 type UserInfo struct {
 	ID            string `json:"id"`
@@ -326,16 +318,8 @@ var (
 	oauthConfig *oauth2.Config
 	store       *sessions.CookieStore
 	jwtSecret   []byte
-	db          *gorm.DB
 )
 
-// InitDB initializes the database connection
-func InitDB(database *gorm.DB) {
-	db = database
-	// Auto migrate the User model
-	db.AutoMigrate(&User{})
-}
-
 func GenerateStateToken() (string, error) {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
@@ -345,7 +329,7 @@ func GenerateStateToken() (string, error) {
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
@@ -366,15 +350,16 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log the exact URL we're redirecting to
+	// Log where we're redirecting to, but not the query: it carries the state token
 	url := oauthConfig.AuthCodeURL(state)
-	fmt.Println("Redirecting to OAuth URL:", url)
+	authLog.DebugContext(r.Context(), "redirecting to oauth provider",
+		"auth_url", oauthConfig.Endpoint.AuthURL, "redirect_url", oauthConfig.RedirectURL)
 
 	// Redirect to Google's OAuth page
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
-func CallbackHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
@@ -390,7 +375,8 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 	state := r.FormValue("state")
 	if state != expectedState {
-		http.Error(w, fmt.Sprintf("Invalid state parameter. Expected: %s, Got: %s", expectedState, state), http.StatusBadRequest)
+		authLog.WarnContext(r.Context(), "oauth state mismatch")
+		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 		return
 	}
 
@@ -398,11 +384,12 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
 	token, err := oauthConfig.Exchange(r.Context(), code)
 	if err != nil {
+		authLog.ErrorContext(r.Context(), "oauth code exchange failed", "error", err)
 		http.Error(w, "Failed to exchange token: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	fmt.Printf("Received cookies: %+v\n", r.Cookies())
+	authLog.DebugContext(r.Context(), "oauth callback", "cookies", r.Cookies())
 
 	// Get user info
 	client := oauthConfig.Client(r.Context(), token)
@@ -425,12 +412,10 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Print user info to console for debugging
-	fmt.Printf("User logged in: %+v\n", userInfo)
-
 	// Check if user exists in database and create if not
-	dbUser, err := findOrCreateUser(userInfo)
+	dbUser, err := s.findOrCreateUser(r.Context(), userInfo)
 	if err != nil {
+		authLog.ErrorContext(r.Context(), "failed to find or create user", "error", err)
 		http.Error(w, "Failed to process user: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -453,39 +438,37 @@ func CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteLaxMode,
 	}
 	http.SetCookie(w, cookie)
+	authLog.InfoContext(r.Context(), "user logged in", "user_id", dbUser.ID)
 
 	// Redirect to frontend
-	fmt.Printf("Received cookies: %+v\n", r.Cookies())
 	frontendURL := "http://localhost:5173"
 	http.Redirect(w, r, frontendURL, http.StatusTemporaryRedirect)
 }
 
 // findOrCreateUser checks if a user exists in the database and creates one if not
-func findOrCreateUser(userInfo UserInfo) (*User, error) {
-	var user User
-
+func (s *Server) findOrCreateUser(ctx context.Context, userInfo UserInfo) (*models.User, error) {
 	// Try to find user by email first
-	result := db.Where("email = ?", userInfo.Email).First(&user)
-	if result.Error == nil {
+	user, err := s.repos.Users.ByEmail(ctx, userInfo.Email)
+	if err == nil {
 		// User found, return
-		return &user, nil
-	} else if result.Error != gorm.ErrRecordNotFound {
+		return user, nil
+	} else if !errors.Is(err, repository.ErrNotFound) {
 		// Some other error occurred
-		return nil, result.Error
+		return nil, err
 	}
 
 	// Try to find user by name as fallback (if you need this)
-	result = db.Where("name = ?", userInfo.Name).First(&user)
-	if result.Error == nil {
+	user, err = s.repos.Users.ByName(ctx, userInfo.Name)
+	if err == nil {
 		// User found by name, return
-		return &user, nil
-	} else if result.Error != gorm.ErrRecordNotFound {
+		return user, nil
+	} else if !errors.Is(err, repository.ErrNotFound) {
 		// Some other error occurred
-		return nil, result.Error
+		return nil, err
 	}
 
 	// User not found, create new user
-	newUser := User{
+	newUser := models.User{
 		Name:     userInfo.Name,
 		Email:    userInfo.Email,
 		Picture:  userInfo.Picture,
@@ -494,14 +477,18 @@ func findOrCreateUser(userInfo UserInfo) (*User, error) {
 	}
 
 	// Save user to database
-	result = db.Create(&newUser)
-	if result.Error != nil {
-		return nil, result.Error
+	if err := s.repos.Users.Create(ctx, &newUser); err != nil {
+		return nil, err
 	}
 
 	return &newUser, nil
 }
 
+// JWTSecret returns the key auth_token cookies are signed with
+func JWTSecret() []byte {
+	return jwtSecret
+}
+
 func CreateJWT(userInfo UserInfo, userID uint) (string, error) {
 	// Create JWT claims
 	claims := jwt.MapClaims{
@@ -521,12 +508,12 @@ func CreateJWT(userInfo UserInfo, userID uint) (string, error) {
 	return token.SignedString(jwtSecret)
 }
 
-func GetUserHandler(w http.ResponseWriter, r *http.Request) {
+// parseAuthToken validates the auth_token cookie and returns its claims
+func parseAuthToken(r *http.Request) (jwt.MapClaims, error) {
 	// Get JWT from cookie
 	cookie, err := r.Cookie("auth_token")
 	if err != nil {
-		http.Error(w, "Authentication required", http.StatusUnauthorized)
-		return
+		return nil, err
 	}
 
 	// Parse and validate JWT
@@ -538,34 +525,54 @@ func GetUserHandler(w http.ResponseWriter, r *http.Request) {
 		return jwtSecret, nil
 	})
 	if err != nil || !token.Valid {
-		http.Error(w, "Invalid authentication token", http.StatusUnauthorized)
-		return
+		return nil, fmt.Errorf("invalid authentication token")
 	}
 
 	// Extract user data from claims
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		http.Error(w, "Failed to parse token claims", http.StatusInternalServerError)
+		return nil, fmt.Errorf("failed to parse token claims")
+	}
+	return claims, nil
+}
+
+// currentUser returns the user behind the request's auth_token cookie
+func (s *Server) currentUser(r *http.Request) (*models.User, error) {
+	claims, err := parseAuthToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := claims["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("token has no user id")
+	}
+	return s.repos.Users.ByID(r.Context(), uint(id))
+}
+
+func (s *Server) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie("auth_token"); err != nil {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
 		return
 	}
 
 	// Get user from database
-	var user User
-	result := db.First(&user, claims["id"])
-	if result.Error != nil {
+	user, err := s.currentUser(r)
+	if errors.Is(err, repository.ErrNotFound) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Invalid authentication token", http.StatusUnauthorized)
+		return
+	}
 
 	// Send user data as JSON response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
-
-	// Also log user data to console
-	fmt.Printf("User data from database: %+v\n", user)
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	// Clear auth cookie
 	cookie := &http.Cookie{
 		Name:     "auth_token",
@@ -0,0 +1,23 @@
+package apis
+
+import (
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/logging"
+	"muhammadyasir-dev/cmd/repository"
+)
+
+var (
+	authLog = logging.New("auth")
+	execLog = logging.New("exec")
+)
+
+// Server carries the dependencies shared by the API handlers
+type Server struct {
+	repos *repository.Store
+	usage *accounting.Recorder
+}
+
+// NewServer returns a Server that reads and writes through repos
+func NewServer(repos *repository.Store) *Server {
+	return &Server{repos: repos, usage: accounting.NewRecorder(repos.Usage)}
+}
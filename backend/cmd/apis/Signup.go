@@ -2,13 +2,14 @@ package apis
 
 import (
 	"encoding/json"
+	"errors"
 	"golang.org/x/crypto/bcrypt"
-	"muhammadyasir-dev/cmd/dbs"
 	"muhammadyasir-dev/cmd/models"
+	"muhammadyasir-dev/cmd/repository"
 	"net/http"
 )
 
-func Signup(w http.ResponseWriter, r *http.Request) {
+func (s *Server) Signup(w http.ResponseWriter, r *http.Request) {
 	var signupuser models.User
 
 	// Decode the JSON request body
@@ -27,7 +28,11 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 
 	// Log the database operations
 	// Create the user in the database
-	if err := dbs.Db.Create(&signupuser).Error; err != nil {
+	if err := s.repos.Users.Create(r.Context(), &signupuser); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			http.Error(w, "Email is already registered", http.StatusConflict)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
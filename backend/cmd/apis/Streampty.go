@@ -2,31 +2,51 @@ package apis
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-
 	"io"
-	//"muhammadyasir-dev/cmd/utils"
-
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/logging"
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/ratelimit"
+	"muhammadyasir-dev/cmd/server"
+	"muhammadyasir-dev/cmd/tracing"
 	"net/http"
 	"os/exec"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func isContainerRunning(containerName string) bool {
-	cmd := exec.Command("docker", "ps", "--filter", "name="+containerName, "--filter", "status=running", "--format", "{{.Names}}")
+// terminalLanguage labels commands typed into the terminal, which are plain shell
+const terminalLanguage = "shell"
+
+// cacheVolumePrefix names the docker volumes holding each user's toolchain caches
+const cacheVolumePrefix = "wasmide-cache-"
+
+func isContainerRunning(ctx context.Context, containerName string) bool {
+	cmd := exec.CommandContext(ctx, "docker", "ps", "--filter", "name="+containerName, "--filter", "status=running", "--format", "{{.Names}}")
 	output, err := cmd.Output()
 	if err != nil {
 		return false
 	}
 	return len(output) > 0
 }
-func startContainer(containerName string) error {
-	cmd := exec.Command("docker", "start", containerName)
-	return cmd.Run()
+func startContainer(ctx context.Context, containerName string) error {
+	ctx, span := tracing.Start(ctx, "container.start", attribute.String("container.name", containerName))
+	cmd := exec.CommandContext(ctx, "docker", "start", containerName)
+	err := cmd.Run()
+	tracing.End(span, err)
+	metrics.ObserveContainer("start", err)
+	if err != nil {
+		execLog.ErrorContext(ctx, "failed to start container", "container", containerName, "error", err)
+	}
+	return err
 }
 
-func containerExists(containerName string) bool {
-	cmd := exec.Command("docker", "ps", "-a", "--filter", "name="+containerName, "--format", "{{.Names}}")
+func containerExists(ctx context.Context, containerName string) bool {
+	cmd := exec.CommandContext(ctx, "docker", "ps", "-a", "--filter", "name="+containerName, "--format", "{{.Names}}")
 	output, err := cmd.Output()
 	if err != nil {
 		return false
@@ -34,48 +54,116 @@ func containerExists(containerName string) bool {
 	return len(output) > 0
 }
 
-func executeCommand(projectName, command string) (string, error) {
+// sampleContainer reads the container's cgroup counters from inside it
+func sampleContainer(ctx context.Context, containerName string) (accounting.CgroupSample, error) {
+	out, err := exec.CommandContext(ctx, "docker", "exec", containerName, "sh", "-c", accounting.CgroupScript).Output()
+	if err != nil {
+		return accounting.CgroupSample{}, err
+	}
+	return accounting.ParseCgroup(out)
+}
+
+// executeCommand runs command inside the project's container and reports
+// what it consumed. CPU time is the container cgroup's usage across the
+// exec, so it is only measured on cgroup v2 hosts, and it covers the whole
+// container: commands running at once in the same project are charged for
+// each other. Likewise the memory peak is the container's. The exec is tracked so a
+// graceful shutdown waits for it, and it is killed when ctx ends.
+//
+// A new container mounts the Cargo and Go module caches of cacheOwner, a
+// docker volume that outlives the container, so dependencies downloaded
+// once survive restarts.
+func executeCommand(ctx context.Context, projectName, cacheOwner, command string) (string, accounting.Stats, error) {
+	var stats accounting.Stats
 	if projectName == "" {
-		return "", fmt.Errorf("project name cannot be empty")
+		return "", stats, fmt.Errorf("project name cannot be empty")
 	}
+	defer server.Track(ctx)()
 
 	containerName := fmt.Sprintf("container-%s", projectName)
 	var out bytes.Buffer
 
 	// Check container state and manage lifecycle
-	if containerExists(containerName) {
-		if !isContainerRunning(containerName) {
-			fmt.Printf("Starting existing container: %s\n", containerName)
-			if err := startContainer(containerName); err != nil {
-				return "", fmt.Errorf("failed to start existing container: %v", err)
+	if containerExists(ctx, containerName) {
+		if !isContainerRunning(ctx, containerName) {
+			execLog.InfoContext(ctx, "starting existing container", "container", containerName)
+			if err := startContainer(ctx, containerName); err != nil {
+				return "", stats, fmt.Errorf("failed to start existing container: %v", err)
 			}
 		} else {
-			fmt.Printf("Using running container: %s\n", containerName)
+			execLog.DebugContext(ctx, "using running container", "container", containerName)
 		}
 	} else {
 		// Create and start new container
-		createCmd := exec.Command("docker", "run", "--name", containerName, "-d", "debian:buster-slim", "sleep", "infinity")
-		if err := createCmd.Run(); err != nil {
-			return "", fmt.Errorf("failed to create container: %v", err)
+		createCtx, span := tracing.Start(ctx, "container.create", attribute.String("container.name", containerName))
+		createCmd := exec.CommandContext(createCtx, "docker", "run", "--name", containerName,
+			"--label", "wasmide.request_id="+logging.RequestID(ctx),
+			"-v", cacheVolumePrefix+cacheOwner+":/cache",
+			"-e", "CARGO_HOME=/cache/cargo",
+			"-e", "GOMODCACHE=/cache/go/mod",
+			"-e", "GOCACHE=/cache/go/build",
+			"-d", "debian:buster-slim", "sleep", "infinity")
+		err := createCmd.Run()
+		tracing.End(span, err)
+		metrics.ObserveContainer("create", err)
+		if err != nil {
+			execLog.ErrorContext(ctx, "failed to create container", "container", containerName, "error", err)
+			return "", stats, fmt.Errorf("failed to create container: %v", err)
 		}
-		fmt.Printf("Created new container: %s\n", containerName)
+		execLog.InfoContext(ctx, "created container", "container", containerName)
 	}
 
-	// Execute the command in the container
-	cmd := exec.Command("docker", "exec", containerName, "sh", "-c", command)
+	before, sampleErr := sampleContainer(ctx, containerName)
+
+	// Execute the command in the container, tagged with the request ID so
+	// anything it logs can be traced back to the HTTP request
+	execCtx, span := tracing.Start(ctx, "container.exec",
+		attribute.String("container.name", containerName),
+		attribute.String("exec.language", terminalLanguage),
+	)
+	cmd := exec.CommandContext(execCtx, "docker", "exec",
+		"-e", logging.RequestIDEnv+"="+logging.RequestID(ctx),
+		containerName, "sh", "-c", command)
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 
 	// Run the command
+	start := time.Now()
 	err := cmd.Run()
+	wall := time.Since(start)
+	span.SetAttributes(attribute.Int("exec.exit_code", metrics.ExitCode(err)))
+	tracing.End(span, err)
+
+	if sampleErr == nil {
+		var after accounting.CgroupSample
+		if after, sampleErr = sampleContainer(context.WithoutCancel(ctx), containerName); sampleErr == nil {
+			stats = accounting.Between(before, after)
+		}
+	}
+	if sampleErr != nil {
+		execLog.DebugContext(ctx, "container cgroup stats unavailable", "container", containerName, "error", sampleErr)
+	}
+	stats.Wall = wall
+	stats.OutputBytes = int64(out.Len())
+
+	metrics.ObserveExec(terminalLanguage, start, err)
+	execLog.InfoContext(ctx, "command finished",
+		"container", containerName,
+		"exit_code", metrics.ExitCode(err),
+		"duration_ms", wall.Milliseconds(),
+		"output_bytes", out.Len(),
+		"cpu_seconds", stats.CPUSeconds,
+	)
 	if err != nil {
-		return out.String(), fmt.Errorf("command execution failed: %v\nOutput: %s", err, out.String())
+		return out.String(), stats, fmt.Errorf("command execution failed: %w\nOutput: %s", err, out.String())
 	}
 
-	return out.String(), nil
+	return out.String(), stats, nil
 }
 
-func Streampty(w http.ResponseWriter, r *http.Request) {
+func (s *Server) Streampty(w http.ResponseWriter, r *http.Request) {
+	metrics.TerminalSessions.Inc()
+	defer metrics.TerminalSessions.Dec()
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")                                // Allow all origins
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS") // Allowed methods
@@ -108,7 +196,9 @@ func Streampty(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	output, err := executeCommand(projectName, commandStr)
+	cacheOwner := ratelimit.Slug(ratelimit.ClientKey(jwtSecret)(r))
+	output, stats, err := executeCommand(r.Context(), projectName, cacheOwner, commandStr)
+	s.usage.Record(r.Context(), accounting.RunFor(r, jwtSecret, projectName, terminalLanguage, metrics.ExitCode(err)), stats)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error executing command: %s", err.Error()), http.StatusInternalServerError)
 		return
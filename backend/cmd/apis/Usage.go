@@ -0,0 +1,158 @@
+package apis
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"muhammadyasir-dev/cmd/models"
+	"muhammadyasir-dev/cmd/repository"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UsageReport is the response of the usage aggregation endpoint
+type UsageReport struct {
+	GroupBy []string            `json:"group_by"`
+	From    string              `json:"from,omitempty"`
+	To      string              `json:"to,omitempty"`
+	Totals  []models.UsageTotal `json:"totals"`
+}
+
+// isAdmin reports whether user's email is listed in ADMIN_EMAILS (comma separated)
+func isAdmin(user *models.User) bool {
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" && strings.EqualFold(email, user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// usageFilter scopes a usage query to the caller. Users only see their own
+// runs; admins may pick a user with user_id or leave it out to see everyone.
+// from and to are UTC dates (YYYY-MM-DD), both inclusive.
+func (s *Server) usageFilter(r *http.Request) (repository.UsageFilter, int, error) {
+	var filter repository.UsageFilter
+
+	user, err := s.currentUser(r)
+	if err != nil {
+		return filter, http.StatusUnauthorized, fmt.Errorf("Authentication required")
+	}
+
+	q := r.URL.Query()
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.DateOnly, v); err != nil {
+			return filter, http.StatusBadRequest, fmt.Errorf("from must be a date like 2006-01-02")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.DateOnly, v); err != nil {
+			return filter, http.StatusBadRequest, fmt.Errorf("to must be a date like 2006-01-02")
+		}
+		filter.To = filter.To.AddDate(0, 0, 1)
+	}
+
+	switch v := q.Get("user_id"); {
+	case v != "":
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return filter, http.StatusBadRequest, fmt.Errorf("user_id must be a number")
+		}
+		if uint(id) != user.ID && !isAdmin(user) {
+			return filter, http.StatusForbidden, fmt.Errorf("Only admins can see other users' usage")
+		}
+		userID := uint(id)
+		filter.UserID = &userID
+	case !isAdmin(user):
+		filter.UserID = &user.ID
+	}
+	return filter, 0, nil
+}
+
+// UsageAggregate sums metered runs by any of user, project and day
+// (group_by, comma separated, default day)
+func (s *Server) UsageAggregate(w http.ResponseWriter, r *http.Request) {
+	filter, status, err := s.usageFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	groupBy := []string{repository.GroupByDay}
+	if v := r.URL.Query().Get("group_by"); v != "" {
+		groupBy = strings.Split(v, ",")
+	}
+	for _, g := range groupBy {
+		if g != repository.GroupByUser && g != repository.GroupByProject && g != repository.GroupByDay {
+			http.Error(w, fmt.Sprintf("Cannot group by %q, use user, project or day", g), http.StatusBadRequest)
+			return
+		}
+	}
+
+	totals, err := s.repos.Usage.Aggregate(r.Context(), filter, groupBy)
+	if err != nil {
+		http.Error(w, "Failed to aggregate usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageReport{
+		GroupBy: groupBy,
+		From:    r.URL.Query().Get("from"),
+		To:      r.URL.Query().Get("to"),
+		Totals:  totals,
+	})
+}
+
+// usageCSVHeader lists the columns of the usage export
+var usageCSVHeader = []string{
+	"id", "user_id", "subject", "project", "language", "exit_code",
+	"cpu_seconds", "memory_peak_bytes", "wall_ms", "output_bytes", "created_at",
+}
+
+// UsageExport streams the raw usage records as CSV for billing
+func (s *Server) UsageExport(w http.ResponseWriter, r *http.Request) {
+	filter, status, err := s.usageFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	records, err := s.repos.Usage.List(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to load usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write(usageCSVHeader)
+	for _, rec := range records {
+		userID := ""
+		if rec.UserID != nil {
+			userID = strconv.FormatUint(uint64(*rec.UserID), 10)
+		}
+		out.Write([]string{
+			strconv.FormatUint(uint64(rec.ID), 10),
+			userID,
+			rec.Subject,
+			rec.Project,
+			rec.Language,
+			strconv.Itoa(rec.ExitCode),
+			strconv.FormatFloat(rec.CPUSeconds, 'f', 6, 64),
+			strconv.FormatInt(rec.MemoryPeakBytes, 10),
+			strconv.FormatInt(rec.WallMs, 10),
+			strconv.FormatInt(rec.OutputBytes, 10),
+			rec.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		execLog.ErrorContext(r.Context(), "failed to write usage export", "error", err)
+	}
+}
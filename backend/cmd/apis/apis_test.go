@@ -0,0 +1,176 @@
+package apis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	"muhammadyasir-dev/cmd/models"
+	"muhammadyasir-dev/cmd/repository"
+)
+
+func newTestServer() *Server {
+	return NewServer(repository.NewMemory())
+}
+
+func TestSignupStoresHashedPassword(t *testing.T) {
+	s := newTestServer()
+
+	body, _ := json.Marshal(models.User{Name: "ada", Email: "ada@example.com", Password: "password123"})
+	w := httptest.NewRecorder()
+	s.Signup(w, httptest.NewRequest("POST", "/signup", bytes.NewReader(body)))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	stored, err := s.repos.Users.ByEmail(context.Background(), "ada@example.com")
+	if err != nil {
+		t.Fatalf("user was not stored: %v", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("password123")); err != nil {
+		t.Errorf("password was not hashed correctly")
+	}
+}
+
+func TestSignupRejectsDuplicateEmail(t *testing.T) {
+	s := newTestServer()
+
+	body, _ := json.Marshal(models.User{Name: "ada", Email: "ada@example.com", Password: "password123"})
+	for i, want := range []int{http.StatusCreated, http.StatusConflict} {
+		w := httptest.NewRecorder()
+		s.Signup(w, httptest.NewRequest("POST", "/signup", bytes.NewReader(body)))
+		if w.Code != want {
+			t.Errorf("signup %d: expected status %d, got %d", i+1, want, w.Code)
+		}
+	}
+}
+
+func TestSignupInvalidJSON(t *testing.T) {
+	s := newTestServer()
+
+	w := httptest.NewRecorder()
+	s.Signup(w, httptest.NewRequest("POST", "/signup", bytes.NewBufferString("invalid json")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestFindOrCreateUserReusesExistingUser(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	info := UserInfo{ID: "1234567890", Email: "grace@example.com", Name: "Grace", Picture: "https://example.com/g.png"}
+
+	first, err := s.findOrCreateUser(ctx, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.GoogleID != info.ID || first.Picture != info.Picture {
+		t.Errorf("google id and picture were not stored separately: %+v", first)
+	}
+
+	second, err := s.findOrCreateUser(ctx, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ID != first.ID {
+		t.Errorf("expected existing user %d, got %d", first.ID, second.ID)
+	}
+}
+
+func TestGetUserNeedsValidToken(t *testing.T) {
+	s := newTestServer()
+	user, cookie := login(t, s, UserInfo{ID: "42", Email: "lin@example.com", Name: "Lin"})
+
+	getUser := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/user", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.GetUserHandler(w, req)
+		return w
+	}
+
+	w := getUser(cookie)
+	var got models.User
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil || got.ID != user.ID {
+		t.Fatalf("expected user %d, got status %d: %+v", user.ID, w.Code, got)
+	}
+	if code := getUser(nil).Code; code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without a token, got %d", http.StatusUnauthorized, code)
+	}
+	forged := &http.Cookie{Name: "auth_token", Value: cookie.Value + "x"}
+	if code := getUser(forged).Code; code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a bad signature, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+// login creates a user and returns the matching auth cookie
+func login(t *testing.T, s *Server, info UserInfo) (*models.User, *http.Cookie) {
+	t.Helper()
+	user, err := s.findOrCreateUser(context.Background(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := CreateJWT(info, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, &http.Cookie{Name: "auth_token", Value: token}
+}
+
+func TestUsageIsScopedToCaller(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+
+	ada, adaCookie := login(t, s, UserInfo{ID: "1", Email: "ada@example.com", Name: "Ada"})
+	bob, _ := login(t, s, UserInfo{ID: "2", Email: "bob@example.com", Name: "Bob"})
+	for _, id := range []uint{ada.ID, ada.ID, bob.ID} {
+		id := id
+		s.repos.Usage.Create(ctx, &models.UsageRecord{UserID: &id, Project: "p", CPUSeconds: 1, WallMs: 2000})
+	}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.AddCookie(adaCookie)
+		w := httptest.NewRecorder()
+		if strings.HasPrefix(url, "/usage/export") {
+			s.UsageExport(w, req)
+		} else {
+			s.UsageAggregate(w, req)
+		}
+		return w
+	}
+
+	var report UsageReport
+	w := get("/usage/aggregate?group_by=user")
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	if len(report.Totals) != 1 || report.Totals[0].Runs != 2 || report.Totals[0].CPUSeconds != 2 {
+		t.Errorf("expected only Ada's two runs, got %+v", report.Totals)
+	}
+
+	if w := get("/usage/aggregate?user_id=" + fmt.Sprint(bob.ID)); w.Code != http.StatusForbidden {
+		t.Errorf("expected %d for another user's usage, got %d", http.StatusForbidden, w.Code)
+	}
+
+	t.Setenv("ADMIN_EMAILS", "ada@example.com")
+	report = UsageReport{}
+	json.NewDecoder(get("/usage/aggregate?group_by=user").Body).Decode(&report)
+	if len(report.Totals) != 2 {
+		t.Errorf("admins should see every user, got %+v", report.Totals)
+	}
+
+	lines := strings.Split(strings.TrimSpace(get("/usage/export").Body.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "id,user_id,") {
+		t.Errorf("unexpected CSV export:\n%s", strings.Join(lines, "\n"))
+	}
+}
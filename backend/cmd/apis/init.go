@@ -5,7 +5,7 @@ import (
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"log"
+	"muhammadyasir-dev/cmd/ratelimit"
 	"net/http"
 	"os"
 )
@@ -14,7 +14,7 @@ func init() {
 	// Load environment variables from .env file
 	err := godotenv.Load()
 	if err != nil {
-		log.Println("Warning: Error loading .env file:", err)
+		authLog.Warn("could not load .env file", "error", err)
 	}
 
 	// Initialize session store with a secure key
@@ -28,8 +28,8 @@ func init() {
 		SameSite: http.SameSiteLaxMode,
 	}
 
-	// JWT Secret
-	jwtSecret = []byte(getEnvWithDefault("JWT_SECRET", "super-secret-jwt-key"))
+	// JWT Secret; main refuses to start without it
+	jwtSecret, _ = ratelimit.Secret()
 
 	// OAuth Configuration - Using the EXACT same callback URL registered with Google
 	redirectURL := getEnvWithDefault("REDIRECT_URL", "http://localhost:8080/auth/callback")
@@ -43,7 +43,7 @@ func init() {
 
 	// Validate essential configuration
 	if oauthConfig.ClientID == "" || oauthConfig.ClientSecret == "" {
-		log.Println("Warning: CLIENT_ID or CLIENT_SECRET not set. OAuth will not work correctly.")
+		authLog.Warn("CLIENT_ID or CLIENT_SECRET not set, OAuth will not work correctly")
 	}
 }
 
@@ -2,7 +2,8 @@ package dbs
 
 import (
 	"log"
-	"muhammadyasir-dev/cmd/models"
+	"muhammadyasir-dev/cmd/migrations"
+	"muhammadyasir-dev/cmd/tracing"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -10,18 +11,28 @@ import (
 
 var Db *gorm.DB
 
-func Initdb() {
-	var err error // Change 'error' to 'err'
+// Connect opens the database connection without touching the schema
+func Connect() error {
+	var err error
 	ConnectionString := "host=localhost user=postgres password=postgres dbname=wasmide port=5432 sslmode=disable"
-	Db, err = gorm.Open(postgres.Open(ConnectionString), &gorm.Config{}) // Use '=' instead of ':='
-
+	Db, err = gorm.Open(postgres.Open(ConnectionString), &gorm.Config{TranslateError: true})
 	if err != nil {
+		return err
+	}
+	return Db.Use(tracing.GormPlugin{})
+}
+
+func Initdb() {
+	if err := Connect(); err != nil {
 		log.Fatalf("db connection refused: %v", err) // Log the actual error
 	}
 
-	//migrating datatbse models
-	err = Db.AutoMigrate(&models.User{})
+	//bring the schema up to date
+	migrator, err := migrations.New(Db)
 	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(); err != nil {
 		log.Fatalf("failed to migrate database: %v", err)
 	}
 }
@@ -5,26 +5,43 @@ import (
 	"net/http"
 )
 
-func PsuedoTerminal(w http.ResponseWriter, r *http.Request) {
-	apis.Streampty(w, r)
+// Handler exposes the API server's endpoints to the router
+type Handler struct {
+	api *apis.Server
 }
-func Signup(w http.ResponseWriter, r *http.Request) {
-	apis.Signup(w, r)
 
+func New(api *apis.Server) *Handler {
+	return &Handler{api: api}
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	apis.LoginHandler(w, r)
+func (h *Handler) PsuedoTerminal(w http.ResponseWriter, r *http.Request) {
+	h.api.Streampty(w, r)
 }
+func (h *Handler) Signup(w http.ResponseWriter, r *http.Request) {
+	h.api.Signup(w, r)
 
-func CallbackHandler(w http.ResponseWriter, r *http.Request) {
-	apis.CallbackHandler(w, r)
 }
 
-func GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	apis.GetUserHandler(w, r)
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	h.api.LoginHandler(w, r)
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	apis.LogoutHandler(w, r)
+func (h *Handler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	h.api.CallbackHandler(w, r)
+}
+
+func (h *Handler) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	h.api.GetUserHandler(w, r)
+}
+
+func (h *Handler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	h.api.LogoutHandler(w, r)
+}
+
+func (h *Handler) UsageAggregate(w http.ResponseWriter, r *http.Request) {
+	h.api.UsageAggregate(w, r)
+}
+
+func (h *Handler) UsageExport(w http.ResponseWriter, r *http.Request) {
+	h.api.UsageExport(w, r)
 }
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// SQL checks that the database answers a ping
+func SQL(db *sql.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// AMQP checks that the broker at url accepts a connection
+func AMQP(url string) CheckFunc {
+	return func(ctx context.Context) error {
+		conn, err := amqp.DialConfig(url, amqp.Config{
+			Dial: func(network, addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Docker checks that the docker daemon responds
+func Docker() CheckFunc {
+	return func(ctx context.Context) error {
+		out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Version}}").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+}
+
+// Writable checks that files can be created in dir
+func Writable(dir string) CheckFunc {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		if _, err := f.WriteString("ok"); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// Binaries checks that every named executable is on PATH
+func Binaries(names ...string) CheckFunc {
+	return func(ctx context.Context) error {
+		var missing []string
+		for _, name := range names {
+			if _, err := exec.LookPath(name); err != nil {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("not found on PATH: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
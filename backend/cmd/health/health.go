@@ -0,0 +1,179 @@
+// Package health serves the /healthz liveness and /readyz readiness probes.
+// Readiness runs every registered dependency check concurrently, each under
+// its own timeout, and reports the outcome of each one as JSON.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CheckFunc reports whether a dependency is usable
+type CheckFunc func(ctx context.Context) error
+
+// Check is a named dependency check
+type Check struct {
+	Name    string
+	Timeout time.Duration
+	// Optional checks are reported but do not make the service unready
+	Optional bool
+	Func     CheckFunc
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Optional   bool   `json:"optional,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Report is the body returned by /readyz
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailing  = "failing"
+)
+
+// DefaultTimeout applies to checks registered without a timeout
+const DefaultTimeout = 2 * time.Second
+
+// Checker runs the readiness checks for one service
+type Checker struct {
+	mu     sync.RWMutex
+	checks []Check
+}
+
+func New() *Checker {
+	return &Checker{}
+}
+
+// Add registers a check that must pass for the service to be ready
+func (c *Checker) Add(name string, timeout time.Duration, fn CheckFunc) {
+	c.register(Check{Name: name, Timeout: timeout, Func: fn})
+}
+
+// AddOptional registers a check whose failure only degrades readiness
+func (c *Checker) AddOptional(name string, timeout time.Duration, fn CheckFunc) {
+	c.register(Check{Name: name, Timeout: timeout, Optional: true, Func: fn})
+}
+
+func (c *Checker) register(check Check) {
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultTimeout
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check)
+}
+
+// Run executes every check concurrently and aggregates the results
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]Check(nil), c.checks...)
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	for i, check := range checks {
+		result := results[i]
+		report.Checks[check.Name] = result
+		if result.Status == StatusOK {
+			continue
+		}
+		if check.Optional {
+			if report.Status == StatusOK {
+				report.Status = StatusDegraded
+			}
+		} else {
+			report.Status = StatusFailing
+		}
+	}
+	return report
+}
+
+// panicError turns a panicking check into an ordinary failure
+type panicError struct {
+	value interface{}
+}
+
+func (p panicError) Error() string {
+	return fmt.Sprintf("check panicked: %v", p.value)
+}
+
+func runCheck(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				errCh <- panicError{p}
+			}
+		}()
+		errCh <- check.Func(ctx)
+	}()
+
+	// A check that ignores its context still cannot hold the probe past its timeout
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Status:     StatusOK,
+		Optional:   check.Optional,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// ServeHTTP answers /readyz: 200 when every required check passes, 503 otherwise
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status == StatusFailing {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// Healthz answers /healthz: the process is up and serving requests
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
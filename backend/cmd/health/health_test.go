@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func readyz(t *testing.T, c *Checker) (int, Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, report
+}
+
+func ok(ctx context.Context) error { return nil }
+
+func TestReadyzAllPassing(t *testing.T) {
+	c := New()
+	c.Add("postgres", time.Second, ok)
+	c.AddOptional("broker", time.Second, ok)
+
+	code, report := readyz(t, c)
+	if code != http.StatusOK || report.Status != StatusOK {
+		t.Errorf("got %d %q, want 200 %q", code, report.Status, StatusOK)
+	}
+	if len(report.Checks) != 2 {
+		t.Errorf("expected 2 checks in report, got %d", len(report.Checks))
+	}
+}
+
+func TestReadyzOptionalFailureDegrades(t *testing.T) {
+	c := New()
+	c.Add("postgres", time.Second, ok)
+	c.AddOptional("broker", time.Second, func(ctx context.Context) error { return errors.New("connection refused") })
+
+	code, report := readyz(t, c)
+	if code != http.StatusOK || report.Status != StatusDegraded {
+		t.Errorf("got %d %q, want 200 %q", code, report.Status, StatusDegraded)
+	}
+	if report.Checks["broker"].Error != "connection refused" {
+		t.Errorf("broker error not reported: %+v", report.Checks["broker"])
+	}
+}
+
+func TestReadyzRequiredFailureAndTimeout(t *testing.T) {
+	c := New()
+	c.Add("docker", 20*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second) // ignores ctx on purpose
+		return nil
+	})
+
+	start := time.Now()
+	code, report := readyz(t, c)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("probe waited %s for a check with a 20ms timeout", elapsed)
+	}
+	if code != http.StatusServiceUnavailable || report.Status != StatusFailing {
+		t.Errorf("got %d %q, want 503 %q", code, report.Status, StatusFailing)
+	}
+	if report.Checks["docker"].Status != StatusFailing {
+		t.Errorf("docker check should fail on timeout: %+v", report.Checks["docker"])
+	}
+}
@@ -0,0 +1,130 @@
+// Package logging builds the JSON slog loggers used across services.
+//
+// Every logger belongs to a component ("api", "auth", "exec", ...). Its level
+// comes from LOG_LEVEL_<COMPONENT>, falling back to LOG_LEVEL and then info.
+// Records logged with a context carry that request's ID and trace ID, and attributes whose
+// keys look like credentials are redacted before they are written.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	mu     sync.Mutex
+	levels           = make(map[string]*slog.LevelVar)
+	output io.Writer = os.Stdout
+)
+
+// New returns the logger for component. Loggers for the same component
+// share a level, so Configure affects all of them.
+func New(component string) *slog.Logger {
+	h := slog.NewJSONHandler(output, &slog.HandlerOptions{
+		Level:       levelFor(component),
+		ReplaceAttr: redact,
+	})
+	return slog.New(contextHandler{h}).With("component", component)
+}
+
+// Configure re-reads the level of every component from the environment.
+// Call it once the environment is final, e.g. after loading .env.
+func Configure() {
+	mu.Lock()
+	defer mu.Unlock()
+	for component, level := range levels {
+		level.Set(envLevel(component))
+	}
+}
+
+func levelFor(component string) *slog.LevelVar {
+	mu.Lock()
+	defer mu.Unlock()
+
+	level, ok := levels[component]
+	if !ok {
+		level = new(slog.LevelVar)
+		level.Set(envLevel(component))
+		levels[component] = level
+	}
+	return level
+}
+
+func envLevel(component string) slog.Level {
+	value := os.Getenv("LOG_LEVEL_" + strings.ToUpper(component))
+	if value == "" {
+		value = os.Getenv("LOG_LEVEL")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// contextHandler adds the request ID and trace ID stored in the record's context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// sensitiveKeys are matched as substrings of lower-cased attribute keys
+var sensitiveKeys = []string{
+	"password", "secret", "token", "cookie", "authorization",
+	"session", "jwt", "api_key", "apikey", "credential",
+}
+
+// sensitiveExactKeys are OAuth parameters that are only sensitive by exact name
+var sensitiveExactKeys = map[string]bool{"code": true, "state": true}
+
+const redacted = "[REDACTED]"
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if sensitiveExactKeys[key] {
+		return slog.String(a.Key, redacted)
+	}
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	// Cookies are reduced to their names wherever they appear
+	switch v := a.Value.Any().(type) {
+	case *http.Cookie:
+		return slog.String(a.Key, v.Name+"="+redacted)
+	case []*http.Cookie:
+		names := make([]string, len(v))
+		for i, c := range v {
+			names[i] = c.Name + "=" + redacted
+		}
+		return slog.Any(a.Key, names)
+	}
+	return a
+}
@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func capture(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := output
+	output = &buf
+	t.Cleanup(func() { output = prev })
+	return &buf
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("log line is not JSON: %v: %s", err, buf.String())
+	}
+	return record
+}
+
+func TestRedactsSecretsAndCookies(t *testing.T) {
+	buf := capture(t)
+
+	New("test").Info("login",
+		"password", "hunter2",
+		"auth_token", "abc",
+		"state", "xyz",
+		"cookies", []*http.Cookie{{Name: "auth_token", Value: "abc"}},
+		"user_id", 7,
+	)
+
+	record := decode(t, buf)
+	for _, key := range []string{"password", "auth_token", "state"} {
+		if record[key] != redacted {
+			t.Errorf("%s was not redacted: %v", key, record[key])
+		}
+	}
+	if bytes.Contains(buf.Bytes(), []byte("abc")) {
+		t.Errorf("cookie value leaked: %s", buf.String())
+	}
+	if record["user_id"] != float64(7) {
+		t.Errorf("user_id should be kept, got %v", record["user_id"])
+	}
+}
+
+func TestComponentLevelOverridesGlobal(t *testing.T) {
+	buf := capture(t)
+	t.Setenv("LOG_LEVEL", "error")
+	t.Setenv("LOG_LEVEL_CHATTY", "debug")
+
+	quiet, chatty := New("quiet"), New("chatty")
+	Configure()
+
+	quiet.Info("hidden")
+	if buf.Len() != 0 {
+		t.Fatalf("info should be filtered at error level: %s", buf.String())
+	}
+	chatty.Debug("shown")
+	if decode(t, buf)["component"] != "chatty" {
+		t.Errorf("expected a chatty debug record, got %s", buf.String())
+	}
+}
+
+func TestMiddlewareAssignsAndPropagatesRequestID(t *testing.T) {
+	buf := capture(t)
+	logger := New("http")
+
+	var seen string
+	handler := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "upstream-id-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if seen != "upstream-id-1" || w.Header().Get(RequestIDHeader) != "upstream-id-1" {
+		t.Errorf("incoming request ID not reused: ctx=%q header=%q", seen, w.Header().Get(RequestIDHeader))
+	}
+	if decode(t, buf)["request_id"] != "upstream-id-1" {
+		t.Errorf("access log lacks request_id: %s", buf.String())
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "bad id\nwith newline")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if seen == "" || seen == "bad id\nwith newline" {
+		t.Errorf("malformed request ID should be replaced, got %q", seen)
+	}
+}
+
+func TestContextRequestID(t *testing.T) {
+	buf := capture(t)
+
+	ctx := WithRequestID(context.Background(), "job-42")
+	New("queue").InfoContext(ctx, "handled")
+	if decode(t, buf)["request_id"] != "job-42" {
+		t.Errorf("request_id missing from context logger: %s", buf.String())
+	}
+}
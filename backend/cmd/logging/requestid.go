@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// RequestIDHeader carries the request ID in and out of every service
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDEnv is set on exec'd processes so their output can be correlated
+const RequestIDEnv = "WASMIDE_REQUEST_ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 128-bit hex ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware assigns every request an ID, reusing a well-formed incoming
+// X-Request-ID so IDs survive hops between services, echoes it in the
+// response and logs the completed request with logger.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = NewRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+			r = r.WithContext(WithRequestID(r.Context(), id))
+
+			m := httpsnoop.CaptureMetrics(next, w, r)
+			logger.InfoContext(r.Context(), "request completed",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", m.Code,
+				"duration_ms", m.Duration.Milliseconds(),
+				"bytes", m.Written,
+			)
+		})
+	}
+}
+
+// validRequestID accepts short IDs made of URL-safe characters only, so a
+// client cannot inject arbitrary text into logs and message headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+// Package metrics defines the Prometheus collectors shared by the API and
+// the file server and serves them on /metrics.
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "wasmide"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	ExecDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "exec_duration_seconds",
+		Help:      "Wall time of command executions and builds by language.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"language"})
+
+	Execs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "exec_total",
+		Help:      "Command executions and builds by language and exit code (-1 when the process never exited).",
+	}, []string{"language", "exit_code"})
+
+	ContainerOps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "container_operations_total",
+		Help:      "Project container lifecycle operations by operation (create, start, reap) and result.",
+	}, []string{"operation", "result"})
+
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Messages waiting in a broker queue, as last reported by the broker.",
+	}, []string{"queue"})
+
+	Jobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_total",
+		Help:      "Queue messages handled by outcome (published, publish_failed, succeeded, failed).",
+	}, []string{"queue", "outcome"})
+
+	TerminalSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "terminal_sessions_active",
+		Help:      "Terminal sessions currently executing a command.",
+	})
+
+	FileOps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "file_operations_total",
+		Help:      "Project file operations by operation and result.",
+	}, []string{"operation", "result"})
+
+	BuildCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "build_cache_lookups_total",
+		Help:      "Project build cache lookups by result (hit, miss).",
+	}, []string{"result"})
+)
+
+// Handler serves the default registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Middleware records HTTP metrics for every request passing through it.
+// routeOf is called after the request is served and must return a bounded
+// route label such as the matched pattern, never the raw URL path.
+func Middleware(routeOf func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m := httpsnoop.CaptureMetrics(next, w, r)
+
+			route := routeOf(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(m.Code)).Inc()
+			HTTPDuration.WithLabelValues(route, r.Method).Observe(m.Duration.Seconds())
+		})
+	}
+}
+
+// ObserveExec records one execution that started at start and ended with err
+func ObserveExec(language string, start time.Time, err error) {
+	ExecDuration.WithLabelValues(language).Observe(time.Since(start).Seconds())
+	Execs.WithLabelValues(language, strconv.Itoa(ExitCode(err))).Inc()
+}
+
+// ObserveContainer records a container lifecycle operation
+func ObserveContainer(operation string, err error) {
+	ContainerOps.WithLabelValues(operation, result(err)).Inc()
+}
+
+// ObserveBuildCache records whether a build was served from the cache
+func ObserveBuildCache(result string) {
+	BuildCache.WithLabelValues(result).Inc()
+}
+
+// ObserveFile records a file operation
+func ObserveFile(operation string, err error) {
+	FileOps.WithLabelValues(operation, result(err)).Inc()
+}
+
+// ExitCode extracts the exit code from an error with an ExitCode method,
+// such as *exec.ExitError: 0 for success, -1 when the program was never
+// started or was killed.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
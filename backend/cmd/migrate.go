@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"muhammadyasir-dev/cmd/dbs"
+	"muhammadyasir-dev/cmd/migrations"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage: main migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [n]      roll back the last n migrations (default 1)
+  status        list migrations and whether they are applied
+  to <version>  migrate up or down to exactly <version> (0 rolls back everything)`
+
+// runMigrate implements the "migrate" subcommand
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	if err := dbs.Connect(); err != nil {
+		log.Fatalf("db connection refused: %v", err)
+	}
+	migrator, err := migrations.New(dbs.Db)
+	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("migrate up: %v", err)
+		}
+		fmt.Printf("applied %d migration(s)\n", n)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("migrate down: invalid step count %q", args[1])
+			}
+		}
+		n, err := migrator.Down(steps)
+		if err != nil {
+			log.Fatalf("migrate down: %v", err)
+		}
+		fmt.Printf("rolled back %d migration(s)\n", n)
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("migrate status: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		tw.Flush()
+
+	case "to":
+		if len(args) < 2 {
+			log.Fatal("migrate to: missing version")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			log.Fatalf("migrate to: invalid version %q", args[1])
+		}
+		n, err := migrator.To(version)
+		if err != nil {
+			log.Fatalf("migrate to: %v", err)
+		}
+		fmt.Printf("migrated to version %d (%d change(s))\n", version, n)
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadOrdersAndPairsFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_index.up.sql":      {Data: []byte("CREATE INDEX x ON t (a);")},
+		"0001_create_t.up.sql":       {Data: []byte("CREATE TABLE t (a INT);")},
+		"0001_create_t.down.sql":     {Data: []byte("DROP TABLE t;")},
+		"README.md":                  {Data: []byte("ignored")},
+		"0010_later_change.up.sql":   {Data: []byte("SELECT 1;")},
+		"0010_later_change.down.sql": {Data: []byte("SELECT 1;")},
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int64{1, 2, 10}
+	if len(migrations) != len(want) {
+		t.Fatalf("got %d migrations, want %d", len(migrations), len(want))
+	}
+	for i, v := range want {
+		if migrations[i].Version != v {
+			t.Errorf("migration %d has version %d, want %d", i, migrations[i].Version, v)
+		}
+	}
+	if migrations[0].Down != "DROP TABLE t;" {
+		t.Errorf("down file not paired with its up file: %q", migrations[0].Down)
+	}
+	if migrations[1].Down != "" {
+		t.Errorf("expected migration without down file to have empty Down, got %q", migrations[1].Down)
+	}
+}
+
+func TestLoadRejectsBadFiles(t *testing.T) {
+	cases := map[string]fstest.MapFS{
+		"no direction":  {"0001_create_t.sql": {}},
+		"no version":    {"create_t.up.sql": {}},
+		"down only":     {"0001_create_t.down.sql": {Data: []byte("DROP TABLE t;")}},
+		"name mismatch": {"0001_a.up.sql": {Data: []byte("SELECT 1;")}, "0001_b.down.sql": {}},
+		"zero version":  {"0000_create_t.up.sql": {Data: []byte("SELECT 1;")}},
+	}
+
+	for name, fsys := range cases {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrationsLoad(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Migrations()) == 0 {
+		t.Fatal("no embedded migrations found")
+	}
+}
@@ -0,0 +1,188 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB returns an empty in-memory database. The embedded SQL is
+// PostgreSQL's, so the migrations tested here stick to what SQLite
+// understands too.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// testMigrator returns a Migrator of three migrations over an empty database
+func testMigrator(t *testing.T) (*Migrator, *gorm.DB) {
+	t.Helper()
+	db := openTestDB(t)
+	m, err := NewFromFS(db, fstest.MapFS{
+		// adopted: an existing table is kept, so there is no down file
+		"0001_create_a.up.sql":   {Data: []byte("CREATE TABLE IF NOT EXISTS a (x INTEGER);")},
+		"0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b (x INTEGER);")},
+		"0002_create_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0003_create_c.up.sql":   {Data: []byte("CREATE TABLE c (x INTEGER);")},
+		"0003_create_c.down.sql": {Data: []byte("DROP TABLE c;")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, db
+}
+
+// assertTables checks which of the test migrations' tables exist
+func assertTables(t *testing.T, db *gorm.DB, want string) {
+	t.Helper()
+	var got []string
+	for _, table := range []string{"a", "b", "c"} {
+		if db.Migrator().HasTable(table) {
+			got = append(got, table)
+		}
+	}
+	if strings.Join(got, "") != want {
+		t.Errorf("tables = %q, want %q", strings.Join(got, ""), want)
+	}
+}
+
+func assertVersion(t *testing.T, m *Migrator, want int64) {
+	t.Helper()
+	v, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != want {
+		t.Errorf("version = %d, want %d", v, want)
+	}
+}
+
+func TestUpAppliesPendingMigrations(t *testing.T) {
+	m, db := testMigrator(t)
+
+	n, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("applied %d migrations, want 3", n)
+	}
+	assertTables(t, db, "abc")
+	assertVersion(t, m, 3)
+
+	if n, err = m.Up(); err != nil || n != 0 {
+		t.Errorf("second Up applied %d migrations (%v), want 0", n, err)
+	}
+}
+
+func TestDownRollsBackNewestFirst(t *testing.T) {
+	m, db := testMigrator(t)
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := m.Down(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("rolled back %d migrations, want 2", n)
+	}
+	assertTables(t, db, "a")
+	assertVersion(t, m, 1)
+}
+
+func TestDownKeepsAdoptedTables(t *testing.T) {
+	m, db := testMigrator(t)
+	if err := db.Exec("CREATE TABLE a (x INTEGER)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO a (x) VALUES (1)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.To(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Down(1); err != nil {
+		t.Fatal(err)
+	}
+	assertTables(t, db, "a")
+	assertVersion(t, m, 0)
+	var rows int64
+	if err := db.Table("a").Count(&rows).Error; err != nil || rows != 1 {
+		t.Errorf("adopted table has %d rows (%v), want 1", rows, err)
+	}
+}
+
+func TestToMigratesBothWays(t *testing.T) {
+	m, db := testMigrator(t)
+
+	if _, err := m.To(2); err != nil {
+		t.Fatal(err)
+	}
+	assertTables(t, db, "ab")
+	assertVersion(t, m, 2)
+
+	if _, err := m.To(3); err != nil {
+		t.Fatal(err)
+	}
+	assertTables(t, db, "abc")
+
+	if _, err := m.To(1); err != nil {
+		t.Fatal(err)
+	}
+	assertTables(t, db, "a")
+	assertVersion(t, m, 1)
+
+	if _, err := m.To(0); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 0)
+
+	if _, err := m.To(4); err == nil {
+		t.Error("expected an error migrating to an unknown version")
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	m, err := NewFromFS(openTestDB(t), fstest.MapFS{
+		"0001_create_a.up.sql": {Data: []byte("CREATE TABLE a (x INTEGER);")},
+		"0002_broken.up.sql":   {Data: []byte("NOT SQL;")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	assertVersion(t, m, 1)
+}
+
+func TestEmbeddedUsersMigrationHasNoDown(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range m.Migrations() {
+		if migration.Version == 1 && migration.Down != "" {
+			t.Errorf("0001_%s drops the adopted users table on rollback", migration.Name)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+-- Tables created by the old AutoMigrate calls are adopted as-is; only the
+-- columns and indexes the canonical models.User needs are added. There is
+-- no down file: an adopted table holds accounts this migration didn't
+-- create, so rolling it back must leave users alone.
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    password TEXT NOT NULL DEFAULT '',
+    picture TEXT NOT NULL DEFAULT '',
+    google_id TEXT NOT NULL DEFAULT ''
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS picture TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS google_id TEXT NOT NULL DEFAULT '';
+
+-- The old model had no unique constraint, so an adopted table may hold
+-- several accounts per email. Which one to keep is not ours to guess, so
+-- stop with the query that lists them instead of failing on the index.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users GROUP BY email HAVING COUNT(*) > 1) THEN
+        RAISE EXCEPTION 'users has duplicate emails; merge or delete them before migrating'
+            USING HINT = 'SELECT email, COUNT(*) FROM users GROUP BY email HAVING COUNT(*) > 1';
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
@@ -0,0 +1,9 @@
+-- models.User used to map GoogleID onto the picture column, so accounts
+-- created through OAuth carry the numeric Google account id where the
+-- avatar URL belongs. Move it to google_id. (~ is PostgreSQL's regex match;
+-- see the package doc.)
+UPDATE users
+SET google_id = picture,
+    picture = ''
+WHERE COALESCE(google_id, '') = ''
+  AND picture ~ '^[0-9]+$';
@@ -0,0 +1,9 @@
+-- Per-day counters behind the execution quotas. subject is the rate limit
+-- key: "user:<id>" for signed-in users, "ip:<address>" otherwise.
+CREATE TABLE IF NOT EXISTS daily_usage (
+    subject TEXT NOT NULL,
+    day DATE NOT NULL,
+    executions INTEGER NOT NULL DEFAULT 0,
+    cpu_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    PRIMARY KEY (subject, day)
+);
@@ -0,0 +1,18 @@
+-- One row per terminal command or build, for metering and billing.
+-- user_id is NULL for anonymous runs, which are identified by subject alone.
+CREATE TABLE IF NOT EXISTS usage_records (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    subject TEXT NOT NULL,
+    project TEXT NOT NULL DEFAULT '',
+    language TEXT NOT NULL DEFAULT '',
+    exit_code INTEGER NOT NULL DEFAULT 0,
+    cpu_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    memory_peak_bytes BIGINT NOT NULL DEFAULT 0,
+    wall_ms BIGINT NOT NULL DEFAULT 0,
+    output_bytes BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_records_user_created ON usage_records (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_usage_records_created ON usage_records (created_at);
@@ -0,0 +1,3 @@
+-- Login sessions are not kept server side: auth tokens are self-contained
+-- JWTs, so nothing ever read or wrote this table.
+DROP TABLE IF EXISTS sessions;
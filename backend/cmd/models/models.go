@@ -1,20 +1,66 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Fileobject struct {
 	gorm.Model        // Embedding gorm.Model provides ID, CreatedAt, UpdatedAt, DeletedAt fields
+	ProjectID  uint   `gorm:"column:project_id;index"`
 	Typeis     string `gorm:"column:typeis"` // Use appropriate field names and tags
 	Name       string `gorm:"column:name"`
 	Content    string `gorm:"column:content"`
 }
 
+// User is the single user model shared by signup and OAuth login.
+// Its schema is owned by the SQL files in cmd/migrations.
 type User struct {
-	Id       uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	ID       uint   `gorm:"primaryKey;autoIncrement" json:"id"`
 	Name     string `gorm:"column:name" json:"name"`
-	Email    string `gorm:"column:email" json:"email"`
-	Password string `gorm:"column:password;default:''" json:"password,omitempty"` // Change to string
-	GoogleID string `gorm:"column:picture" json:"picture,omitempty"`
+	Email    string `gorm:"column:email;uniqueIndex" json:"email"`
+	Password string `gorm:"column:password;default:''" json:"password,omitempty"`
+	Picture  string `gorm:"column:picture" json:"picture,omitempty"`
+	GoogleID string `gorm:"column:google_id" json:"google_id,omitempty"`
+}
+
+// DailyUsage counts what one quota subject consumed on one UTC day
+type DailyUsage struct {
+	Subject    string    `gorm:"primaryKey;column:subject" json:"subject"`
+	Day        time.Time `gorm:"primaryKey;column:day;type:date" json:"day"`
+	Executions int       `gorm:"column:executions" json:"executions"`
+	CPUSeconds float64   `gorm:"column:cpu_seconds" json:"cpu_seconds"`
+}
+
+func (DailyUsage) TableName() string { return "daily_usage" }
+
+// UsageRecord meters one terminal command or build. MemoryPeakBytes is the
+// high-water mark of the environment the run happened in, which for a
+// project container covers the container's lifetime, not just this run.
+type UsageRecord struct {
+	ID              uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID          *uint     `gorm:"column:user_id" json:"user_id"`
+	Subject         string    `gorm:"column:subject" json:"subject"`
+	Project         string    `gorm:"column:project" json:"project"`
+	Language        string    `gorm:"column:language" json:"language"`
+	ExitCode        int       `gorm:"column:exit_code" json:"exit_code"`
+	CPUSeconds      float64   `gorm:"column:cpu_seconds" json:"cpu_seconds"`
+	MemoryPeakBytes int64     `gorm:"column:memory_peak_bytes" json:"memory_peak_bytes"`
+	WallMs          int64     `gorm:"column:wall_ms" json:"wall_ms"`
+	OutputBytes     int64     `gorm:"column:output_bytes" json:"output_bytes"`
+	CreatedAt       time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// UsageTotal sums usage records over one group. Fields not grouped by are
+// left at their zero value.
+type UsageTotal struct {
+	UserID          *uint   `json:"user_id,omitempty"`
+	Project         string  `json:"project,omitempty"`
+	Day             string  `json:"day,omitempty"`
+	Runs            int64   `json:"runs"`
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	WallSeconds     float64 `json:"wall_seconds"`
+	OutputBytes     int64   `json:"output_bytes"`
+	MemoryPeakBytes int64   `json:"memory_peak_bytes"`
 }
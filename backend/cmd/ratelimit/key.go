@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrNoSecret is returned by Secret when JWT_SECRET is not set. There is no
+// default: anyone knowing it could sign a cookie for any user.
+var ErrNoSecret = errors.New("JWT_SECRET is not set")
+
+// Secret returns JWT_SECRET, the key auth_token cookies are signed with. The
+// API and the file server both read it here so they always agree, and
+// refuse to start without it.
+func Secret() ([]byte, error) {
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	return nil, ErrNoSecret
+}
+
+// KeyFunc names the client a request is counted against
+type KeyFunc func(*http.Request) string
+
+// ClientKey keys requests by the user in a valid auth_token cookie signed
+// with secret ("user:<id>"), and everything else by remote IP ("ip:<addr>").
+// An empty secret keys every request by IP.
+func ClientKey(secret []byte) KeyFunc {
+	return func(r *http.Request) string {
+		if id, ok := UserID(r, secret); ok {
+			return fmt.Sprintf("user:%d", id)
+		}
+		return "ip:" + clientIP(r)
+	}
+}
+
+// UserID returns the user in r's auth_token cookie if the token is signed
+// with secret. An empty secret never matches.
+func UserID(r *http.Request, secret []byte) (uint, bool) {
+	claims, ok := Claims(r, secret)
+	if !ok {
+		return 0, false
+	}
+	id, ok := claims["id"].(float64)
+	if !ok {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Claims returns the claims of r's auth_token cookie if the token is signed
+// with secret. An empty secret never matches.
+func Claims(r *http.Request, secret []byte) (jwt.MapClaims, bool) {
+	if len(secret) == 0 {
+		return nil, false
+	}
+	cookie, err := r.Cookie("auth_token")
+	if err != nil {
+		return nil, false
+	}
+	token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	return claims, ok
+}
+
+// clientIP uses the connection's address; X-Forwarded-For is ignored
+// because any client can set it
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Slug turns a client key into a name safe for files and docker volumes,
+// replacing anything outside [A-Za-z0-9_.-]: "user:3" becomes "user-3"
+func Slug(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '-'
+	}, key)
+}
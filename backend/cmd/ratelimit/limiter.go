@@ -0,0 +1,137 @@
+// Package ratelimit throttles clients with per-key token buckets and enforces
+// daily execution quotas. Clients are keyed by signed-in user, falling back
+// to their IP address.
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"muhammadyasir-dev/cmd/logging"
+)
+
+var limitLog = logging.New("ratelimit")
+
+// Limiter keeps one token bucket per key. Buckets idle for longer than it
+// takes them to refill are forgotten.
+type Limiter struct {
+	name  string
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// New returns a Limiter named name that allows perMinute requests per key
+// on average, with bursts of up to burst.
+func New(name string, perMinute float64, burst int) *Limiter {
+	return &Limiter{
+		name:    name,
+		limit:   rate.Limit(perMinute / 60),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// FromEnv returns a Limiter whose rate and burst can be overridden with
+// RATE_LIMIT_<NAME>_PER_MINUTE and RATE_LIMIT_<NAME>_BURST.
+// A rate of 0 disables the limiter.
+func FromEnv(name string, perMinute float64, burst int) *Limiter {
+	prefix := "RATE_LIMIT_" + strings.ToUpper(name)
+	if v := os.Getenv(prefix + "_PER_MINUTE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			perMinute = f
+		} else {
+			limitLog.Warn("ignoring invalid rate", "key", prefix+"_PER_MINUTE", "value", v)
+		}
+	}
+	if v := os.Getenv(prefix + "_BURST"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			burst = n
+		} else {
+			limitLog.Warn("ignoring invalid burst", "key", prefix+"_BURST", "value", v)
+		}
+	}
+	return New(name, perMinute, burst)
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it
+// reports how long until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.limit == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely since their last use
+func (l *Limiter) sweep(now time.Time) {
+	refill := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Middleware rejects requests over the limit with 429 Too Many Requests and
+// a Retry-After header. CORS preflights are never limited.
+func (l *Limiter) Middleware(keyOf KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := keyOf(r)
+			if ok, wait := l.Allow(key); !ok {
+				limitLog.InfoContext(r.Context(), "rate limited", "limiter", l.name, "subject", key)
+				tooManyRequests(w, wait, "Too many requests, slow down")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
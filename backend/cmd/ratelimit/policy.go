@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"muhammadyasir-dev/cmd/repository"
+)
+
+// Policy is the set of limits a service applies to its routes
+type Policy struct {
+	Key   KeyFunc
+	Auth  *Limiter // login and signup
+	Files *Limiter // file writes
+	Exec  *Limiter // terminal commands and builds
+	Quota *Quota
+}
+
+// DefaultPolicy builds the standard buckets, each overridable through
+// RATE_LIMIT_<AUTH|FILES|EXEC>_{PER_MINUTE,BURST}, and the daily quota
+func DefaultPolicy(key KeyFunc, usage repository.DailyUsageRepository) *Policy {
+	return &Policy{
+		Key:   key,
+		Auth:  FromEnv("auth", 10, 5),
+		Files: FromEnv("files", 120, 30),
+		Exec:  FromEnv("exec", 30, 5),
+		Quota: QuotaFromEnv(usage),
+	}
+}
+
+// AuthLimited applies the auth bucket to h
+func (p *Policy) AuthLimited(h http.Handler) http.Handler {
+	return p.Auth.Middleware(p.Key)(h)
+}
+
+// FileLimited applies the file write bucket to h
+func (p *Policy) FileLimited(h http.Handler) http.Handler {
+	return p.Files.Middleware(p.Key)(h)
+}
+
+// ExecLimited applies the exec bucket and the daily quota to h
+func (p *Policy) ExecLimited(h http.Handler) http.Handler {
+	return p.Exec.Middleware(p.Key)(p.Quota.Middleware(p.Key)(h))
+}
+
+// UsageHandler serves the caller's remaining quota
+func (p *Policy) UsageHandler() http.Handler {
+	return p.Quota.Handler(p.Key)
+}
@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+
+	"muhammadyasir-dev/cmd/repository"
+)
+
+// Quota caps how many executions and CPU-seconds a subject may use per UTC
+// day. A zero limit is unlimited.
+type Quota struct {
+	usage         repository.DailyUsageRepository
+	MaxExecutions int
+	MaxCPUSeconds float64
+	// FailOpen lets executions through when the usage store can't be
+	// reached, rather than refusing them with 503
+	FailOpen bool
+	now      func() time.Time
+}
+
+// NewQuota returns a Quota that keeps its counters in usage
+func NewQuota(usage repository.DailyUsageRepository, maxExecutions int, maxCPUSeconds float64) *Quota {
+	return &Quota{
+		usage:         usage,
+		MaxExecutions: maxExecutions,
+		MaxCPUSeconds: maxCPUSeconds,
+		FailOpen:      true,
+		now:           time.Now,
+	}
+}
+
+// QuotaFromEnv is NewQuota with limits from QUOTA_DAILY_EXECUTIONS and
+// QUOTA_DAILY_CPU_SECONDS, defaulting to 500 executions and one CPU-hour.
+// QUOTA_FAIL_OPEN=false refuses executions while the usage store is down.
+func QuotaFromEnv(usage repository.DailyUsageRepository) *Quota {
+	q := NewQuota(usage, 500, 3600)
+	if v := os.Getenv("QUOTA_FAIL_OPEN"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			q.FailOpen = b
+		} else {
+			limitLog.Warn("ignoring invalid quota setting", "key", "QUOTA_FAIL_OPEN", "value", v)
+		}
+	}
+	if v := os.Getenv("QUOTA_DAILY_EXECUTIONS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			q.MaxExecutions = n
+		} else {
+			limitLog.Warn("ignoring invalid quota", "key", "QUOTA_DAILY_EXECUTIONS", "value", v)
+		}
+	}
+	if v := os.Getenv("QUOTA_DAILY_CPU_SECONDS"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			q.MaxCPUSeconds = f
+		} else {
+			limitLog.Warn("ignoring invalid quota", "key", "QUOTA_DAILY_CPU_SECONDS", "value", v)
+		}
+	}
+	return q
+}
+
+// Usage is a subject's consumption for the current day. Remaining values
+// are -1 when the corresponding limit is unlimited.
+type Usage struct {
+	Subject             string    `json:"subject"`
+	Day                 string    `json:"day"`
+	ExecutionsUsed      int       `json:"executions_used"`
+	ExecutionsLimit     int       `json:"executions_limit"`
+	ExecutionsRemaining int       `json:"executions_remaining"`
+	CPUSecondsUsed      float64   `json:"cpu_seconds_used"`
+	CPUSecondsLimit     float64   `json:"cpu_seconds_limit"`
+	CPUSecondsRemaining float64   `json:"cpu_seconds_remaining"`
+	ResetsAt            time.Time `json:"resets_at"`
+}
+
+// Exhausted reports whether either limit has been reached
+func (u *Usage) Exhausted() bool {
+	return u.ExecutionsRemaining == 0 || u.CPUSecondsRemaining == 0
+}
+
+// Usage returns subject's consumption and remaining allowance for today
+func (q *Quota) Usage(ctx context.Context, subject string) (*Usage, error) {
+	now := q.now().UTC()
+	daily, err := q.usage.Get(ctx, subject, now)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Usage{
+		Subject:             subject,
+		Day:                 now.Format(time.DateOnly),
+		ExecutionsUsed:      daily.Executions,
+		ExecutionsLimit:     q.MaxExecutions,
+		ExecutionsRemaining: -1,
+		CPUSecondsUsed:      daily.CPUSeconds,
+		CPUSecondsLimit:     q.MaxCPUSeconds,
+		CPUSecondsRemaining: -1,
+		ResetsAt:            resetsAt(now),
+	}
+	if q.MaxExecutions > 0 {
+		u.ExecutionsRemaining = max(q.MaxExecutions-daily.Executions, 0)
+	}
+	if q.MaxCPUSeconds > 0 {
+		u.CPUSecondsRemaining = max(q.MaxCPUSeconds-daily.CPUSeconds, 0)
+	}
+	return u, nil
+}
+
+// resetsAt returns the next UTC midnight after now, when quotas reset
+func resetsAt(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// Middleware refuses executions once the subject's quota for the day is
+// used up. Each execution is counted before it starts, so executions
+// started at once can't take the subject over its quota, and given back if
+// the request is rejected as a client error. The CPU time charged once it
+// finishes is what the handler reported with Charge, or the request's wall
+// time if it reported nothing.
+//
+// If the usage store is unavailable requests are let through when FailOpen
+// is set, the default: a database outage should not lock everyone out of
+// running code, and the per-minute exec bucket still bounds each client.
+// Otherwise they are refused with 503.
+func (q *Quota) Middleware(keyOf KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			subject := keyOf(r)
+			now := q.now()
+			reserved, err := q.usage.Reserve(r.Context(), subject, now, q.MaxExecutions, q.MaxCPUSeconds)
+			switch {
+			case err != nil && !q.FailOpen:
+				limitLog.ErrorContext(r.Context(), "quota check failed, refusing request", "subject", subject, "error", err)
+				http.Error(w, "Execution quota unavailable, try again later", http.StatusServiceUnavailable)
+				return
+			case err != nil:
+				limitLog.ErrorContext(r.Context(), "quota check failed, allowing request", "subject", subject, "error", err)
+			case !reserved:
+				limitLog.InfoContext(r.Context(), "daily quota exhausted", "subject", subject)
+				tooManyRequests(w, resetsAt(now).Sub(q.now()), "Daily execution quota exhausted")
+				return
+			}
+
+			m := &meter{}
+			ctx := context.WithValue(r.Context(), meterKey{}, m)
+			snoop := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+			// the response is written, so a cancelled request context must not lose the charge
+			ctx = context.WithoutCancel(ctx)
+			if snoop.Code >= 400 && snoop.Code < 500 {
+				if reserved {
+					if err := q.usage.Add(ctx, subject, now, -1, 0); err != nil {
+						limitLog.ErrorContext(ctx, "failed to release execution", "subject", subject, "error", err)
+					}
+				}
+				return
+			}
+
+			cpu, charged := m.total()
+			if !charged {
+				cpu = snoop.Duration.Seconds()
+			}
+			// an execution let through without a reservation is counted now
+			executions := 0
+			if !reserved {
+				executions = 1
+			}
+			if err := q.usage.Add(ctx, subject, now, executions, cpu); err != nil {
+				limitLog.ErrorContext(ctx, "failed to record usage", "subject", subject, "error", err)
+			}
+		})
+	}
+}
+
+// Handler serves the caller's usage for today as JSON
+func (q *Quota) Handler(keyOf KeyFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usage, err := q.Usage(r.Context(), keyOf(r))
+		if err != nil {
+			http.Error(w, "Failed to load usage", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(usage)
+	})
+}
+
+type meterKey struct{}
+
+// meter accumulates the CPU time reported for one request
+type meter struct {
+	mu      sync.Mutex
+	cpu     float64
+	charged bool
+}
+
+func (m *meter) total() (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cpu, m.charged
+}
+
+// Charge reports CPU time spent on behalf of the request in ctx, so the
+// quota charges measured CPU instead of wall time. It is a no-op outside
+// Quota.Middleware.
+func Charge(ctx context.Context, cpuSeconds float64) {
+	m, ok := ctx.Value(meterKey{}).(*meter)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cpu += cpuSeconds
+	m.charged = true
+}
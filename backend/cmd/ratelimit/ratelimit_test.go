@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"muhammadyasir-dev/cmd/models"
+	"muhammadyasir-dev/cmd/repository"
+)
+
+var fixedKey KeyFunc = func(*http.Request) string { return "user:1" }
+
+func TestLimiterRejectsAfterBurst(t *testing.T) {
+	l := New("test", 60, 2)
+	h := l.Middleware(fixedKey)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	codes := make([]int, 3)
+	var retryAfter string
+	for i := range codes {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/stream", nil))
+		codes[i] = w.Code
+		retryAfter = w.Header().Get("Retry-After")
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("expected two requests then a 429, got %v", codes)
+	}
+	if secs, err := strconv.Atoi(retryAfter); err != nil || secs < 1 {
+		t.Errorf("expected a positive Retry-After, got %q", retryAfter)
+	}
+
+	if ok, _ := l.Allow("user:2"); !ok {
+		t.Error("buckets must be separate per key")
+	}
+}
+
+func TestQuotaChargesAndRejects(t *testing.T) {
+	usage := repository.NewMemory().DailyUsage
+	q := NewQuota(usage, 2, 100)
+
+	status := http.StatusOK
+	h := q.Middleware(fixedKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Charge(r.Context(), 1.5)
+		w.WriteHeader(status)
+	}))
+	run := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/runcode", nil))
+		return w
+	}
+
+	// client errors are not charged
+	status = http.StatusBadRequest
+	run()
+	status = http.StatusOK
+	run()
+	run()
+
+	got, err := q.Usage(context.Background(), "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ExecutionsUsed != 2 || got.CPUSecondsUsed != 3 || got.ExecutionsRemaining != 0 {
+		t.Errorf("unexpected usage %+v", got)
+	}
+
+	w := run()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the quota is used, got %d", w.Code)
+	}
+	secs, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+	if secs <= 0 || secs > int((24*time.Hour).Seconds()) {
+		t.Errorf("Retry-After should point at the next UTC midnight, got %d", secs)
+	}
+}
+
+func TestQuotaReservesConcurrentExecutions(t *testing.T) {
+	q := NewQuota(repository.NewMemory().DailyUsage, 3, 0)
+	release := make(chan struct{})
+	h := q.Middleware(fixedKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	const n = 10
+	codes := make(chan int, n)
+	for range n {
+		go func() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("POST", "/runcode", nil))
+			codes <- w.Code
+		}()
+	}
+	// the rejected requests return while the others are still running
+	for range n - 3 {
+		if code := <-codes; code != http.StatusTooManyRequests {
+			t.Errorf("expected 429 beyond the quota, got %d", code)
+		}
+	}
+	close(release)
+	for range 3 {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("expected the first three executions to run, got %d", code)
+		}
+	}
+
+	got, _ := q.Usage(context.Background(), "user:1")
+	if got.ExecutionsUsed != 3 {
+		t.Errorf("expected 3 executions used, got %d", got.ExecutionsUsed)
+	}
+}
+
+// downUsage is a usage store that can't be reached
+type downUsage struct{}
+
+func (downUsage) Add(context.Context, string, time.Time, int, float64) error {
+	return errors.New("connection refused")
+}
+
+func (downUsage) Reserve(context.Context, string, time.Time, int, float64) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (downUsage) Get(context.Context, string, time.Time) (*models.DailyUsage, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestQuotaStoreOutage(t *testing.T) {
+	for _, tc := range []struct {
+		failOpen bool
+		want     int
+	}{
+		{failOpen: true, want: http.StatusOK},
+		{failOpen: false, want: http.StatusServiceUnavailable},
+	} {
+		q := NewQuota(downUsage{}, 2, 100)
+		q.FailOpen = tc.failOpen
+		ran := false
+		h := q.Middleware(fixedKey)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { ran = true }))
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/runcode", nil))
+		if w.Code != tc.want || ran != tc.failOpen {
+			t.Errorf("FailOpen=%v: got %d and ran=%v, want %d", tc.failOpen, w.Code, ran, tc.want)
+		}
+	}
+}
+
+func TestQuotaFromEnvFailOpen(t *testing.T) {
+	if !QuotaFromEnv(downUsage{}).FailOpen {
+		t.Error("quotas should fail open by default")
+	}
+	t.Setenv("QUOTA_FAIL_OPEN", "false")
+	if QuotaFromEnv(downUsage{}).FailOpen {
+		t.Error("QUOTA_FAIL_OPEN=false should fail closed")
+	}
+}
+
+func TestClientKeyPrefersUser(t *testing.T) {
+	secret := []byte("test-secret")
+	key := ClientKey(secret)
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 7}).SignedString(secret)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+	if got := key(req); got != "user:7" {
+		t.Errorf("expected user key, got %q", got)
+	}
+
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 7}).SignedString([]byte("other"))
+	req = httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: forged})
+	if got := key(req); got != "ip:203.0.113.9" {
+		t.Errorf("forged tokens must fall back to the IP, got %q", got)
+	}
+}
+
+func TestSecretHasNoDefault(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if secret, err := Secret(); !errors.Is(err, ErrNoSecret) || secret != nil {
+		t.Errorf("Secret() = %q, %v; want ErrNoSecret", secret, err)
+	}
+	t.Setenv("JWT_SECRET", "from-env")
+	if secret, err := Secret(); err != nil || string(secret) != "from-env" {
+		t.Errorf("Secret() = %q, %v; want JWT_SECRET", secret, err)
+	}
+
+	// a cookie signed with an empty key must not pass for a user
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 7}).SignedString([]byte{})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+	if id, ok := UserID(req, []byte{}); ok {
+		t.Errorf("empty secret matched user %d", id)
+	}
+}
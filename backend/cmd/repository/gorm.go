@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"muhammadyasir-dev/cmd/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm returns a Store backed by db, normally the Postgres connection from dbs
+func NewGorm(db *gorm.DB) *Store {
+	return &Store{
+		Users:      &gormUsers{db: db},
+		DailyUsage: &gormDailyUsage{db: db},
+		Usage:      &gormUsage{db: db},
+	}
+}
+
+// translate maps GORM's errors onto the package errors. Duplicate keys are
+// only recognised when the connection was opened with TranslateError.
+func translate(err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	}
+	return err
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r *gormUsers) Create(ctx context.Context, user *models.User) error {
+	return translate(r.db.WithContext(ctx).Create(user).Error)
+}
+
+func (r *gormUsers) ByID(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) ByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) ByName(ctx context.Context, name string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("name = ?", name).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+type gormDailyUsage struct {
+	db *gorm.DB
+}
+
+func (r *gormDailyUsage) Add(ctx context.Context, subject string, day time.Time, executions int, cpuSeconds float64) error {
+	usage := models.DailyUsage{Subject: subject, Day: utcDay(day), Executions: executions, CPUSeconds: cpuSeconds}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "subject"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"executions":  gorm.Expr("daily_usage.executions + EXCLUDED.executions"),
+			"cpu_seconds": gorm.Expr("daily_usage.cpu_seconds + EXCLUDED.cpu_seconds"),
+		}),
+	}).Create(&usage).Error
+}
+
+func (r *gormDailyUsage) Reserve(ctx context.Context, subject string, day time.Time, maxExecutions int, maxCPUSeconds float64) (bool, error) {
+	// a row that is over a limit is left alone, which affects no rows
+	result := r.db.WithContext(ctx).Exec(`INSERT INTO daily_usage (subject, day, executions, cpu_seconds)
+		VALUES (?, ?, 1, 0)
+		ON CONFLICT (subject, day) DO UPDATE SET executions = daily_usage.executions + 1
+		WHERE (? = 0 OR daily_usage.executions < ?) AND (? = 0 OR daily_usage.cpu_seconds < ?)`,
+		subject, utcDay(day), maxExecutions, maxExecutions, maxCPUSeconds, maxCPUSeconds)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *gormDailyUsage) Get(ctx context.Context, subject string, day time.Time) (*models.DailyUsage, error) {
+	usage := models.DailyUsage{Subject: subject, Day: utcDay(day)}
+	err := r.db.WithContext(ctx).Where("subject = ? AND day = ?", subject, usage.Day).First(&usage).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// utcDay truncates t to midnight UTC, the boundary quotas reset on
+func utcDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+type gormUsage struct {
+	db *gorm.DB
+}
+
+// usageGroupColumns maps Aggregate dimensions to SQL expressions
+var usageGroupColumns = map[string]string{
+	GroupByUser:    "user_id",
+	GroupByProject: "project",
+	GroupByDay:     "to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')",
+}
+
+func (r *gormUsage) Create(ctx context.Context, record *models.UsageRecord) error {
+	return r.db.WithContext(ctx).Create(record).Error
+}
+
+func (r *gormUsage) List(ctx context.Context, filter UsageFilter) ([]models.UsageRecord, error) {
+	var records []models.UsageRecord
+	err := r.filtered(ctx, filter).Order("created_at, id").Find(&records).Error
+	return records, err
+}
+
+func (r *gormUsage) Aggregate(ctx context.Context, filter UsageFilter, groupBy []string) ([]models.UsageTotal, error) {
+	selects := []string{
+		"COUNT(*) AS runs",
+		"COALESCE(SUM(cpu_seconds), 0) AS cpu_seconds",
+		"COALESCE(SUM(wall_ms), 0) / 1000.0 AS wall_seconds",
+		"COALESCE(SUM(output_bytes), 0) AS output_bytes",
+		"COALESCE(MAX(memory_peak_bytes), 0) AS memory_peak_bytes",
+	}
+	var groups []string
+	for _, g := range groupBy {
+		expr, ok := usageGroupColumns[g]
+		if !ok {
+			return nil, fmt.Errorf("cannot group usage by %q", g)
+		}
+		alias := g
+		if g == GroupByUser {
+			alias = "user_id"
+		}
+		selects = append(selects, expr+" AS "+alias)
+		groups = append(groups, expr)
+	}
+
+	q := r.filtered(ctx, filter).Select(strings.Join(selects, ", "))
+	if len(groups) > 0 {
+		q = q.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+	}
+	var totals []models.UsageTotal
+	err := q.Scan(&totals).Error
+	return totals, err
+}
+
+func (r *gormUsage) filtered(ctx context.Context, filter UsageFilter) *gorm.DB {
+	q := r.db.WithContext(ctx).Model(&models.UsageRecord{})
+	if filter.UserID != nil {
+		q = q.Where("user_id = ?", *filter.UserID)
+	}
+	if !filter.From.IsZero() {
+		q = q.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		q = q.Where("created_at < ?", filter.To)
+	}
+	return q
+}
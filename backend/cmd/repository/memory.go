@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"muhammadyasir-dev/cmd/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewMemory returns a Store that keeps everything in process memory.
+// It enforces the same unique keys as the SQL schema and is meant for tests.
+func NewMemory() *Store {
+	return &Store{
+		Users:      &memoryUsers{byID: make(map[uint]models.User)},
+		DailyUsage: &memoryDailyUsage{byKey: make(map[dailyKey]models.DailyUsage)},
+		Usage:      &memoryUsage{},
+	}
+}
+
+type memoryUsers struct {
+	mu     sync.Mutex
+	nextID uint
+	byID   map[uint]models.User
+}
+
+func (r *memoryUsers) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.byID {
+		if existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+	r.nextID++
+	user.ID = r.nextID
+	r.byID[user.ID] = *user
+	return nil
+}
+
+func (r *memoryUsers) ByID(ctx context.Context, id uint) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.byID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *memoryUsers) ByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(u models.User) bool { return u.Email == email })
+}
+
+func (r *memoryUsers) ByName(ctx context.Context, name string) (*models.User, error) {
+	return r.find(func(u models.User) bool { return u.Name == name })
+}
+
+// find returns the lowest-ID user matching, like ORDER BY id LIMIT 1
+func (r *memoryUsers) find(match func(models.User) bool) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var found *models.User
+	for _, user := range r.byID {
+		if match(user) && (found == nil || user.ID < found.ID) {
+			u := user
+			found = &u
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+type dailyKey struct {
+	subject string
+	day     time.Time
+}
+
+type memoryDailyUsage struct {
+	mu    sync.Mutex
+	byKey map[dailyKey]models.DailyUsage
+}
+
+func (r *memoryDailyUsage) Add(ctx context.Context, subject string, day time.Time, executions int, cpuSeconds float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := dailyKey{subject, utcDay(day)}
+	usage := r.byKey[key]
+	usage.Subject, usage.Day = key.subject, key.day
+	usage.Executions += executions
+	usage.CPUSeconds += cpuSeconds
+	r.byKey[key] = usage
+	return nil
+}
+
+func (r *memoryDailyUsage) Reserve(ctx context.Context, subject string, day time.Time, maxExecutions int, maxCPUSeconds float64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := dailyKey{subject, utcDay(day)}
+	usage := r.byKey[key]
+	if maxExecutions > 0 && usage.Executions >= maxExecutions || maxCPUSeconds > 0 && usage.CPUSeconds >= maxCPUSeconds {
+		return false, nil
+	}
+	usage.Subject, usage.Day = key.subject, key.day
+	usage.Executions++
+	r.byKey[key] = usage
+	return true, nil
+}
+
+func (r *memoryDailyUsage) Get(ctx context.Context, subject string, day time.Time) (*models.DailyUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := dailyKey{subject, utcDay(day)}
+	usage, ok := r.byKey[key]
+	if !ok {
+		usage = models.DailyUsage{Subject: key.subject, Day: key.day}
+	}
+	return &usage, nil
+}
+
+type memoryUsage struct {
+	mu      sync.Mutex
+	nextID  uint
+	records []models.UsageRecord
+}
+
+func (r *memoryUsage) Create(ctx context.Context, record *models.UsageRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	record.ID = r.nextID
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	r.records = append(r.records, *record)
+	return nil
+}
+
+func (r *memoryUsage) List(ctx context.Context, filter UsageFilter) ([]models.UsageRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var records []models.UsageRecord
+	for _, rec := range r.records {
+		if usageMatches(rec, filter) {
+			records = append(records, rec)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+func (r *memoryUsage) Aggregate(ctx context.Context, filter UsageFilter, groupBy []string) ([]models.UsageTotal, error) {
+	for _, g := range groupBy {
+		if g != GroupByUser && g != GroupByProject && g != GroupByDay {
+			return nil, fmt.Errorf("cannot group usage by %q", g)
+		}
+	}
+	records, _ := r.List(ctx, filter)
+
+	type groupKey struct {
+		userID  uint
+		hasUser bool
+		project string
+		day     string
+	}
+	byKey := make(map[groupKey]*models.UsageTotal)
+	var order []groupKey
+	for _, rec := range records {
+		var key groupKey
+		for _, g := range groupBy {
+			switch g {
+			case GroupByUser:
+				if rec.UserID != nil {
+					key.userID, key.hasUser = *rec.UserID, true
+				}
+			case GroupByProject:
+				key.project = rec.Project
+			case GroupByDay:
+				key.day = rec.CreatedAt.UTC().Format(time.DateOnly)
+			}
+		}
+
+		total, ok := byKey[key]
+		if !ok {
+			total = &models.UsageTotal{Project: key.project, Day: key.day}
+			if key.hasUser {
+				id := key.userID
+				total.UserID = &id
+			}
+			byKey[key] = total
+			order = append(order, key)
+		}
+		total.Runs++
+		total.CPUSeconds += rec.CPUSeconds
+		total.WallSeconds += float64(rec.WallMs) / 1000
+		total.OutputBytes += rec.OutputBytes
+		total.MemoryPeakBytes = max(total.MemoryPeakBytes, rec.MemoryPeakBytes)
+	}
+
+	// like SQL, an ungrouped sum over nothing is one row of zeros
+	if len(groupBy) == 0 && len(order) == 0 {
+		return []models.UsageTotal{{}}, nil
+	}
+	totals := make([]models.UsageTotal, 0, len(order))
+	for _, key := range order {
+		totals = append(totals, *byKey[key])
+	}
+	return totals, nil
+}
+
+func usageMatches(rec models.UsageRecord, filter UsageFilter) bool {
+	if filter.UserID != nil && (rec.UserID == nil || *rec.UserID != *filter.UserID) {
+		return false
+	}
+	if !filter.From.IsZero() && rec.CreatedAt.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !rec.CreatedAt.Before(filter.To) {
+		return false
+	}
+	return true
+}
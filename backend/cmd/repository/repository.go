@@ -0,0 +1,69 @@
+// Package repository hides the database behind small per-model interfaces so
+// handlers can run against Postgres in production and memory in tests.
+package repository
+
+import (
+	"context"
+	"errors"
+	"muhammadyasir-dev/cmd/models"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a lookup matches no record
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicate is returned when a create collides with a unique key
+	ErrDuplicate = errors.New("record already exists")
+)
+
+// UserRepository stores users
+type UserRepository interface {
+	Create(ctx context.Context, user *models.User) error
+	ByID(ctx context.Context, id uint) (*models.User, error)
+	ByEmail(ctx context.Context, email string) (*models.User, error)
+	ByName(ctx context.Context, name string) (*models.User, error)
+}
+
+// DailyUsageRepository keeps the per-day counters that quotas are checked against
+type DailyUsageRepository interface {
+	// Add increments the counters of subject for the UTC day containing day
+	Add(ctx context.Context, subject string, day time.Time, executions int, cpuSeconds float64) error
+	// Reserve counts one execution of subject on the UTC day containing day
+	// unless that day's executions are at maxExecutions or its CPU seconds
+	// at maxCPUSeconds, zero limits being unlimited. The check and the count
+	// are one step, so executions started at once can't overshoot.
+	Reserve(ctx context.Context, subject string, day time.Time, maxExecutions int, maxCPUSeconds float64) (bool, error)
+	// Get returns zero counters, not ErrNotFound, for a day with no usage
+	Get(ctx context.Context, subject string, day time.Time) (*models.DailyUsage, error)
+}
+
+// UsageFilter selects usage records. A nil UserID matches every user; a
+// zero From or To leaves that end of the range open. To is exclusive.
+type UsageFilter struct {
+	UserID *uint
+	From   time.Time
+	To     time.Time
+}
+
+// Dimensions accepted by UsageRepository.Aggregate
+const (
+	GroupByUser    = "user"
+	GroupByProject = "project"
+	GroupByDay     = "day" // UTC calendar day
+)
+
+// UsageRepository stores metered runs
+type UsageRepository interface {
+	Create(ctx context.Context, record *models.UsageRecord) error
+	// List returns matching records, oldest first
+	List(ctx context.Context, filter UsageFilter) ([]models.UsageRecord, error)
+	// Aggregate sums matching records per distinct combination of groupBy
+	Aggregate(ctx context.Context, filter UsageFilter, groupBy []string) ([]models.UsageTotal, error)
+}
+
+// Store bundles the repositories handed to the API server
+type Store struct {
+	Users      UserRepository
+	DailyUsage DailyUsageRepository
+	Usage      UsageRepository
+}
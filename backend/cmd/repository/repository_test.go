@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"muhammadyasir-dev/cmd/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testUsers checks a UserRepository against the behaviour handlers rely on
+func testUsers(t *testing.T, users UserRepository) {
+	ctx := context.Background()
+
+	ada := &models.User{Name: "ada", Email: "ada@example.com"}
+	if err := users.Create(ctx, ada); err != nil {
+		t.Fatal(err)
+	}
+	if ada.ID == 0 {
+		t.Fatal("Create did not assign an ID")
+	}
+	if err := users.Create(ctx, &models.User{Name: "other ada", Email: "ada@example.com"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("creating a second user with the same email = %v, want ErrDuplicate", err)
+	}
+	if err := users.Create(ctx, &models.User{Name: "ada", Email: "ada2@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := users.ByID(ctx, ada.ID); err != nil || got.Email != ada.Email {
+		t.Errorf("ByID = %+v, %v", got, err)
+	}
+	if got, err := users.ByEmail(ctx, "ada@example.com"); err != nil || got.ID != ada.ID {
+		t.Errorf("ByEmail = %+v, %v", got, err)
+	}
+	if got, err := users.ByName(ctx, "ada"); err != nil || got.ID != ada.ID {
+		t.Errorf("ByName = %+v, %v, want the oldest user of that name", got, err)
+	}
+
+	if _, err := users.ByID(ctx, 999); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ByID of a missing user = %v, want ErrNotFound", err)
+	}
+	if _, err := users.ByEmail(ctx, "nobody@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ByEmail of a missing user = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryUsers(t *testing.T) {
+	testUsers(t, NewMemory().Users)
+}
+
+// openSQLite returns an empty in-memory database with the tables of
+// models, to run the GORM repositories without a Postgres server
+func openSQLite(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a database of its own
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestGormUsers(t *testing.T) {
+	testUsers(t, NewGorm(openSQLite(t, &models.User{})).Users)
+}
+
+// testReserve checks that Reserve counts executions up to the limits
+func testReserve(t *testing.T, usage DailyUsageRepository) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	for i := range 3 {
+		ok, err := usage.Reserve(ctx, "user:1", day, 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i < 2; ok != want {
+			t.Errorf("reservation %d = %v, want %v", i+1, ok, want)
+		}
+	}
+	if got, _ := usage.Get(ctx, "user:1", day); got.Executions != 2 {
+		t.Errorf("executions = %d, want the 2 reserved", got.Executions)
+	}
+
+	// the CPU limit holds once it is reached, whatever the executions
+	if err := usage.Add(ctx, "user:2", day, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := usage.Reserve(ctx, "user:2", day, 0, 10); err != nil || ok {
+		t.Errorf("reservation over the CPU limit = %v, %v, want false", ok, err)
+	}
+	if ok, err := usage.Reserve(ctx, "user:2", day, 0, 0); err != nil || !ok {
+		t.Errorf("reservation without limits = %v, %v, want true", ok, err)
+	}
+
+	// giving a reservation back makes room for another
+	if err := usage.Add(ctx, "user:1", day, -1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := usage.Reserve(ctx, "user:1", day, 2, 0); err != nil || !ok {
+		t.Errorf("reservation after a release = %v, %v, want true", ok, err)
+	}
+}
+
+func TestMemoryReserve(t *testing.T) {
+	testReserve(t, NewMemory().DailyUsage)
+}
+
+func TestGormReserve(t *testing.T) {
+	testReserve(t, NewGorm(openSQLite(t, &models.DailyUsage{})).DailyUsage)
+}
+
+func TestMemoryDailyUsage(t *testing.T) {
+	ctx := context.Background()
+	usage := NewMemory().DailyUsage
+	morning := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	got, err := usage.Get(ctx, "user:1", morning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Executions != 0 || got.CPUSeconds != 0 {
+		t.Errorf("usage of an unused day = %+v, want zero counters", got)
+	}
+
+	if err := usage.Add(ctx, "user:1", morning, 1, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := usage.Add(ctx, "user:1", morning.Add(10*time.Hour), 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := usage.Add(ctx, "user:2", morning, 5, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ = usage.Get(ctx, "user:1", morning.Add(time.Hour))
+	if got.Executions != 3 || got.CPUSeconds != 1.5 {
+		t.Errorf("usage of the day = %+v, want 3 executions and 1.5 CPU seconds", got)
+	}
+	if !got.Day.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("day = %v, want midnight UTC", got.Day)
+	}
+
+	got, _ = usage.Get(ctx, "user:1", morning.Add(24*time.Hour))
+	if got.Executions != 0 {
+		t.Errorf("the next day starts with %d executions, want 0", got.Executions)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	ctx := context.Background()
+	usage := NewMemory().Usage
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	one, two := uint(1), uint(2)
+
+	records := []models.UsageRecord{
+		{UserID: &one, Subject: "user:1", Project: "a", CPUSeconds: 1, WallMs: 1000, OutputBytes: 10, MemoryPeakBytes: 100, CreatedAt: day.Add(2 * time.Hour)},
+		{UserID: &one, Subject: "user:1", Project: "b", CPUSeconds: 2, WallMs: 2000, OutputBytes: 20, MemoryPeakBytes: 300, CreatedAt: day.Add(time.Hour)},
+		{UserID: &two, Subject: "user:2", Project: "a", CPUSeconds: 4, WallMs: 4000, OutputBytes: 40, MemoryPeakBytes: 200, CreatedAt: day.Add(26 * time.Hour)},
+		{Subject: "ip:127.0.0.1", Project: "a", CPUSeconds: 8, CreatedAt: day.Add(3 * time.Hour)},
+	}
+	for i := range records {
+		if err := usage.Create(ctx, &records[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := usage.List(ctx, UsageFilter{UserID: &one})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Project != "b" || list[1].Project != "a" {
+		t.Errorf("List by user = %+v, want user 1's records oldest first", list)
+	}
+	list, _ = usage.List(ctx, UsageFilter{From: day.Add(24 * time.Hour)})
+	if len(list) != 1 || list[0].Subject != "user:2" {
+		t.Errorf("List from the second day = %+v", list)
+	}
+	list, _ = usage.List(ctx, UsageFilter{To: day.Add(2 * time.Hour)})
+	if len(list) != 1 || list[0].Project != "b" {
+		t.Errorf("List before an exclusive To = %+v", list)
+	}
+
+	totals, err := usage.Aggregate(ctx, UsageFilter{UserID: &one}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals[0].Runs != 2 || totals[0].CPUSeconds != 3 || totals[0].WallSeconds != 3 || totals[0].OutputBytes != 30 || totals[0].MemoryPeakBytes != 300 {
+		t.Errorf("ungrouped totals = %+v", totals)
+	}
+
+	totals, _ = usage.Aggregate(ctx, UsageFilter{}, []string{GroupByProject, GroupByDay})
+	byGroup := map[string]int64{}
+	for _, total := range totals {
+		byGroup[total.Project+" "+total.Day] = total.Runs
+	}
+	want := map[string]int64{"a 2024-03-01": 2, "b 2024-03-01": 1, "a 2024-03-02": 1}
+	if len(byGroup) != len(want) {
+		t.Errorf("totals by project and day = %v, want %v", byGroup, want)
+	}
+	for group, runs := range want {
+		if byGroup[group] != runs {
+			t.Errorf("%s has %d runs, want %d", group, byGroup[group], runs)
+		}
+	}
+
+	totals, _ = usage.Aggregate(ctx, UsageFilter{UserID: new(uint)}, nil)
+	if len(totals) != 1 || totals[0].Runs != 0 {
+		t.Errorf("totals over nothing = %+v, want one row of zeros", totals)
+	}
+
+	if _, err := usage.Aggregate(ctx, UsageFilter{}, []string{"language"}); err == nil {
+		t.Error("expected an error grouping by an unknown dimension")
+	}
+}
@@ -2,19 +2,47 @@ package routes
 
 import (
 	"muhammadyasir-dev/cmd/handler"
+	"muhammadyasir-dev/cmd/health"
+	"muhammadyasir-dev/cmd/logging"
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/ratelimit"
+	"muhammadyasir-dev/cmd/server"
+	"muhammadyasir-dev/cmd/tracing"
+	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
-func Router() *mux.Router {
+func Router(h *handler.Handler, cfg server.Config, ready *health.Checker, limits *ratelimit.Policy) *mux.Router {
 	router := mux.NewRouter()
-	router.HandleFunc("/stream", handler.PsuedoTerminal).Methods("POST")
+	router.HandleFunc("/healthz", health.Healthz).Methods("GET")
+	router.Handle("/readyz", ready).Methods("GET")
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	router.Use(logging.Middleware(logging.New("http")))
+	router.Use(tracing.Middleware(routeTemplate))
+	router.Use(metrics.Middleware(routeTemplate))
 
-	router.HandleFunc("/signup", handler.Signup).Methods("POST")
+	// commands can run for minutes, so /stream gets the long write deadline
+	router.Handle("/stream", limits.ExecLimited(server.WithTimeouts(cfg.ReadTimeout, cfg.StreamTimeout, http.HandlerFunc(h.PsuedoTerminal)))).Methods("POST")
+	router.Handle("/usage", limits.UsageHandler()).Methods("GET")
+	router.HandleFunc("/usage/aggregate", h.UsageAggregate).Methods("GET")
+	router.HandleFunc("/usage/export", h.UsageExport).Methods("GET")
 
-	router.HandleFunc("/login", handler.LoginHandler).Methods("GET")
-	router.HandleFunc("/auth/callback", handler.CallbackHandler).Methods("GET")
-	router.HandleFunc("/user", handler.GetUserHandler).Methods("GET")
-	router.HandleFunc("/logout", handler.LogoutHandler).Methods("POST")
+	router.Handle("/signup", limits.AuthLimited(http.HandlerFunc(h.Signup))).Methods("POST")
+
+	router.Handle("/login", limits.AuthLimited(http.HandlerFunc(h.LoginHandler))).Methods("GET")
+	router.Handle("/auth/callback", limits.AuthLimited(http.HandlerFunc(h.CallbackHandler))).Methods("GET")
+	router.HandleFunc("/user", h.GetUserHandler).Methods("GET")
+	router.HandleFunc("/logout", h.LogoutHandler).Methods("POST")
 	return router
 }
+
+// routeTemplate labels metrics and spans with the matched path template, not the raw path
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Config holds the listener settings for one service
+type Config struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// StreamTimeout replaces WriteTimeout on routes serving long-lived
+	// responses such as command execution and builds
+	StreamTimeout   time.Duration
+	ShutdownTimeout time.Duration
+	TLSCertFile     string
+	TLSKeyFile      string
+}
+
+// Defaults returns sensible production settings listening on addr
+func Defaults(addr string) Config {
+	return Config{
+		Addr:              addr,
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		StreamTimeout:     10 * time.Minute,
+		ShutdownTimeout:   30 * time.Second,
+	}
+}
+
+// FromEnv overrides cfg with PREFIX_ADDR, PREFIX_READ_TIMEOUT,
+// PREFIX_READ_HEADER_TIMEOUT, PREFIX_WRITE_TIMEOUT, PREFIX_IDLE_TIMEOUT,
+// PREFIX_STREAM_TIMEOUT, PREFIX_SHUTDOWN_TIMEOUT, PREFIX_TLS_CERT and
+// PREFIX_TLS_KEY. Durations use time.ParseDuration syntax ("90s", "5m").
+func FromEnv(prefix string, cfg Config) Config {
+	if v := os.Getenv(prefix + "_ADDR"); v != "" {
+		cfg.Addr = v
+	}
+	envDuration(prefix+"_READ_TIMEOUT", &cfg.ReadTimeout)
+	envDuration(prefix+"_READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout)
+	envDuration(prefix+"_WRITE_TIMEOUT", &cfg.WriteTimeout)
+	envDuration(prefix+"_IDLE_TIMEOUT", &cfg.IdleTimeout)
+	envDuration(prefix+"_STREAM_TIMEOUT", &cfg.StreamTimeout)
+	envDuration(prefix+"_SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	if v := os.Getenv(prefix + "_TLS_CERT"); v != "" {
+		cfg.TLSCertFile = v
+	}
+	if v := os.Getenv(prefix + "_TLS_KEY"); v != "" {
+		cfg.TLSKeyFile = v
+	}
+	return cfg
+}
+
+// TLSEnabled reports whether both a certificate and a key are configured
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+func envDuration(key string, dst *time.Duration) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("ignoring invalid duration", "key", key, "value", v, "error", err)
+		return
+	}
+	*dst = d
+}
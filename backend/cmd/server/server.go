@@ -0,0 +1,202 @@
+// Package server is the HTTP bootstrap shared by the API and the file server:
+// timeouts from the environment, optional TLS, and a graceful shutdown on
+// SIGINT/SIGTERM that drains in-flight executions and long-lived streams.
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"muhammadyasir-dev/cmd/logging"
+)
+
+// Server wraps http.Server with shutdown bookkeeping
+type Server struct {
+	cfg    Config
+	http   *http.Server
+	logger *slog.Logger
+
+	// base is the parent of every request context. It is only cancelled
+	// once the shutdown deadline passes, so draining work is not cut short.
+	base       context.Context
+	cancelBase context.CancelFunc
+
+	mu      sync.Mutex
+	active  int           // tracked work still running
+	drained chan struct{} // closed when active drops to zero during shutdown
+	closing chan struct{}
+	closed  bool
+}
+
+type contextKey struct{}
+
+// New builds a Server for handler. A nil logger means the "server" component logger.
+func New(cfg Config, handler http.Handler, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = logging.New("server")
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		logger:  logger,
+		closing: make(chan struct{}),
+	}
+	s.base, s.cancelBase = context.WithCancel(context.WithValue(context.Background(), contextKey{}, s))
+
+	s.http = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		BaseContext:       func(net.Listener) context.Context { return s.base },
+	}
+	return s
+}
+
+// Run serves until the listener fails or a termination signal arrives, then
+// shuts down gracefully. It returns nil after a clean shutdown.
+func (s *Server) Run() error {
+	errCh := make(chan error, 1)
+	go func() {
+		if s.cfg.TLSEnabled() {
+			s.logger.Info("starting server", "addr", s.cfg.Addr, "tls", true)
+			errCh <- s.http.ListenAndServeTLS(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		} else {
+			s.logger.Info("starting server", "addr", s.cfg.Addr, "tls", false)
+			errCh <- s.http.ListenAndServe()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case sig := <-signals:
+		s.logger.Info("shutting down", "signal", sig.String(), "grace_period", s.cfg.ShutdownTimeout.String())
+	}
+
+	return s.Shutdown()
+}
+
+// Shutdown stops accepting connections, signals long-lived streams to wrap
+// up, and waits for requests and tracked work to finish. Whatever is still
+// running when ShutdownTimeout expires has its context cancelled.
+func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.closing)
+	}
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+		if s.active == 0 {
+			close(s.drained)
+		}
+	}
+	drained := s.drained
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	defer s.cancelBase()
+
+	err := s.http.Shutdown(ctx)
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		s.logger.Error("graceful shutdown incomplete, aborting remaining work", "error", err)
+		s.cancelBase()
+		s.http.Close()
+		return err
+	}
+	s.logger.Info("server stopped")
+	return nil
+}
+
+// track registers one unit of in-flight work
+func (s *Server) track() func() {
+	s.mu.Lock()
+	s.active++
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.active--
+			if s.active == 0 && s.drained != nil {
+				select {
+				case <-s.drained:
+				default:
+					close(s.drained)
+				}
+			}
+		})
+	}
+}
+
+// Track registers work that must finish before the server owning ctx exits,
+// such as a container exec or a hijacked WebSocket. Call the returned
+// function when the work is done. Contexts that do not come from a Server
+// request get a no-op.
+func Track(ctx context.Context) func() {
+	if s, ok := ctx.Value(contextKey{}).(*Server); ok {
+		return s.track()
+	}
+	return func() {}
+}
+
+// ShuttingDown returns a channel that is closed once the server owning ctx
+// begins shutting down. Streams should finish their current message and
+// close when it fires. Outside a Server request it never fires.
+func ShuttingDown(ctx context.Context) <-chan struct{} {
+	if s, ok := ctx.Value(contextKey{}).(*Server); ok {
+		return s.closing
+	}
+	return nil
+}
+
+// WithTimeouts overrides the server-wide read and write deadlines for one
+// route, typically a long-running exec or a stream. Zero removes the deadline.
+func WithTimeouts(read, write time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(deadline(read)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			slog.ErrorContext(r.Context(), "failed to set read deadline", "error", err)
+		}
+		if err := rc.SetWriteDeadline(deadline(write)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			slog.ErrorContext(r.Context(), "failed to set write deadline", "error", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
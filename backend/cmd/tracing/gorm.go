@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// GormPlugin traces every gorm operation as a client span. Register it with
+// db.Use; spans are children of the context passed to WithContext.
+type GormPlugin struct{}
+
+const gormSpanKey = "tracing:span"
+
+func (GormPlugin) Name() string { return "tracing" }
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("tracing:before_create", startDBSpan("create")),
+		cb.Create().After("gorm:create").Register("tracing:after_create", endDBSpan),
+		cb.Query().Before("gorm:query").Register("tracing:before_query", startDBSpan("query")),
+		cb.Query().After("gorm:query").Register("tracing:after_query", endDBSpan),
+		cb.Update().Before("gorm:update").Register("tracing:before_update", startDBSpan("update")),
+		cb.Update().After("gorm:update").Register("tracing:after_update", endDBSpan),
+		cb.Delete().Before("gorm:delete").Register("tracing:before_delete", startDBSpan("delete")),
+		cb.Delete().After("gorm:delete").Register("tracing:after_delete", endDBSpan),
+		cb.Row().Before("gorm:row").Register("tracing:before_row", startDBSpan("row")),
+		cb.Row().After("gorm:row").Register("tracing:after_row", endDBSpan),
+		cb.Raw().Before("gorm:raw").Register("tracing:before_raw", startDBSpan("raw")),
+		cb.Raw().After("gorm:raw").Register("tracing:after_raw", endDBSpan),
+	)
+}
+
+func startDBSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement.Context == nil {
+			return
+		}
+		ctx, span := otel.Tracer(instrumentationName).Start(db.Statement.Context, "db."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL),
+		)
+		db.Statement.Context = ctx
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endDBSpan(db *gorm.DB) {
+	v, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	span.SetAttributes(
+		attribute.String("db.sql.table", db.Statement.Table),
+		semconv.DBQueryText(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+
+	// a missing row is an answer, not a failure
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+}
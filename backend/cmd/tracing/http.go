@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/felixge/httpsnoop"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"muhammadyasir-dev/cmd/logging"
+)
+
+// Middleware starts a server span for every request, continuing a trace
+// passed in by the caller's traceparent header. routeOf works as for
+// metrics.Middleware and names the span once the route is known.
+func Middleware(routeOf func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := otel.Tracer(instrumentationName).Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+					attribute.String("request_id", logging.RequestID(ctx)),
+				),
+			)
+			defer span.End()
+
+			r = r.WithContext(ctx)
+			m := httpsnoop.CaptureMetrics(next, w, r)
+
+			if route := routeOf(r); route != "" {
+				span.SetName(r.Method + " " + route)
+				span.SetAttributes(semconv.HTTPRoute(route))
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(m.Code))
+			if m.Code >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, strconv.Itoa(m.Code))
+			}
+		})
+	}
+}
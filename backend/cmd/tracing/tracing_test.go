@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return recorder
+}
+
+func TestInitExporters(t *testing.T) {
+	for _, exporter := range []string{"", "none", "stdout"} {
+		t.Setenv("OTEL_TRACES_EXPORTER", exporter)
+		shutdown, err := Init(context.Background(), "test")
+		if err != nil {
+			t.Fatalf("exporter %q: %v", exporter, err)
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("exporter %q shutdown: %v", exporter, err)
+		}
+	}
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "carrier-pigeon")
+	if _, err := Init(context.Background(), "test"); err == nil {
+		t.Error("expected an error for an unknown exporter")
+	}
+}
+
+func TestAMQPHeadersContinueTrace(t *testing.T) {
+	recordSpans(t)
+
+	ctx, span := Start(context.Background(), "publish")
+	headers := amqp.Table{}
+	InjectAMQP(ctx, headers)
+	span.End()
+
+	got := trace.SpanContextFromContext(ExtractAMQP(context.Background(), headers))
+	if got.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("consumer trace %s does not continue publisher trace %s", got.TraceID(), span.SpanContext().TraceID())
+	}
+}
+
+func TestMiddlewareNamesSpanAfterRoute(t *testing.T) {
+	recorder := recordSpans(t)
+
+	handler := Middleware(func(*http.Request) string { return "/files/{name}" })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}),
+	)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/files/a.go", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name() != "GET /files/{name}" {
+		t.Errorf("unexpected span name %q", spans[0].Name())
+	}
+	if spans[0].Status().Code.String() != "Error" {
+		t.Errorf("a 500 should mark the span failed, got %v", spans[0].Status())
+	}
+}
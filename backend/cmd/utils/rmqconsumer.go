@@ -1,32 +1,86 @@
 package utils
 
 import (
+	"context"
+	"fmt"
+	"muhammadyasir-dev/cmd/logging"
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/tracing"
+
 	amqp "github.com/rabbitmq/amqp091-go"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
 )
-conn, err := amqp.Dial("amqp://guest:guest://localhost:5672")
-failOnError(err, "failed to listen rabbitmqe")
 
-defer conn.Close()
+// Consume delivers messages from queue to handle until ctx is cancelled or
+// the connection drops. A message is acked when handle returns nil and
+// dead-lettered (nacked without requeue) when it returns an error. The
+// context passed to handle carries the publishing request's ID and continues
+// its trace.
+func Consume(ctx context.Context, url, queue string, handle func(context.Context, amqp.Delivery) error) error {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return fmt.Errorf("failed to connect to broker: %v", err)
+	}
+	defer conn.Close()
 
-ch, err := conn.Channel()
-failOnError(err, "failed to listen rabbitmqe")
-defer ch.Close()
-q, err := ch.QueueDeclare(
-"hello",
-false,
-false,
-false,
-false,
-nil,
-	)
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %v", err)
+	}
+	defer ch.Close()
 
-failOnError(err, "failed to make que")
+	q, err := ch.QueueDeclare(queue, true, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to declare queue: %v", err)
+	}
+	metrics.QueueDepth.WithLabelValues(queue).Set(float64(q.Messages))
 
-}
+	deliveries, err := ch.ConsumeWithContext(ctx, queue, "", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to consume: %v", err)
+	}
 
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-deliveries:
+			if !ok {
+				return fmt.Errorf("delivery channel for %s closed", queue)
+			}
 
-func failOnError(error error, msg string){
+			msgCtx := tracing.ExtractAMQP(ctx, d.Headers)
+			if id := deliveryRequestID(d); id != "" {
+				msgCtx = logging.WithRequestID(msgCtx, id)
+			}
+			msgCtx, span := tracing.Start(msgCtx, "amqp.consume "+queue,
+				attribute.String("messaging.system", "rabbitmq"),
+				attribute.String("messaging.destination.name", queue),
+			)
 
+			err := handle(msgCtx, d)
+			tracing.End(span, err)
+			if err != nil {
+				metrics.Jobs.WithLabelValues(queue, "failed").Inc()
+				queueLog.ErrorContext(msgCtx, "message handling failed", "queue", queue, "error", err)
+				d.Nack(false, false)
+			} else {
+				metrics.Jobs.WithLabelValues(queue, "succeeded").Inc()
+				d.Ack(false)
+			}
+
+			if q, err := ch.QueueDeclare(queue, true, false, false, false, nil); err == nil {
+				metrics.QueueDepth.WithLabelValues(queue).Set(float64(q.Messages))
+			}
+		}
+	}
 }
 
+// deliveryRequestID reads the request ID set by Publisher.Publish
+func deliveryRequestID(d amqp.Delivery) string {
+	if d.CorrelationId != "" {
+		return d.CorrelationId
+	}
+	id, _ := d.Headers[requestIDHeader].(string)
+	return id
+}
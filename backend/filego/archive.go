@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	"xxx/projects"
+
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// Defaults for IMPORT_MAX_UPLOAD_BYTES, IMPORT_MAX_FILES and IMPORT_MAX_UNPACKED_BYTES
+const (
+	defaultImportUploadBytes   = 50 << 20
+	defaultImportFiles         = 5000
+	defaultImportUnpackedBytes = 200 << 20
+)
+
+// ImportResponse lists the files an import wrote
+type ImportResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Files   []string `json:"files"`
+}
+
+// envInt64 reads a non-negative integer from key, falling back to def
+func envInt64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		slog.Warn("ignoring invalid limit", "key", key, "value", v)
+		return def
+	}
+	return n
+}
+
+// importHandler unpacks an uploaded zip or tar.gz, sent as the raw request
+// body, into a project. ?strip=1 drops a single top-level directory.
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+		return
+	}
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	// zip needs random access, so the upload is spooled to disk first
+	upload, err := os.CreateTemp("", "wasmide-import-*")
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error creating import spool file", "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error importing project",
+		})
+		return
+	}
+	defer os.Remove(upload.Name())
+	defer upload.Close()
+
+	maxUpload := envInt64("IMPORT_MAX_UPLOAD_BYTES", defaultImportUploadBytes)
+	size, err := io.Copy(upload, http.MaxBytesReader(w, r.Body, maxUpload))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.jsonResponse(w, http.StatusRequestEntityTooLarge, FileResponse{
+			Success: false,
+			Message: "Archive is larger than " + strconv.FormatInt(maxUpload, 10) + " bytes",
+		})
+		return
+	}
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Error reading request body",
+		})
+		return
+	}
+
+	limits := projects.ImportLimits{
+		MaxFiles: int(envInt64("IMPORT_MAX_FILES", defaultImportFiles)),
+		MaxBytes: envInt64("IMPORT_MAX_UNPACKED_BYTES", defaultImportUnpackedBytes),
+	}
+	files, err := s.projects.Import(project.ID, upload, size, limits, r.URL.Query().Get("strip") == "1")
+	metrics.ObserveFile("import", err)
+	var archiveErr *projects.ArchiveError
+	switch {
+	case errors.As(err, &archiveErr), errors.Is(err, projects.ErrUnsupportedArchive):
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		s.logger.ErrorContext(r.Context(), "error importing project", "project", project.ID, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error importing project",
+		})
+		return
+	}
+
+	s.logger.InfoContext(r.Context(), "project imported", "project", project.ID, "files", len(files), "bytes", size)
+	s.jsonResponse(w, http.StatusOK, ImportResponse{
+		Success: true,
+		Message: "Imported " + strconv.Itoa(len(files)) + " files",
+		Files:   files,
+	})
+}
+
+// exportHandler streams a project as ?format=zip (the default) or tar.gz.
+// Build outputs are included with ?artifacts=1.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+		return
+	}
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var contentType string
+	switch format {
+	case "", projects.FormatZip:
+		format, contentType = projects.FormatZip, "application/zip"
+	case projects.FormatTarGz, "tgz":
+		format, contentType = projects.FormatTarGz, "application/gzip"
+	default:
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "format must be zip or tar.gz",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+project.ID+"."+format+`"`)
+	// the archive is streamed, so a failure part way can only be logged
+	err := s.projects.Export(project.ID, w, format, r.URL.Query().Get("artifacts") == "1")
+	metrics.ObserveFile("export", err)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error exporting project", "project", project.ID, "error", err)
+	}
+}
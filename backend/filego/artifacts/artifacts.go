@@ -0,0 +1,306 @@
+// Package artifacts keeps what project builds produce: the compiled module,
+// its source map and the build log, per project and build, with a SHA-256
+// of every file and the hash of the sources it was built from.
+package artifacts
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// manifestFile describes a build inside its directory
+const manifestFile = "build.json"
+
+// LogFile is the name the build log is stored under
+const LogFile = "build.log"
+
+// Kinds of stored files
+const (
+	KindModule    = "wasm"
+	KindSourceMap = "sourcemap"
+	KindLog       = "log"
+)
+
+var (
+	// ErrNotFound is returned for a build or file that is not stored
+	ErrNotFound = errors.New("artifact not found")
+	// ErrNotRegular is returned by Save for a build output that is not a
+	// regular file inside the project, such as a link to a file of the host
+	ErrNotRegular = errors.New("build output is not a regular file in the project")
+
+	validBuildID = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[0-9a-f]{8}$`)
+)
+
+// File is one stored artifact
+type File struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Build is one stored build of a project
+type Build struct {
+	ID         string    `json:"id"`
+	Project    string    `json:"project"`
+	SourceHash string    `json:"source_hash"`
+	Command    string    `json:"command"`
+	Success    bool      `json:"success"`
+	Artifact   string    `json:"artifact,omitempty"` // path of the module in the project
+	CreatedAt  time.Time `json:"created_at"`
+	Files      []File    `json:"files"`
+}
+
+// Module returns the stored compiled module, if the build produced one
+func (b *Build) Module() (File, bool) {
+	for _, f := range b.Files {
+		if f.Kind == KindModule {
+			return f, true
+		}
+	}
+	return File{}, false
+}
+
+// Retention bounds how many builds are kept per project and for how long.
+// Zero disables that limit.
+type Retention struct {
+	KeepBuilds int
+	MaxAge     time.Duration
+}
+
+// Store keeps builds under Root/<project>/<build>
+type Store struct {
+	Root      string
+	Retention Retention
+	now       func() time.Time
+}
+
+// NewStore returns a store rooted at root, creating it if needed
+func NewStore(root string, retention Retention) (*Store, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{Root: root, Retention: retention, now: time.Now}, nil
+}
+
+// newBuildID returns an ID that sorts by creation time
+func (s *Store) newBuildID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return s.now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// buildDir returns where build id of project is stored. The project ID is
+// validated by the caller; build IDs come from clients, so are checked here.
+func (s *Store) buildDir(project, id string) (string, error) {
+	if !validBuildID.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.Root, project, id), nil
+}
+
+// Save stores a build of the project in projectDir: its log and, when it
+// succeeded, the module at build.Artifact and its source map if present.
+// It fills in the build's ID, time and files, then applies the retention
+// limits.
+func (s *Store) Save(projectDir string, build *Build, log []byte) error {
+	build.ID = s.newBuildID()
+	build.CreatedAt = s.now().UTC()
+	build.Files = []File{}
+
+	dir := filepath.Join(s.Root, build.Project, build.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if build.Success && build.Artifact != "" {
+		src := filepath.FromSlash(build.Artifact)
+		for _, candidate := range []struct{ path, kind string }{
+			{src, KindModule},
+			{src + ".map", KindSourceMap},
+		} {
+			f, err := openOutput(projectDir, candidate.path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				os.RemoveAll(dir)
+				return err
+			}
+			stored, err := store(dir, filepath.Base(candidate.path), candidate.kind, f)
+			f.Close()
+			if err != nil {
+				os.RemoveAll(dir)
+				return err
+			}
+			build.Files = append(build.Files, stored)
+		}
+	}
+
+	stored, err := store(dir, LogFile, KindLog, bytes.NewReader(log))
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	build.Files = append(build.Files, stored)
+
+	data, err := json.MarshalIndent(build, "", "  ")
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFile), data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return s.prune(build.Project)
+}
+
+// openOutput opens the build output at rel in projectDir, which must be a
+// regular file whose directory resolves inside the project: the build runs
+// the project's own commands, which could leave a link to any file the
+// server can read
+func openOutput(projectDir, rel string) (*os.File, error) {
+	root, err := filepath.EvalSymlinks(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(filepath.Join(root, rel)))
+	if err != nil {
+		return nil, err
+	}
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return nil, ErrNotRegular
+	}
+	// O_NONBLOCK keeps a named pipe from blocking the open
+	f, err := os.OpenFile(filepath.Join(dir, filepath.Base(rel)), os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, ErrNotRegular
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		if err == nil {
+			err = ErrNotRegular
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// store copies src into dir/name, hashing it on the way
+func store(dir, name, kind string, src io.Reader) (File, error) {
+	dst, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return File{}, err
+	}
+	defer dst.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hash), src)
+	if err != nil {
+		return File{}, err
+	}
+	if err := dst.Close(); err != nil {
+		return File{}, err
+	}
+	return File{Name: name, Kind: kind, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// List returns the stored builds of project, newest first
+func (s *Store) List(project string) ([]*Build, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Root, project))
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Build{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	builds := []*Build{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validBuildID.MatchString(entry.Name()) {
+			continue
+		}
+		build, err := s.Get(project, entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			continue // still being written, or half removed
+		}
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, build)
+	}
+	sort.Slice(builds, func(i, j int) bool { return builds[i].ID > builds[j].ID })
+	return builds, nil
+}
+
+// Get loads build id of project
+func (s *Store) Get(project, id string) (*Build, error) {
+	dir, err := s.buildDir(project, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var build Build
+	if err := json.Unmarshal(data, &build); err != nil {
+		return nil, fmt.Errorf("build %s/%s: %w", project, id, err)
+	}
+	return &build, nil
+}
+
+// Open opens a file of build id of project
+func (s *Store) Open(project, id, name string) (*os.File, File, error) {
+	build, err := s.Get(project, id)
+	if err != nil {
+		return nil, File{}, err
+	}
+	for _, file := range build.Files {
+		if file.Name == name {
+			dir, _ := s.buildDir(project, id)
+			f, err := os.Open(filepath.Join(dir, name))
+			return f, file, err
+		}
+	}
+	return nil, File{}, ErrNotFound
+}
+
+// prune removes the builds of project beyond the retention limits
+func (s *Store) prune(project string) error {
+	builds, err := s.List(project)
+	if err != nil {
+		return err
+	}
+	cutoff := s.now().Add(-s.Retention.MaxAge)
+	for i, build := range builds {
+		tooMany := s.Retention.KeepBuilds > 0 && i >= s.Retention.KeepBuilds
+		tooOld := s.Retention.MaxAge > 0 && build.CreatedAt.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.Root, project, build.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
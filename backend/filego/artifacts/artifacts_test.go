@@ -0,0 +1,92 @@
+package artifacts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndRetention(t *testing.T) {
+	project := t.TempDir()
+	os.MkdirAll(filepath.Join(project, "out"), 0755)
+	os.WriteFile(filepath.Join(project, "out", "app.wasm"), []byte("\x00asm\x01\x00\x00\x00"), 0644)
+	os.WriteFile(filepath.Join(project, "out", "app.wasm.map"), []byte(`{"version":3}`), 0644)
+
+	store, err := NewStore(t.TempDir(), Retention{KeepBuilds: 2, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	build := &Build{Project: "demo", SourceHash: "abc", Command: "make", Success: true, Artifact: "out/app.wasm"}
+	if err := store.Save(project, build, []byte("compiled\n")); err != nil {
+		t.Fatal(err)
+	}
+	if len(build.Files) != 3 {
+		t.Fatalf("expected module, source map and log, got %+v", build.Files)
+	}
+	module, ok := build.Module()
+	// sha256 of the 8-byte empty module header
+	if !ok || module.Name != "app.wasm" || module.Size != 8 || module.SHA256 != "93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476" {
+		t.Errorf("unexpected module %+v", module)
+	}
+
+	failed := &Build{Project: "demo", SourceHash: "abc", Command: "make", Success: false, Artifact: "out/app.wasm"}
+	now = now.Add(time.Minute)
+	if err := store.Save(project, failed, []byte("error\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := failed.Module(); ok {
+		t.Error("a failed build must not store a module")
+	}
+
+	now = now.Add(time.Minute)
+	if err := store.Save(project, &Build{Project: "demo", SourceHash: "def", Success: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	builds, err := store.List("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 2 || builds[1].ID != failed.ID {
+		t.Errorf("expected the two newest builds to be kept, got %d", len(builds))
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := store.Save(project, &Build{Project: "demo", Success: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if builds, _ := store.List("demo"); len(builds) != 1 {
+		t.Errorf("expected builds older than an hour to be removed, got %d", len(builds))
+	}
+
+	if _, err := store.Get("demo", "../../etc"); err != ErrNotFound {
+		t.Errorf("expected invalid build IDs to be not found, got %v", err)
+	}
+}
+
+func TestSaveRefusesLinkedOutputs(t *testing.T) {
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.wasm"), []byte("secret"), 0644)
+
+	project := t.TempDir()
+	os.Symlink(filepath.Join(outside, "secret.wasm"), filepath.Join(project, "app.wasm"))
+	os.Symlink(outside, filepath.Join(project, "out"))
+
+	store, err := NewStore(t.TempDir(), Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, artifact := range []string{"app.wasm", "out/secret.wasm", "../" + filepath.Base(outside) + "/secret.wasm"} {
+		build := &Build{Project: "demo", Success: true, Artifact: artifact}
+		if err := store.Save(project, build, nil); !errors.Is(err, ErrNotRegular) {
+			t.Errorf("%s: expected ErrNotRegular, got %v", artifact, err)
+		}
+	}
+	if builds, _ := store.List("demo"); len(builds) != 0 {
+		t.Errorf("expected no build to be stored, got %d", len(builds))
+	}
+}
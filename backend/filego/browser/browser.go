@@ -0,0 +1,153 @@
+// Package browser builds projects for the browser rather than WASI: a wasm
+// module, the JavaScript glue its toolchain generates to load it, and an
+// HTML harness that runs it and shows its output, all written to Dir.
+package browser
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is where a project's browser bundle is written, relative to its root.
+// The server owns it and replaces it on every build, so it is named not to
+// clash with a directory of the project's own.
+const Dir = ".wasmide-preview"
+
+// Loaders, which say how the harness starts a module
+const (
+	LoaderBindgen    = "bindgen"    // wasm-bindgen's ES module
+	LoaderGo         = "go"         // wasm_exec.js from Go or TinyGo
+	LoaderEmscripten = "emscripten" // the glue emcc writes next to the module
+	LoaderWASI       = "wasi"       // wasi.js, a small WASI shim printing to the page
+)
+
+// ErrUnsupported is returned for languages without a browser target
+var ErrUnsupported = errors.New("no browser build for this language")
+
+//go:embed harness.html wasi.js
+var assets embed.FS
+
+var harness = template.Must(template.ParseFS(assets, "harness.html"))
+
+// Target says how a language is built for the browser
+type Target struct {
+	// Build is the shell command writing the bundle into Dir, run from the
+	// project root. It is empty for WAT, which the server assembles itself.
+	Build  string
+	Module string // the module Build produces, relative to the project root
+	Loader string
+}
+
+// goWasmExec copies wasm_exec.js, which moved from misc/wasm to lib/wasm
+// in Go 1.24
+const goWasmExec = `cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" ` + Dir + `/ 2>/dev/null || cp "$(go env GOROOT)/misc/wasm/wasm_exec.js" ` + Dir + `/`
+
+// TargetFor returns the browser target of a project in language. artifact
+// is its WASI build output, from which the Rust crate name is taken.
+func TargetFor(language, artifact string) (Target, error) {
+	module := Dir + "/app.wasm"
+	switch language {
+	case "rust":
+		crate := "app"
+		if artifact != "" {
+			// Cargo names the module after the crate, with - replaced
+			crate = strings.ReplaceAll(strings.TrimSuffix(path.Base(artifact), ".wasm"), "-", "_")
+		}
+		return Target{
+			Build: "cargo build --release --target wasm32-unknown-unknown && " +
+				"wasm-bindgen --target web --no-typescript --out-dir " + Dir + " --out-name app " +
+				"target/wasm32-unknown-unknown/release/" + crate + ".wasm",
+			Module: Dir + "/app_bg.wasm",
+			Loader: LoaderBindgen,
+		}, nil
+	case "go":
+		return Target{
+			Build:  "GOOS=js GOARCH=wasm go build -o " + module + " . && " + goWasmExec,
+			Module: module,
+			Loader: LoaderGo,
+		}, nil
+	case "tinygo":
+		return Target{
+			Build:  "tinygo build -target=wasm -opt=z -o " + module + ` . && cp "$(tinygo env TINYGOROOT)/targets/wasm_exec.js" ` + Dir + "/",
+			Module: module,
+			Loader: LoaderGo,
+		}, nil
+	case "c", "c++":
+		compiler := "emcc"
+		if language == "c++" {
+			compiler = "em++"
+		}
+		return Target{
+			Build:  compiler + " -O2 -sEXIT_RUNTIME=1 -o " + Dir + "/app.js $(find . -path ./" + Dir + " -prune -o \\( -name '*.c' -o -name '*.cpp' -o -name '*.cc' \\) -print)",
+			Module: module,
+			Loader: LoaderEmscripten,
+		}, nil
+	case "assemblyscript":
+		return Target{
+			Build:  "npm install --no-audit --no-fund && npx asc assembly/index.ts --target release --outFile " + module,
+			Module: module,
+			Loader: LoaderWASI,
+		}, nil
+	case "wat":
+		return Target{Module: module, Loader: LoaderWASI}, nil
+	}
+	return Target{}, fmt.Errorf("%w: %s", ErrUnsupported, language)
+}
+
+// ErrLinked is returned by WriteHarness when the bundle directory, or a file
+// it writes, was replaced by something other than what it expects, such as a
+// link the build left to a file of the host
+var ErrLinked = errors.New("browser bundle directory is not a plain directory")
+
+// WriteHarness writes index.html, and the WASI shim when the target needs
+// it, into the bundle directory dir. The build ran the project's own
+// commands in dir, so files there are replaced rather than written through.
+func WriteHarness(dir string, t Target, title string) error {
+	if info, err := os.Lstat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return ErrLinked
+	}
+	var page strings.Builder
+	err := harness.Execute(&page, struct {
+		Title, Loader, Module string
+	}{title, t.Loader, path.Base(t.Module)})
+	if err == nil {
+		err = replaceFile(filepath.Join(dir, "index.html"), []byte(page.String()))
+	}
+	if err != nil || t.Loader != LoaderWASI {
+		return err
+	}
+	shim, err := assets.ReadFile("wasi.js")
+	if err != nil {
+		return err
+	}
+	return replaceFile(filepath.Join(dir, "wasi.js"), shim)
+}
+
+// replaceFile writes data to a new file renamed over name, so a link at
+// name is replaced, not followed
+func replaceFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".harness-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
@@ -0,0 +1,75 @@
+package browser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTargetFor(t *testing.T) {
+	rust, err := TargetFor("rust", "target/wasm32-wasip1/release/my-app.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rust.Build, "release/my_app.wasm") || rust.Module != Dir+"/app_bg.wasm" {
+		t.Errorf("rust target: %+v", rust)
+	}
+	if _, err := TargetFor("cobol", ""); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestWriteHarness(t *testing.T) {
+	dir := t.TempDir()
+	target, _ := TargetFor("wat", "")
+	if err := WriteHarness(dir, target, "demo <1>"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := os.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`run("app.wasm", print)`, "<title>demo &lt;1&gt;</title>"} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("index.html does not contain %s:\n%s", want, index)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wasi.js")); err != nil {
+		t.Error("the WASI shim was not written")
+	}
+
+	dir = t.TempDir()
+	target, _ = TargetFor("go", "")
+	if err := WriteHarness(dir, target, "go"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wasi.js")); !os.IsNotExist(err) {
+		t.Error("the WASI shim was written for Go")
+	}
+}
+
+func TestWriteHarnessReplacesLinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "host.html")
+	os.WriteFile(outside, []byte("host"), 0644)
+	dir := t.TempDir()
+	os.Symlink(outside, filepath.Join(dir, "index.html"))
+
+	target, _ := TargetFor("wat", "")
+	if err := WriteHarness(dir, target, "demo"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(outside); string(data) != "host" {
+		t.Errorf("the harness was written through the link: %q", data)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "index.html")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("expected the link to be replaced, got %v", err)
+	}
+
+	linked := filepath.Join(t.TempDir(), "bundle")
+	os.Symlink(t.TempDir(), linked)
+	if err := WriteHarness(linked, target, "demo"); !errors.Is(err, ErrLinked) {
+		t.Errorf("expected a linked bundle directory to be refused, got %v", err)
+	}
+}
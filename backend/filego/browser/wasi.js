@@ -0,0 +1,114 @@
+// A small wasi_snapshot_preview1 for modules built for WASI: stdout and
+// stderr are printed to the page, there is no stdin, no arguments, no
+// environment and no filesystem. Functions it does not implement return
+// ENOSYS.
+
+const ESUCCESS = 0;
+const EBADF = 8;
+const ENOSYS = 52;
+
+class Exit extends Error {
+  constructor(code) {
+    super("exit status " + code);
+    this.code = code;
+  }
+}
+
+export async function run(url, print) {
+  let instance;
+  const { imports, flush } = wasi(() => instance.exports.memory, print);
+  ({ instance } = await WebAssembly.instantiateStreaming(fetch(url), {
+    wasi_snapshot_preview1: new Proxy(imports, {
+      get: (target, name) => target[name] ?? (() => ENOSYS),
+    }),
+  }));
+  try {
+    if (instance.exports._start) instance.exports._start();
+    else if (instance.exports.main) instance.exports.main();
+  } catch (err) {
+    if (!(err instanceof Exit)) throw err;
+    if (err.code !== 0) print(err.message, "stderr");
+  } finally {
+    flush();
+  }
+}
+
+function wasi(memory, print) {
+  const view = () => new DataView(memory().buffer);
+  const decoders = { 1: new TextDecoder(), 2: new TextDecoder() };
+  const pending = { 1: "", 2: "" };
+
+  const flush = () => {
+    for (const fd of [1, 2]) {
+      if (pending[fd]) print(pending[fd], fd === 1 ? "stdout" : "stderr");
+      pending[fd] = "";
+    }
+  };
+
+  const imports = {
+    fd_write(fd, iovs, iovsLen, written) {
+      if (!(fd in decoders)) return EBADF;
+      const dv = view();
+      let total = 0;
+      for (let i = 0; i < iovsLen; i++) {
+        const ptr = dv.getUint32(iovs + i * 8, true);
+        const len = dv.getUint32(iovs + i * 8 + 4, true);
+        pending[fd] += decoders[fd].decode(new Uint8Array(memory().buffer, ptr, len), { stream: true });
+        total += len;
+      }
+      // print whole lines; the rest waits for its newline
+      const end = pending[fd].lastIndexOf("\n");
+      if (end >= 0) {
+        print(pending[fd].slice(0, end), fd === 1 ? "stdout" : "stderr");
+        pending[fd] = pending[fd].slice(end + 1);
+      }
+      dv.setUint32(written, total, true);
+      return ESUCCESS;
+    },
+    fd_read(fd, iovs, iovsLen, read) {
+      if (fd !== 0) return EBADF;
+      view().setUint32(read, 0, true);
+      return ESUCCESS;
+    },
+    fd_close: () => ESUCCESS,
+    fd_fdstat_get(fd, stat) {
+      if (fd > 2) return EBADF;
+      const dv = view();
+      dv.setUint8(stat, 2); // character device
+      dv.setUint16(stat + 2, 0, true);
+      dv.setBigUint64(stat + 8, 0n, true);
+      dv.setBigUint64(stat + 16, 0n, true);
+      return ESUCCESS;
+    },
+    fd_prestat_get: () => EBADF,
+    args_sizes_get(count, size) {
+      view().setUint32(count, 0, true);
+      view().setUint32(size, 0, true);
+      return ESUCCESS;
+    },
+    args_get: () => ESUCCESS,
+    environ_sizes_get(count, size) {
+      view().setUint32(count, 0, true);
+      view().setUint32(size, 0, true);
+      return ESUCCESS;
+    },
+    environ_get: () => ESUCCESS,
+    clock_time_get(id, precision, time) {
+      const now = id === 0 ? Date.now() * 1e6 : performance.now() * 1e6;
+      view().setBigUint64(time, BigInt(Math.round(now)), true);
+      return ESUCCESS;
+    },
+    random_get(buf, len) {
+      // getRandomValues fills at most 64 KiB at a time
+      for (let off = 0; off < len; off += 65536) {
+        crypto.getRandomValues(new Uint8Array(memory().buffer, buf + off, Math.min(65536, len - off)));
+      }
+      return ESUCCESS;
+    },
+    sched_yield: () => ESUCCESS,
+    proc_exit(code) {
+      throw new Exit(code);
+    },
+  };
+  return { imports, flush };
+}
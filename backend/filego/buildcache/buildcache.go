@@ -0,0 +1,342 @@
+// Package buildcache is a local content-addressed store of compiled
+// modules. Entries are keyed by everything that decides a build's output
+// (sources, toolchain version, build command and flags) and point at blobs
+// named by their SHA-256, so identical modules are stored once.
+package buildcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var validHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ErrNotRegular is returned for a module that is not a regular file inside
+// the project, such as a link to a file of the host
+var ErrNotRegular = errors.New("module is not a regular file in the project")
+
+// Key hashes the parts that determine a build's output. Parts are length
+// prefixed, so ("ab", "c") and ("a", "bc") differ.
+func Key(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		io.WriteString(hash, strconv.Itoa(len(part))+":"+part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Entry is one cached build
+type Entry struct {
+	Key       string    `json:"key"`
+	Artifact  string    `json:"artifact"`             // path of the module in the project
+	Module    string    `json:"module"`               // blob of the module
+	SourceMap string    `json:"source_map,omitempty"` // blob of Artifact + ".map", if the build wrote one
+	Build     string    `json:"build,omitempty"`      // stored build that produced it
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Cache stores entries under Root/keys and blobs under Root/blobs. When
+// the blobs grow past MaxBytes the least recently used entries are evicted;
+// zero means unbounded.
+type Cache struct {
+	Root     string
+	MaxBytes int64
+
+	mu sync.Mutex // serialises writes and eviction
+}
+
+// New returns a cache rooted at root, creating it if needed
+func New(root string, maxBytes int64) (*Cache, error) {
+	for _, dir := range []string{"keys", "blobs"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Cache{Root: root, MaxBytes: maxBytes}, nil
+}
+
+func (c *Cache) entryPath(key string) string {
+	return filepath.Join(c.Root, "keys", key+".json")
+}
+
+func (c *Cache) blobPath(hash string) string {
+	return filepath.Join(c.Root, "blobs", hash[:2], hash)
+}
+
+// Get returns the entry for key when it and its blobs are present, and marks
+// it as recently used
+func (c *Cache) Get(key string) (*Entry, bool) {
+	if !validHash.MatchString(key) {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.entryPath(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil || !validHash.MatchString(entry.Module) {
+		return nil, false
+	}
+	if _, err := os.Stat(c.blobPath(entry.Module)); err != nil {
+		return nil, false
+	}
+	// the entry's modification time is its last use, for eviction
+	now := time.Now()
+	os.Chtimes(c.entryPath(key), now, now)
+	return &entry, true
+}
+
+// Put caches the module at artifact in projectDir, and its source map if
+// present, under key
+func (c *Cache) Put(key, projectDir, artifact, build string) error {
+	if !validHash.MatchString(key) {
+		return fmt.Errorf("invalid cache key %q", key)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, err := projectPath(projectDir, artifact, false)
+	if err != nil {
+		return err
+	}
+	module, err := c.putBlob(src)
+	if err != nil {
+		return err
+	}
+	entry := Entry{Key: key, Artifact: artifact, Module: module, Build: build, CreatedAt: time.Now().UTC()}
+	if sourceMap, err := c.putBlob(src + ".map"); err == nil {
+		entry.SourceMap = sourceMap
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(c.entryPath(key), data); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+// projectPath returns where rel is in projectDir, with its directory's links
+// resolved and checked to stay inside the project, creating the directory
+// when mkdir is set. The file itself is not resolved: a link there is
+// refused when read and replaced when written.
+func projectPath(projectDir, rel string, mkdir bool) (string, error) {
+	root, err := filepath.EvalSymlinks(projectDir)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(filepath.Join(root, filepath.FromSlash(rel)))
+	if mkdir {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+	if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return "", ErrNotRegular
+	}
+	return filepath.Join(dir, path.Base(rel)), nil
+}
+
+// putBlob copies the regular file at src into the blob store and returns
+// its hash
+func (c *Cache) putBlob(src string) (string, error) {
+	// O_NONBLOCK keeps a named pipe from blocking the open
+	f, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return "", ErrNotRegular
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return "", err
+	} else if !info.Mode().IsRegular() {
+		return "", ErrNotRegular
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.Root, "blobs"), ".blob-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), f); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	dst := c.blobPath(sum)
+	if _, err := os.Stat(dst); err == nil {
+		return sum, nil // already stored
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	return sum, os.Rename(tmp.Name(), dst)
+}
+
+// Restore writes the entry's module, and source map, back into projectDir
+// and checks them against their hashes. Links at their paths are replaced,
+// not written through.
+func (c *Cache) Restore(entry *Entry, projectDir string) error {
+	dst, err := projectPath(projectDir, entry.Artifact, true)
+	if err != nil {
+		return err
+	}
+	if err := c.restoreBlob(entry.Module, dst); err != nil {
+		return err
+	}
+	if entry.SourceMap != "" && validHash.MatchString(entry.SourceMap) {
+		return c.restoreBlob(entry.SourceMap, dst+".map")
+	}
+	return nil
+}
+
+func (c *Cache) restoreBlob(hash, dst string) error {
+	src, err := os.Open(c.blobPath(hash))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, sum), src); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != hash {
+		return fmt.Errorf("cached blob %s is corrupt", hash)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// writeAtomic replaces path with data so readers never see a partial file
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// evict drops least recently used entries until the blobs they reference
+// fit in MaxBytes, then removes blobs no entry references
+func (c *Cache) evict() error {
+	if c.MaxBytes <= 0 {
+		return nil
+	}
+
+	type used struct {
+		path  string
+		blobs []string
+		at    time.Time
+	}
+	files, err := os.ReadDir(filepath.Join(c.Root, "keys"))
+	if err != nil {
+		return err
+	}
+	var entries []used
+	sizes := map[string]int64{}
+	refs := map[string]int{}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(c.Root, "keys", file.Name())
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry Entry
+		if json.Unmarshal(data, &entry) != nil {
+			continue
+		}
+		u := used{path: path, at: info.ModTime()}
+		for _, blob := range []string{entry.Module, entry.SourceMap} {
+			if !validHash.MatchString(blob) {
+				continue
+			}
+			if _, ok := sizes[blob]; !ok {
+				if info, err := os.Stat(c.blobPath(blob)); err == nil {
+					sizes[blob] = info.Size()
+				}
+			}
+			refs[blob]++
+			u.blobs = append(u.blobs, blob)
+		}
+		entries = append(entries, u)
+	}
+
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+	for _, entry := range entries {
+		if total <= c.MaxBytes {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, blob := range entry.blobs {
+			if refs[blob]--; refs[blob] == 0 {
+				total -= sizes[blob]
+				if err := os.Remove(c.blobPath(blob)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package buildcache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyIsUnambiguous(t *testing.T) {
+	if Key("ab", "c") == Key("a", "bc") {
+		t.Error("keys of different parts must differ")
+	}
+	if Key("src", "go1.23", "go build") != Key("src", "go1.23", "go build") {
+		t.Error("keys must be deterministic")
+	}
+}
+
+func TestPutGetRestoreEvict(t *testing.T) {
+	project := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(project, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("out/app.wasm", "module one")
+	write("out/app.wasm.map", `{"version":3}`)
+
+	cache, err := New(t.TempDir(), 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := Key("first")
+	if _, ok := cache.Get(first); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+	if err := cache.Put(first, project, "out/app.wasm", "build-1"); err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := cache.Get(first)
+	if !ok || entry.Build != "build-1" || entry.SourceMap == "" {
+		t.Fatalf("expected a hit with a source map, got %+v", entry)
+	}
+
+	os.RemoveAll(filepath.Join(project, "out"))
+	if err := cache.Restore(entry, project); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(project, "out", "app.wasm")); string(data) != "module one" {
+		t.Errorf("restored %q", data)
+	}
+
+	// a blob altered on disk is caught on restore
+	os.WriteFile(cache.blobPath(entry.Module), []byte("tampered!!"), 0644)
+	if err := cache.Restore(entry, project); err == nil {
+		t.Error("expected a corrupt blob to be rejected")
+	}
+
+	// the second module pushes the cache past 30 bytes, evicting the older entry
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(cache.entryPath(first), past, past)
+	write("out/app.wasm", "module two")
+	os.Remove(filepath.Join(project, "out", "app.wasm.map"))
+	second := Key("second")
+	if err := cache.Put(second, project, "out/app.wasm", "build-2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(first); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, ok := cache.Get(second); !ok {
+		t.Error("expected the newest entry to stay")
+	}
+}
+
+func TestLinksInTheProjectAreNotFollowed(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	os.WriteFile(secret, []byte("secret"), 0644)
+
+	project := t.TempDir()
+	os.Symlink(secret, filepath.Join(project, "app.wasm"))
+	os.Symlink(outside, filepath.Join(project, "out"))
+
+	cache, err := New(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, artifact := range []string{"app.wasm", "out/secret"} {
+		if err := cache.Put(Key(artifact), project, artifact, ""); !errors.Is(err, ErrNotRegular) {
+			t.Errorf("%s: expected ErrNotRegular, got %v", artifact, err)
+		}
+	}
+
+	os.WriteFile(filepath.Join(project, "module.wasm"), []byte("module"), 0644)
+	if err := cache.Put(Key("module"), project, "module.wasm", ""); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := cache.Get(Key("module"))
+	entry.Artifact = "app.wasm"
+	if err := cache.Restore(entry, project); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(secret); string(data) != "secret" {
+		t.Errorf("restore wrote through the link: %q", data)
+	}
+	if info, err := os.Lstat(filepath.Join(project, "app.wasm")); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("expected the link to be replaced by the module, got %v", err)
+	}
+	entry.Artifact = "out/secret"
+	if err := cache.Restore(entry, project); !errors.Is(err, ErrNotRegular) {
+		t.Errorf("expected restoring through a linked directory to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"xxx/artifacts"
+	"xxx/buildcache"
+	"xxx/diagnostics"
+	"xxx/projects"
+	"xxx/runnerservice"
+
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/ratelimit"
+)
+
+// Storage for builds: stored builds, one directory per project; the
+// content-addressed module cache; and each user's Cargo and Go caches
+const (
+	artifactsDir        = "./builds"
+	buildCacheDir       = "./cache/builds"
+	toolchainCachesDir  = "./cache/toolchains"
+	defaultCacheMaxSize = 1 << 30
+)
+
+// Cache results reported by RunResponse
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// buildFlagEnv are environment variables that change what a build produces,
+// so they are part of its cache key
+var buildFlagEnv = []string{
+	"RUSTFLAGS", "CARGO_BUILD_TARGET", "GOFLAGS", "GOOS", "GOARCH", "CGO_ENABLED",
+	"TINYGOFLAGS", "CFLAGS", "LDFLAGS", "WASI_SDK_PATH",
+}
+
+// RunResponse is the result of running a project
+type RunResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Content string `json:"content"`
+	Build   string `json:"build,omitempty"` // the stored build that was run
+	Cache   string `json:"cache,omitempty"` // hit when the module came from the build cache, miss when compiled
+
+	// Set by in-process runs of WAT and wasm
+	Results   []string `json:"results,omitempty"`   // values returned by the entry function
+	FuelUsed  int64    `json:"fuel_used,omitempty"` // roughly, instructions executed
+	Truncated bool     `json:"truncated,omitempty"` // output past the limit was dropped
+	ExitCode  int      `json:"exit_code,omitempty"`
+
+	Preview string `json:"preview,omitempty"` // where a browser build is served
+
+	// Problems the toolchain reported, for the editor to underline
+	Diagnostics []diagnostics.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// artifactRetention reads ARTIFACTS_KEEP_BUILDS (default 10 per project) and
+// ARTIFACTS_MAX_AGE (default 30 days); zero disables either limit
+func artifactRetention() artifacts.Retention {
+	retention := artifacts.Retention{
+		KeepBuilds: int(envInt64("ARTIFACTS_KEEP_BUILDS", 10)),
+		MaxAge:     30 * 24 * time.Hour,
+	}
+	if v := os.Getenv("ARTIFACTS_MAX_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			retention.MaxAge = d
+		} else {
+			slog.Warn("ignoring invalid duration", "key", "ARTIFACTS_MAX_AGE", "value", v)
+		}
+	}
+	return retention
+}
+
+// buildKey is the build cache key of project: the hash of its sources, its
+// toolchain version, build command and the flags the environment adds to it
+func buildKey(r *http.Request, project *projects.Project, sourceHash string) string {
+	parts := []string{
+		sourceHash,
+		project.Toolchain,
+		runnerservice.ToolchainVersion(r.Context(), project.Toolchain),
+		project.Build,
+		project.Artifact,
+	}
+	for _, key := range buildFlagEnv {
+		parts = append(parts, key+"="+os.Getenv(key))
+	}
+	return buildcache.Key(parts...)
+}
+
+// toolchainCache returns the directory of the requesting user's Cargo and Go
+// caches. Clients that are not signed in get none: everyone behind one
+// address would share it.
+func (s *Server) toolchainCache(r *http.Request) string {
+	user, ok := ratelimit.UserID(r, jwtSecret())
+	if !ok {
+		return ""
+	}
+	return filepath.Join(s.toolchainCaches, ratelimit.Slug(fmt.Sprintf("user:%d", user)))
+}
+
+// saveBuild stores a build of project from its directory dir with log,
+// returning the build's ID, or "" when it couldn't be stored
+func (s *Server) saveBuild(ctx context.Context, dir string, project *projects.Project, sourceHash string, success bool, log string) string {
+	build := &artifacts.Build{
+		Project:    project.ID,
+		SourceHash: sourceHash,
+		Command:    project.Build,
+		Success:    success,
+		Artifact:   project.Artifact,
+	}
+	if err := s.artifacts.Save(dir, build, []byte(log)); err != nil {
+		s.logger.ErrorContext(ctx, "failed to store build artifacts", "project", project.ID, "error", err)
+		return ""
+	}
+	return build.ID
+}
+
+// runProject builds and runs a project in its directory. Builds are stored
+// as artifacts and their modules cached by buildKey: when the cache has the
+// module for the current sources it is restored and run without compiling,
+// unless ?rebuild=1 is given. The cache is shared between projects, so a
+// restored module is stored as a new build of this one. Cargo and Go caches
+// are kept per user.
+func (s *Server) runProject(w http.ResponseWriter, r *http.Request, project *projects.Project) {
+	ctx := r.Context()
+	dir, _ := s.projects.Dir(project.ID)
+	cache := s.toolchainCache(r)
+
+	var (
+		response RunResponse
+		stats    accounting.Stats
+		err      error
+	)
+	if project.Build != "" {
+		response.Cache = cacheMiss
+		var key string
+		sourceHash, hashErr := s.projects.SourceHash(project.ID)
+		if hashErr != nil {
+			s.logger.WarnContext(ctx, "cannot hash project sources, compiling", "project", project.ID, "error", hashErr)
+		} else {
+			key = buildKey(r, project, sourceHash)
+		}
+		if entry, ok := s.cache.Get(key); ok && r.URL.Query().Get("rebuild") != "1" {
+			if restoreErr := s.cache.Restore(entry, dir); restoreErr != nil {
+				s.logger.WarnContext(ctx, "cannot restore cached build, compiling", "project", project.ID, "error", restoreErr)
+			} else {
+				response.Cache = cacheHit
+				response.Build = s.saveBuild(ctx, dir, project, sourceHash, true, "Restored from the build cache\n")
+			}
+		}
+
+		if response.Cache == cacheMiss {
+			var output string
+			output, stats, err = runnerservice.Run(ctx, runnerservice.Job{Dir: dir, Language: project.Language, Command: diagnostics.Instrument(project.Language, project.Build), Cache: cache, ReadOnly: projects.ReadOnly})
+			response.Diagnostics, response.Content = diagnostics.Parse(output)
+			response.Build = s.saveBuild(ctx, dir, project, sourceHash, err == nil, response.Content)
+			if err == nil && key != "" && project.Artifact != "" {
+				if putErr := s.cache.Put(key, dir, project.Artifact, response.Build); putErr != nil {
+					s.logger.WarnContext(ctx, "failed to cache build", "project", project.ID, "error", putErr)
+				}
+			}
+		}
+		metrics.ObserveBuildCache(response.Cache)
+	}
+
+	if err == nil {
+		output, runStats, runErr := runnerservice.Run(ctx, runnerservice.Job{Dir: dir, Language: project.Language, Command: project.Run, Cache: cache, ReadOnly: projects.ReadOnly})
+		if response.Cache == cacheMiss {
+			stats = stats.Add(runStats)
+		} else {
+			stats = runStats
+		}
+		response.Content += output
+		err = runErr
+	}
+	s.usage.Record(ctx, accounting.RunFor(r, jwtSecret(), project.ID, project.Language, metrics.ExitCode(err)), stats)
+
+	response.Success = err == nil
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error running project", "project", project.ID, "error", err)
+		response.Message = err.Error()
+		s.jsonResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, response)
+}
+
+// buildsHandler lists a project's stored builds, newest first
+func (s *Server) buildsHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	builds, err := s.artifacts.List(project.ID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error listing builds", "project", project.ID, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error listing builds",
+		})
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, builds)
+}
+
+// buildHandler returns one stored build
+func (s *Server) buildHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	build, err := s.artifacts.Get(project.ID, r.PathValue("build"))
+	if err != nil {
+		s.artifactError(w, r, err)
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, build)
+}
+
+// artifactContentTypes are served for stored file kinds
+var artifactContentTypes = map[string]string{
+	artifacts.KindModule:    "application/wasm",
+	artifacts.KindSourceMap: "application/json",
+	artifacts.KindLog:       "text/plain; charset=utf-8",
+}
+
+// artifactHandler downloads a file of a stored build. Its SHA-256 is the
+// ETag, so clients can skip downloading a module they already have.
+func (s *Server) artifactHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	id, name := r.PathValue("build"), r.PathValue("name")
+	f, file, err := s.artifacts.Open(project.ID, id, name)
+	if err != nil {
+		s.artifactError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	build, err := s.artifacts.Get(project.ID, id)
+	if err != nil {
+		s.artifactError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", artifactContentTypes[file.Kind])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+path.Base(file.Name)+`"`)
+	w.Header().Set("ETag", strconv.Quote(file.SHA256))
+	w.Header().Set("X-Content-SHA256", file.SHA256)
+	http.ServeContent(w, r, file.Name, build.CreatedAt, f)
+}
+
+// artifactError maps a failed artifact lookup to a response
+func (s *Server) artifactError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, artifacts.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		s.jsonResponse(w, http.StatusNotFound, FileResponse{
+			Success: false,
+			Message: "Build or artifact not found",
+		})
+		return
+	}
+	s.logger.ErrorContext(r.Context(), "error reading build", "error", err)
+	s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+		Success: false,
+		Message: "Error reading build",
+	})
+}
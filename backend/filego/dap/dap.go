@@ -0,0 +1,199 @@
+// Package dap relays the Debug Adapter Protocol between the IDE and a debug
+// adapter debugging a WebAssembly runtime. The runtime compiles the module
+// with its DWARF, so a native debugger such as lldb-dap sets breakpoints by
+// source line, steps and shows locals and the call stack in the original
+// source.
+//
+// The IDE is not trusted with the adapter: the server picks what is
+// launched, and only requests that inspect or drive the program get
+// through. Requests that would run debugger commands or evaluate code in
+// the runtime's process are answered with an error instead.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"regexp"
+	"strconv"
+)
+
+// maxMessage bounds one message from the adapter, such as a large
+// variables response
+const maxMessage = 16 << 20
+
+// ReadMessage reads one message framed by its Content-Length header, as
+// adapters speak over stdio
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 || length > maxMessage {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteMessage writes msg with its Content-Length header
+func WriteMessage(w io.Writer, msg []byte) error {
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(msg)); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// Launch is what a session debugs
+type Launch struct {
+	Runtime string   // the WebAssembly runtime, such as wasmtime
+	Module  string   // relative to Dir
+	Dir     string   // the project directory, which the module sees as /
+	Args    []string // passed to the module
+	Env     []string // K=V, set in the module
+}
+
+// command returns the runtime's command line running the module with its
+// debug info and without the optimisations that would lose variables
+func (l Launch) command() []string {
+	args := []string{"run", "-D", "debug-info", "-O", "opt-level=0", "--dir", ".::/"}
+	for _, kv := range l.Env {
+		args = append(args, "--env", kv)
+	}
+	args = append(args, "--", l.Module)
+	return append(args, l.Args...)
+}
+
+// allowed lists the requests forwarded to the adapter
+var allowed = map[string]bool{
+	"initialize":              true,
+	"launch":                  true,
+	"configurationDone":       true,
+	"disconnect":              true,
+	"terminate":               true,
+	"setBreakpoints":          true,
+	"setFunctionBreakpoints":  true,
+	"setExceptionBreakpoints": true,
+	"breakpointLocations":     true,
+	"continue":                true,
+	"next":                    true,
+	"stepIn":                  true,
+	"stepOut":                 true,
+	"pause":                   true,
+	"threads":                 true,
+	"stackTrace":              true,
+	"scopes":                  true,
+	"variables":               true,
+	"setVariable":             true,
+	"evaluate":                true,
+	"source":                  true,
+	"loadedSources":           true,
+	"modules":                 true,
+	"exceptionInfo":           true,
+}
+
+var (
+	// a variable, field, element or dereference, but no calls or
+	// assignments: local, p->next, *buf, items[2].name
+	plainExpression = regexp.MustCompile(`^[\w\s.\[\]*&>-]+$`)
+	// a literal for setVariable: 42, -1.5, 'a', true, 0x10
+	literal = regexp.MustCompile(`^-?[\w.']+$`)
+)
+
+// request is the part of a DAP request the session reads
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// Session checks and rewrites what the IDE sends the adapter
+type Session struct {
+	Launch Launch
+	// InitCommands are debugger commands run before launching, such as
+	// enabling lldb's JIT loader so it sees the runtime's compiled code
+	InitCommands []string
+}
+
+// Filter checks msg from the IDE. It returns the message to forward to the
+// adapter or, for a request the session rejects, the error response to send
+// back to the IDE.
+func (s *Session) Filter(msg []byte) (forward, reply []byte) {
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil || req.Type != "request" {
+		// responses to the adapter's reverse requests are not expected, as
+		// launches never run in a terminal
+		return nil, errorResponse(req, "expected a request")
+	}
+	if !allowed[req.Command] {
+		return nil, errorResponse(req, req.Command+" is not supported")
+	}
+
+	var args map[string]any
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, errorResponse(req, "invalid arguments")
+		}
+	}
+	switch req.Command {
+	case "launch":
+		args = s.launchArguments(args)
+	case "evaluate":
+		// evaluating in the repl runs debugger commands, and calls in an
+		// expression run in the runtime's process, outside the sandbox
+		expression, _ := args["expression"].(string)
+		if !plainExpression.MatchString(expression) {
+			return nil, errorResponse(req, "only variables can be evaluated")
+		}
+		if args["context"] == "repl" {
+			args["context"] = "watch"
+		}
+	case "setVariable":
+		if value, _ := args["value"].(string); !literal.MatchString(value) {
+			return nil, errorResponse(req, "variables can only be set to literals")
+		}
+	}
+	if args != nil {
+		req.Arguments, _ = json.Marshal(args)
+	}
+	forward, _ = json.Marshal(req)
+	return forward, nil
+}
+
+// launchArguments replaces the IDE's launch arguments with the session's,
+// keeping only whether to stop on entry
+func (s *Session) launchArguments(from map[string]any) map[string]any {
+	args := map[string]any{
+		"program":       s.Launch.Runtime,
+		"args":          s.Launch.command(),
+		"cwd":           s.Launch.Dir,
+		"env":           []string{},
+		"runInTerminal": false,
+		"initCommands":  append([]string{}, s.InitCommands...),
+	}
+	if stop, ok := from["stopOnEntry"].(bool); ok {
+		args["stopOnEntry"] = stop
+	}
+	return args
+}
+
+// errorResponse answers req with a failure
+func errorResponse(req request, message string) []byte {
+	resp, _ := json.Marshal(map[string]any{
+		"seq":         0,
+		"type":        "response",
+		"request_seq": req.Seq,
+		"command":     req.Command,
+		"success":     false,
+		"message":     message,
+	})
+	return resp
+}
@@ -0,0 +1,69 @@
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFraming(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMessage(&buf, []byte(`{"seq":1}`))
+	WriteMessage(&buf, []byte(`{"seq":2}`))
+	r := bufio.NewReader(&buf)
+	for _, want := range []string{`{"seq":1}`, `{"seq":2}`} {
+		if msg, err := ReadMessage(r); err != nil || string(msg) != want {
+			t.Errorf("got %s, %v, want %s", msg, err, want)
+		}
+	}
+	if _, err := ReadMessage(bufio.NewReader(strings.NewReader("Content-Length: x\r\n\r\n"))); err == nil {
+		t.Error("accepted an invalid Content-Length")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	s := &Session{
+		Launch:       Launch{Runtime: "wasmtime", Module: "main.wasm", Dir: "/p", Args: []string{"a"}, Env: []string{"K=V"}},
+		InitCommands: []string{"settings set plugin.jit-loader.gdb.enable on"},
+	}
+
+	forward, reply := s.Filter([]byte(`{"seq":3,"type":"request","command":"launch","arguments":{"program":"/bin/sh","initCommands":["platform shell id"],"stopOnEntry":true}}`))
+	if reply != nil {
+		t.Fatalf("launch rejected: %s", reply)
+	}
+	var launch struct {
+		Seq       int
+		Arguments struct {
+			Program      string
+			Args         []string
+			Cwd          string
+			InitCommands []string
+			StopOnEntry  bool
+		}
+	}
+	json.Unmarshal(forward, &launch)
+	wantArgs := []string{"run", "-D", "debug-info", "-O", "opt-level=0", "--dir", ".::/", "--env", "K=V", "--", "main.wasm", "a"}
+	if launch.Seq != 3 || launch.Arguments.Program != "wasmtime" || !reflect.DeepEqual(launch.Arguments.Args, wantArgs) ||
+		launch.Arguments.Cwd != "/p" || !reflect.DeepEqual(launch.Arguments.InitCommands, s.InitCommands) || !launch.Arguments.StopOnEntry {
+		t.Errorf("launch: got %s", forward)
+	}
+
+	for _, msg := range []string{
+		`{"seq":4,"type":"request","command":"attach","arguments":{"pid":1}}`,
+		`{"seq":5,"type":"request","command":"evaluate","arguments":{"expression":"(int)system(\"id\")"}}`,
+		`{"seq":6,"type":"request","command":"setVariable","arguments":{"name":"x","value":"f()"}}`,
+		`{"seq":7,"type":"response","command":"runInTerminal","success":true}`,
+	} {
+		if forward, reply := s.Filter([]byte(msg)); forward != nil || !strings.Contains(string(reply), `"success":false`) {
+			t.Errorf("%s: forwarded %s", msg, forward)
+		}
+	}
+
+	forward, _ = s.Filter([]byte(`{"seq":8,"type":"request","command":"evaluate","arguments":{"expression":"p->next","context":"repl"}}`))
+	if !strings.Contains(string(forward), `"context":"watch"`) {
+		t.Errorf("evaluate: got %s", forward)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"xxx/dap"
+	"xxx/diagnostics"
+	"xxx/projects"
+	"xxx/runnerservice"
+
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/metrics"
+	httpserver "muhammadyasir-dev/cmd/server"
+)
+
+// defaultDebugTimeoutMS bounds a debug session, which mostly waits on the
+// person debugging
+const defaultDebugTimeoutMS = 30 * 60 * 1000
+
+// debugTools reads DEBUG_ADAPTER (default lldb-dap), the DAP adapter spoken
+// to over stdio, and DEBUG_RUNTIME (default wasmtime), the runtime it debugs
+func debugTools() (adapter, runtime string) {
+	return cmp.Or(os.Getenv("DEBUG_ADAPTER"), "lldb-dap"), cmp.Or(os.Getenv("DEBUG_RUNTIME"), "wasmtime")
+}
+
+// debugBuildEnv keeps the DWARF a debugger needs in builds that would
+// otherwise drop it, such as Cargo's release profile
+func debugBuildEnv(language string) []string {
+	if language == "rust" {
+		return []string{
+			"CARGO_PROFILE_RELEASE_DEBUG=true",
+			"CARGO_PROFILE_RELEASE_STRIP=none",
+		}
+	}
+	return nil
+}
+
+// debugHandler builds the project with debug info and debugs its module over
+// a WebSocket, one DAP message per text frame. The module runs with the
+// arguments and environment of the request's run configuration, see
+// requestRunConfig, and sees the project directory as its root. A failed
+// build is answered like a run, with its diagnostics, before upgrading.
+func (s *Server) debugHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	if project.Artifact == "" || isDirectLanguage(project.Language) {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Only projects compiled from source with debug info can be debugged",
+		})
+		return
+	}
+	config, ok := s.requestRunConfig(w, r, project)
+	if !ok {
+		return
+	}
+	adapter, runtime := debugTools()
+	// the adapter launches the runtime by path
+	runtime, err := exec.LookPath(runtime)
+	if err == nil {
+		_, err = exec.LookPath(adapter)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "debugger not found", "adapter", adapter, "runtime", runtime, "error", err)
+		s.jsonResponse(w, http.StatusServiceUnavailable, FileResponse{
+			Success: false,
+			Message: "Debugging is not available",
+		})
+		return
+	}
+
+	dir, _ := s.projects.Dir(project.ID)
+	if project.Build != "" {
+		output, stats, err := runnerservice.Run(ctx, runnerservice.Job{
+			Dir:      dir,
+			Language: project.Language,
+			Command:  diagnostics.Instrument(project.Language, project.Build),
+			Cache:    s.toolchainCache(r),
+			Env:      debugBuildEnv(project.Language),
+			ReadOnly: projects.ReadOnly,
+		})
+		s.usage.Record(ctx, accounting.RunFor(r, jwtSecret(), project.ID, project.Language, metrics.ExitCode(err)), stats)
+		if err != nil {
+			response := RunResponse{Success: false, Message: err.Error()}
+			response.Diagnostics, response.Content = diagnostics.Parse(output)
+			s.jsonResponse(w, http.StatusUnprocessableEntity, response)
+			return
+		}
+	}
+
+	session := &dap.Session{
+		Launch: dap.Launch{
+			Runtime: runtime,
+			Module:  project.Artifact,
+			Dir:     dir,
+			Args:    config.Args,
+		},
+		// lldb finds the code wasmtime compiles through the GDB JIT interface
+		InitCommands: []string{"settings set plugin.jit-loader.gdb.enable on"},
+	}
+	for name, value := range config.Env {
+		session.Launch.Env = append(session.Launch.Env, name+"="+value)
+	}
+	sort.Strings(session.Launch.Env)
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has answered the request
+		s.logger.WarnContext(ctx, "debug session not opened", "project", project.ID, "error", err)
+		return
+	}
+	s.debugSession(r, ws, project, adapter, session)
+}
+
+// debugSession relays messages between the IDE on ws and the adapter until
+// either side closes, the session times out or the server shuts down
+func (s *Server) debugSession(r *http.Request, ws *websocket.Conn, project *projects.Project, adapter string, session *dap.Session) {
+	ctx := r.Context()
+	defer httpserver.Track(ctx)()
+	defer ws.Close()
+	// the session has its own deadline rather than the server's
+	sessionCtx, cancel := context.WithTimeout(ctx, time.Duration(envInt64("DEBUG_SESSION_TIMEOUT_MS", defaultDebugTimeoutMS))*time.Millisecond)
+	defer cancel()
+
+	cmd := exec.CommandContext(sessionCtx, adapter)
+	cmd.Dir = session.Launch.Dir
+	// the adapter passes its environment on to the module it launches, so it
+	// gets nothing of the server's
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + os.TempDir(), "LC_ALL=C"}
+	cmd.WaitDelay = 5 * time.Second
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error starting debug adapter", "project", project.ID, "error", err)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error starting debug adapter", "project", project.ID, "error", err)
+		return
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		s.logger.ErrorContext(ctx, "error starting debug adapter", "project", project.ID, "error", err)
+		return
+	}
+
+	// frames are written from both directions' goroutines, one at a time
+	var writeMu sync.Mutex
+	send := func(msg []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return ws.WriteMessage(websocket.TextMessage, msg)
+	}
+
+	// adapter to IDE
+	go func() {
+		defer cancel()
+		out := bufio.NewReader(stdout)
+		for {
+			msg, err := dap.ReadMessage(out)
+			if err != nil {
+				if err != io.EOF {
+					s.logger.WarnContext(ctx, "error reading from debug adapter", "project", project.ID, "error", err)
+				}
+				return
+			}
+			if err := send(msg); err != nil {
+				return
+			}
+		}
+	}()
+	// IDE to adapter
+	go func() {
+		defer cancel()
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			forward, reply := session.Filter(msg)
+			if reply != nil {
+				if err := send(reply); err != nil {
+					return
+				}
+				continue
+			}
+			if err := dap.WriteMessage(stdin, forward); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-sessionCtx.Done():
+	case <-httpserver.ShuttingDown(ctx):
+		cancel()
+	}
+	ws.Close()
+	err = cmd.Wait()
+	stats := accounting.FromProcess(cmd.ProcessState)
+	stats.Wall = time.Since(start)
+	s.usage.Record(ctx, accounting.RunFor(r, jwtSecret(), project.ID, project.Language, metrics.ExitCode(err)), stats)
+	s.logger.InfoContext(ctx, "debug session ended", "project", project.ID, "duration_ms", stats.Wall.Milliseconds())
+}
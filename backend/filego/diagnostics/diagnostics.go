@@ -0,0 +1,312 @@
+// Package diagnostics turns the output of a build into structured problems
+// an editor can underline: rustc's JSON messages as Cargo prints them, the
+// file:line:col: format of go, clang, gcc and wat2wasm, rustc's human
+// output and AssemblyScript's.
+package diagnostics
+
+import (
+	"bufio"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severities
+const (
+	Error   = "error"
+	Warning = "warning"
+	Note    = "note"
+)
+
+// maxDiagnostics bounds what one build reports
+const maxDiagnostics = 500
+
+// Diagnostic is one problem reported by a toolchain. Lines and columns
+// count from 1; zero means unknown.
+type Diagnostic struct {
+	File        string       `json:"file"` // relative to the project root
+	Line        int          `json:"line"`
+	Column      int          `json:"column,omitempty"`
+	EndLine     int          `json:"end_line,omitempty"`
+	EndColumn   int          `json:"end_column,omitempty"`
+	Severity    string       `json:"severity"`
+	Code        string       `json:"code,omitempty"` // such as E0425 or -Wunused-variable
+	Message     string       `json:"message"`
+	Suggestions []Suggestion `json:"suggestions,omitempty"`
+}
+
+// Suggestion is a hint attached to a diagnostic, such as rustc's help and
+// clang's notes, with a replacement for a span when the toolchain has one
+type Suggestion struct {
+	Message     string  `json:"message"`
+	File        string  `json:"file,omitempty"`
+	Line        int     `json:"line,omitempty"`
+	Column      int     `json:"column,omitempty"`
+	EndLine     int     `json:"end_line,omitempty"`
+	EndColumn   int     `json:"end_column,omitempty"`
+	Replacement *string `json:"replacement,omitempty"`
+}
+
+// Instrument returns the build command of a project in language with the
+// flags that make its toolchain report diagnostics as Parse reads them
+// best: Cargo builds print rustc's JSON messages.
+func Instrument(language, command string) string {
+	if language != "rust" || strings.Contains(command, "--message-format") {
+		return command
+	}
+	for _, sub := range []string{"cargo build", "cargo check", "cargo run", "cargo test"} {
+		if i := strings.Index(command, sub); i >= 0 {
+			i += len(sub)
+			return command[:i] + " --message-format=json" + command[i:]
+		}
+	}
+	return command
+}
+
+// Parse extracts the diagnostics from the output of a build. It also
+// returns the output to show the user, in which Cargo's JSON messages are
+// replaced by the text rustc would have printed.
+func Parse(output string) ([]Diagnostic, string) {
+	p := &parser{}
+	var text strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "{") {
+			if rendered, ok := p.cargo(line); ok {
+				text.WriteString(rendered)
+				continue
+			}
+		}
+		text.WriteString(line)
+		text.WriteByte('\n')
+		p.line(line)
+	}
+	p.flush()
+	if len(p.diags) > maxDiagnostics {
+		p.diags = p.diags[:maxDiagnostics]
+	}
+	return p.diags, text.String()
+}
+
+type parser struct {
+	diags []Diagnostic
+	// rust is rustc's human output waiting for its --> location
+	rust *Diagnostic
+	// asc is an AssemblyScript message waiting for its "in" location
+	asc *Diagnostic
+}
+
+var (
+	// main.c:3:10: error: use of undeclared identifier 'x' [-Wfoo]
+	// ./main.go:12:5: undefined: foo
+	gccLine = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (?:(fatal error|error|warning|note|remark): )?(.*)$`)
+	// error[E0425]: cannot find value `x` in this scope
+	rustHead = regexp.MustCompile(`^(error|warning)(?:\[(\w+)\])?: (.*)$`)
+	//   --> src/main.rs:2:5
+	rustLoc = regexp.MustCompile(`^\s*--> (.+?):(\d+):(\d+)$`)
+	// ERROR TS2304: Cannot find name 'foo'.
+	ascHead = regexp.MustCompile(`^(ERROR|WARNING|INFO) (\w+): (.*)$`)
+	//     └─ in assembly/index.ts(1,1)
+	ascLoc = regexp.MustCompile(`in (\S+?)\((\d+),(\d+)\)$`)
+	// clang's [-Wunused-variable] and similar
+	gccFlag = regexp.MustCompile(` \[(-W[\w=-]+)\]$`)
+)
+
+func (p *parser) add(d Diagnostic) {
+	d.File = cleanPath(d.File)
+	p.diags = append(p.diags, d)
+}
+
+// flush drops diagnostics still waiting for their location at the end of
+// the output, as there is nowhere to show them
+func (p *parser) flush() {
+	p.rust, p.asc = nil, nil
+}
+
+// line parses one line of human-readable output
+func (p *parser) line(line string) {
+	if p.rust != nil {
+		if m := rustLoc.FindStringSubmatch(line); m != nil {
+			p.rust.File = m[1]
+			p.rust.Line, _ = strconv.Atoi(m[2])
+			p.rust.Column, _ = strconv.Atoi(m[3])
+			p.add(*p.rust)
+			p.rust = nil
+			return
+		}
+		if strings.TrimSpace(line) == "" {
+			p.rust = nil
+		}
+	}
+	if p.asc != nil {
+		if m := ascLoc.FindStringSubmatch(line); m != nil {
+			p.asc.File = m[1]
+			p.asc.Line, _ = strconv.Atoi(m[2])
+			p.asc.Column, _ = strconv.Atoi(m[3])
+			p.add(*p.asc)
+			p.asc = nil
+			return
+		}
+	}
+
+	if m := rustHead.FindStringSubmatch(line); m != nil {
+		p.rust = &Diagnostic{Severity: m[1], Code: m[2], Message: m[3]}
+		return
+	}
+	if m := ascHead.FindStringSubmatch(line); m != nil {
+		p.asc = &Diagnostic{Severity: strings.ToLower(m[1]), Code: m[2], Message: m[3]}
+		if p.asc.Severity == "info" {
+			p.asc.Severity = Note
+		}
+		return
+	}
+	if m := gccLine.FindStringSubmatch(line); m != nil && plausibleFile(m[1]) {
+		d := Diagnostic{File: m[1], Severity: m[4], Message: m[5]}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		switch d.Severity {
+		case "", "fatal error":
+			d.Severity = Error
+		case "remark":
+			d.Severity = Note
+		}
+		if flag := gccFlag.FindStringSubmatch(d.Message); flag != nil {
+			d.Code = flag[1]
+			d.Message = strings.TrimSuffix(d.Message, flag[0])
+		}
+		// clang and gcc follow a diagnostic with the notes explaining it
+		if d.Severity == Note && len(p.diags) > 0 {
+			last := &p.diags[len(p.diags)-1]
+			if last.Severity != Note {
+				last.Suggestions = append(last.Suggestions, Suggestion{
+					Message: d.Message,
+					File:    cleanPath(d.File),
+					Line:    d.Line,
+					Column:  d.Column,
+				})
+				return
+			}
+		}
+		p.add(d)
+	}
+}
+
+// plausibleFile rules out lines such as "note: ..." or URLs that happen to
+// match file:line:
+func plausibleFile(name string) bool {
+	return path.Ext(name) != "" && !strings.ContainsAny(name, " \t") && !strings.Contains(name, "://")
+}
+
+// cleanPath makes a toolchain's file name relative to the project root,
+// which toolchains see at /project in the runner container
+func cleanPath(name string) string {
+	name = path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimPrefix(name, "/project/")
+	return strings.TrimPrefix(name, "./")
+}
+
+// cargoMessage is one line of cargo --message-format=json
+type cargoMessage struct {
+	Reason  string          `json:"reason"`
+	Message *rustDiagnostic `json:"message"`
+}
+
+type rustDiagnostic struct {
+	Message  string                 `json:"message"`
+	Code     *struct{ Code string } `json:"code"`
+	Level    string                 `json:"level"`
+	Spans    []rustSpan             `json:"spans"`
+	Children []rustDiagnostic       `json:"children"`
+	Rendered *string                `json:"rendered"`
+}
+
+type rustSpan struct {
+	FileName    string  `json:"file_name"`
+	LineStart   int     `json:"line_start"`
+	LineEnd     int     `json:"line_end"`
+	ColumnStart int     `json:"column_start"`
+	ColumnEnd   int     `json:"column_end"`
+	IsPrimary   bool    `json:"is_primary"`
+	Label       *string `json:"label"`
+	Replacement *string `json:"suggested_replacement"`
+}
+
+// cargo parses one line of Cargo's JSON output, returning the text to show
+// in its place. Lines that are not Cargo messages are left to line.
+func (p *parser) cargo(line string) (string, bool) {
+	var msg cargoMessage
+	if err := json.Unmarshal([]byte(line), &msg); err != nil || msg.Reason == "" {
+		return "", false
+	}
+	if msg.Reason != "compiler-message" || msg.Message == nil {
+		// artifacts, build scripts and the final status are not for people
+		return "", true
+	}
+	diag := msg.Message
+	rendered := ""
+	if diag.Rendered != nil {
+		rendered = *diag.Rendered
+	}
+
+	severity := diag.Level
+	switch {
+	case strings.HasPrefix(severity, "error"):
+		severity = Error
+	case severity == "warning":
+	case severity == "note", severity == "help":
+		severity = Note
+	default:
+		return rendered, true
+	}
+	primary := -1
+	for i, span := range diag.Spans {
+		if span.IsPrimary {
+			primary = i
+			break
+		}
+	}
+	if primary < 0 {
+		// summaries such as "aborting due to 2 previous errors"
+		return rendered, true
+	}
+
+	span := diag.Spans[primary]
+	d := Diagnostic{
+		File:      span.FileName,
+		Line:      span.LineStart,
+		Column:    span.ColumnStart,
+		EndLine:   span.LineEnd,
+		EndColumn: span.ColumnEnd,
+		Severity:  severity,
+		Message:   diag.Message,
+	}
+	if diag.Code != nil {
+		d.Code = diag.Code.Code
+	}
+	if span.Label != nil && *span.Label != "" {
+		d.Message += ": " + *span.Label
+	}
+	for _, child := range diag.Children {
+		if len(child.Spans) == 0 {
+			d.Suggestions = append(d.Suggestions, Suggestion{Message: child.Level + ": " + child.Message})
+			continue
+		}
+		for _, s := range child.Spans {
+			d.Suggestions = append(d.Suggestions, Suggestion{
+				Message:     child.Level + ": " + child.Message,
+				File:        cleanPath(s.FileName),
+				Line:        s.LineStart,
+				Column:      s.ColumnStart,
+				EndLine:     s.LineEnd,
+				EndColumn:   s.ColumnEnd,
+				Replacement: s.Replacement,
+			})
+		}
+	}
+	p.add(d)
+	return rendered, true
+}
@@ -0,0 +1,76 @@
+package diagnostics
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCargoJSON(t *testing.T) {
+	output := `   Compiling app v0.1.0 (/work)
+{"reason":"compiler-message","message":{"rendered":"error[E0425]: cannot find value ` + "`y`" + `\n","children":[{"children":[],"code":null,"level":"help","message":"a local variable with a similar name exists","rendered":null,"spans":[{"file_name":"src/main.rs","line_start":3,"line_end":3,"column_start":20,"column_end":21,"is_primary":true,"label":null,"suggested_replacement":"x"}]}],"code":{"code":"E0425","explanation":"..."},"level":"error","message":"cannot find value ` + "`y`" + ` in this scope","spans":[{"file_name":"src/main.rs","line_start":3,"line_end":3,"column_start":20,"column_end":21,"is_primary":true,"label":"not found in this scope","suggested_replacement":null}]}}
+{"reason":"compiler-message","message":{"rendered":"error: aborting due to 1 previous error\n","children":[],"code":null,"level":"error","message":"aborting due to 1 previous error","spans":[]}}
+{"reason":"build-finished","success":false}
+`
+	diags, text := Parse(output)
+	if len(diags) != 1 {
+		t.Fatalf("got %+v", diags)
+	}
+	d := diags[0]
+	if d.File != "src/main.rs" || d.Line != 3 || d.Column != 20 || d.EndColumn != 21 || d.Code != "E0425" || d.Severity != Error {
+		t.Errorf("got %+v", d)
+	}
+	if len(d.Suggestions) != 1 || d.Suggestions[0].Replacement == nil || *d.Suggestions[0].Replacement != "x" {
+		t.Errorf("suggestions: %+v", d.Suggestions)
+	}
+	if strings.Contains(text, `"reason"`) || !strings.Contains(text, "error[E0425]") || !strings.Contains(text, "Compiling app") {
+		t.Errorf("rendered output:\n%s", text)
+	}
+}
+
+func TestParseText(t *testing.T) {
+	output := `# command-line-arguments
+./main.go:7:2: declared and not used: x
+main.c:3:10: warning: unused variable 'y' [-Wunused-variable]
+main.c:1:1: note: declared here
+/project/hello.wat:4:5: unknown instruction i32.bogus
+error[E0308]: mismatched types
+ --> src/lib.rs:2:5
+  |
+ERROR TS2304: Cannot find name 'foo'.
+    :
+  1 │ foo();
+    └─ in assembly/index.ts(1,1)
+see https://example.com:443: for details
+`
+	diags, _ := Parse(output)
+	want := []Diagnostic{
+		{File: "main.go", Line: 7, Column: 2, Severity: Error, Message: "declared and not used: x"},
+		{File: "main.c", Line: 3, Column: 10, Severity: Warning, Code: "-Wunused-variable", Message: "unused variable 'y'"},
+		{File: "hello.wat", Line: 4, Column: 5, Severity: Error, Message: "unknown instruction i32.bogus"},
+		{File: "src/lib.rs", Line: 2, Column: 5, Severity: Error, Code: "E0308", Message: "mismatched types"},
+		{File: "assembly/index.ts", Line: 1, Column: 1, Severity: Error, Code: "TS2304", Message: "Cannot find name 'foo'."},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %d diagnostics: %+v", len(diags), diags)
+	}
+	for i := range want {
+		got := diags[i]
+		got.Suggestions = nil
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("%d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+	if n := diags[1].Suggestions; len(n) != 1 || n[0].Message != "declared here" {
+		t.Errorf("clang note not attached: %+v", n)
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	if got := Instrument("rust", "cargo build --release --target wasm32-wasip1"); got != "cargo build --message-format=json --release --target wasm32-wasip1" {
+		t.Errorf("got %q", got)
+	}
+	if got := Instrument("go", "go build ."); got != "go build ." {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"xxx/projects"
+
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// encodingBase64 marks FileResponse content, and request bodies, that are
+// base64 rather than text
+const encodingBase64 = "base64"
+
+// errOutsideProject is returned for paths that lead out of a project
+// through a symbolic link
+var errOutsideProject = errors.New("path leaves the project")
+
+// errNotRegular is returned for files that are symbolic links, directories
+// or anything else but a regular file
+var errNotRegular = errors.New("not a regular file")
+
+// UploadResponse lists the files an upload wrote
+type UploadResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Files   []string `json:"files"`
+}
+
+// maxUploadBytes reads FILES_MAX_UPLOAD_BYTES, the largest file, or
+// multipart upload, a request may write; 10 MB by default
+func maxUploadBytes() int64 {
+	return envInt64("FILES_MAX_UPLOAD_BYTES", maxFileSizes)
+}
+
+// encodeContent returns content for a FileResponse and its encoding:
+// base64 when asked for or when content is not UTF-8 text
+func encodeContent(content []byte, encoding string) (string, string) {
+	if encoding == encodingBase64 || !utf8.Valid(content) {
+		return base64.StdEncoding.EncodeToString(content), encodingBase64
+	}
+	return string(content), ""
+}
+
+// readBody reads a file sent as the request body, decoding it when
+// ?encoding=base64
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxUploadBytes())
+	if r.URL.Query().Get("encoding") == encodingBase64 {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return io.ReadAll(body)
+}
+
+// bodyError answers a request whose body could not be read, with 413 when
+// it was over the limit
+func (s *Server) bodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.jsonResponse(w, http.StatusRequestEntityTooLarge, FileResponse{
+			Success: false,
+			Message: "File is larger than the upload limit",
+		})
+		return
+	}
+	s.logger.WarnContext(r.Context(), "error reading request body", "error", err)
+	s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+		Success: false,
+		Message: "Error reading request body",
+	})
+}
+
+// openRegular opens filePath for reading if it is a regular file. It
+// doesn't follow a symbolic link there, which a command run in the
+// directory could have pointed anywhere on the host.
+func openRegular(filePath string) (*os.File, error) {
+	// O_NONBLOCK keeps a named pipe from blocking the open
+	f, err := os.OpenFile(filePath, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, errNotRegular
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = errNotRegular
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// readRegular reads the regular file at filePath, see openRegular
+func readRegular(filePath string) ([]byte, error) {
+	f, err := openRegular(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// readProjectFile reads the file rel in the project in dir, which must be a
+// regular file inside it, see projectFilePath and openRegular
+func readProjectFile(dir, rel string) ([]byte, error) {
+	filePath, err := projectFilePath(dir, rel, false)
+	if err != nil {
+		return nil, err
+	}
+	return readRegular(filePath)
+}
+
+// serveRaw sends the file at filePath as is, with its Content-Type from
+// its extension or content and support for Range requests. ?download=1
+// asks browsers to save it rather than show it.
+func (s *Server) serveRaw(w http.ResponseWriter, r *http.Request, filePath string) {
+	f, err := openRegular(filePath)
+	var info os.FileInfo
+	if err == nil {
+		defer f.Close()
+		info, err = f.Stat()
+	}
+	metrics.ObserveFile("read", err)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errNotRegular) {
+		s.jsonResponse(w, http.StatusNotFound, FileResponse{
+			Success: false,
+			Message: "File not found",
+		})
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error reading file", "path", filePath, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error reading file",
+		})
+		return
+	}
+
+	// user files are not pages of this origin
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// writeFile writes what r holds to filePath through a temporary file, so a
+// failed upload leaves the old content in place. A symbolic link at
+// filePath is replaced, not followed.
+func writeFile(filePath string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// handlePutFile saves the raw request body, streamed to disk, as the file
+// at filePath
+func (s *Server) handlePutFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	err := writeFile(filePath, http.MaxBytesReader(w, r.Body, maxUploadBytes()))
+	metrics.ObserveFile("write", err)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.bodyError(w, r, err)
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error writing file", "path", filePath, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error writing file",
+		})
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, FileResponse{
+		Success: true,
+		Message: "File saved successfully",
+	})
+}
+
+// handleMultipartUpload saves each file of a multipart/form-data request,
+// streaming them to disk. target maps a part's file name, which may hold
+// directories, to the name reported and where it is written, failing for
+// names it refuses.
+func (s *Server) handleMultipartUpload(w http.ResponseWriter, r *http.Request, target func(name string) (string, string, error)) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes())
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Expected a multipart/form-data body",
+		})
+		return
+	}
+
+	response := UploadResponse{Success: true, Files: []string{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.bodyError(w, r, err)
+			return
+		}
+		// the name as sent, with the directories of a folder upload that
+		// FileName drops
+		_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if part.FileName() == "" || params["filename"] == "" {
+			// form fields other than files
+			continue
+		}
+		name, filePath, err := target(params["filename"])
+		if err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: "Invalid file name " + params["filename"],
+			})
+			return
+		}
+		err = writeFile(filePath, part)
+		metrics.ObserveFile("write", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.bodyError(w, r, err)
+			return
+		}
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error writing uploaded file", "path", filePath, "error", err)
+			s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+				Success: false,
+				Message: "Error writing file",
+			})
+			return
+		}
+		response.Files = append(response.Files, name)
+	}
+	response.Message = "Files uploaded"
+	s.jsonResponse(w, http.StatusOK, response)
+}
+
+// uploadHandler saves the files of a multipart upload into the files
+// directory
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	s.handleMultipartUpload(w, r, func(name string) (string, string, error) {
+		if !isValidFilename(name) {
+			return "", "", os.ErrInvalid
+		}
+		return name, filepath.Join(fileDir, name), nil
+	})
+}
+
+// projectFilePath returns where the file rel, a slash-separated path from
+// the root of the project in dir, is on disk. The metadata and git
+// repository are not files of the project, and symbolic links may not lead
+// out of it. With create, missing parent directories are made.
+func projectFilePath(dir, rel string, create bool) (string, error) {
+	rel = path.Clean(strings.TrimPrefix(rel, "/"))
+	if !fs.ValidPath(rel) || rel == "." || rel == projects.MetaFile || rel == ".git" || strings.HasPrefix(rel, ".git/") {
+		return "", os.ErrInvalid
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	filePath := filepath.Join(dir, filepath.FromSlash(rel))
+	// a file is read through its links, but an upload replaces the file
+	// itself, so only the directories it goes in must stay inside
+	check := filePath
+	if create {
+		check = filepath.Dir(filePath)
+		// directories are made through links, so check what exists first
+		for {
+			if _, err := os.Lstat(check); err == nil || check == dir {
+				break
+			}
+			check = filepath.Dir(check)
+		}
+	}
+	real, err := filepath.EvalSymlinks(check)
+	if err != nil {
+		return "", err
+	}
+	if inside, err := filepath.Rel(root, real); err != nil || inside != "." && !filepath.IsLocal(inside) {
+		return "", errOutsideProject
+	}
+	if create {
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return "", err
+		}
+	}
+	return filePath, nil
+}
+
+// projectFileHandler downloads, with GET, or uploads, with PUT, one file of
+// a project as raw bytes
+func (s *Server) projectFileHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	dir, _ := s.projects.Dir(project.ID)
+	filePath, err := projectFilePath(dir, r.PathValue("path"), r.Method == http.MethodPut)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		s.jsonResponse(w, http.StatusNotFound, FileResponse{
+			Success: false,
+			Message: "File not found",
+		})
+		return
+	case errors.Is(err, os.ErrInvalid), errors.Is(err, errOutsideProject):
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Invalid file path",
+		})
+		return
+	case err != nil:
+		s.logger.ErrorContext(r.Context(), "error resolving project file", "project", project.ID, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error resolving file",
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.serveRaw(w, r, filePath)
+	case http.MethodPut:
+		s.handlePutFile(w, r, filePath)
+	default:
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+	}
+}
+
+// projectUploadHandler saves the files of a multipart upload into a
+// project. File names are paths from the project root, under ?dir= when
+// given.
+func (s *Server) projectUploadHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	dir, _ := s.projects.Dir(project.ID)
+	prefix := r.URL.Query().Get("dir")
+	s.handleMultipartUpload(w, r, func(name string) (string, string, error) {
+		name = strings.ReplaceAll(name, "\\", "/")
+		if !fs.ValidPath(name) {
+			return "", "", os.ErrInvalid
+		}
+		rel := path.Join(prefix, name)
+		filePath, err := projectFilePath(dir, rel, true)
+		return rel, filePath, err
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"xxx/formatter"
+	"xxx/projects"
+	"xxx/runnerservice"
+
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// formatTimeout bounds one external formatter run
+const formatTimeout = 30 * time.Second
+
+// Outputs of a format request
+const (
+	formatOutputContent = "content"
+	formatOutputPatch   = "patch"
+)
+
+// FormatRequest asks to format one file of a project, or all of them
+type FormatRequest struct {
+	File string `json:"file,omitempty"` // relative to the project root; every file when empty
+	// Content is formatted instead of File's content on disk, such as an
+	// unsaved editor buffer
+	Content *string `json:"content,omitempty"`
+	Output  string  `json:"output,omitempty"` // content, the default, or patch
+	Write   bool    `json:"write,omitempty"`  // save the formatted files
+}
+
+// FormattedFile is the result of formatting one file
+type FormattedFile struct {
+	File      string `json:"file"`
+	Formatter string `json:"formatter"`
+	Changed   bool   `json:"changed"`
+	Content   string `json:"content,omitempty"`
+	Patch     string `json:"patch,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FormatResponse lists the formatted files
+type FormatResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message,omitempty"`
+	Files   []FormattedFile `json:"files"`
+}
+
+// formatFilter runs external formatters in the project's container
+func formatFilter(ctx context.Context, dir, command string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, formatTimeout)
+	defer cancel()
+	return runnerservice.Filter(ctx, runnerservice.Job{Dir: dir, Language: "format", Command: command, ReadOnly: projects.ReadOnly}, input)
+}
+
+// formatHandler formats a file of the project, or every file with a
+// formatter when none is named, answering with the formatted content or a
+// unified diff and optionally saving the result
+func (s *Server) formatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+		return
+	}
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	var req FormatRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFileSizes)).Decode(&req); err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Expected a JSON body",
+		})
+		return
+	}
+	if req.Output == "" {
+		req.Output = formatOutputContent
+	}
+	if req.Output != formatOutputContent && req.Output != formatOutputPatch {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "output must be content or patch",
+		})
+		return
+	}
+
+	files, err := s.formatTargets(project, req)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.jsonResponse(w, http.StatusNotFound, FileResponse{
+				Success: false,
+				Message: "File not found",
+			})
+			return
+		}
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	dir, _ := s.projects.Dir(project.ID)
+	response := FormatResponse{Success: true, Files: []FormattedFile{}}
+	for _, name := range files {
+		result := s.formatFile(r.Context(), dir, name, req)
+		if result.Error != "" {
+			response.Success = false
+		}
+		response.Files = append(response.Files, result)
+	}
+	if !response.Success {
+		response.Message = "Some files could not be formatted"
+	}
+	s.jsonResponse(w, http.StatusOK, response)
+}
+
+// formatTargets returns the files a request formats
+func (s *Server) formatTargets(project *projects.Project, req FormatRequest) ([]string, error) {
+	if req.File == "" {
+		if req.Content != nil {
+			return nil, errors.New("content needs the file it belongs to")
+		}
+		all, err := s.projects.Files(project.ID)
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, name := range all {
+			if _, ok := formatter.For(name); ok && !project.IsBuildOutput(name) {
+				files = append(files, name)
+			}
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	name := path.Clean(req.File)
+	if !filepath.IsLocal(filepath.FromSlash(name)) || name == projects.MetaFile {
+		return nil, errors.New("invalid file " + req.File)
+	}
+	if _, ok := formatter.For(name); !ok {
+		return nil, formatter.ErrUnsupported
+	}
+	if req.Content == nil {
+		dir, _ := s.projects.Dir(project.ID)
+		filePath, err := projectFilePath(dir, name, false)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Lstat(filePath); err != nil {
+			return nil, err
+		} else if !info.Mode().IsRegular() {
+			return nil, errors.New(req.File + " is not a regular file")
+		}
+	}
+	return []string{name}, nil
+}
+
+// formatFile formats one file as req asks
+func (s *Server) formatFile(ctx context.Context, dir, name string, req FormatRequest) FormattedFile {
+	f, _ := formatter.For(name)
+	result := FormattedFile{File: name, Formatter: f.Name}
+
+	var src []byte
+	if req.Content != nil {
+		src = []byte(*req.Content)
+	} else {
+		var err error
+		src, err = readProjectFile(dir, name)
+		metrics.ObserveFile("read", err)
+		if errors.Is(err, errNotRegular) || errors.Is(err, errOutsideProject) {
+			result.Error = "Not a regular file in the project"
+			return result
+		}
+		if err != nil {
+			s.logger.ErrorContext(ctx, "error reading file to format", "file", name, "error", err)
+			result.Error = "Error reading file"
+			return result
+		}
+	}
+
+	formatted, err := f.Format(ctx, formatFilter, dir, name, src)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Changed = string(formatted) != string(src)
+	if req.Output == formatOutputPatch {
+		result.Patch = formatter.Unified(name, string(src), string(formatted))
+	} else {
+		result.Content = string(formatted)
+	}
+	if req.Write && (result.Changed || req.Content != nil) {
+		// writeFile replaces a link at the path rather than writing through it
+		filePath, err := projectFilePath(dir, name, true)
+		if err == nil {
+			err = writeFile(filePath, bytes.NewReader(formatted))
+		}
+		metrics.ObserveFile("write", err)
+		if errors.Is(err, errOutsideProject) {
+			result.Error = "Not a regular file in the project"
+		} else if err != nil {
+			s.logger.ErrorContext(ctx, "error writing formatted file", "file", name, "error", err)
+			result.Error = "Error writing file"
+		}
+	}
+	return result
+}
@@ -0,0 +1,171 @@
+package formatter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is how many unchanged lines surround each hunk
+const contextLines = 3
+
+// maxEdits bounds the Myers search, whose memory grows with the square of
+// the number of changed lines; beyond it the files are diffed as one
+// replacement
+const maxEdits = 4000
+
+// edit is one line of a diff: ' ' kept, '-' removed or '+' added, with
+// where it falls in the old and new text, counting lines from 0
+type edit struct {
+	op   byte
+	line string
+	a, b int
+}
+
+// Unified returns the unified diff turning old into new, labelled with name
+// as git does, or "" when they are equal
+func Unified(name, old, new string) string {
+	if old == new {
+		return ""
+	}
+	edits := diffLines(splitLines(old), splitLines(new))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+	for i := 0; i < len(edits); {
+		for i < len(edits) && edits[i].op == ' ' {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+		// a hunk runs until the changes are further apart than two contexts
+		last := i
+		for j := i; j < len(edits) && j-last <= 2*contextLines; j++ {
+			if edits[j].op != ' ' {
+				last = j
+			}
+		}
+		start, stop := max(0, i-contextLines), min(len(edits), last+contextLines+1)
+		writeHunk(&out, edits[start:stop])
+		i = stop
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, hunk []edit) {
+	aStart, bStart := hunk[0].a+1, hunk[0].b+1
+	var aCount, bCount int
+	for _, e := range hunk {
+		if e.op != '+' {
+			aCount++
+		}
+		if e.op != '-' {
+			bCount++
+		}
+	}
+	// an empty side is numbered by the line before it
+	if aCount == 0 {
+		aStart--
+	}
+	if bCount == 0 {
+		bStart--
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+	for _, e := range hunk {
+		out.WriteByte(e.op)
+		out.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// splitLines splits s after each newline
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines finds the shortest edit script from a to b with Myers' algorithm
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] is the frontier before round d, for diagonals -d-1 to d+1
+	var trace [][]int
+	for d := 0; d <= min(n+m, maxEdits); d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b)
+			}
+		}
+	}
+	return replaceAll(a, b)
+}
+
+// backtrack walks the saved frontiers from the end of both texts back to
+// the start, recovering the edits
+func backtrack(trace [][]int, a, b []string) []edit {
+	var edits []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		frontier := func(k int) int { return trace[d][k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && frontier(k-1) < frontier(k+1)) {
+			prevK = k + 1
+		}
+		prevX := frontier(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			edits = append(edits, edit{' ', a[x], x, y})
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{'+', b[y], x, y})
+		} else {
+			x--
+			edits = append(edits, edit{'-', a[x], x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		edits = append(edits, edit{' ', a[x], x, y})
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// replaceAll is the diff that removes all of a and adds all of b
+func replaceAll(a, b []string) []edit {
+	edits := make([]edit, 0, len(a)+len(b))
+	for i, line := range a {
+		edits = append(edits, edit{'-', line, i, 0})
+	}
+	for i, line := range b {
+		edits = append(edits, edit{'+', line, len(a), i})
+	}
+	return edits
+}
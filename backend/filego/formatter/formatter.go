@@ -0,0 +1,79 @@
+// Package formatter formats source files with each language's canonical
+// formatter: gofmt in process for Go, and rustfmt, clang-format and
+// prettier, for AssemblyScript, as filters the caller runs, in the project's
+// container. prettier is the one installed with the toolchains, never one
+// from the project's node_modules.
+package formatter
+
+import (
+	"context"
+	"errors"
+	"go/format"
+	"path"
+	"strings"
+)
+
+// ErrUnsupported is returned for files no formatter handles
+var ErrUnsupported = errors.New("no formatter for this file type")
+
+// Runner runs a formatter command in dir, with input as its stdin, and
+// returns its stdout
+type Runner func(ctx context.Context, dir, command string, input []byte) ([]byte, error)
+
+// Formatter formats one kind of file
+type Formatter struct {
+	Name string
+	// command is the shell command formatting stdin to stdout; %s is the
+	// quoted file name, which tools use to find their configuration
+	command   string
+	inProcess func([]byte) ([]byte, error)
+}
+
+var (
+	gofmt       = &Formatter{Name: "gofmt", inProcess: format.Source}
+	rustfmt     = &Formatter{Name: "rustfmt", command: "rustfmt --edition 2021 --emit stdout"}
+	clangFormat = &Formatter{Name: "clang-format", command: "clang-format --assume-filename=%s"}
+	prettier    = &Formatter{Name: "prettier", command: "prettier --stdin-filepath %s"}
+)
+
+// byExt maps file extensions to their formatter
+var byExt = map[string]*Formatter{
+	".go":   gofmt,
+	".rs":   rustfmt,
+	".c":    clangFormat,
+	".h":    clangFormat,
+	".cc":   clangFormat,
+	".cpp":  clangFormat,
+	".hpp":  clangFormat,
+	".ts":   prettier,
+	".js":   prettier,
+	".json": prettier,
+}
+
+// For returns the formatter of the file name
+func For(name string) (*Formatter, bool) {
+	f, ok := byExt[strings.ToLower(path.Ext(name))]
+	return f, ok
+}
+
+// Format formats src, the content of name, a path relative to dir.
+// External formatters are run through run.
+func (f *Formatter) Format(ctx context.Context, run Runner, dir, name string, src []byte) ([]byte, error) {
+	if f.inProcess != nil {
+		return f.inProcess(src)
+	}
+	return run(ctx, dir, strings.ReplaceAll(f.command, "%s", shellQuote(name)), src)
+}
+
+// Format formats src, the content of name, with the formatter for its type
+func Format(ctx context.Context, run Runner, dir, name string, src []byte) ([]byte, error) {
+	f, ok := For(name)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return f.Format(ctx, run, dir, name, src)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,67 @@
+package formatter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	var ran string
+	run := func(ctx context.Context, dir, command string, input []byte) ([]byte, error) {
+		ran = command
+		return input, nil
+	}
+
+	out, err := Format(context.Background(), run, ".", "main.go", []byte("package main\nfunc main(){}\n"))
+	if err != nil || string(out) != "package main\n\nfunc main() {}\n" {
+		t.Errorf("gofmt: got %q, %v", out, err)
+	}
+	if _, err := Format(context.Background(), run, ".", "main.go", []byte("package main\nfunc {")); err == nil {
+		t.Error("gofmt accepted a syntax error")
+	}
+	if ran != "" {
+		t.Errorf("gofmt ran %q", ran)
+	}
+
+	Format(context.Background(), run, ".", "src/it's.c", nil)
+	if ran != `clang-format --assume-filename='src/it'\''s.c'` {
+		t.Errorf("clang-format ran %q", ran)
+	}
+	if _, err := Format(context.Background(), run, ".", "notes.txt", nil); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	new := strings.Replace(strings.Replace(old, "b\n", "B\n", 1), "l\n", "", 1) + "n"
+	want := `--- a/x.txt
++++ b/x.txt
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -9,5 +9,5 @@
+ i
+ j
+ k
+-l
+ m
++n
+\ No newline at end of file
+`
+	if got := Unified("x.txt", old, new); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := Unified("x.txt", old, old); got != "" {
+		t.Errorf("equal files: got %q", got)
+	}
+	if got := Unified("x.txt", "", "one\n"); got != "--- a/x.txt\n+++ b/x.txt\n@@ -0,0 +1,1 @@\n+one\n" {
+		t.Errorf("new file: got %q", got)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"xxx/gitrepo"
+	"xxx/projects"
+
+	"muhammadyasir-dev/cmd/ratelimit"
+)
+
+// defaultRemotesDir holds the bare repositories projects clone from and push
+// to, unless GIT_REMOTES_DIR says otherwise
+const defaultRemotesDir = "./git-remotes"
+
+// GitRequest is the body of the git operations that change something.
+// Each operation reads the fields it needs.
+type GitRequest struct {
+	Branch  string   `json:"branch"`
+	Create  bool     `json:"create"`
+	Paths   []string `json:"paths"`
+	Message string   `json:"message"`
+	Remote  string   `json:"remote"`
+}
+
+// CommitResponse reports a new commit
+type CommitResponse struct {
+	Success bool   `json:"success"`
+	Hash    string `json:"hash"`
+}
+
+// commitAuthor names the signed-in user for a commit, preferring the account
+// on record over the token's claims, which were copied from it at login
+func (s *Server) commitAuthor(r *http.Request) (gitrepo.Signature, bool) {
+	claims, ok := ratelimit.Claims(r, jwtSecret())
+	if !ok {
+		return gitrepo.Signature{}, false
+	}
+	var author gitrepo.Signature
+	author.Name, _ = claims["name"].(string)
+	author.Email, _ = claims["email"].(string)
+	if id, ok := claims["id"].(float64); ok && s.users != nil {
+		if user, err := s.users.ByID(r.Context(), uint(id)); err == nil {
+			author = gitrepo.Signature{Name: user.Name, Email: user.Email}
+		}
+	}
+	if author.Email == "" {
+		return author, false
+	}
+	if author.Name == "" {
+		author.Name = author.Email
+	}
+	return author, true
+}
+
+// gitHandler serves /projects/{id}/git/{op}:
+//
+//	POST init      {"branch"}             create a repository (branch defaults to main)
+//	GET  status                           current branch and changed files
+//	GET  diff      ?staged=1&path=...     unified diff
+//	POST stage     {"paths"}              add to the index, everything when empty
+//	POST unstage   {"paths"}              remove from the index
+//	POST commit    {"message"}            commit as the signed-in user
+//	GET  log       ?limit=50              recent commits
+//	GET  branches                         local branches
+//	POST checkout  {"branch", "create"}   switch, or create and switch
+//	POST clone     {"remote"}             check out one of the owner's remotes into the project
+//	POST push      {"remote", "branch"}   push a branch to the owner's remote, creating it if needed
+func (s *Server) gitHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	dir, _ := s.projects.Dir(project.ID)
+	gitDir, _ := s.projects.GitDir(project.ID)
+	repo := gitrepo.Open(dir, gitDir, "/"+projects.MetaFile)
+	op := r.PathValue("op")
+
+	var req GitRequest
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: "Invalid JSON body",
+			})
+			return
+		}
+	}
+
+	ctx := r.Context()
+	var err error
+	switch r.Method + " " + op {
+	case "POST init":
+		if req.Branch == "" {
+			req.Branch = "main"
+		}
+		err = repo.Init(ctx, req.Branch)
+	case "GET status":
+		var status *gitrepo.Status
+		if status, err = repo.Status(ctx); err == nil {
+			s.jsonResponse(w, http.StatusOK, status)
+			return
+		}
+	case "GET diff":
+		var diff string
+		if diff, err = repo.Diff(ctx, r.URL.Query().Get("staged") == "1", r.URL.Query()["path"]...); err == nil {
+			w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+			w.Write([]byte(diff))
+			return
+		}
+	case "POST stage":
+		err = repo.Stage(ctx, req.Paths...)
+	case "POST unstage":
+		err = repo.Unstage(ctx, req.Paths...)
+	case "POST commit":
+		author, signedIn := s.commitAuthor(r)
+		if !signedIn {
+			s.jsonResponse(w, http.StatusUnauthorized, FileResponse{
+				Success: false,
+				Message: "Sign in to commit",
+			})
+			return
+		}
+		var hash string
+		if hash, err = repo.Commit(ctx, req.Message, author); err == nil {
+			s.logger.InfoContext(ctx, "git commit", "project", project.ID, "hash", hash)
+			s.jsonResponse(w, http.StatusCreated, CommitResponse{Success: true, Hash: hash})
+			return
+		}
+	case "GET log":
+		limit := 50
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if n, convErr := strconv.Atoi(v); convErr == nil && n > 0 && n <= 1000 {
+				limit = n
+			}
+		}
+		var commits []gitrepo.Commit
+		if commits, err = repo.Log(ctx, limit); err == nil {
+			s.jsonResponse(w, http.StatusOK, commits)
+			return
+		}
+	case "GET branches":
+		var branches *gitrepo.Branches
+		if branches, err = repo.Branches(ctx); err == nil {
+			s.jsonResponse(w, http.StatusOK, branches)
+			return
+		}
+	case "POST checkout":
+		err = repo.Checkout(ctx, req.Branch, req.Create)
+	case "POST clone":
+		err = repo.Clone(ctx, s.remotes.Owner(project.Owner), req.Remote)
+	case "POST push":
+		err = repo.Push(ctx, s.remotes.Owner(project.Owner), req.Remote, req.Branch)
+	default:
+		s.jsonResponse(w, http.StatusNotFound, FileResponse{
+			Success: false,
+			Message: "Unknown git operation " + r.Method + " " + op,
+		})
+		return
+	}
+
+	if err != nil {
+		s.gitError(w, r, project.ID, op, err)
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, FileResponse{
+		Success: true,
+		Message: "git " + op + " succeeded",
+	})
+}
+
+// gitError maps a failed git operation to a response
+func (s *Server) gitError(w http.ResponseWriter, r *http.Request, project, op string, err error) {
+	var gitErr *gitrepo.Error
+	switch {
+	case errors.Is(err, gitrepo.ErrInvalidName):
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+	case errors.Is(err, gitrepo.ErrNotRepository), errors.Is(err, gitrepo.ErrAlreadyRepository), errors.As(err, &gitErr):
+		// refused by git itself: nothing to commit, unknown branch, rejected push...
+		s.jsonResponse(w, http.StatusConflict, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+	default:
+		s.logger.ErrorContext(r.Context(), "git operation failed", "project", project, "op", op, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "git " + op + " failed",
+		})
+	}
+}
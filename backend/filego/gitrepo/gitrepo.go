@@ -0,0 +1,469 @@
+// Package gitrepo runs version control operations on a project directory
+// with the git binary. The repository itself is kept outside the project, so
+// nothing that runs in the project can change its configuration. Remotes are
+// bare repositories hosted under a local root directory, so projects never
+// reach out to the network.
+package gitrepo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"muhammadyasir-dev/cmd/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	// ErrNotRepository is returned for operations on a project without git
+	ErrNotRepository = errors.New("project is not a git repository")
+	// ErrAlreadyRepository is returned when initialising or cloning into a repository
+	ErrAlreadyRepository = errors.New("project is already a git repository")
+	// ErrInvalidName is returned for unsafe branch or remote names
+	ErrInvalidName = errors.New("invalid branch or remote name")
+)
+
+// Error is a git command that ran and failed, such as a commit with
+// nothing staged. Output is what git printed.
+type Error struct {
+	Command string
+	Output  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("git %s: %s", e.Command, strings.TrimSpace(e.Output))
+}
+
+// safeFlags are a second line of defence behind keeping the repository out
+// of users' reach: hooks and filesystem monitors are ignored
+var safeFlags = []string{"-c", "core.hooksPath=/dev/null", "-c", "core.fsmonitor=false"}
+
+var (
+	validBranch = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,99}$`)
+	validRemote = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}(/[a-z0-9][a-z0-9_-]{0,63})?$`)
+)
+
+// Signature names who makes a commit
+type Signature struct {
+	Name  string
+	Email string
+}
+
+// Repo is the working tree at Dir, whose repository is at GitDir
+type Repo struct {
+	Dir    string
+	GitDir string
+	// Ignore lists patterns written to .git/info/exclude when the repository
+	// is created, for files that belong to the server rather than the code
+	Ignore []string
+}
+
+// Open returns the repository at gitDir of the project at dir, which need
+// not be initialised yet. gitDir must be outside dir.
+func Open(dir, gitDir string, ignore ...string) *Repo {
+	return &Repo{Dir: dir, GitDir: gitDir, Ignore: ignore}
+}
+
+// Initialized reports whether the project has a repository
+func (r *Repo) Initialized() bool {
+	info, err := os.Stat(r.GitDir)
+	return err == nil && info.IsDir()
+}
+
+// git runs a git subcommand in the working tree, or on the bare repository
+// when there is none, with env added to a minimal environment: nothing of
+// the server's, and no system or global configuration
+func (r *Repo) git(ctx context.Context, env []string, args ...string) (string, error) {
+	ctx, span := tracing.Start(ctx, "git."+args[0], attribute.String("git.dir", r.Dir))
+
+	gitDir, err := filepath.Abs(r.GitDir)
+	if err != nil {
+		tracing.End(span, err)
+		return "", err
+	}
+	flags := []string{"--git-dir=" + gitDir}
+	if r.Dir != "" {
+		workTree, err := filepath.Abs(r.Dir)
+		if err != nil {
+			tracing.End(span, err)
+			return "", err
+		}
+		flags = append(flags, "--work-tree="+workTree, "-C", workTree)
+	}
+	cmd := exec.CommandContext(ctx, "git", slices.Concat(safeFlags, flags, args)...)
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_TERMINAL_PROMPT=0",
+		"LC_ALL=C",
+	}
+	cmd.Env = append(cmd.Env, env...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = &Error{Command: args[0], Output: out.String()}
+	}
+	tracing.End(span, err)
+	return out.String(), err
+}
+
+// ready fails unless the project has a repository
+func (r *Repo) ready() error {
+	if !r.Initialized() {
+		return ErrNotRepository
+	}
+	return nil
+}
+
+// Init creates a repository with branch as the initial branch
+func (r *Repo) Init(ctx context.Context, branch string) error {
+	if r.Initialized() {
+		return ErrAlreadyRepository
+	}
+	if !validBranch.MatchString(branch) {
+		return ErrInvalidName
+	}
+	if err := os.MkdirAll(filepath.Dir(r.GitDir), 0755); err != nil {
+		return err
+	}
+	if _, err := r.git(ctx, nil, "init", "--quiet", "--initial-branch="+branch); err != nil {
+		return err
+	}
+	if len(r.Ignore) == 0 {
+		return nil
+	}
+	exclude := filepath.Join(r.GitDir, "info", "exclude")
+	if err := os.MkdirAll(filepath.Dir(exclude), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(exclude, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(r.Ignore, "\n") + "\n"); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// FileStatus is one changed path as reported by git status
+type FileStatus struct {
+	Path     string `json:"path"`
+	From     string `json:"from,omitempty"` // original path of a rename
+	Staged   string `json:"staged"`         // index status letter, " " when unchanged
+	Worktree string `json:"worktree"`       // working tree status letter
+}
+
+// Status is the current branch and changed files
+type Status struct {
+	Branch string       `json:"branch"`
+	Files  []FileStatus `json:"files"`
+}
+
+// Status lists changed and untracked files
+func (r *Repo) Status(ctx context.Context) (*Status, error) {
+	if err := r.ready(); err != nil {
+		return nil, err
+	}
+	out, err := r.git(ctx, nil, "status", "--porcelain=v1", "-z", "--branch", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(out), nil
+}
+
+// parseStatus reads the -z porcelain v1 format: "XY path\0", where renames
+// are followed by their original path as a separate entry
+func parseStatus(out string) *Status {
+	status := &Status{Files: []FileStatus{}}
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		if strings.HasPrefix(entry, "## ") {
+			branch := strings.TrimPrefix(entry, "## ")
+			branch = strings.TrimPrefix(branch, "No commits yet on ")
+			branch, _, _ = strings.Cut(branch, "...")
+			status.Branch, _, _ = strings.Cut(branch, " ")
+			continue
+		}
+		file := FileStatus{Staged: entry[:1], Worktree: entry[1:2], Path: entry[3:]}
+		if (file.Staged == "R" || file.Staged == "C") && i+1 < len(entries) {
+			i++
+			file.From = entries[i]
+		}
+		status.Files = append(status.Files, file)
+	}
+	return status
+}
+
+// Diff returns the unified diff of the working tree against the index, or
+// of the index against HEAD when staged is set, limited to paths if any
+func (r *Repo) Diff(ctx context.Context, staged bool, paths ...string) (string, error) {
+	if err := r.ready(); err != nil {
+		return "", err
+	}
+	args := []string{"diff", "--no-color", "--no-ext-diff"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	return r.git(ctx, nil, append(append(args, "--"), paths...)...)
+}
+
+// Stage adds paths, or everything when none are given, to the index
+func (r *Repo) Stage(ctx context.Context, paths ...string) error {
+	if err := r.ready(); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	_, err := r.git(ctx, nil, append([]string{"add", "--all", "--"}, paths...)...)
+	return err
+}
+
+// Unstage removes paths, or everything when none are given, from the index
+func (r *Repo) Unstage(ctx context.Context, paths ...string) error {
+	if err := r.ready(); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	// rm --cached also works before the first commit, when there is no HEAD to reset to
+	if _, err := r.git(ctx, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		_, err = r.git(ctx, nil, append([]string{"rm", "--cached", "-r", "--quiet", "--"}, paths...)...)
+		return err
+	}
+	_, err := r.git(ctx, nil, append([]string{"reset", "--quiet", "--"}, paths...)...)
+	return err
+}
+
+// Commit records the index as author and returns the new commit's hash
+func (r *Repo) Commit(ctx context.Context, message string, author Signature) (string, error) {
+	if err := r.ready(); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(message) == "" {
+		return "", &Error{Command: "commit", Output: "empty commit message"}
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=" + author.Name, "GIT_AUTHOR_EMAIL=" + author.Email,
+		"GIT_COMMITTER_NAME=" + author.Name, "GIT_COMMITTER_EMAIL=" + author.Email,
+	}
+	if _, err := r.git(ctx, env, "commit", "--quiet", "--no-verify", "--cleanup=strip", "-m", message); err != nil {
+		return "", err
+	}
+	out, err := r.git(ctx, nil, "rev-parse", "HEAD")
+	return strings.TrimSpace(out), err
+}
+
+// Commit is one entry of the log
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// Log returns up to limit commits reachable from HEAD, newest first
+func (r *Repo) Log(ctx context.Context, limit int) ([]Commit, error) {
+	if err := r.ready(); err != nil {
+		return nil, err
+	}
+	commits := []Commit{}
+	if _, err := r.git(ctx, nil, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return commits, nil // nothing committed yet
+	}
+	out, err := r.git(ctx, nil, "log", "-n", strconv.Itoa(limit), "--format=%H%x1f%an%x1f%ae%x1f%at%x1f%s%x1e")
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		unix, _ := strconv.ParseInt(fields[3], 10, 64)
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    time.Unix(unix, 0).UTC(),
+			Subject: fields[4],
+		})
+	}
+	return commits, nil
+}
+
+// Branches is the local branches and the one checked out
+type Branches struct {
+	Current  string   `json:"current"`
+	Branches []string `json:"branches"`
+}
+
+// Branches lists local branches
+func (r *Repo) Branches(ctx context.Context) (*Branches, error) {
+	if err := r.ready(); err != nil {
+		return nil, err
+	}
+	out, err := r.git(ctx, nil, "branch", "--list", "--format=%(HEAD)%(refname:short)")
+	if err != nil {
+		return nil, err
+	}
+	branches := &Branches{Branches: []string{}}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 2 {
+			continue
+		}
+		name := line[1:]
+		if line[0] == '*' {
+			branches.Current = name
+		}
+		branches.Branches = append(branches.Branches, name)
+	}
+	if branches.Current == "" {
+		// before the first commit the branch exists only as HEAD's target
+		if head, err := r.git(ctx, nil, "symbolic-ref", "--short", "HEAD"); err == nil {
+			branches.Current = strings.TrimSpace(head)
+		}
+	}
+	return branches, nil
+}
+
+// Checkout switches to branch, creating it from the current commit when create is set
+func (r *Repo) Checkout(ctx context.Context, branch string, create bool) error {
+	if err := r.ready(); err != nil {
+		return err
+	}
+	if !validBranch.MatchString(branch) {
+		return ErrInvalidName
+	}
+	if create {
+		_, err := r.git(ctx, nil, "switch", "--quiet", "--create", branch)
+		return err
+	}
+	_, err := r.git(ctx, nil, "switch", "--quiet", branch)
+	return err
+}
+
+// Remotes hosts bare repositories under Root that projects clone from and
+// push to. A remote is named by a path such as "team/app", stored at
+// Root/team/app.git. Each user has their own Remotes, from Owner.
+type Remotes struct {
+	Root string
+}
+
+// Owner returns the remotes of user owner, under Root/user-<owner>, so
+// users can't clone or push to each other's
+func (rs *Remotes) Owner(owner uint) *Remotes {
+	return &Remotes{Root: filepath.Join(rs.Root, "user-"+strconv.FormatUint(uint64(owner), 10))}
+}
+
+// NewRemotes returns the remotes under root, creating it if needed
+func NewRemotes(root string) (*Remotes, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Remotes{Root: abs}, nil
+}
+
+// Path returns where remote name is stored
+func (rs *Remotes) Path(name string) (string, error) {
+	if !validRemote.MatchString(name) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(rs.Root, filepath.FromSlash(name)+".git"), nil
+}
+
+// Clone checks out remote into the project, replacing files that exist in
+// both. The project must not have a repository yet.
+func (r *Repo) Clone(ctx context.Context, remotes *Remotes, remote string) error {
+	if r.Initialized() {
+		return ErrAlreadyRepository
+	}
+	url, err := remotes.Path(remote)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(url); err != nil {
+		return &Error{Command: "clone", Output: "remote " + remote + " does not exist"}
+	}
+
+	// fetching into the existing tree keeps files the remote doesn't have
+	if err := r.Init(ctx, "main"); err != nil {
+		return err
+	}
+	steps := [][]string{
+		{"remote", "add", "origin", url},
+		{"fetch", "--quiet", "origin"},
+	}
+	for _, args := range steps {
+		if _, err := r.git(ctx, nil, args...); err != nil {
+			os.RemoveAll(r.GitDir)
+			return err
+		}
+	}
+
+	// check out the remote's default branch, if it has any commits
+	head, err := r.git(ctx, nil, "ls-remote", "--symref", "origin", "HEAD")
+	branch := "main"
+	if rest, ok := strings.CutPrefix(head, "ref: refs/heads/"); err == nil && ok {
+		branch, _, _ = strings.Cut(rest, "\t")
+	}
+	if _, err := r.git(ctx, nil, "rev-parse", "--verify", "--quiet", "origin/"+branch); err != nil {
+		return nil // an empty remote leaves an empty repository tracking origin
+	}
+	_, err = r.git(ctx, nil, "checkout", "--quiet", "--force", "-B", branch, "--track", "origin/"+branch)
+	return err
+}
+
+// Push pushes branch to remote, creating the bare repository on first push
+func (r *Repo) Push(ctx context.Context, remotes *Remotes, remote, branch string) error {
+	if err := r.ready(); err != nil {
+		return err
+	}
+	if !validBranch.MatchString(branch) {
+		return ErrInvalidName
+	}
+	url, err := remotes.Path(remote)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(url); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(url, 0755); err != nil {
+			return err
+		}
+		if _, err := (&Repo{GitDir: url}).git(ctx, nil, "init", "--quiet", "--bare", "--initial-branch="+branch); err != nil {
+			return err
+		}
+	}
+	_, err = r.git(ctx, nil, "push", "--quiet", "--no-verify", url, "refs/heads/"+branch+":refs/heads/"+branch)
+	return err
+}
@@ -0,0 +1,163 @@
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommitPushClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	ctx := context.Background()
+	author := Signature{Name: "Ada", Email: "ada@example.com"}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".wasmide.json"), []byte("{}"), 0644)
+	repo := Open(dir, filepath.Join(t.TempDir(), "repos", "repo.git"), "/.wasmide.json")
+
+	if _, err := repo.Status(ctx); !errors.Is(err, ErrNotRepository) {
+		t.Fatalf("expected ErrNotRepository, got %v", err)
+	}
+	if err := repo.Init(ctx, "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := repo.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Branch != "main" || len(status.Files) != 1 || status.Files[0].Path != "main.go" || status.Files[0].Worktree != "?" {
+		t.Fatalf("expected only main.go untracked on main, got %+v", status)
+	}
+
+	if err := repo.Stage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.Commit(ctx, "Initial commit", author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit(ctx, "Nothing", author); err == nil {
+		t.Error("expected committing a clean tree to fail")
+	}
+
+	commits, err := repo.Log(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Hash != hash || commits[0].Email != author.Email || commits[0].Subject != "Initial commit" {
+		t.Fatalf("unexpected log %+v", commits)
+	}
+
+	if err := repo.Checkout(ctx, "feature", true); err != nil {
+		t.Fatal(err)
+	}
+	branches, err := repo.Branches(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branches.Current != "feature" || len(branches.Branches) != 2 {
+		t.Errorf("expected to be on feature with two branches, got %+v", branches)
+	}
+	if err := repo.Checkout(ctx, "--force", false); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected option-like branch names to be rejected, got %v", err)
+	}
+
+	remotes, err := NewRemotes(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(ctx, remotes, "team/app", "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(ctx, remotes, "../escape", "feature"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected remote names outside the root to be rejected, got %v", err)
+	}
+
+	clone := Open(t.TempDir(), filepath.Join(t.TempDir(), "clone.git"))
+	if err := clone.Clone(ctx, remotes, "team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(clone.Dir, "main.go")); err != nil {
+		t.Errorf("expected the clone to check out main.go: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(clone.Dir, ".wasmide.json")); err == nil {
+		t.Error("ignored files must not be committed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		t.Error("the repository must be kept outside the working tree")
+	}
+}
+
+func TestProjectCannotConfigureRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	ctx := context.Background()
+
+	// a project planting a filter the way it could in a repository inside it
+	dir := t.TempDir()
+	pwned := filepath.Join(t.TempDir(), "pwned")
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, ".git", "config"), []byte("[filter \"x\"]\n\tclean = touch "+pwned+"\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".gitattributes"), []byte("* filter=x\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+
+	repo := Open(dir, filepath.Join(t.TempDir(), "repo.git"))
+	if err := repo.Init(ctx, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Stage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Fatal("a filter configured inside the project ran")
+	}
+}
+
+func TestRemotesAreKeyedByOwner(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644)
+	repo := Open(dir, filepath.Join(t.TempDir(), "repo.git"))
+	if err := repo.Init(ctx, "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Stage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit(ctx, "Initial commit", Signature{Name: "Ada", Email: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	remotes, err := NewRemotes(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(ctx, remotes.Owner(1), "app", "main"); err != nil {
+		t.Fatal(err)
+	}
+
+	var gitErr *Error
+	other := Open(t.TempDir(), filepath.Join(t.TempDir(), "other.git"))
+	if err := other.Clone(ctx, remotes.Owner(2), "app"); !errors.As(err, &gitErr) {
+		t.Errorf("expected another user's remote to be missing, got %v", err)
+	}
+	if err := other.Clone(ctx, remotes.Owner(2), "../user-1/app"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("expected names reaching into another user's remotes to be rejected, got %v", err)
+	}
+	mine := Open(t.TempDir(), filepath.Join(t.TempDir(), "mine.git"))
+	if err := mine.Clone(ctx, remotes.Owner(1), "app"); err != nil {
+		t.Errorf("the owner must be able to clone their remote: %v", err)
+	}
+}
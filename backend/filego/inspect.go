@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"xxx/wasm"
+)
+
+// loadModule decodes the compiled module of a stored build, writing the
+// error response when there is none or it cannot be decoded
+func (s *Server) loadModule(w http.ResponseWriter, r *http.Request) (*wasm.Module, string, bool) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return nil, "", false
+	}
+	id := r.PathValue("build")
+	build, err := s.artifacts.Get(project.ID, id)
+	if err != nil {
+		s.artifactError(w, r, err)
+		return nil, "", false
+	}
+	module, ok := build.Module()
+	if !ok {
+		s.jsonResponse(w, http.StatusNotFound, FileResponse{
+			Success: false,
+			Message: "Build did not produce a module",
+		})
+		return nil, "", false
+	}
+	f, _, err := s.artifacts.Open(project.ID, id, module.Name)
+	if err != nil {
+		s.artifactError(w, r, err)
+		return nil, "", false
+	}
+	defer f.Close()
+	bin, err := io.ReadAll(f)
+	if err != nil {
+		s.artifactError(w, r, err)
+		return nil, "", false
+	}
+
+	m, err := wasm.Decode(bin)
+	if err != nil {
+		s.logger.WarnContext(r.Context(), "cannot decode module", "project", project.ID, "build", id, "error", err)
+		s.jsonResponse(w, http.StatusUnprocessableEntity, FileResponse{
+			Success: false,
+			Message: "Invalid module: " + err.Error(),
+		})
+		return nil, "", false
+	}
+	return m, module.SHA256, true
+}
+
+// inspectHandler describes the module of a stored build: its sections,
+// imports, exports with signatures, memory and table limits, custom
+// sections and where its bytes go
+func (s *Server) inspectHandler(w http.ResponseWriter, r *http.Request) {
+	m, _, ok := s.loadModule(w, r)
+	if !ok {
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, wasm.Inspect(m))
+}
+
+// watHandler disassembles the module of a stored build to the text format
+func (s *Server) watHandler(w http.ResponseWriter, r *http.Request) {
+	m, hash, ok := s.loadModule(w, r)
+	if !ok {
+		return
+	}
+	// a stored module never changes, so neither does its disassembly
+	etag := strconv.Quote("wat-" + hash)
+	w.Header().Set("ETag", etag)
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := wasm.Disassemble(w, m); err != nil {
+		s.logger.WarnContext(r.Context(), "error writing disassembly", "error", err)
+	}
+}
@@ -1,22 +1,45 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"io"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+	"xxx/artifacts"
+	"xxx/buildcache"
+	"xxx/formatter"
+	"xxx/gitrepo"
+	"xxx/projects"
 	"xxx/runnerservice"
+	"xxx/search"
+	"xxx/templates"
+	"xxx/watch"
+
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/dbs"
+	"muhammadyasir-dev/cmd/health"
+	"muhammadyasir-dev/cmd/logging"
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/ratelimit"
+	"muhammadyasir-dev/cmd/repository"
+	httpserver "muhammadyasir-dev/cmd/server"
+	"muhammadyasir-dev/cmd/tracing"
 )
 
 // Configuration constants
 const (
 	fileDir      = "./files" // Directory to store files
 	maxFileSizes = 10 << 20  // 10 MB maximum file size
-	serverPort   = ":8082"   // Server port
+	serverPort   = ":8082"   // Default listen address, override with FILEGO_ADDR
+	// defaultRunnerIdleMS is how long a runner container may go without a
+	// job before it is removed; RUNNER_IDLE_TIMEOUT_MS=0 keeps them
+	defaultRunnerIdleMS = 30 * 60 * 1000
 )
 
 // FileChange represents a change made to a file
@@ -31,47 +54,238 @@ type FileResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Content string `json:"content,omitempty"`
+	// Encoding is base64 when Content is, for files that are not text
+	Encoding string `json:"encoding,omitempty"`
 }
 
 // Server represents our HTTP server and its dependencies
 type Server struct {
-	logger *log.Logger
+	logger    *slog.Logger
+	usage     *accounting.Recorder
+	templates *templates.Registry
+	projects  *projects.Store
+	remotes   *gitrepo.Remotes
+	artifacts *artifacts.Store
+	cache     *buildcache.Cache
+	indexes   *search.Indexes // trigrams of project files, for search
+	watchers  *watch.Hub
+	// toolchainCaches holds each user's Cargo and Go caches, as an absolute
+	// path since builds run in the project directory
+	toolchainCaches string
+	users           repository.UserRepository
+	// previewOrigin is where browser bundles are served from
+	previewOrigin string
 }
 
 func main() {
 	// Initialize logger
-	logger := log.New(os.Stdout, "[FileEditor] ", log.LstdFlags|log.Lshortfile)
+	logger := logging.New("filego")
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Init(context.Background(), "wasmide-filego")
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	if secret, err = ratelimit.Secret(); err != nil {
+		logger.Error("refusing to start", "error", err)
+		os.Exit(1)
+	}
 
 	// Create new server instance
 	server := &Server{
-		logger: logger,
+		logger:    logger,
+		templates: templates.NewRegistry(),
+		indexes:   search.NewIndexes(),
+	}
+	server.watchers = watch.NewHub(time.Duration(envInt64("WATCH_DEBOUNCE_MS", defaultWatchDebounceMS))*time.Millisecond, skipWatched, logger)
+	server.watchers.MaxWait = time.Duration(envInt64("WATCH_MAX_WAIT_MS", int64(server.watchers.MaxWait/time.Millisecond))) * time.Millisecond
+	server.watchers.MaxDirs = int(envInt64("WATCH_MAX_DIRS", int64(server.watchers.MaxDirs)))
+	if err := loadCustomTemplates(server.templates); err != nil {
+		logger.Error("failed to load custom templates", "error", err)
+		os.Exit(1)
+	}
+
+	if os.Getenv("RUNNER_IMAGE") == "" {
+		logger.Warn("RUNNER_IMAGE is not set: builds, runs, tests and external formatters will fail")
+	}
+	if idle := time.Duration(envInt64("RUNNER_IDLE_TIMEOUT_MS", defaultRunnerIdleMS)) * time.Millisecond; idle > 0 {
+		go runnerservice.ReapIdle(context.Background(), idle, time.Minute)
 	}
 
 	// Ensure the files directory exists
 	if err := os.MkdirAll(fileDir, os.ModePerm); err != nil {
-		logger.Fatalf("Failed to create files directory: %v", err)
+		logger.Error("failed to create files directory", "dir", fileDir, "error", err)
+		os.Exit(1)
+	}
+	if server.projects, err = projects.NewStore(projectsDir); err != nil {
+		logger.Error("failed to create projects directory", "dir", projectsDir, "error", err)
+		os.Exit(1)
+	}
+	if server.artifacts, err = artifacts.NewStore(artifactsDir, artifactRetention()); err != nil {
+		logger.Error("failed to create artifacts directory", "dir", artifactsDir, "error", err)
+		os.Exit(1)
+	}
+	if server.cache, err = buildcache.New(buildCacheDir, envInt64("BUILD_CACHE_MAX_BYTES", defaultCacheMaxSize)); err != nil {
+		logger.Error("failed to create build cache", "dir", buildCacheDir, "error", err)
+		os.Exit(1)
 	}
+	if server.toolchainCaches, err = filepath.Abs(toolchainCachesDir); err != nil {
+		logger.Error("failed to resolve toolchain cache directory", "dir", toolchainCachesDir, "error", err)
+		os.Exit(1)
+	}
+	remotesDir := os.Getenv("GIT_REMOTES_DIR")
+	if remotesDir == "" {
+		remotesDir = defaultRemotesDir
+	}
+	if server.remotes, err = gitrepo.NewRemotes(remotesDir); err != nil {
+		logger.Error("failed to create git remotes directory", "dir", remotesDir, "error", err)
+		os.Exit(1)
+	}
+
+	// Configure server
+	cfg := httpserver.FromEnv("FILEGO", httpserver.Defaults(serverPort))
+	repos := openStore(logger)
+	server.usage = accounting.NewRecorder(repos.Usage)
+	server.users = repos.Users
+	limits := ratelimit.DefaultPolicy(ratelimit.ClientKey(jwtSecret()), repos.DailyUsage)
 
 	// Initialize routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/files/", server.corsMiddleware(server.fileHandler))
-	mux.HandleFunc("/create-file", server.corsMiddleware(server.createFileHandler))
+	// writes get their own bucket; reads and preflights fall through to the
+	// route above. Saves with ?format=1 run a formatter, so they count as
+	// executions.
+	saveFile := limits.FileLimited(http.HandlerFunc(server.fileHandler))
+	formatAndSaveFile := limits.ExecLimited(http.HandlerFunc(server.fileHandler))
+	mux.HandleFunc("POST /files/", server.corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "1" {
+			formatAndSaveFile.ServeHTTP(w, r)
+			return
+		}
+		saveFile.ServeHTTP(w, r)
+	}))
+	// raw uploads stream up to FILES_MAX_UPLOAD_BYTES, so they get the stream
+	// deadline for reading, and downloads for writing
+	mux.Handle("PUT /files/", httpserver.WithTimeouts(cfg.StreamTimeout, cfg.WriteTimeout,
+		server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.fileHandler)).ServeHTTP)))
+	mux.Handle("POST /upload", httpserver.WithTimeouts(cfg.StreamTimeout, cfg.WriteTimeout,
+		server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.uploadHandler)).ServeHTTP)))
+	mux.HandleFunc("/create-file", server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.createFileHandler)).ServeHTTP))
 	mux.HandleFunc("/list-files", server.corsMiddleware(server.listFilesHandler))
-	mux.HandleFunc("/runcode", server.corsMiddleware(server.Runcode))
-	// Configure server
-	srv := &http.Server{
-		Addr:         serverPort,
-		Handler:      mux,
-		ReadTimeout:  1 * time.Second,
-		WriteTimeout: 1 * time.Second,
-		IdleTimeout:  6 * time.Second,
-	}
+	mux.HandleFunc("/templates", server.corsMiddleware(server.templatesHandler))
+	mux.HandleFunc("/projects", server.corsMiddleware(server.projectsHandler))
+	mux.HandleFunc("POST /projects", server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.projectsHandler)).ServeHTTP))
+	mux.HandleFunc("/projects/{id}", server.corsMiddleware(server.projectHandler))
+	// archives can take a while to upload or download
+	mux.Handle("/projects/{id}/import", httpserver.WithTimeouts(cfg.StreamTimeout, cfg.WriteTimeout,
+		server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.importHandler)).ServeHTTP)))
+	mux.Handle("/projects/{id}/export", httpserver.WithTimeouts(cfg.ReadTimeout, cfg.StreamTimeout,
+		server.corsMiddleware(server.exportHandler)))
+	mux.Handle("GET /projects/{id}/files/{path...}", httpserver.WithTimeouts(cfg.ReadTimeout, cfg.StreamTimeout,
+		server.corsMiddleware(server.projectFileHandler)))
+	mux.Handle("PUT /projects/{id}/files/{path...}", httpserver.WithTimeouts(cfg.StreamTimeout, cfg.WriteTimeout,
+		server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.projectFileHandler)).ServeHTTP)))
+	mux.Handle("POST /projects/{id}/files", httpserver.WithTimeouts(cfg.StreamTimeout, cfg.WriteTimeout,
+		server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.projectUploadHandler)).ServeHTTP)))
+	mux.HandleFunc("GET /projects/{id}/builds", server.corsMiddleware(server.buildsHandler))
+	mux.HandleFunc("GET /projects/{id}/builds/{build}", server.corsMiddleware(server.buildHandler))
+	mux.HandleFunc("GET /projects/{id}/builds/{build}/files/{name}", server.corsMiddleware(server.artifactHandler))
+	mux.HandleFunc("GET /projects/{id}/builds/{build}/inspect", server.corsMiddleware(server.inspectHandler))
+	mux.HandleFunc("GET /projects/{id}/builds/{build}/wat", server.corsMiddleware(server.watHandler))
+	mux.HandleFunc("/projects/{id}/format", server.corsMiddleware(limits.ExecLimited(http.HandlerFunc(server.formatHandler)).ServeHTTP))
+	mux.HandleFunc("/projects/{id}/run-configs", server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.runConfigsHandler)).ServeHTTP))
+	mux.HandleFunc("/projects/{id}/run-configs/{name}", server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.runConfigHandler)).ServeHTTP))
+	mux.HandleFunc("GET /projects/{id}/search", server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.searchHandler)).ServeHTTP))
+	mux.HandleFunc("/projects/{id}/tests", server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.testsHandler)).ServeHTTP))
+	mux.Handle("POST /projects/{id}/tests", httpserver.WithTimeouts(cfg.ReadTimeout, cfg.StreamTimeout,
+		server.corsMiddleware(limits.ExecLimited(http.HandlerFunc(server.testsHandler)).ServeHTTP)))
+	mux.HandleFunc("/projects/{id}/git/{op}", server.corsMiddleware(limits.FileLimited(http.HandlerFunc(server.gitHandler)).ServeHTTP))
+	// debug sessions are bounded by DEBUG_SESSION_TIMEOUT_MS instead of the
+	// server's deadlines
+	mux.Handle("GET /projects/{id}/debug", httpserver.WithTimeouts(0, 0,
+		server.corsMiddleware(limits.ExecLimited(http.HandlerFunc(server.debugHandler)).ServeHTTP)))
+	// watches last as long as the client keeps them open
+	mux.Handle("GET /projects/{id}/watch", httpserver.WithTimeouts(0, 0, server.corsMiddleware(server.watchHandler)))
+	mux.Handle("GET /watch", httpserver.WithTimeouts(0, 0, server.corsMiddleware(server.watchFilesHandler)))
+	// builds run far longer than a file save, so /runcode gets the stream deadline
+	mux.Handle("/runcode", httpserver.WithTimeouts(cfg.ReadTimeout, cfg.StreamTimeout,
+		server.corsMiddleware(limits.ExecLimited(http.HandlerFunc(server.Runcode)).ServeHTTP)))
+	mux.HandleFunc("/usage", server.corsMiddleware(limits.UsageHandler().ServeHTTP))
+	mux.HandleFunc("GET /healthz", health.Healthz)
+	mux.Handle("GET /readyz", readinessChecks())
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	// Label metrics and spans with the matched pattern, which the mux sets on
+	// the request, and give every request an ID before anything logs
+	pattern := func(r *http.Request) string { return r.Pattern }
+	handler := metrics.Middleware(pattern)(mux)
+	handler = tracing.Middleware(pattern)(handler)
+	handler = logging.Middleware(logging.New("http"))(handler)
+
+	// Previews are served on their own listener, so user pages have an origin
+	// of their own
+	previewCfg := httpserver.FromEnv("PREVIEW", httpserver.Defaults(previewPort))
+	server.previewOrigin = previewOrigin(previewCfg.Addr)
+	previewMux := http.NewServeMux()
+	previewMux.HandleFunc("GET /preview/{id}/{token}/{path...}", server.previewHandler)
+	go func() {
+		if err := httpserver.New(previewCfg, previewMux, logger).Run(); err != nil {
+			logger.Error("preview server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
 
 	// Start server
-	logger.Printf("Starting server on port %s...", serverPort)
-	if err := srv.ListenAndServe(); err != nil {
-		logger.Fatalf("Server failed to start: %v", err)
+	if err := httpserver.New(cfg, handler, logger).Run(); err != nil {
+		logger.Error("server failed", "error", err)
+		shutdownTracing(context.Background())
+		os.Exit(1)
+	}
+}
+
+// secret is the key the API signs auth_token cookies with, read once at
+// startup
+var secret []byte
+
+// jwtSecret returns the key the API signs auth_token cookies with, so
+// projects and limits follow signed-in users
+func jwtSecret() []byte {
+	return secret
+}
+
+// openStore returns the Postgres store shared with the API, which holds
+// quota counters and usage records. When the database is unreachable they
+// are kept in memory instead, per process, which still bounds a runaway client.
+func openStore(logger *slog.Logger) *repository.Store {
+	if err := dbs.Connect(); err != nil {
+		logger.Warn("quota counters and usage kept in memory, database unavailable", "error", err)
+		return repository.NewMemory()
+	}
+	return repository.NewGorm(dbs.Db)
+}
+
+// readinessChecks lists the dependencies reported by /readyz
+func readinessChecks() *health.Checker {
+	checks := health.New()
+	checks.Add("storage", time.Second, health.Writable(fileDir))
+	checks.Add("projects", time.Second, health.Writable(projectsDir))
+	checks.Add("artifacts", time.Second, health.Writable(artifactsDir))
+	if dbs.Db != nil {
+		if sqlDB, err := dbs.Db.DB(); err == nil {
+			checks.AddOptional("postgres", 2*time.Second, health.SQL(sqlDB))
+		}
 	}
+
+	checks.AddOptional("git", time.Second, health.Binaries("git"))
+
+	// a missing toolchain only breaks its own language
+	for lang, binary := range map[string]string{"rust": "cargo", "go": "go", "c": "make"} {
+		checks.AddOptional("toolchain:"+lang, time.Second, health.Binaries(binary))
+	}
+	return checks
 }
 
 // corsMiddleware handles CORS headers and preflight requests
@@ -80,7 +294,8 @@ func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Range, Accept-Ranges, Content-Disposition")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -108,10 +323,16 @@ func (s *Server) fileHandler(w http.ResponseWriter, r *http.Request) {
 	filePath := filepath.Join(fileDir, fileName)
 
 	switch r.Method {
-	case http.MethodGet:
-		s.handleGetFile(w, filePath)
+	case http.MethodGet, http.MethodHead:
+		if r.URL.Query().Get("raw") == "1" {
+			s.serveRaw(w, r, filePath)
+			return
+		}
+		s.handleGetFile(w, r, filePath)
 	case http.MethodPost:
 		s.handleSaveFile(w, r, filePath)
+	case http.MethodPut:
+		s.handlePutFile(w, r, filePath)
 	default:
 		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
 			Success: false,
@@ -120,10 +341,12 @@ func (s *Server) fileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleGetFile handles retrieving file content
-func (s *Server) handleGetFile(w http.ResponseWriter, filePath string) {
-	content, err := os.ReadFile(filePath)
-	if os.IsNotExist(err) {
+// handleGetFile handles retrieving file content. Content that is not
+// UTF-8, or all content with ?encoding=base64, is returned base64 encoded.
+func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	content, err := readRegular(filePath)
+	metrics.ObserveFile("read", err)
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, errNotRegular) {
 		s.jsonResponse(w, http.StatusNotFound, FileResponse{
 			Success: false,
 			Message: "File not found",
@@ -131,7 +354,7 @@ func (s *Server) handleGetFile(w http.ResponseWriter, filePath string) {
 		return
 	}
 	if err != nil {
-		s.logger.Printf("Error reading file %s: %v", filePath, err)
+		s.logger.ErrorContext(r.Context(), "error reading file", "path", filePath, "error", err)
 		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
 			Success: false,
 			Message: "Error reading file",
@@ -139,31 +362,40 @@ func (s *Server) handleGetFile(w http.ResponseWriter, filePath string) {
 		return
 	}
 
-	s.jsonResponse(w, http.StatusOK, FileResponse{
-		Success: true,
-		Content: string(content),
-	})
+	response := FileResponse{Success: true}
+	response.Content, response.Encoding = encodeContent(content, r.URL.Query().Get("encoding"))
+	s.jsonResponse(w, http.StatusOK, response)
 }
 
-// handleSaveFile handles saving file content
+// handleSaveFile handles saving file content. With ?format=1 the content
+// is formatted first when its type has a formatter, and the formatted
+// content is returned; content that does not format is saved as sent.
+// With ?encoding=base64 the body is base64 encoded.
 func (s *Server) handleSaveFile(w http.ResponseWriter, r *http.Request, filePath string) {
-	// Limit request size
-	r.Body = http.MaxBytesReader(w, r.Body, 10048)
-
-	content, err := io.ReadAll(r.Body)
+	content, err := readBody(w, r)
 	if err != nil {
-		s.logger.Printf("Error reading request body: %v", err)
-		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
-			Success: false,
-			Message: "Error reading request body",
-		})
+		s.bodyError(w, r, err)
 		return
 	}
 
-	err = os.WriteFile(filePath, content, 0644)
+	message := "File saved successfully"
+	var formatted []byte
+	if r.URL.Query().Get("format") == "1" {
+		formatted, err = formatter.Format(r.Context(), formatFilter, filepath.Dir(filePath), filepath.Base(filePath), content)
+		switch {
+		case err == nil:
+			content = formatted
+			message = "File formatted and saved"
+		case !errors.Is(err, formatter.ErrUnsupported):
+			message = "File saved without formatting: " + err.Error()
+		}
+	}
+
+	err = writeFile(filePath, bytes.NewReader(content))
+	metrics.ObserveFile("write", err)
 
 	if err != nil {
-		s.logger.Printf("Error writing file %s: %v", filePath, err)
+		s.logger.ErrorContext(r.Context(), "error writing file", "path", filePath, "error", err)
 		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
 			Success: false,
 			Message: "Error writing file",
@@ -173,7 +405,8 @@ func (s *Server) handleSaveFile(w http.ResponseWriter, r *http.Request, filePath
 
 	s.jsonResponse(w, http.StatusOK, FileResponse{
 		Success: true,
-		Message: "File saved successfully",
+		Message: message,
+		Content: string(formatted),
 	})
 }
 
@@ -205,16 +438,21 @@ func (s *Server) createFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filePath := filepath.Join(fileDir, fileName)
-	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+	// O_EXCL also fails on a symbolic link, wherever it points
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		err = f.Close()
+	}
+	metrics.ObserveFile("create", err)
+	if errors.Is(err, os.ErrExist) {
 		s.jsonResponse(w, http.StatusConflict, FileResponse{
 			Success: false,
 			Message: "File already exists",
 		})
 		return
 	}
-
-	if err := os.WriteFile(filePath, []byte(""), 0644); err != nil {
-		s.logger.Printf("Error creating file %s: %v", filePath, err)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error creating file", "path", filePath, "error", err)
 		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
 			Success: false,
 			Message: "Error creating file",
@@ -239,8 +477,9 @@ func (s *Server) listFilesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	files, err := os.ReadDir(fileDir)
+	metrics.ObserveFile("list", err)
 	if err != nil {
-		s.logger.Printf("Error reading directory: %v", err)
+		s.logger.ErrorContext(r.Context(), "error reading directory", "dir", fileDir, "error", err)
 		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
 			Success: false,
 			Message: "Error reading directory",
@@ -250,7 +489,7 @@ func (s *Server) listFilesHandler(w http.ResponseWriter, r *http.Request) {
 
 	fileList := make([]string, 0, len(files))
 	for _, file := range files {
-		if !file.IsDir() {
+		if file.Type().IsRegular() {
 			fileList = append(fileList, file.Name())
 		}
 	}
@@ -258,11 +497,45 @@ func (s *Server) listFilesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(fileList)
 }
+
+// Runcode builds and runs the project ?project=<id> with its build and run
+// commands in its container. ?lang=wat and ?lang=wasm run in process
+// instead, see runDirect, and ?target=browser builds for the browser, see
+// buildBrowser. The legacy files directory is shared by every client, so
+// nothing is run there.
 func (s *Server) Runcode(w http.ResponseWriter, r *http.Request) {
-	//first run the assembly files
 	programminglang := r.URL.Query().Get("lang")
-	runnerservice.Execwasm(programminglang)
-	w.WriteHeader(http.StatusOK)
+	projectID := r.URL.Query().Get("project")
+	if projectID == "" {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Choose a project to run",
+		})
+		return
+	}
+	project, ok := s.loadProject(w, r, projectID)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Get("target") == "browser" {
+		s.buildBrowser(w, r, project)
+		return
+	}
+	if isDirectLanguage(programminglang) {
+		dir, _ := s.projects.Dir(project.ID)
+		files, err := s.projects.Files(project.ID)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error listing project files", "project", project.ID, "error", err)
+			s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+				Success: false,
+				Message: "Error listing project files",
+			})
+			return
+		}
+		s.runDirect(w, r, programminglang, dir, project, files)
+		return
+	}
+	s.runProject(w, r, project)
 }
 
 // jsonResponse sends a JSON response with the given status code and data
@@ -270,7 +543,7 @@ func (s *Server) jsonResponse(w http.ResponseWriter, status int, data interface{
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		s.logger.Printf("Error encoding JSON response: %v", err)
+		s.logger.Error("error encoding JSON response", "error", err)
 	}
 }
 
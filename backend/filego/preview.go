@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"xxx/artifacts"
+	"xxx/browser"
+	"xxx/diagnostics"
+	"xxx/projects"
+	"xxx/runnerservice"
+	"xxx/wasm"
+
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// previewPort is where browser bundles are served, override with
+// PREVIEW_ADDR. Previews run users' HTML and JavaScript, so they get an
+// origin of their own, away from the API's cookies and CORS.
+const previewPort = ":8083"
+
+// previewOrigin is the public origin of the preview listener at addr:
+// PREVIEW_ORIGIN, or localhost
+func previewOrigin(addr string) string {
+	if origin := os.Getenv("PREVIEW_ORIGIN"); origin != "" {
+		return strings.TrimSuffix(origin, "/")
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return "http://" + addr
+}
+
+// previewToken authorizes serving project id's preview. The preview origin
+// sees no auth cookie, so the URL carries it instead.
+func previewToken(id string) string {
+	mac := hmac.New(sha256.New, jwtSecret())
+	mac.Write([]byte("preview\x00" + id))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// previewURL is where a project's browser bundle is served
+func (s *Server) previewURL(id string) string {
+	return s.previewOrigin + "/preview/" + id + "/" + previewToken(id) + "/"
+}
+
+// buildBrowser builds project for the browser into its browser.Dir: the
+// module, the glue its toolchain generates and an HTML harness. The build
+// is stored like any other and the response points at the preview URL.
+// WAT is assembled in process, from ?file= or the first .wat file.
+func (s *Server) buildBrowser(w http.ResponseWriter, r *http.Request, project *projects.Project) {
+	ctx := r.Context()
+	target, err := browser.TargetFor(project.Language, project.Artifact)
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	dir, _ := s.projects.Dir(project.ID)
+	dist := filepath.Join(dir, browser.Dir)
+	err = os.RemoveAll(dist)
+	if err == nil {
+		err = os.MkdirAll(dist, 0755)
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error preparing browser bundle directory", "project", project.ID, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error preparing the browser build",
+		})
+		return
+	}
+
+	var (
+		response RunResponse
+		stats    accounting.Stats
+		command  = target.Build
+	)
+	if command == "" {
+		files, listErr := s.projects.Files(project.ID)
+		if listErr != nil {
+			s.logger.ErrorContext(ctx, "error listing project files", "project", project.ID, "error", listErr)
+			s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+				Success: false,
+				Message: "Error listing project files",
+			})
+			return
+		}
+		name, ok := pickSource(r.URL.Query().Get("file"), ".wat", files)
+		if !ok {
+			s.jsonResponse(w, http.StatusNotFound, FileResponse{
+				Success: false,
+				Message: "No .wat file to build",
+			})
+			return
+		}
+		command = "assemble " + name
+		var src, bin []byte
+		if src, err = readProjectFile(dir, name); err == nil {
+			if bin, err = wasm.Assemble(string(src)); err != nil {
+				response.Content = name + ":" + err.Error()
+				response.Diagnostics = syntaxDiagnostics(name, err)
+			} else {
+				var module string
+				if module, err = projectFilePath(dir, target.Module, true); err == nil {
+					err = writeFile(module, bytes.NewReader(bin))
+				}
+			}
+		}
+	} else {
+		var output string
+		output, stats, err = runnerservice.Run(ctx, runnerservice.Job{Dir: dir, Language: project.Language, Command: diagnostics.Instrument(project.Language, command), Cache: s.toolchainCache(r), ReadOnly: projects.ReadOnly})
+		response.Diagnostics, response.Content = diagnostics.Parse(output)
+	}
+	if err == nil {
+		err = browser.WriteHarness(dist, target, project.ID)
+	}
+	s.usage.Record(ctx, accounting.RunFor(r, jwtSecret(), project.ID, project.Language, metrics.ExitCode(err)), stats)
+
+	sourceHash, hashErr := s.projects.SourceHash(project.ID)
+	if hashErr != nil {
+		s.logger.WarnContext(ctx, "cannot hash project sources", "project", project.ID, "error", hashErr)
+	}
+	build := &artifacts.Build{
+		Project:    project.ID,
+		SourceHash: sourceHash,
+		Command:    command,
+		Success:    err == nil,
+		Artifact:   target.Module,
+	}
+	if saveErr := s.artifacts.Save(dir, build, []byte(response.Content)); saveErr != nil {
+		s.logger.ErrorContext(ctx, "failed to store build artifacts", "project", project.ID, "error", saveErr)
+	} else {
+		response.Build = build.ID
+	}
+
+	response.Success = err == nil
+	var syntax *wasm.SyntaxError
+	if errors.As(err, &syntax) {
+		response.Message = response.Content
+		s.jsonResponse(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+	if errors.Is(err, errNotRegular) || errors.Is(err, errOutsideProject) || errors.Is(err, browser.ErrLinked) {
+		response.Message = "Sources and build outputs must be regular files in the project"
+		s.jsonResponse(w, http.StatusBadRequest, response)
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error building for the browser", "project", project.ID, "error", err)
+		response.Message = err.Error()
+		s.jsonResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+	response.Preview = s.previewURL(project.ID)
+	s.jsonResponse(w, http.StatusOK, response)
+}
+
+// previewHandler serves a project's browser bundle on the preview origin,
+// to anyone with its previewToken. Modules are sent as application/wasm,
+// which streaming compilation requires, and pages are cross-origin isolated
+// (COOP/COEP) so they may use SharedArrayBuffer and threads, while still
+// allowed to load in the IDE's iframe.
+func (s *Server) previewHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !hmac.Equal([]byte(r.PathValue("token")), []byte(previewToken(id))) {
+		http.NotFound(w, r)
+		return
+	}
+	name := r.PathValue("path")
+	if name == "" || strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	dir, err := s.projects.Dir(id)
+	if err != nil || !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	dist := filepath.Join(dir, browser.Dir)
+	if _, err := os.Stat(filepath.Join(dist, "index.html")); errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "No browser build; build one with /runcode?project="+id+"&target=browser", http.StatusNotFound)
+		return
+	}
+	// the bundle is written by the project's build, which could leave links
+	// to files of the host in it
+	filePath, err := projectFilePath(dir, browser.Dir+"/"+name, false)
+	var f *os.File
+	if err == nil {
+		f, err = openRegular(filePath)
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
+	w.Header().Set("Cross-Origin-Embedder-Policy", "require-corp")
+	w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+	w.Header().Set("Cache-Control", "no-cache")
+	switch path.Ext(name) {
+	case ".wasm":
+		w.Header().Set("Content-Type", "application/wasm")
+	case ".js", ".mjs":
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
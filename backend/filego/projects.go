@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"xxx/projects"
+	"xxx/templates"
+
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/ratelimit"
+)
+
+// projectsDir holds one directory per project
+const projectsDir = "./workspaces"
+
+// CreateProjectRequest is the body of POST /projects
+type CreateProjectRequest struct {
+	ID       string `json:"id"`
+	Template string `json:"template"`
+}
+
+// ProjectResponse is a project with the files in its tree
+type ProjectResponse struct {
+	*projects.Project
+	Files []string `json:"files"`
+}
+
+// RegisterTemplatesRequest is the body of POST /templates
+type RegisterTemplatesRequest struct {
+	Dir string `json:"dir"`
+}
+
+// loadCustomTemplates registers the template directories listed in
+// TEMPLATES_DIR, separated like PATH
+func loadCustomTemplates(registry *templates.Registry) error {
+	for _, dir := range filepath.SplitList(os.Getenv("TEMPLATES_DIR")) {
+		if dir == "" {
+			continue
+		}
+		if _, err := registry.AddDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAdmin reports whether r is signed in with an email listed in
+// ADMIN_EMAILS (comma separated), the same list the API checks
+func isAdmin(r *http.Request) bool {
+	claims, ok := ratelimit.Claims(r, jwtSecret())
+	if !ok {
+		return false
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return false
+	}
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" && strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// templatesHandler lists the templates, and lets admins register custom
+// ones from a directory on the server
+func (s *Server) templatesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.templates.List())
+	case http.MethodPost:
+		if !isAdmin(r) {
+			s.jsonResponse(w, http.StatusForbidden, FileResponse{
+				Success: false,
+				Message: "Only admins can register templates",
+			})
+			return
+		}
+		var req RegisterTemplatesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Dir == "" {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: "Expected a JSON body with a dir",
+			})
+			return
+		}
+		names, err := s.templates.AddDir(req.Dir)
+		if err != nil {
+			s.logger.WarnContext(r.Context(), "failed to register templates", "dir", req.Dir, "error", err)
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+		s.logger.InfoContext(r.Context(), "registered templates", "dir", req.Dir, "templates", names)
+		s.jsonResponse(w, http.StatusCreated, FileResponse{
+			Success: true,
+			Message: "Registered " + strings.Join(names, ", "),
+		})
+	default:
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+	}
+}
+
+// projectsHandler lists projects and creates them from a template
+func (s *Server) projectsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		user, ok := s.signedIn(w, r)
+		if !ok {
+			return
+		}
+		list, err := s.projects.List(user)
+		metrics.ObserveFile("list", err)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error listing projects", "error", err)
+			s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+				Success: false,
+				Message: "Error listing projects",
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		s.createProject(w, r)
+	default:
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+	}
+}
+
+// createProject scaffolds a new project from the requested template
+func (s *Server) createProject(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.signedIn(w, r)
+	if !ok {
+		return
+	}
+	var req CreateProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Expected a JSON body with an id and a template",
+		})
+		return
+	}
+
+	tmpl, err := s.templates.Get(req.Template)
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Unknown template " + req.Template,
+		})
+		return
+	}
+
+	project, err := s.projects.Create(req.ID, tmpl, user)
+	metrics.ObserveFile("create", err)
+	switch {
+	case errors.Is(err, projects.ErrInvalidID):
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	case errors.Is(err, projects.ErrExists):
+		s.jsonResponse(w, http.StatusConflict, FileResponse{
+			Success: false,
+			Message: "Project already exists",
+		})
+		return
+	case err != nil:
+		s.logger.ErrorContext(r.Context(), "error creating project", "project", req.ID, "template", req.Template, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error creating project",
+		})
+		return
+	}
+
+	s.logger.InfoContext(r.Context(), "project created", "project", project.ID, "template", project.Template)
+	s.projectResponse(w, r, http.StatusCreated, project)
+}
+
+// projectHandler returns a project's metadata and files
+func (s *Server) projectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+		return
+	}
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	s.projectResponse(w, r, http.StatusOK, project)
+}
+
+// signedIn returns the user in r's auth_token cookie, answering the request
+// itself when there is none
+func (s *Server) signedIn(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	user, ok := ratelimit.UserID(r, jwtSecret())
+	if !ok {
+		s.jsonResponse(w, http.StatusUnauthorized, FileResponse{
+			Success: false,
+			Message: "Sign in to use projects",
+		})
+	}
+	return user, ok
+}
+
+// loadProject loads project id for its owner, answering the request itself
+// when it can't. Other users' projects are reported as not found.
+func (s *Server) loadProject(w http.ResponseWriter, r *http.Request, id string) (*projects.Project, bool) {
+	user, ok := s.signedIn(w, r)
+	if !ok {
+		return nil, false
+	}
+	project, err := s.projects.Get(id)
+	if err == nil && project.Owner != user {
+		err = projects.ErrNotFound
+	}
+	switch {
+	case errors.Is(err, projects.ErrInvalidID):
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return nil, false
+	case errors.Is(err, projects.ErrNotFound):
+		s.jsonResponse(w, http.StatusNotFound, FileResponse{
+			Success: false,
+			Message: "Project not found",
+		})
+		return nil, false
+	case err != nil:
+		s.logger.ErrorContext(r.Context(), "error loading project", "project", id, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error loading project",
+		})
+		return nil, false
+	}
+	return project, true
+}
+
+// projectResponse writes project along with its file list
+func (s *Server) projectResponse(w http.ResponseWriter, r *http.Request, status int, project *projects.Project) {
+	files, err := s.projects.Files(project.ID)
+	if err != nil {
+		s.logger.ErrorContext(r.Context(), "error listing project files", "project", project.ID, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error listing project files",
+		})
+		return
+	}
+	s.jsonResponse(w, status, ProjectResponse{Project: project, Files: files})
+}
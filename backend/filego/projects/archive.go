@@ -0,0 +1,396 @@
+package projects
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Archive formats accepted by Import and produced by Export
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+// ErrUnsupportedArchive is returned for uploads that are neither zip nor tar.gz
+var ErrUnsupportedArchive = errors.New("archive must be a zip or a tar.gz")
+
+// ArchiveError is a problem with the uploaded archive itself, as opposed to
+// a failure to store it
+type ArchiveError struct {
+	Msg string
+}
+
+func (e *ArchiveError) Error() string { return e.Msg }
+
+func archiveErrorf(format string, args ...any) error {
+	return &ArchiveError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// ImportLimits bound what an imported archive may unpack to
+type ImportLimits struct {
+	MaxFiles int   // regular files in the archive
+	MaxBytes int64 // their uncompressed size in total
+}
+
+// buildOutputDirs are where the supported toolchains put build outputs and
+// downloaded dependencies, and where browser bundles are written
+var buildOutputDirs = []string{"target", "build", "node_modules", ".wasmide-preview"}
+
+// IsBuildOutput reports whether rel, a slash-separated path in the project,
+// is produced by building it: the artifact, any .wasm module, or anything
+// under a toolchain's output directory
+func (p *Project) IsBuildOutput(rel string) bool {
+	if rel == p.Artifact || path.Ext(rel) == ".wasm" {
+		return true
+	}
+	top, _, _ := strings.Cut(rel, "/")
+	for _, dir := range buildOutputDirs {
+		if top == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectFormat tells a zip from a tar.gz by its first bytes
+func DetectFormat(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	}
+	return "", ErrUnsupportedArchive
+}
+
+// entryPath validates an archive entry name and returns it cleaned. Names
+// that are absolute, climb out with .., or use backslashes (zip slip) are
+// rejected rather than sanitised.
+func entryPath(name string) (string, error) {
+	if strings.Contains(name, "\\") || path.IsAbs(name) {
+		return "", archiveErrorf("unsafe path %q in archive", name)
+	}
+	clean := path.Clean(strings.TrimPrefix(name, "./"))
+	if clean == "." {
+		return "", nil
+	}
+	if !fs.ValidPath(clean) {
+		return "", archiveErrorf("unsafe path %q in archive", name)
+	}
+	return clean, nil
+}
+
+// extractor unpacks entries into a staging directory within limits
+type extractor struct {
+	dir    string
+	limits ImportLimits
+	files  int
+	bytes  int64
+}
+
+func (x *extractor) file(name string, mode fs.FileMode, r io.Reader) error {
+	rel, err := entryPath(name)
+	if err != nil || rel == "" {
+		return err
+	}
+	x.files++
+	if x.limits.MaxFiles > 0 && x.files > x.limits.MaxFiles {
+		return archiveErrorf("archive has more than %d files", x.limits.MaxFiles)
+	}
+
+	dst := filepath.Join(x.dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// copy one byte past the budget to tell "exactly at the limit" from "over it"
+	budget := int64(-1)
+	if x.limits.MaxBytes > 0 {
+		budget = x.limits.MaxBytes - x.bytes
+		r = io.LimitReader(r, budget+1)
+	}
+	n, err := io.Copy(f, r)
+	x.bytes += n
+	if err != nil {
+		return archiveErrorf("reading %s from archive: %v", rel, err)
+	}
+	if budget >= 0 && n > budget {
+		return archiveErrorf("archive unpacks to more than %d bytes", x.limits.MaxBytes)
+	}
+	return f.Close()
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return archiveErrorf("invalid zip: %v", err)
+	}
+	for _, entry := range zr.File {
+		// directories are created as their files need them; links and
+		// devices are skipped so nothing can point outside the project
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return archiveErrorf("invalid zip entry %s: %v", entry.Name, err)
+		}
+		err = x.file(entry.Name, entry.Mode(), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return archiveErrorf("invalid gzip: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return archiveErrorf("invalid tar: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := x.file(header.Name, header.FileInfo().Mode(), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Import unpacks the zip or tar.gz in r, of the given size, into project
+// id, replacing files with the same path. With stripTopDir, an archive whose
+// entries all sit under one top-level directory, such as a download of a
+// repository, is unpacked from inside it. Nothing is written to the project
+// unless the whole archive unpacks within limits; problems with the archive
+// are *ArchiveError. Import returns the imported paths.
+func (s *Store) Import(id string, r io.ReaderAt, size int64, limits ImportLimits, stripTopDir bool) ([]string, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	dir, _ := s.Dir(id)
+
+	header := make([]byte, 4)
+	n, _ := r.ReadAt(header, 0)
+	format, err := DetectFormat(header[:n])
+	if err != nil {
+		return nil, err
+	}
+
+	// staging directories start with a dot so they are never listed as projects
+	staging, err := os.MkdirTemp(s.Root, ".import-"+id+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	x := &extractor{dir: staging, limits: limits}
+	if format == FormatZip {
+		err = x.zip(r, size)
+	} else {
+		err = x.tarGz(bufio.NewReader(io.NewSectionReader(r, 0, size)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	root := staging
+	if entries, err := os.ReadDir(staging); stripTopDir && err == nil && len(entries) == 1 && entries[0].IsDir() {
+		root = filepath.Join(staging, entries[0].Name())
+	}
+
+	// check every entry before moving any, so a rejected archive leaves the
+	// project as it was
+	var imported []string
+	err = filepath.WalkDir(root, func(src string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case rel == MetaFile:
+			return nil // project metadata is never taken from an upload
+		case rel == gitDir || strings.HasPrefix(rel, gitDir+"/"):
+			return archiveErrorf("archive contains %s, the project's git repository can't be uploaded", rel)
+		}
+		if link, err := linkedDir(dir, rel); err != nil {
+			return err
+		} else if link != "" {
+			return archiveErrorf("archive contains %s, but %s is a symbolic link in the project", rel, link)
+		}
+		imported = append(imported, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range imported {
+		dst := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, err
+		}
+		// a link made since the check above, by a build for instance, must
+		// not lead the file out either
+		parent, err := filepath.EvalSymlinks(filepath.Dir(dst))
+		if err != nil {
+			return nil, err
+		}
+		if inside, err := filepath.Rel(realDir, parent); err != nil || inside != "." && !filepath.IsLocal(inside) {
+			return nil, archiveErrorf("archive contains %s, which would be written outside the project", rel)
+		}
+		if err := os.Rename(filepath.Join(root, filepath.FromSlash(rel)), dst); err != nil {
+			return nil, err
+		}
+	}
+	return imported, nil
+}
+
+// linkedDir returns the first directory of rel, a slash-separated path in
+// dir, that is a symbolic link, or "" when none is. Writing through one
+// could reach another project or the project's git repository.
+func linkedDir(dir, rel string) (string, error) {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		prefix := strings.Join(parts[:i], "/")
+		info, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(prefix)))
+		if errors.Is(err, fs.ErrNotExist) {
+			// made by the import, as a directory
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return prefix, nil
+		}
+	}
+	return "", nil
+}
+
+// Export writes project id to w as a zip or tar.gz. Build outputs are left
+// out unless withBuildOutputs is set; the metadata and git repository always are.
+func (s *Store) Export(id string, w io.Writer, format string, withBuildOutputs bool) error {
+	p, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	dir, _ := s.Dir(id)
+
+	var add func(rel string, info fs.FileInfo, src io.Reader) error
+	var finish func() error
+	switch format {
+	case FormatZip:
+		zw := zip.NewWriter(w)
+		add = func(rel string, info fs.FileInfo, src io.Reader) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+			header.Method = zip.Deflate
+			dst, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dst, src)
+			return err
+		}
+		finish = zw.Close
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		add = func(rel string, info fs.FileInfo, src io.Reader) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			header.Name = rel
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, src)
+			return err
+		}
+		finish = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}
+	default:
+		return ErrUnsupportedArchive
+	}
+
+	err = filepath.WalkDir(dir, func(src string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, src)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == gitDir {
+				return filepath.SkipDir
+			}
+			if rel != "." && !withBuildOutputs && p.IsBuildOutput(rel+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || rel == MetaFile || (!withBuildOutputs && p.IsBuildOutput(rel)) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return add(rel, info, f)
+	})
+	if err != nil {
+		return err
+	}
+	return finish()
+}
@@ -0,0 +1,197 @@
+package projects
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"xxx/templates"
+)
+
+func newProject(t *testing.T, id string) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := templates.NewRegistry().Get("rust-wasi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(id, tmpl, 1); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportRejectsUnsafeArchives(t *testing.T) {
+	store := newProject(t, "demo")
+	limits := ImportLimits{MaxFiles: 2, MaxBytes: 16}
+
+	cases := map[string][]byte{
+		"zip slip":      zipOf(t, map[string]string{"../evil.txt": "x"}),
+		"absolute path": zipOf(t, map[string]string{"/etc/evil": "x"}),
+		"too many":      zipOf(t, map[string]string{"a": "", "b": "", "c": ""}),
+		"too large":     zipOf(t, map[string]string{"big": "0123456789abcdefg"}),
+		"git config":    zipOf(t, map[string]string{"a": "", ".git/config": "x"}),
+	}
+	for name, archive := range cases {
+		_, err := store.Import("demo", bytes.NewReader(archive), int64(len(archive)), limits, false)
+		var archiveErr *ArchiveError
+		if !errors.As(err, &archiveErr) {
+			t.Errorf("%s: expected an ArchiveError, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(store.Root, "evil.txt")); err == nil {
+		t.Error("zip slip wrote outside the project")
+	}
+	if files, _ := store.Files("demo"); len(files) != 3 {
+		t.Errorf("a rejected archive must not change the project, got %v", files)
+	}
+
+	text := []byte("not an archive")
+	if _, err := store.Import("demo", bytes.NewReader(text), int64(len(text)), limits, false); !errors.Is(err, ErrUnsupportedArchive) {
+		t.Errorf("expected ErrUnsupportedArchive, got %v", err)
+	}
+}
+
+func TestImportRejectsPlantedSymlinks(t *testing.T) {
+	store := newProject(t, "demo")
+	tmpl, _ := templates.NewRegistry().Get("rust-wasi")
+	if _, err := store.Create("other", tmpl, 2); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := store.Dir("demo")
+	otherMeta := filepath.Join(store.Root, "other", MetaFile)
+	before, err := os.ReadFile(otherMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitDir, _ := store.GitDir("demo")
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// as a build or a terminal command in the project could leave them
+	if err := os.Symlink("../other", filepath.Join(dir, "evil")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(gitDir, filepath.Join(dir, "src", "repo")); err != nil {
+		t.Fatal(err)
+	}
+
+	limits := ImportLimits{MaxFiles: 10, MaxBytes: 1 << 10}
+	cases := map[string][]byte{
+		"other project":  zipOf(t, map[string]string{"evil/" + MetaFile: `{"id":"other","owner":1}`}),
+		"git repository": zipOf(t, map[string]string{"src/repo/config": "[core]\n\tfsmonitor = evil\n"}),
+	}
+	for name, archive := range cases {
+		_, err := store.Import("demo", bytes.NewReader(archive), int64(len(archive)), limits, false)
+		var archiveErr *ArchiveError
+		if !errors.As(err, &archiveErr) {
+			t.Errorf("%s: expected an ArchiveError, got %v", name, err)
+		}
+	}
+	if after, _ := os.ReadFile(otherMeta); !bytes.Equal(before, after) {
+		t.Error("an import through a link changed another project")
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "config")); err == nil {
+		t.Error("an import through a link wrote into the git repository")
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newProject(t, "demo")
+	dir, _ := src.Dir("demo")
+	os.MkdirAll(filepath.Join(dir, "target", "wasm32-wasip1", "release"), 0755)
+	os.WriteFile(filepath.Join(dir, "target", "wasm32-wasip1", "release", "app.wasm"), []byte("\x00asm"), 0644)
+
+	for _, format := range []string{FormatZip, FormatTarGz} {
+		var buf bytes.Buffer
+		if err := src.Export("demo", &buf, format, false); err != nil {
+			t.Fatal(err)
+		}
+
+		dst := newProject(t, "copy")
+		files, err := dst.Import("copy", bytes.NewReader(buf.Bytes()), int64(buf.Len()), ImportLimits{}, false)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		sort.Strings(files)
+		want := []string{".gitignore", "Cargo.toml", "src/main.rs"}
+		if len(files) != len(want) {
+			t.Fatalf("%s: expected %v without build outputs or metadata, got %v", format, want, files)
+		}
+		for i := range want {
+			if files[i] != want[i] {
+				t.Errorf("%s: expected %v, got %v", format, want, files)
+			}
+		}
+		if p, err := dst.Get("copy"); err != nil || p.ID != "copy" {
+			t.Errorf("%s: import must keep the target's metadata, got %+v, %v", format, p, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export("demo", &buf, FormatZip, true); err != nil {
+		t.Fatal(err)
+	}
+	zr, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	var sawArtifact bool
+	for _, f := range zr.File {
+		sawArtifact = sawArtifact || f.Name == "target/wasm32-wasip1/release/app.wasm"
+	}
+	if !sawArtifact {
+		t.Error("expected the artifact when exporting build outputs")
+	}
+}
+
+func TestImportStripsTopDir(t *testing.T) {
+	store := newProject(t, "demo")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string]string{"repo-main/README.md": "hi", "repo-main/.wasmide.json": "{}"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+
+	files, err := store.Import("demo", bytes.NewReader(buf.Bytes()), int64(buf.Len()), ImportLimits{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "README.md" {
+		t.Errorf("expected README.md at the project root, got %v", files)
+	}
+	if p, err := store.Get("demo"); err != nil || p.Template != "rust-wasi" {
+		t.Errorf("an uploaded %s must not replace the metadata, got %+v, %v", MetaFile, p, err)
+	}
+}
@@ -0,0 +1,283 @@
+// Package projects keeps each workspace in its own directory under a root,
+// with a small metadata file recording how it is built and run.
+package projects
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"xxx/templates"
+)
+
+// MetaFile holds a project's metadata inside its directory
+const MetaFile = ".wasmide.json"
+
+// ReadOnly lists the paths of a project that the commands it runs must not
+// change: the metadata names its owner and commands
+var ReadOnly = []string{MetaFile}
+
+// gitDir is where git would look for a repository inside a project. The
+// repository is kept under reposDir instead; a .git directory in a project
+// is neither listed nor exported with its files.
+const gitDir = ".git"
+
+// reposDir holds the projects' git repositories under the store root,
+// outside every project directory and so out of reach of the commands
+// projects run. The dot keeps it from being listed as a project.
+const reposDir = ".repos"
+
+var (
+	// ErrNotFound is returned for a project that does not exist
+	ErrNotFound = errors.New("project not found")
+	// ErrExists is returned when creating a project whose ID is taken
+	ErrExists = errors.New("project already exists")
+	// ErrInvalidID is returned for IDs that are not safe directory names
+	ErrInvalidID = errors.New("project IDs are 1-64 lowercase letters, digits, - or _")
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Project is the metadata of one project
+type Project struct {
+	ID        string    `json:"id"`
+	Owner     uint      `json:"owner,omitempty"` // the signed-in user who created it, the only one who can open it
+	Template  string    `json:"template,omitempty"`
+	Language  string    `json:"language"`
+	Toolchain string    `json:"toolchain,omitempty"`
+	Build     string    `json:"build,omitempty"`
+	Run       string    `json:"run"`
+	Artifact  string    `json:"artifact,omitempty"`
+	Test      string    `json:"test,omitempty"` // runs the tests, instead of the language's usual command
+	CreatedAt time.Time `json:"created_at"`
+
+	RunConfigs []RunConfig `json:"run_configs,omitempty"`
+}
+
+// Command is the shell command that builds and then runs the project
+func (p *Project) Command() string {
+	if p.Build == "" {
+		return p.Run
+	}
+	return p.Build + " && " + p.Run
+}
+
+// Store manages the projects under Root
+type Store struct {
+	Root string
+}
+
+// NewStore returns a store rooted at root, creating it if needed
+func NewStore(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{Root: root}, nil
+}
+
+// Dir returns the directory of project id, whether or not it exists
+func (s *Store) Dir(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", ErrInvalidID
+	}
+	return filepath.Join(s.Root, id), nil
+}
+
+// GitDir returns where project id's git repository is kept, whether or not
+// it exists
+func (s *Store) GitDir(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", ErrInvalidID
+	}
+	return filepath.Join(s.Root, reposDir, id+".git"), nil
+}
+
+// Create scaffolds project id from t, owned by user owner
+func (s *Store) Create(id string, t *templates.Template, owner uint) (*Project, error) {
+	dir, err := s.Dir(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Scaffold(dir); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil, ErrExists
+		}
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	p := &Project{
+		ID:        id,
+		Owner:     owner,
+		Template:  t.Name,
+		Language:  t.Language,
+		Toolchain: t.Toolchain,
+		Build:     t.Build,
+		Run:       t.Run,
+		Artifact:  t.Artifact,
+		Test:      t.Test,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.save(dir, p); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return p, nil
+}
+
+// save writes p's metadata into dir
+func (s *Store) save(dir string, p *Project) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MetaFile), data, 0644)
+}
+
+// Get loads the metadata of project id
+func (s *Store) Get(id string) (*Project, error) {
+	dir, err := s.Dir(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, MetaFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Project
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("project %s: %w", id, err)
+	}
+	p.ID = id
+	return &p, nil
+}
+
+// SetTest saves the command running project id's tests; empty restores the
+// language's usual command
+func (s *Store) SetTest(id, command string) (*Project, error) {
+	p, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	p.Test = command
+	dir, _ := s.Dir(id)
+	return p, s.save(dir, p)
+}
+
+// List returns the projects owned by owner sorted by ID
+func (s *Store) List(owner uint) ([]*Project, error) {
+	entries, err := os.ReadDir(s.Root)
+	if err != nil {
+		return nil, err
+	}
+	list := []*Project{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !validID.MatchString(entry.Name()) {
+			continue
+		}
+		p, err := s.Get(entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.Owner != owner {
+			continue
+		}
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// Files lists the project's files relative to its root, leaving out the
+// metadata file and the git repository
+func (s *Store) Files(id string) ([]string, error) {
+	dir, err := s.Dir(id)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == gitDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != MetaFile {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return files, err
+}
+
+// SourceHash fingerprints the project's sources: every file except build
+// outputs, the metadata and the git repository. Builds of the same sources
+// have the same hash.
+func (s *Store) SourceHash(id string) (string, error) {
+	p, err := s.Get(id)
+	if err != nil {
+		return "", err
+	}
+	dir, _ := s.Dir(id)
+
+	hash := sha256.New()
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == gitDir || (rel != "." && p.IsBuildOutput(rel+"/")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || rel == MetaFile || p.IsBuildOutput(rel) {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// WalkDir visits in lexical order, so the hash is stable
+		fmt.Fprintf(hash, "%s\x00", rel)
+		n, err := io.Copy(hash, f)
+		fmt.Fprintf(hash, "\x00%d\x00", n)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,29 @@
+package projects
+
+import (
+	"testing"
+
+	"xxx/templates"
+)
+
+func TestListOnlyOwnersProjects(t *testing.T) {
+	store := newProject(t, "mine")
+	tmpl, err := templates.NewRegistry().Get("rust-wasi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("theirs", tmpl, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := store.List(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != "mine" || list[0].Owner != 1 {
+		t.Fatalf("List(1) = %+v, want only mine", list)
+	}
+	if p, err := store.Get("theirs"); err != nil || p.Owner != 2 {
+		t.Fatalf("Get(theirs) = %+v, %v, want owner 2", p, err)
+	}
+}
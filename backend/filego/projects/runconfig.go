@@ -0,0 +1,154 @@
+package projects
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MaxStdin bounds the stdin content a run configuration may carry
+const MaxStdin = 1 << 20
+
+var (
+	// ErrRunConfigNotFound is returned for a run configuration that does not exist
+	ErrRunConfigNotFound = errors.New("run configuration not found")
+	// ErrInvalidRunConfig wraps the reasons a run configuration is rejected
+	ErrInvalidRunConfig = errors.New("invalid run configuration")
+
+	validRunConfigName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 ._-]{0,63}$`)
+	validEnvName       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// RunConfig describes what a module can see when it runs, as IDE run
+// configurations do: its arguments, environment, directories, stdin, clock
+// and randomness, and which exit codes count as success
+type RunConfig struct {
+	Name     string            `json:"name"`
+	Entry    string            `json:"entry,omitempty"` // exported function to call
+	Args     []string          `json:"args,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	Preopens []Preopen         `json:"preopens,omitempty"` // the project root, read-only, when empty
+	Stdin    string            `json:"stdin,omitempty"`
+	// Clock and Random give the module the real clocks and a secure random
+	// source; without them it sees a fixed clock and repeatable randomness
+	Clock  bool `json:"clock"`
+	Random bool `json:"random"`
+	// SuccessExitCodes are the exit codes reported as a successful run
+	SuccessExitCodes []uint32 `json:"success_exit_codes,omitempty"` // just 0 when empty
+}
+
+// Preopen maps a directory of the project tree to a path the module sees
+type Preopen struct {
+	Path     string `json:"path"`  // relative to the project root, "." for the root itself
+	Mount    string `json:"mount"` // absolute path inside the module
+	ReadOnly bool   `json:"read_only,omitempty"`
+}
+
+// DefaultRunConfig is the run configuration used when none is given.
+// Decoding JSON over it leaves fields the JSON omits at their defaults.
+func DefaultRunConfig() RunConfig {
+	return RunConfig{Clock: true, Random: true}
+}
+
+// Validate checks that c is safe to run with: preopens stay inside the
+// project and mount at distinct absolute paths
+func (c *RunConfig) Validate() error {
+	if c.Name != "" && !validRunConfigName.MatchString(c.Name) {
+		return invalid("invalid run configuration name %q", c.Name)
+	}
+	for name := range c.Env {
+		if !validEnvName.MatchString(name) {
+			return invalid("invalid environment variable name %q", name)
+		}
+	}
+	if len(c.Stdin) > MaxStdin {
+		return invalid("stdin is larger than %d bytes", MaxStdin)
+	}
+	mounts := map[string]bool{}
+	for i, p := range c.Preopens {
+		clean := path.Clean(p.Path)
+		if path.IsAbs(clean) || strings.Contains(clean, "\\") || clean == ".." || strings.HasPrefix(clean, "../") {
+			return invalid("preopen %d: path must be a directory inside the project", i)
+		}
+		c.Preopens[i].Path = clean
+		if !path.IsAbs(p.Mount) {
+			return invalid("preopen %d: mount must be an absolute path", i)
+		}
+		c.Preopens[i].Mount = path.Clean(p.Mount)
+		if mounts[c.Preopens[i].Mount] {
+			return invalid("preopen %d: %s is mounted twice", i, c.Preopens[i].Mount)
+		}
+		mounts[c.Preopens[i].Mount] = true
+	}
+	return nil
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRunConfig, fmt.Sprintf(format, args...))
+}
+
+// IsSuccess reports whether a run exiting with code counts as successful
+func (c *RunConfig) IsSuccess(code uint32) bool {
+	if len(c.SuccessExitCodes) == 0 {
+		return code == 0
+	}
+	for _, ok := range c.SuccessExitCodes {
+		if code == ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RunConfig returns the project's run configuration called name
+func (p *Project) RunConfig(name string) (RunConfig, bool) {
+	for _, c := range p.RunConfigs {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return RunConfig{}, false
+}
+
+// SaveRunConfig adds c to project id, replacing one of the same name
+func (s *Store) SaveRunConfig(id string, c RunConfig) (*Project, error) {
+	if c.Name == "" {
+		return nil, invalid("a run configuration needs a name")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	p, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	replaced := false
+	for i := range p.RunConfigs {
+		if p.RunConfigs[i].Name == c.Name {
+			p.RunConfigs[i], replaced = c, true
+		}
+	}
+	if !replaced {
+		p.RunConfigs = append(p.RunConfigs, c)
+	}
+	dir, _ := s.Dir(id)
+	return p, s.save(dir, p)
+}
+
+// DeleteRunConfig removes project id's run configuration called name
+func (s *Store) DeleteRunConfig(id, name string) error {
+	p, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	for i := range p.RunConfigs {
+		if p.RunConfigs[i].Name == name {
+			p.RunConfigs = append(p.RunConfigs[:i], p.RunConfigs[i+1:]...)
+			dir, _ := s.Dir(id)
+			return s.save(dir, p)
+		}
+	}
+	return ErrRunConfigNotFound
+}
@@ -0,0 +1,60 @@
+package projects
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunConfigValidate(t *testing.T) {
+	for _, bad := range []RunConfig{
+		{Name: "../escape"},
+		{Env: map[string]string{"A=B": "c"}},
+		{Preopens: []Preopen{{Path: "../other", Mount: "/"}}},
+		{Preopens: []Preopen{{Path: "/etc", Mount: "/"}}},
+		{Preopens: []Preopen{{Path: "data", Mount: "data"}}},
+		{Preopens: []Preopen{{Path: "a", Mount: "/x"}, {Path: "b", Mount: "/x/"}}},
+	} {
+		if err := bad.Validate(); !errors.Is(err, ErrInvalidRunConfig) {
+			t.Errorf("%+v: got %v, want an invalid run configuration", bad, err)
+		}
+	}
+
+	good := RunConfig{Name: "with data", Preopens: []Preopen{{Path: "", Mount: "/"}, {Path: "data/", Mount: "/data/"}}}
+	if err := good.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p := good.Preopens; p[0].Path != "." || p[1].Path != "data" || p[1].Mount != "/data" {
+		t.Errorf("preopens not cleaned: %+v", p)
+	}
+}
+
+func TestRunConfigsPersist(t *testing.T) {
+	store := newProject(t, "app")
+	config := DefaultRunConfig()
+	config.Name = "tests"
+	config.Args = []string{"--verbose"}
+	config.SuccessExitCodes = []uint32{0, 3}
+	if _, err := store.SaveRunConfig("app", config); err != nil {
+		t.Fatal(err)
+	}
+	config.Args = nil
+	if _, err := store.SaveRunConfig("app", config); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := store.Get("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, ok := p.RunConfig("tests")
+	if len(p.RunConfigs) != 1 || !ok || saved.Args != nil || !saved.Clock || !saved.IsSuccess(3) || saved.IsSuccess(1) {
+		t.Errorf("got %+v", p.RunConfigs)
+	}
+
+	if err := store.DeleteRunConfig("app", "tests"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteRunConfig("app", "tests"); !errors.Is(err, ErrRunConfigNotFound) {
+		t.Errorf("deleting twice: got %v", err)
+	}
+}
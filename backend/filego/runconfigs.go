@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"xxx/projects"
+	"xxx/sandbox"
+
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// runConfigsHandler lists a project's run configurations and saves new ones
+func (s *Server) runConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		configs := project.RunConfigs
+		if configs == nil {
+			configs = []projects.RunConfig{}
+		}
+		s.jsonResponse(w, http.StatusOK, configs)
+	case http.MethodPost:
+		s.saveRunConfig(w, r, project, "")
+	default:
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+	}
+}
+
+// runConfigHandler gets, replaces or deletes one named run configuration
+func (s *Server) runConfigHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		config, ok := project.RunConfig(name)
+		if !ok {
+			s.jsonResponse(w, http.StatusNotFound, FileResponse{
+				Success: false,
+				Message: "Run configuration not found",
+			})
+			return
+		}
+		s.jsonResponse(w, http.StatusOK, config)
+	case http.MethodPut:
+		s.saveRunConfig(w, r, project, name)
+	case http.MethodDelete:
+		err := s.projects.DeleteRunConfig(project.ID, name)
+		metrics.ObserveFile("delete", err)
+		switch {
+		case errors.Is(err, projects.ErrRunConfigNotFound):
+			s.jsonResponse(w, http.StatusNotFound, FileResponse{
+				Success: false,
+				Message: "Run configuration not found",
+			})
+		case err != nil:
+			s.logger.ErrorContext(r.Context(), "error deleting run configuration", "project", project.ID, "name", name, "error", err)
+			s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+				Success: false,
+				Message: "Error deleting run configuration",
+			})
+		default:
+			s.jsonResponse(w, http.StatusOK, FileResponse{
+				Success: true,
+				Message: "Run configuration deleted",
+			})
+		}
+	default:
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+	}
+}
+
+// saveRunConfig stores the run configuration in the request body. name,
+// when set, overrides the name in the body.
+func (s *Server) saveRunConfig(w http.ResponseWriter, r *http.Request, project *projects.Project, name string) {
+	config := projects.DefaultRunConfig()
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*projects.MaxStdin)).Decode(&config); err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Expected a JSON run configuration",
+		})
+		return
+	}
+	if name != "" {
+		config.Name = name
+	}
+
+	updated, err := s.projects.SaveRunConfig(project.ID, config)
+	metrics.ObserveFile("write", err)
+	switch {
+	case errors.Is(err, projects.ErrInvalidRunConfig):
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		s.logger.ErrorContext(r.Context(), "error saving run configuration", "project", project.ID, "name", config.Name, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error saving run configuration",
+		})
+		return
+	}
+	saved, _ := updated.RunConfig(config.Name)
+	s.jsonResponse(w, http.StatusOK, saved)
+}
+
+// requestRunConfig resolves the run configuration of a run: the project's
+// configuration named by ?config=, a JSON configuration POSTed as the
+// body, or the default. ?entry= and ?arg= override the entry and arguments.
+func (s *Server) requestRunConfig(w http.ResponseWriter, r *http.Request, project *projects.Project) (projects.RunConfig, bool) {
+	query := r.URL.Query()
+	config := projects.DefaultRunConfig()
+	if name := query.Get("config"); name != "" {
+		var ok bool
+		if config, ok = project.RunConfig(name); !ok {
+			s.jsonResponse(w, http.StatusNotFound, FileResponse{
+				Success: false,
+				Message: "Run configuration not found",
+			})
+			return config, false
+		}
+	} else if isJSON(r) {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*projects.MaxStdin)).Decode(&config); err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: "Expected a JSON run configuration",
+			})
+			return config, false
+		}
+	}
+	if entry := query.Get("entry"); entry != "" {
+		config.Entry = entry
+	}
+	if args, ok := query["arg"]; ok {
+		config.Args = args
+	}
+	if err := config.Validate(); err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return config, false
+	}
+	return config, true
+}
+
+// isJSON reports whether the request body is JSON
+func isJSON(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// sandboxConfig turns a validated run configuration into what the sandbox
+// runs with, mapping preopens to directories under root
+func sandboxConfig(config projects.RunConfig, root string) (sandbox.Config, error) {
+	cfg := sandbox.Config{
+		Args:   config.Args,
+		Entry:  config.Entry,
+		Clock:  config.Clock,
+		Random: config.Random,
+		Stdin:  strings.NewReader(config.Stdin),
+		Limits: wasmLimits(),
+	}
+	for name, value := range config.Env {
+		cfg.Env = append(cfg.Env, name+"="+value)
+	}
+	sort.Strings(cfg.Env)
+
+	if len(config.Preopens) == 0 {
+		cfg.Mounts = []sandbox.Mount{{Dir: root, Path: "/", ReadOnly: true}}
+		return cfg, nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return cfg, err
+	}
+	for _, p := range config.Preopens {
+		// symlinks must not lead a preopen out of the project
+		dir, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(p.Path)))
+		rel, relErr := filepath.Rel(realRoot, dir)
+		if err != nil || relErr != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return cfg, errors.New("preopen " + p.Path + " is not a directory of the project")
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return cfg, errors.New("preopen " + p.Path + " is not a directory of the project")
+		}
+		cfg.Mounts = append(cfg.Mounts, sandbox.Mount{Dir: dir, Path: p.Mount, ReadOnly: p.ReadOnly})
+	}
+	return cfg, nil
+}
@@ -0,0 +1,310 @@
+package runnerservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/tracing"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Paths inside a runner container
+const (
+	// Workdir is where the project directory is mounted, and where commands
+	// run
+	Workdir = "/project"
+	// cacheDir is where the user's toolchain caches are mounted
+	cacheDir = "/cache"
+)
+
+// projectLabel marks runner containers with the hash of their project
+// directory, so operators can find all of a project's containers
+const projectLabel = "wasmide.project"
+
+// jobEnv marks the processes of one job inside a container, so cancelling
+// the job kills them and leaves other jobs in the container running
+const jobEnv = "WASMIDE_JOB"
+
+// Defaults of the resources of a runner container, in docker's syntax
+const (
+	defaultMemory = "2g"
+	defaultCPUs   = "2"
+)
+
+// ErrNoImage is returned when RUNNER_IMAGE, the image with the toolchains
+// that builds run in, is not set
+var ErrNoImage = errors.New("RUNNER_IMAGE is not set")
+
+// image reads RUNNER_IMAGE
+func image() (string, error) {
+	if img := os.Getenv("RUNNER_IMAGE"); img != "" {
+		return img, nil
+	}
+	return "", ErrNoImage
+}
+
+// Job is a command run in a project's container
+type Job struct {
+	// Dir is the project directory on the host, mounted at Workdir
+	Dir string
+	// Language labels the job in metrics and logs
+	Language string
+	// Command is run with sh -c in Workdir
+	Command string
+	// Cache, if set, is a host directory holding the user's Cargo and Go
+	// caches; jobs with different caches get different containers
+	Cache string
+	// Env adds NAME=value variables. Nothing else of the server's
+	// environment reaches the container.
+	Env []string
+	// ReadOnly lists paths relative to Dir that commands can't change, such
+	// as the project's metadata. Missing paths are skipped.
+	ReadOnly []string
+}
+
+// containerLocks serializes creating, starting and reaping each container
+var containerLocks sync.Map
+
+// containerUse is when each container was last used and how many jobs are
+// running in it, for ReapIdle
+var (
+	useMu        sync.Mutex
+	containerUse = map[string]*use{}
+)
+
+type use struct {
+	jobs int
+	last time.Time
+}
+
+// acquire counts a job starting in container; the returned func ends it
+func acquire(container string) func() {
+	useMu.Lock()
+	defer useMu.Unlock()
+	u, ok := containerUse[container]
+	if !ok {
+		u = &use{}
+		containerUse[container] = u
+	}
+	u.jobs++
+	u.last = time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			useMu.Lock()
+			defer useMu.Unlock()
+			u.jobs--
+			u.last = time.Now()
+		})
+	}
+}
+
+// resource reads the docker resource limit in the environment variable
+// key, or def when it is not set
+func resource(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// projectHash names the project directory dir in container names and labels
+func projectHash(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:8])
+}
+
+// container returns the name of the running container for job, creating or
+// starting it as needed, and counts the job as running in it until release
+// is called. The container keeps running between jobs, like the
+// terminal's, until ReapIdle removes it. It has no network, bounded memory
+// and CPU, the project directory mounted read-write and nothing else of the
+// host but the cache.
+func (job Job) container(ctx context.Context) (name string, release func(), err error) {
+	img, err := image()
+	if err != nil {
+		return "", nil, err
+	}
+	dir, err := filepath.Abs(job.Dir)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256([]byte(dir + "\x00" + job.Cache + "\x00" + strings.Join(job.ReadOnly, "\x00")))
+	name = "runner-" + hex.EncodeToString(sum[:8])
+
+	lock, _ := containerLocks.LoadOrStore(name, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	out, err := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{.State.Running}}", name).Output()
+	switch {
+	case err == nil && strings.TrimSpace(string(out)) == "true":
+		return name, acquire(name), nil
+	case err == nil:
+		err = exec.CommandContext(ctx, "docker", "start", name).Run()
+		metrics.ObserveContainer("start", err)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to start container: %w", err)
+		}
+		return name, acquire(name), nil
+	}
+
+	ctx, span := tracing.Start(ctx, "container.create", attribute.String("container.name", name))
+	args := []string{"run", "-d", "--name", name,
+		"--label", projectLabel + "=" + projectHash(dir),
+		// files the toolchains write stay the server's
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--pids-limit", "1024",
+		"--network", "none",
+		"--memory", resource("RUNNER_MEMORY", defaultMemory),
+		"--cpus", resource("RUNNER_CPUS", defaultCPUs),
+		"-v", dir + ":" + Workdir,
+		"-w", Workdir,
+		"-e", "HOME=/tmp",
+	}
+	for _, rel := range job.ReadOnly {
+		// docker would create a missing source as a directory
+		if _, err := os.Stat(filepath.Join(dir, rel)); err != nil {
+			continue
+		}
+		args = append(args, "-v", filepath.Join(dir, rel)+":"+Workdir+"/"+filepath.ToSlash(rel)+":ro")
+	}
+	if job.Cache != "" {
+		if err := os.MkdirAll(job.Cache, 0755); err != nil {
+			tracing.End(span, err)
+			return "", nil, err
+		}
+		args = append(args,
+			"-v", job.Cache+":"+cacheDir,
+			"-e", "CARGO_HOME="+cacheDir+"/cargo",
+			"-e", "GOMODCACHE="+cacheDir+"/go/mod",
+			"-e", "GOCACHE="+cacheDir+"/go/build",
+		)
+	}
+	args = append(args, img, "sleep", "infinity")
+	err = exec.CommandContext(ctx, "docker", args...).Run()
+	tracing.End(span, err)
+	metrics.ObserveContainer("create", err)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create container: %w", err)
+	}
+	runLog.InfoContext(ctx, "created runner container", "container", name, "dir", dir)
+	return name, acquire(name), nil
+}
+
+// newJobID returns a random ID for jobEnv
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// execArgs returns the docker arguments running command in container with
+// env, which must be NAME=value pairs: a bare NAME would copy the server's
+// variable
+func execArgs(container string, env []string, stdin bool, command string) ([]string, error) {
+	args := []string{"exec"}
+	if stdin {
+		args = append(args, "-i")
+	}
+	for _, kv := range env {
+		if name, _, ok := strings.Cut(kv, "="); !ok || name == "" {
+			return nil, fmt.Errorf("invalid environment variable %q", kv)
+		}
+		args = append(args, "-e", kv)
+	}
+	return append(args, container, "sh", "-c", command), nil
+}
+
+// sample reads the container's cgroup counters from inside it
+func sample(ctx context.Context, container string) (accounting.CgroupSample, error) {
+	out, err := exec.CommandContext(ctx, "docker", "exec", container, "sh", "-c", accounting.CgroupScript).Output()
+	if err != nil {
+		return accounting.CgroupSample{}, err
+	}
+	return accounting.ParseCgroup(out)
+}
+
+// killScript kills the processes whose environment holds $1, which is
+// jobEnv=<id>: those of one job, children included
+const killScript = `for p in /proc/[0-9]*; do
+	if tr '\0' '\n' 2>/dev/null < "$p/environ" | grep -qx "$1"; then kill -KILL "${p#/proc/}" 2>/dev/null; fi
+done`
+
+// cancel kills what is left of the job id after it was cancelled: killing
+// docker exec leaves the command running inside the container
+func cancel(ctx context.Context, container, id string) {
+	ctx, done := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer done()
+	if err := exec.CommandContext(ctx, "docker", "exec", container, "sh", "-c", killScript, "sh", jobEnv+"="+id).Run(); err != nil {
+		runLog.WarnContext(ctx, "failed to stop cancelled job", "container", container, "error", err)
+	}
+}
+
+// ReapIdle removes runner containers that have run no job for idle,
+// checking every interval until ctx ends. Containers left by an earlier
+// process count as idle from when they are first seen. A removed
+// container is created again for the next job; the project directory and
+// the caches are on the host and survive it.
+func ReapIdle(ctx context.Context, idle, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		out, err := exec.CommandContext(ctx, "docker", "ps", "-a", "--filter", "label="+projectLabel, "--format", "{{.Names}}").Output()
+		if err != nil {
+			runLog.WarnContext(ctx, "failed to list runner containers", "error", err)
+			continue
+		}
+		for _, name := range strings.Fields(string(out)) {
+			reap(ctx, name, idle)
+		}
+	}
+}
+
+// reap removes container if it has been idle for idle
+func reap(ctx context.Context, container string, idle time.Duration) {
+	lock, _ := containerLocks.LoadOrStore(container, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	useMu.Lock()
+	u, ok := containerUse[container]
+	if !ok {
+		containerUse[container] = &use{last: time.Now()}
+	}
+	busy := !ok || u.jobs > 0 || time.Since(u.last) < idle
+	useMu.Unlock()
+	if busy {
+		return
+	}
+
+	err := exec.CommandContext(ctx, "docker", "rm", "-f", container).Run()
+	metrics.ObserveContainer("reap", err)
+	if err != nil {
+		runLog.WarnContext(ctx, "failed to remove idle container", "container", container, "error", err)
+		return
+	}
+	runLog.InfoContext(ctx, "removed idle runner container", "container", container)
+	useMu.Lock()
+	delete(containerUse, container)
+	useMu.Unlock()
+}
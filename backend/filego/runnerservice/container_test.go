@@ -0,0 +1,81 @@
+package runnerservice
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExecArgs(t *testing.T) {
+	args, err := execArgs("runner-1", []string{"A=1", "B="}, true, "cargo build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"exec", "-i", "-e", "A=1", "-e", "B=", "runner-1", "sh", "-c", "cargo build"}
+	if !slices.Equal(args, want) {
+		t.Errorf("got %q, want %q", args, want)
+	}
+
+	// a bare name would copy the server's own variable into the container
+	for _, env := range []string{"JWT_SECRET", "=x"} {
+		if _, err := execArgs("runner-1", []string{env}, false, "true"); err == nil {
+			t.Errorf("%q: expected an error", env)
+		}
+	}
+}
+
+func TestKillScriptKillsOnlyTheJob(t *testing.T) {
+	start := func(id string) *exec.Cmd {
+		cmd := exec.Command("sh", "-c", "sleep 30 & wait")
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH"), jobEnv + "=" + id}
+		if err := cmd.Start(); err != nil {
+			t.Skipf("sh unavailable: %v", err)
+		}
+		t.Cleanup(func() { cmd.Process.Kill() })
+		return cmd
+	}
+	job, other := start("job"), start("other")
+	// let sh start its child, which the script must kill too
+	time.Sleep(200 * time.Millisecond)
+
+	kill := exec.Command("sh", "-c", killScript, "sh", jobEnv+"=job")
+	kill.Env = []string{"PATH=" + os.Getenv("PATH")}
+	if out, err := kill.CombinedOutput(); err != nil || len(out) > 0 {
+		t.Fatalf("kill script: %v: %s", err, out)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- job.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job is still running")
+	}
+	if err := other.Process.Signal(syscall.Signal(0)); err != nil {
+		t.Errorf("the other job was killed too: %v", err)
+	}
+}
+
+func TestReapKeepsBusyContainers(t *testing.T) {
+	ctx := context.Background()
+
+	release := acquire("runner-busy")
+	reap(ctx, "runner-busy", 0)
+	release()
+	// an unknown container, such as one left by an earlier process, only
+	// starts its idle time now
+	reap(ctx, "runner-unknown", time.Hour)
+
+	useMu.Lock()
+	defer useMu.Unlock()
+	if u := containerUse["runner-busy"]; u == nil || u.jobs != 0 {
+		t.Errorf("busy container use = %+v, want it kept with no jobs left", u)
+	}
+	if containerUse["runner-unknown"] == nil {
+		t.Error("unknown container was not recorded")
+	}
+}
@@ -1,22 +1,138 @@
 package runnerservice
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/logging"
+	"muhammadyasir-dev/cmd/metrics"
+	"muhammadyasir-dev/cmd/server"
+	"muhammadyasir-dev/cmd/tracing"
 	"os/exec"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func Execwasm(programminglanguage string) {
+var runLog = logging.New("runner")
+
+// Run runs job, such as a project's build and run commands, in the
+// project's container and returns its combined output
+func Run(ctx context.Context, job Job) (string, accounting.Stats, error) {
+	var out bytes.Buffer
+	stats, err := Stream(ctx, job, &out)
+	return out.String(), stats, err
+}
 
-	switch programminglanguage {
-	case "rust":
-		exec.Command("bash", "-c", "cargo", "run")
+// Stream is Run writing the combined output to out as the command prints
+// it, for callers that report progress. CPU time and memory are those of
+// the whole container across the job, so jobs running at once in the same
+// container are charged for each other.
+func Stream(ctx context.Context, job Job, out io.Writer) (accounting.Stats, error) {
+	defer server.Track(ctx)()
 
-	case "go":
-		exec.Command("bash", "-c", "go", "run", "main.go")
+	ctx, span := tracing.Start(ctx, "toolchain.run",
+		attribute.String("toolchain.language", job.Language),
+		attribute.String("toolchain.command", job.Command),
+	)
+	container, release, err := job.container(ctx)
+	if err != nil {
+		tracing.End(span, err)
+		return accounting.Stats{}, err
+	}
+	defer release()
+	id := newJobID()
+	args, err := execArgs(container, append([]string{jobEnv + "=" + id, logging.RequestIDEnv + "=" + logging.RequestID(ctx)}, job.Env...), false, job.Command)
+	if err != nil {
+		tracing.End(span, err)
+		return accounting.Stats{}, err
+	}
+	counted := &countingWriter{w: out}
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = counted
+	cmd.Stderr = counted
 
-	case "c":
-		exec.Command("bash", "-c", "make", "main")
-	case "c++":
-		exec.Command("bash", "-c", "make", "main")
+	before, sampleErr := sample(ctx, container)
+	start := time.Now()
+	err = cmd.Run()
+	var stats accounting.Stats
+	if ctx.Err() != nil {
+		cancel(ctx, container, id)
+	} else if sampleErr == nil {
+		var after accounting.CgroupSample
+		if after, sampleErr = sample(ctx, container); sampleErr == nil {
+			stats = accounting.Between(before, after)
+		}
+	}
+	if sampleErr != nil {
+		runLog.DebugContext(ctx, "container cgroup stats unavailable", "container", container, "error", sampleErr)
+	}
+	stats.Wall = time.Since(start)
+	stats.OutputBytes = counted.n
+	span.SetAttributes(attribute.Int("toolchain.exit_code", metrics.ExitCode(err)))
+	tracing.End(span, err)
+	metrics.ObserveExec(job.Language, start, err)
+	runLog.InfoContext(ctx, "build finished",
+		"language", job.Language,
+		"container", container,
+		"exit_code", metrics.ExitCode(err),
+		"duration_ms", stats.Wall.Milliseconds(),
+		"cpu_seconds", stats.CPUSeconds,
+	)
+	if err != nil {
+		return stats, fmt.Errorf("%s failed: %w", job.Command, err)
+	}
+	return stats, nil
+}
 
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Filter runs job in the project's container as a filter, such as a
+// formatter: input is its stdin and its stdout is returned. When it fails,
+// the error carries what it printed on stderr.
+func Filter(ctx context.Context, job Job, input []byte) ([]byte, error) {
+	defer server.Track(ctx)()
+
+	ctx, span := tracing.Start(ctx, "toolchain.filter", attribute.String("toolchain.command", job.Command))
+	container, release, err := job.container(ctx)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	defer release()
+	id := newJobID()
+	args, err := execArgs(container, append([]string{jobEnv + "=" + id, logging.RequestIDEnv + "=" + logging.RequestID(ctx)}, job.Env...), true, job.Command)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		cancel(ctx, container, id)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("%s failed: %w: %s", job.Command, err, msg)
+		}
+		return nil, fmt.Errorf("%s failed: %w", job.Command, err)
 	}
+	return stdout.Bytes(), nil
 }
@@ -0,0 +1,48 @@
+package runnerservice
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// versionArgs lists toolchains whose version is not printed by --version
+var versionArgs = map[string][]string{
+	"go":     {"version"},
+	"tinygo": {"version"},
+}
+
+// toolchainVersions caches ToolchainVersion per binary; a toolchain is only
+// upgraded by redeploying, which restarts the process
+var toolchainVersions sync.Map
+
+// ToolchainVersion returns the first line toolchain, in the runner image,
+// prints about its version, or "unknown" when it can't be run. Results are
+// cached for the life of the process.
+func ToolchainVersion(ctx context.Context, toolchain string) string {
+	if v, ok := toolchainVersions.Load(toolchain); ok {
+		return v.(string)
+	}
+	img, err := image()
+	if err != nil {
+		return "unknown"
+	}
+
+	args, ok := versionArgs[filepath.Base(toolchain)]
+	if !ok {
+		args = []string{"--version"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", append([]string{"run", "--rm", "--network", "none", img, toolchain}, args...)...).Output()
+	if err != nil {
+		runLog.WarnContext(ctx, "cannot read toolchain version", "toolchain", toolchain, "error", err)
+		return "unknown"
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	toolchainVersions.Store(toolchain, version)
+	return version
+}
@@ -0,0 +1,263 @@
+// Package sandbox runs WebAssembly modules in process on the wazero
+// runtime, for quick runs that need neither a toolchain nor a container.
+// Runs are bounded by fuel, memory, wall time and output size, and see only
+// the directory they are given, through WASI.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"xxx/wasm"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+var (
+	// ErrInvalid is returned for modules that do not decode or validate
+	ErrInvalid = errors.New("invalid module")
+	// ErrFuelExhausted is returned when a run uses up its fuel
+	ErrFuelExhausted = errors.New("fuel exhausted")
+	// ErrTimeout is returned when a run takes longer than its timeout
+	ErrTimeout = errors.New("time limit exceeded")
+)
+
+// ExitError is returned when a module exits with a non-zero status
+type ExitError struct {
+	Code uint32
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode returns the status, as exec.ExitError does
+func (e *ExitError) ExitCode() int {
+	return int(e.Code)
+}
+
+// Limits bound a run. Zero disables a limit.
+type Limits struct {
+	Fuel        int64 // roughly, instructions executed
+	MemoryPages uint32
+	Timeout     time.Duration
+	MaxOutput   int // bytes of output kept
+}
+
+// Mount maps a host directory to a path inside the module
+type Mount struct {
+	Dir      string
+	Path     string
+	ReadOnly bool
+}
+
+// Config describes a run
+type Config struct {
+	Mounts []Mount
+	Args   []string
+	Env    []string // KEY=VALUE
+	Stdin  io.Reader
+	// Clock and Random give the module the host's clocks and crypto/rand;
+	// otherwise it sees wazero's fixed clock and deterministic randomness
+	Clock  bool
+	Random bool
+	// Entry is the exported function to call. By default it is _start, as
+	// for WASI commands, or main; a module exporting neither only runs its
+	// start function.
+	Entry string
+	Limits
+}
+
+// Result is what a run produced
+type Result struct {
+	Output      string   // stdout and stderr, interleaved
+	Truncated   bool     // output beyond MaxOutput was dropped
+	Results     []string // values the entry function returned
+	FuelUsed    int64
+	MemoryBytes int64 // size of the module's memory when it finished
+	Duration    time.Duration
+}
+
+// Run instantiates bin and calls its entry function. The result holds the
+// output even when the run fails.
+func Run(ctx context.Context, bin []byte, cfg Config) (Result, error) {
+	var result Result
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	m, err := wasm.Decode(bin)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	hasMemory := len(m.Memories) > 0
+	if cfg.Fuel > 0 {
+		metered, err := wasm.Meter(bin, cfg.Fuel)
+		if err != nil {
+			return result, fmt.Errorf("cannot meter module: %w", err)
+		}
+		bin = metered
+	}
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	// each run compiles its module afresh: a cache shared across runs would
+	// grow with every distinct module users submit
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true)
+	if cfg.MemoryPages > 0 {
+		runtimeConfig = runtimeConfig.WithMemoryLimitPages(cfg.MemoryPages)
+	}
+	rt := wazero.NewRuntimeWithConfig(ctx, runtimeConfig)
+	defer rt.Close(context.Background())
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		return result, err
+	}
+	compiled, err := rt.CompileModule(ctx, bin)
+	if err != nil {
+		return result, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	out := &limitedBuffer{max: cfg.MaxOutput}
+	moduleConfig := wazero.NewModuleConfig().
+		WithName("main").
+		WithArgs(append([]string{"main.wasm"}, cfg.Args...)...).
+		WithStdout(out).
+		WithStderr(out).
+		WithStartFunctions() // called below, once the fuel can be read
+	if cfg.Clock {
+		moduleConfig = moduleConfig.WithSysWalltime().WithSysNanotime().WithSysNanosleep()
+	}
+	if cfg.Random {
+		moduleConfig = moduleConfig.WithRandSource(rand.Reader)
+	}
+	if cfg.Stdin != nil {
+		moduleConfig = moduleConfig.WithStdin(cfg.Stdin)
+	}
+	for _, kv := range cfg.Env {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			moduleConfig = moduleConfig.WithEnv(key, value)
+		}
+	}
+	fsConfig := wazero.NewFSConfig()
+	for _, m := range cfg.Mounts {
+		if m.ReadOnly {
+			fsConfig = fsConfig.WithReadOnlyDirMount(m.Dir, m.Path)
+		} else {
+			fsConfig = fsConfig.WithDirMount(m.Dir, m.Path)
+		}
+	}
+	moduleConfig = moduleConfig.WithFSConfig(fsConfig)
+
+	mod, err := rt.InstantiateModule(ctx, compiled, moduleConfig)
+	if err == nil {
+		err = call(ctx, mod, cfg.Entry, &result)
+	}
+	result.Output, result.Truncated = out.String(), out.truncated
+
+	if mod != nil {
+		if fuel := mod.ExportedGlobal(wasm.FuelExport); fuel != nil {
+			left := int64(fuel.Get())
+			result.FuelUsed = min(cfg.Fuel, cfg.Fuel-left)
+			if left < 0 && err != nil {
+				return result, ErrFuelExhausted
+			}
+		}
+		// Memory returns a typed nil for modules without one
+		if len(compiled.ImportedMemories()) > 0 || hasMemory {
+			result.MemoryBytes = int64(mod.Memory().Size())
+		}
+	}
+	return result, exitError(err)
+}
+
+// call runs the start function Meter exported, if any, then the entry
+func call(ctx context.Context, mod api.Module, entry string, result *Result) error {
+	if start := mod.ExportedFunction(wasm.StartExport); start != nil {
+		if _, err := start.Call(ctx); err != nil {
+			return err
+		}
+	}
+
+	var fn api.Function
+	if entry != "" {
+		if fn = mod.ExportedFunction(entry); fn == nil {
+			return fmt.Errorf("module does not export a function %q", entry)
+		}
+	} else if fn = mod.ExportedFunction("_start"); fn == nil {
+		if fn = mod.ExportedFunction("main"); fn == nil {
+			return nil
+		}
+	}
+	if params := fn.Definition().ParamTypes(); len(params) > 0 {
+		return fmt.Errorf("function %q takes parameters", fn.Definition().ExportNames()[0])
+	}
+	values, err := fn.Call(ctx)
+	if err != nil {
+		return err
+	}
+	for i, t := range fn.Definition().ResultTypes() {
+		result.Results = append(result.Results, formatValue(t, values[i]))
+	}
+	return nil
+}
+
+// exitError turns how a run ended into one of the package's errors
+func exitError(err error) error {
+	var exit *sys.ExitError
+	if !errors.As(err, &exit) {
+		return err
+	}
+	switch exit.ExitCode() {
+	case 0:
+		return nil
+	case sys.ExitCodeDeadlineExceeded:
+		return ErrTimeout
+	case sys.ExitCodeContextCanceled:
+		return context.Canceled
+	}
+	return &ExitError{Code: exit.ExitCode()}
+}
+
+func formatValue(t api.ValueType, v uint64) string {
+	switch t {
+	case api.ValueTypeI32:
+		return strconv.FormatInt(int64(int32(v)), 10)
+	case api.ValueTypeI64:
+		return strconv.FormatInt(int64(v), 10)
+	case api.ValueTypeF32:
+		return strconv.FormatFloat(float64(api.DecodeF32(v)), 'g', -1, 32)
+	case api.ValueTypeF64:
+		return strconv.FormatFloat(api.DecodeF64(v), 'g', -1, 64)
+	}
+	return fmt.Sprintf("%s:%#x", api.ValueTypeName(t), v)
+}
+
+// limitedBuffer keeps the first max bytes written to it and drops the rest,
+// without failing the writes
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.max > 0 && b.Len()+len(p) > b.max {
+		b.truncated = true
+		b.Buffer.Write(p[:max(0, b.max-b.Len())])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
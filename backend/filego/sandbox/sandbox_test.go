@@ -0,0 +1,86 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xxx/wasm"
+)
+
+func assemble(t *testing.T, src string) []byte {
+	t.Helper()
+	bin, err := wasm.Assemble(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestRunTemplate(t *testing.T) {
+	src, err := os.ReadFile("../templates/builtin/wat-hello/hello.wat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Run(context.Background(), assemble(t, string(src)), Config{Limits: Limits{Fuel: 1_000_000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Output == "" || result.FuelUsed <= 0 {
+		t.Errorf("got output %q using %d fuel", result.Output, result.FuelUsed)
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	loop := assemble(t, `(module (func (export "_start") (loop (br 0))))`)
+	if _, err := Run(context.Background(), loop, Config{Limits: Limits{Fuel: 10_000}}); !errors.Is(err, ErrFuelExhausted) {
+		t.Errorf("infinite loop with fuel: got %v", err)
+	}
+	if _, err := Run(context.Background(), loop, Config{Limits: Limits{Timeout: 50 * time.Millisecond}}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("infinite loop with a timeout: got %v", err)
+	}
+
+	grow := assemble(t, `(module (memory 1) (func (export "main") (result i32) (memory.grow (i32.const 10))))`)
+	result, err := Run(context.Background(), grow, Config{Limits: Limits{MemoryPages: 4}})
+	if err != nil || len(result.Results) != 1 || result.Results[0] != "-1" {
+		t.Errorf("growing past the memory limit: got %v, %v", result.Results, err)
+	}
+}
+
+func TestRunExitAndFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "input.txt"), []byte("from the project\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// opens /input.txt, copies it to stdout and exits with status 3
+	bin := assemble(t, `
+(module
+  (import "wasi_snapshot_preview1" "path_open"
+    (func $path_open (param i32 i32 i32 i32 i32 i64 i64 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_read" (func $fd_read (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "fd_write" (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func $proc_exit (param i32)))
+  (memory 1)
+  (data (i32.const 0) "input.txt")
+  (func (export "_start")
+    (drop (call $path_open (i32.const 3) (i32.const 0) (i32.const 0) (i32.const 9)
+      (i32.const 0) (i64.const 2) (i64.const 0) (i32.const 0) (i32.const 16)))
+    (i32.store (i32.const 32) (i32.const 64))
+    (i32.store (i32.const 36) (i32.const 64))
+    (drop (call $fd_read (i32.load (i32.const 16)) (i32.const 32) (i32.const 1) (i32.const 40)))
+    (i32.store (i32.const 36) (i32.load (i32.const 40)))
+    (drop (call $fd_write (i32.const 1) (i32.const 32) (i32.const 1) (i32.const 44)))
+    (call $proc_exit (i32.const 3))))
+`)
+	result, err := Run(context.Background(), bin, Config{Mounts: []Mount{{Dir: dir, Path: "/"}}, Limits: Limits{Fuel: 1_000_000}})
+	var exit *ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Errorf("got %v, want exit status 3", err)
+	}
+	if result.Output != "from the project\n" {
+		t.Errorf("got output %q", result.Output)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+
+	"xxx/search"
+
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// SearchResponse lists the matches of a search
+type SearchResponse struct {
+	Success   bool           `json:"success"`
+	Message   string         `json:"message,omitempty"`
+	Matches   []search.Match `json:"matches"`
+	Files     int            `json:"files"`               // files searched
+	Truncated bool           `json:"truncated,omitempty"` // the result limit was reached
+}
+
+// searchStreamMatch and searchStreamEnd are the lines of a streamed search
+type searchStreamMatch struct {
+	Action string `json:"action"` // always match
+	search.Match
+}
+
+type searchStreamEnd struct {
+	Action string `json:"action"` // always done
+	SearchResponse
+}
+
+// searchHandler searches the project's files for ?q=, literally or with
+// ?regex=1 as a regular expression, ignoring case unless ?case=1. ?include=
+// and ?exclude= globs, repeatable, pick the files; ?context= adds lines
+// around each match and ?max= bounds the matches. With ?stream=1 the
+// answer is newline-delimited JSON, one match per line as it is found and
+// then the summary with action done.
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	opts := search.Options{
+		Pattern:       query.Get("q"),
+		Regex:         query.Get("regex") == "1",
+		CaseSensitive: query.Get("case") == "1",
+		Include:       query["include"],
+		Exclude:       query["exclude"],
+	}
+	opts.Context, _ = strconv.Atoi(query.Get("context"))
+	opts.MaxResults, _ = strconv.Atoi(query.Get("max"))
+	searcher, err := search.Compile(opts)
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	names, err := s.projects.Files(project.ID)
+	metrics.ObserveFile("list", err)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error listing project files", "project", project.ID, "error", err)
+		s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+			Success: false,
+			Message: "Error listing project files",
+		})
+		return
+	}
+	sources := names[:0]
+	for _, name := range names {
+		if !project.IsBuildOutput(name) {
+			sources = append(sources, name)
+		}
+	}
+	dir, _ := s.projects.Dir(project.ID)
+	candidates := s.indexes.Get(project.ID).Candidates(dir, sources, searcher)
+
+	stream := query.Get("stream") == "1"
+	var enc *json.Encoder
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		enc = json.NewEncoder(w)
+	}
+	response := SearchResponse{Success: true, Matches: []search.Match{}}
+	flusher := http.NewResponseController(w)
+	found := 0
+	emit := func(m search.Match) bool {
+		if found == searcher.MaxResults() {
+			response.Truncated = true
+			return false
+		}
+		found++
+		if stream {
+			return enc.Encode(searchStreamMatch{Action: "match", Match: m}) == nil
+		}
+		response.Matches = append(response.Matches, m)
+		return true
+	}
+	for _, name := range candidates {
+		if ctx.Err() != nil {
+			return
+		}
+		// links and anything else but regular files are not searched
+		path, err := projectFilePath(dir, name, false)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() || info.Size() > maxFileSizes {
+			continue
+		}
+		content, err := readRegular(path)
+		metrics.ObserveFile("read", err)
+		if err != nil {
+			s.logger.WarnContext(ctx, "error reading file to search", "path", path, "error", err)
+			continue
+		}
+		response.Files++
+		if !searcher.File(name, content, emit) {
+			break
+		}
+		if stream {
+			flusher.Flush()
+		}
+	}
+
+	if stream {
+		enc.Encode(searchStreamEnd{Action: "done", SearchResponse: response})
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, response)
+}
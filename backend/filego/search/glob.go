@@ -0,0 +1,56 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compileGlob turns a glob into a regular expression matching the paths it
+// selects: the files it names and everything under the directories it names
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	glob = strings.Trim(glob, "/")
+	if glob == "" {
+		return nil, invalid("empty glob")
+	}
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(glob[i:], "**/"):
+				expr.WriteString("(?:.*/)?")
+				i += 2
+			case strings.HasPrefix(glob[i:], "**"):
+				expr.WriteString(".*")
+				i++
+			default:
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, invalid("unterminated [ in glob %q", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	// a name matches anywhere in the path, a path from the root
+	start := "^"
+	if !strings.Contains(glob, "/") {
+		start = "(?:^|/)"
+	}
+	re, err := regexp.Compile(start + expr.String() + "(?:/|$)")
+	if err != nil {
+		return nil, invalid("glob %q: %v", glob, err)
+	}
+	return re, nil
+}
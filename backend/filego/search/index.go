@@ -0,0 +1,177 @@
+package search
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxIndexedSize is the largest file indexed; larger ones are always read
+const maxIndexedSize = 1 << 20
+
+// maxIndexes bounds how many projects keep an index in memory
+const maxIndexes = 64
+
+// Index records the trigrams of a project's files, in lower case, so a
+// search reads only the files containing its required text. Entries are
+// checked against each file's size and modification time before use and
+// refreshed when the file changed, however it was written.
+type Index struct {
+	mu    sync.Mutex
+	files map[string]*indexEntry
+	used  time.Time
+}
+
+type indexEntry struct {
+	size    int64
+	modTime time.Time
+	binary  bool
+	grams   []uint32 // sorted; nil for files too large to index
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{files: map[string]*indexEntry{}}
+}
+
+// Candidates returns the files under root, of those named, that may match
+// s: the ones the globs select that are text and contain s's required text
+func (x *Index) Candidates(root string, names []string, s *Searcher) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.used = time.Now()
+
+	want := trigrams([]byte(s.required))
+	present := make(map[string]bool, len(names))
+	var candidates []string
+	for _, name := range names {
+		present[name] = true
+		if !s.Selects(name) {
+			continue
+		}
+		entry := x.refresh(root, name)
+		if entry == nil || entry.binary {
+			continue
+		}
+		if entry.grams == nil || containsAll(entry.grams, want) {
+			candidates = append(candidates, name)
+		}
+	}
+	for name := range x.files {
+		if !present[name] {
+			delete(x.files, name)
+		}
+	}
+	return candidates
+}
+
+// refresh returns the entry of name, indexing the file again when it
+// changed, or nil when it can't be read
+func (x *Index) refresh(root, name string) *indexEntry {
+	path := filepath.Join(root, filepath.FromSlash(name))
+	// links are skipped, they could lead out of the project
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		delete(x.files, name)
+		return nil
+	}
+	if entry, ok := x.files[name]; ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry
+	}
+	entry := &indexEntry{size: info.Size(), modTime: info.ModTime()}
+	if info.Size() <= maxIndexedSize {
+		content, err := readRegular(path)
+		if err != nil {
+			return nil
+		}
+		entry.binary = IsBinary(content)
+		if !entry.binary {
+			entry.grams = trigrams(content)
+		}
+	}
+	x.files[name] = entry
+	return entry
+}
+
+// readRegular reads the file at path, refusing links, and anything else
+// that became of it since it was checked but a regular file
+func readRegular(path string) ([]byte, error) {
+	// O_NONBLOCK keeps a named pipe from blocking the open
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return nil, err
+	} else if !info.Mode().IsRegular() {
+		return nil, os.ErrInvalid
+	}
+	return io.ReadAll(f)
+}
+
+// trigrams returns the sorted distinct trigrams of text in lower case
+func trigrams(text []byte) []uint32 {
+	text = bytes.ToLower(text)
+	set := make(map[uint32]struct{})
+	for i := 0; i+3 <= len(text); i++ {
+		set[uint32(text[i])<<16|uint32(text[i+1])<<8|uint32(text[i+2])] = struct{}{}
+	}
+	grams := make([]uint32, 0, len(set))
+	for g := range set {
+		grams = append(grams, g)
+	}
+	slices.Sort(grams)
+	return grams
+}
+
+func containsAll(grams, want []uint32) bool {
+	for _, g := range want {
+		if _, ok := slices.BinarySearch(grams, g); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Indexes keeps the index of each project, dropping the least recently used
+// beyond a bound
+type Indexes struct {
+	mu      sync.Mutex
+	indexes map[string]*Index
+}
+
+// NewIndexes returns an empty set of indexes
+func NewIndexes() *Indexes {
+	return &Indexes{indexes: map[string]*Index{}}
+}
+
+// Get returns the index of project id, creating it if needed
+func (xs *Indexes) Get(id string) *Index {
+	xs.mu.Lock()
+	defer xs.mu.Unlock()
+	if x, ok := xs.indexes[id]; ok {
+		return x
+	}
+	if len(xs.indexes) >= maxIndexes {
+		var oldest string
+		var oldestUse time.Time
+		for id, x := range xs.indexes {
+			x.mu.Lock()
+			used := x.used
+			x.mu.Unlock()
+			if oldest == "" || used.Before(oldestUse) {
+				oldest, oldestUse = id, used
+			}
+		}
+		delete(xs.indexes, oldest)
+	}
+	x := NewIndex()
+	xs.indexes[id] = x
+	return x
+}
@@ -0,0 +1,186 @@
+// Package search finds text in project files: literal strings or regular
+// expressions, with or without case, in the files picked by include and
+// exclude globs, reporting each match with its position and surrounding
+// lines. An Index of each file's trigrams skips the files that can't match.
+package search
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on what one search returns
+const (
+	DefaultMaxResults = 1000
+	MaxResults        = 10000
+	MaxContext        = 10
+	// maxLineLength cuts the lines of minified files and the like
+	maxLineLength = 1000
+)
+
+// ErrInvalid is returned for searches that can't run, such as an empty
+// pattern or one that does not compile
+var ErrInvalid = errors.New("invalid search")
+
+// Options describe a search
+type Options struct {
+	Pattern       string
+	Regex         bool
+	CaseSensitive bool
+	// Include limits the search to files matching one of the globs, and
+	// Exclude leaves out those matching one. Globs without a slash match any
+	// file or directory name, others the path from the project root; **
+	// matches any number of directories.
+	Include, Exclude []string
+	Context          int // lines shown before and after each match
+	MaxResults       int
+}
+
+// Match is one occurrence of the pattern. Lines and columns count from 1;
+// columns are in characters and EndColumn is just past the match.
+type Match struct {
+	File      string   `json:"file"`
+	Line      int      `json:"line"`
+	Column    int      `json:"column"`
+	EndColumn int      `json:"end_column"`
+	Text      string   `json:"text"`
+	Before    []string `json:"before,omitempty"`
+	After     []string `json:"after,omitempty"`
+}
+
+// Searcher is a compiled search
+type Searcher struct {
+	opts    Options
+	re      *regexp.Regexp
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	// required is text every match contains, in lower case, for the index
+	required string
+}
+
+// Compile checks opts and prepares the search
+func Compile(opts Options) (*Searcher, error) {
+	if opts.Pattern == "" {
+		return nil, invalid("empty pattern")
+	}
+	opts.Context = min(max(opts.Context, 0), MaxContext)
+	if opts.MaxResults <= 0 {
+		opts.MaxResults = DefaultMaxResults
+	}
+	opts.MaxResults = min(opts.MaxResults, MaxResults)
+
+	s := &Searcher{opts: opts}
+	expr := opts.Pattern
+	if !opts.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	s.required, _ = re.LiteralPrefix()
+	s.required = strings.ToLower(s.required)
+	if !opts.CaseSensitive {
+		if re, err = regexp.Compile("(?i)" + expr); err != nil {
+			return nil, invalid("%v", err)
+		}
+	}
+	s.re = re
+
+	for _, glob := range opts.Include {
+		g, err := compileGlob(glob)
+		if err != nil {
+			return nil, err
+		}
+		s.include = append(s.include, g)
+	}
+	for _, glob := range opts.Exclude {
+		g, err := compileGlob(glob)
+		if err != nil {
+			return nil, err
+		}
+		s.exclude = append(s.exclude, g)
+	}
+	return s, nil
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// MaxResults is how many matches the search reports at most
+func (s *Searcher) MaxResults() int {
+	return s.opts.MaxResults
+}
+
+// Selects reports whether the globs let the search look in the file name,
+// a slash-separated path from the project root
+func (s *Searcher) Selects(name string) bool {
+	for _, g := range s.exclude {
+		if g.MatchString(name) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, g := range s.include {
+		if g.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBinary reports whether content looks like something other than text,
+// which the search skips
+func IsBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+// File searches content, the file name, calling emit with each match until
+// it returns false. It returns false when emit stopped it.
+func (s *Searcher) File(name string, content []byte, emit func(Match) bool) bool {
+	if IsBinary(content) || !s.re.Match(content) {
+		return true
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		for _, loc := range s.re.FindAllStringIndex(line, -1) {
+			m := Match{
+				File:      name,
+				Line:      i + 1,
+				Column:    utf8.RuneCountInString(line[:loc[0]]) + 1,
+				EndColumn: utf8.RuneCountInString(line[:loc[1]]) + 1,
+				Text:      cut(line),
+			}
+			for j := max(0, i-s.opts.Context); j < i; j++ {
+				m.Before = append(m.Before, cut(strings.TrimSuffix(lines[j], "\r")))
+			}
+			for j := i + 1; j < min(len(lines), i+1+s.opts.Context); j++ {
+				m.After = append(m.After, cut(strings.TrimSuffix(lines[j], "\r")))
+			}
+			if !emit(m) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// cut shortens a line to maxLineLength bytes, on a character boundary
+func cut(line string) string {
+	if len(line) <= maxLineLength {
+		return line
+	}
+	end := maxLineLength
+	for end > 0 && !utf8.RuneStart(line[end]) {
+		end--
+	}
+	return line[:end]
+}
@@ -0,0 +1,107 @@
+package search
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	s, err := Compile(Options{Pattern: "todo", Context: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Match
+	s.File("main.go", []byte("package main\n// TODO: é todo\r\nfunc main() {}\n"), func(m Match) bool {
+		got = append(got, m)
+		return true
+	})
+	want := []Match{
+		{File: "main.go", Line: 2, Column: 4, EndColumn: 8, Text: "// TODO: é todo", Before: []string{"package main"}, After: []string{"func main() {}"}},
+		{File: "main.go", Line: 2, Column: 12, EndColumn: 16, Text: "// TODO: é todo", Before: []string{"package main"}, After: []string{"func main() {}"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	s, _ = Compile(Options{Pattern: `fn \w+\(`, Regex: true, CaseSensitive: true})
+	got = nil
+	s.File("lib.rs", []byte("FN no(\nfn yes(x: i32)\n"), func(m Match) bool {
+		got = append(got, m)
+		return false
+	})
+	if len(got) != 1 || got[0].Line != 2 || got[0].EndColumn != 8 {
+		t.Errorf("regex: got %+v", got)
+	}
+
+	if _, err := Compile(Options{Pattern: "(", Regex: true}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("got %v, want ErrInvalid", err)
+	}
+}
+
+func TestSelects(t *testing.T) {
+	s, err := Compile(Options{Pattern: "x", Include: []string{"*.go", "src/**/*.rs"}, Exclude: []string{"vendor", "src/gen/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"main.go":             true,
+		"cmd/tool/main.go":    true,
+		"vendor/x/x.go":       false,
+		"src/lib.rs":          true,
+		"src/a/b/lib.rs":      true,
+		"src/gen/out.rs":      false,
+		"lib.rs":              false,
+		"README.md":           false,
+		"notvendor/x/main.go": true,
+	} {
+		if got := s.Selects(name); got != want {
+			t.Errorf("Selects(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "hello world")
+	write("b.txt", "goodbye")
+	write("c.bin", "hello\x00")
+	names := []string{"a.txt", "b.txt", "c.bin"}
+
+	x := NewIndex()
+	s, _ := Compile(Options{Pattern: "HELLO"})
+	if got := x.Candidates(root, names, s); !reflect.DeepEqual(got, []string{"a.txt"}) {
+		t.Errorf("got %v", got)
+	}
+	// the index notices the change, even in the same second
+	write("b.txt", "hello again")
+	os.Chtimes(filepath.Join(root, "b.txt"), x.files["b.txt"].modTime, x.files["b.txt"].modTime.Add(1))
+	if got := x.Candidates(root, names, s); !reflect.DeepEqual(got, []string{"a.txt", "b.txt"}) {
+		t.Errorf("after a change: got %v", got)
+	}
+	// a regex is narrowed by its literal prefix only
+	s, _ = Compile(Options{Pattern: "good|hello", Regex: true})
+	if got := x.Candidates(root, names, s); len(got) != 2 {
+		t.Errorf("regex: got %v", got)
+	}
+}
+
+func TestIndexSkipsLinks(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(outside, []byte("hello secret"), 0644)
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644)
+	os.Symlink(outside, filepath.Join(root, "link.txt"))
+
+	s, _ := Compile(Options{Pattern: "hello"})
+	if got := NewIndex().Candidates(root, []string{"a.txt", "link.txt"}, s); !reflect.DeepEqual(got, []string{"a.txt"}) {
+		t.Errorf("got %v, want the link skipped", got)
+	}
+}
@@ -0,0 +1,6 @@
+#include <stdio.h>
+
+int main(void) {
+    printf("Hello from C on WASI!\n");
+    return 0;
+}
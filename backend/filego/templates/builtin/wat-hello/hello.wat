@@ -0,0 +1,20 @@
+(module
+  (import "wasi_snapshot_preview1" "fd_write"
+    (func $fd_write (param i32 i32 i32 i32) (result i32)))
+
+  (memory (export "memory") 1)
+
+  ;; iovec at 0: pointer to the text and its length
+  (data (i32.const 8) "Hello from WAT on WASI!\n")
+
+  (func (export "_start")
+    (i32.store (i32.const 0) (i32.const 8))
+    (i32.store (i32.const 4) (i32.const 24))
+    (drop
+      (call $fd_write
+        (i32.const 1)    ;; stdout
+        (i32.const 0)    ;; iovs
+        (i32.const 1)    ;; iovs_len
+        (i32.const 40))) ;; where to write the byte count
+  )
+)
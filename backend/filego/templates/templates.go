@@ -0,0 +1,237 @@
+// Package templates holds the starter projects new workspaces are scaffolded
+// from. Each template is a directory with a template.json manifest naming
+// its files, toolchain and the commands that build and run it.
+package templates
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ManifestFile is the name of the manifest in every template directory
+const ManifestFile = "template.json"
+
+// tmplSuffix is dropped from file names when scaffolding, so files such as
+// go.mod and Go sources can ship inside this module without being treated
+// as nested modules or built as its packages
+const tmplSuffix = ".tmpl"
+
+//go:embed all:builtin
+var builtin embed.FS
+
+// ErrNotFound is returned for a template name that is not registered
+var ErrNotFound = errors.New("template not found")
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Manifest describes a template
+type Manifest struct {
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Language    string   `json:"language"`
+	Toolchain   string   `json:"toolchain"`       // binary that must be installed to build
+	Build       string   `json:"build,omitempty"` // shell command, run from the project root
+	Run         string   `json:"run"`
+	Artifact    string   `json:"artifact,omitempty"` // what Build produces, relative to the root
+	Test        string   `json:"test,omitempty"`     // runs the tests when the language has no usual command
+	Files       []string `json:"files"`
+}
+
+// Template is a manifest plus the files it names
+type Template struct {
+	Manifest
+	Builtin bool `json:"builtin"`
+	fsys    fs.FS
+}
+
+// validate checks m is complete and that its files stay inside the template
+func (m *Manifest) validate() error {
+	if !validName.MatchString(m.Name) {
+		return fmt.Errorf("invalid template name %q", m.Name)
+	}
+	if m.Language == "" || m.Toolchain == "" || m.Run == "" {
+		return fmt.Errorf("template %s: language, toolchain and run are required", m.Name)
+	}
+	if len(m.Files) == 0 {
+		return fmt.Errorf("template %s has no files", m.Name)
+	}
+	for _, name := range m.Files {
+		if !fs.ValidPath(name) || name == "." || name == ManifestFile {
+			return fmt.Errorf("template %s: invalid file %q", m.Name, name)
+		}
+	}
+	return nil
+}
+
+// load reads the template rooted at dir in fsys
+func load(fsys fs.FS, dir string) (*Template, error) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path.Join(dir, ManifestFile), err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range m.Files {
+		if _, err := fs.Stat(sub, name); err != nil {
+			return nil, fmt.Errorf("template %s: %w", m.Name, err)
+		}
+	}
+	return &Template{Manifest: m, fsys: sub}, nil
+}
+
+// loadAll reads the template at root of fsys, or when root has no manifest,
+// every subdirectory that has one
+func loadAll(fsys fs.FS) ([]*Template, error) {
+	if _, err := fs.Stat(fsys, ManifestFile); err == nil {
+		t, err := load(fsys, ".")
+		if err != nil {
+			return nil, err
+		}
+		return []*Template{t}, nil
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var found []*Template
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := fs.Stat(fsys, path.Join(entry.Name(), ManifestFile)); err != nil {
+			continue
+		}
+		t, err := load(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, t)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no %s found", ManifestFile)
+	}
+	return found, nil
+}
+
+// Scaffold writes the template's files into dir, which must not exist yet
+func (t *Template) Scaffold(dir string) error {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range t.Files {
+		data, err := fs.ReadFile(t.fsys, name)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(name, tmplSuffix)))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Registry is the set of templates projects can be created from
+type Registry struct {
+	mu        sync.RWMutex
+	templates map[string]*Template
+}
+
+// NewRegistry returns a registry holding the built-in templates
+func NewRegistry() *Registry {
+	sub, err := fs.Sub(builtin, "builtin")
+	if err != nil {
+		panic(err)
+	}
+	found, err := loadAll(sub)
+	if err != nil {
+		panic(fmt.Sprintf("built-in templates: %v", err))
+	}
+
+	r := &Registry{templates: make(map[string]*Template)}
+	for _, t := range found {
+		t.Builtin = true
+		r.templates[t.Name] = t
+	}
+	return r
+}
+
+// AddDir registers the template in dir, or every template in its
+// subdirectories, and returns their names. The files are read when a
+// project is scaffolded, so later edits in dir are picked up. A custom
+// template replaces one of the same name, except a built-in one.
+func (r *Registry) AddDir(dir string) ([]string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	found, err := loadAll(os.DirFS(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range found {
+		if existing, ok := r.templates[t.Name]; ok && existing.Builtin {
+			return nil, fmt.Errorf("%s is a built-in template", t.Name)
+		}
+	}
+	names := make([]string, 0, len(found))
+	for _, t := range found {
+		r.templates[t.Name] = t
+		names = append(names, t.Name)
+	}
+	return names, nil
+}
+
+// Get returns the template called name
+func (r *Registry) Get(name string) (*Template, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.templates[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+// List returns every template sorted by name
+func (r *Registry) List() []*Template {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*Template, 0, len(r.templates))
+	for _, t := range r.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,82 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinTemplatesScaffold(t *testing.T) {
+	r := NewRegistry()
+	for _, name := range []string{"rust-wasi", "go-wasip1", "tinygo", "c-wasi-sdk", "assemblyscript", "wat-hello"} {
+		tmpl, err := r.Get(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		dir := filepath.Join(t.TempDir(), "project")
+		if err := tmpl.Scaffold(dir); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for _, file := range tmpl.Files {
+			if _, err := os.Stat(filepath.Join(dir, strings.TrimSuffix(file, tmplSuffix))); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+
+	// go.mod and main.go ship as go.mod.tmpl and main.go.tmpl
+	tmpl, _ := r.Get("go-wasip1")
+	dir := filepath.Join(t.TempDir(), "project")
+	if err := tmpl.Scaffold(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"go.mod", "main.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+
+	if err := tmpl.Scaffold(dir); !os.IsExist(err) {
+		t.Errorf("expected scaffolding over an existing directory to fail, got %v", err)
+	}
+}
+
+func TestAddDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("zig/template.json", `{"name":"zig","title":"Zig","language":"zig","toolchain":"zig",
+		"build":"zig build-exe main.zig -target wasm32-wasi","run":"wasmtime main.wasm","files":["main.zig"]}`)
+	write("zig/main.zig", "pub fn main() void {}\n")
+
+	r := NewRegistry()
+	names, err := r.AddDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "zig" {
+		t.Fatalf("expected [zig], got %v", names)
+	}
+	if tmpl, err := r.Get("zig"); err != nil || tmpl.Builtin {
+		t.Errorf("expected a custom zig template, got %+v, %v", tmpl, err)
+	}
+
+	write("shadow/template.json", `{"name":"wat-hello","language":"wat","toolchain":"wat2wasm","run":"true","files":["a.wat"]}`)
+	write("shadow/a.wat", "(module)\n")
+	if _, err := r.AddDir(filepath.Join(dir, "shadow")); err == nil {
+		t.Error("expected a custom template not to replace a built-in one")
+	}
+
+	write("escape/template.json", `{"name":"escape","language":"c","toolchain":"cc","run":"true","files":["../zig/main.zig"]}`)
+	if _, err := r.AddDir(filepath.Join(dir, "escape")); err == nil {
+		t.Error("expected files outside the template to be rejected")
+	}
+}
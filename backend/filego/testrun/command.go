@@ -0,0 +1,46 @@
+package testrun
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Command returns the shell command running the tests of a project in
+// language, or only the test called name when it isn't empty. configured,
+// the project's own test command, wins over the language's; the name is
+// passed to it as a last argument. Tests run on the host, not as WebAssembly.
+func Command(language, configured, name string) (string, error) {
+	if configured != "" {
+		if name == "" {
+			return configured, nil
+		}
+		return configured + " " + shellQuote(name), nil
+	}
+	switch language {
+	case "rust":
+		if name == "" {
+			return "cargo test", nil
+		}
+		return "cargo test " + shellQuote(name) + " -- --exact", nil
+	case "go", "tinygo":
+		if name == "" {
+			return "go test -json ./...", nil
+		}
+		return "go test -json -run " + shellQuote(goRunPattern(name)) + " ./...", nil
+	}
+	return "", ErrNoCommand
+}
+
+// goRunPattern matches exactly the test called name, which -run splits at
+// slashes into a pattern per level of subtests
+func goRunPattern(name string) string {
+	levels := strings.Split(name, "/")
+	for i, level := range levels {
+		levels[i] = "^" + regexp.QuoteMeta(level) + "$"
+	}
+	return strings.Join(levels, "/")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,328 @@
+// Package testrun picks the command running a project's tests and turns
+// what the test tools print into per-test results as they finish: go test
+// -json events, libtest's output as cargo test prints it, and TAP from other
+// configured commands.
+package testrun
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Statuses of a test
+const (
+	Running = "run"
+	Pass    = "pass"
+	Fail    = "fail"
+	Skip    = "skip"
+)
+
+// Output is capped per test and for the whole run, as a test stuck printing
+// in a loop would otherwise fill the server's memory
+const (
+	maxTestOutput = 64 << 10
+	maxOutput     = 1 << 20
+)
+
+// ErrNoCommand is returned for projects in a language without a default
+// test command that have not configured their own
+var ErrNoCommand = errors.New("no test command for this project")
+
+// Result is the outcome of one test. Results without a name are packages
+// that failed outside any test, such as when they don't compile.
+type Result struct {
+	Name       string  `json:"name"`
+	Package    string  `json:"package,omitempty"` // the Go package or Rust test binary
+	Status     string  `json:"status"`
+	DurationMS float64 `json:"duration_ms,omitempty"` // when the tool reports it
+	Output     string  `json:"output,omitempty"`
+}
+
+// Report sums up a test run
+type Report struct {
+	Tests     []Result `json:"tests"`
+	Passed    int      `json:"passed"`
+	Failed    int      `json:"failed"`
+	Skipped   int      `json:"skipped"`
+	Output    string   `json:"output"` // everything printed, as text
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// Event is a step of a test run as it happens: a test started, printed a
+// line of output or finished with Status
+type Event struct {
+	Action     string  `json:"action"` // run, output, pass, fail or skip
+	Test       string  `json:"test,omitempty"`
+	Package    string  `json:"package,omitempty"`
+	Output     string  `json:"output,omitempty"`
+	DurationMS float64 `json:"duration_ms,omitempty"`
+}
+
+// Collector reads the output of a test command, written to it as the
+// command prints, and reports each test's progress to its callback
+type Collector struct {
+	onEvent func(Event)
+	partial []byte
+
+	report  Report
+	output  strings.Builder
+	results map[string]int // index in report.Tests by package and name
+
+	// goFailed records Go packages with a failing test, the others failing
+	// are reported on their own
+	goFailed map[string]bool
+	// goOutput is the output of Go packages outside their tests
+	goOutput map[string]*strings.Builder
+
+	// libtest state: the test binary running and the test whose captured
+	// output is being printed
+	binary   string
+	captured string
+}
+
+// NewCollector returns a collector calling onEvent, which may be nil, for
+// each event
+func NewCollector(onEvent func(Event)) *Collector {
+	if onEvent == nil {
+		onEvent = func(Event) {}
+	}
+	return &Collector{
+		onEvent:  onEvent,
+		results:  map[string]int{},
+		goFailed: map[string]bool{},
+		goOutput: map[string]*strings.Builder{},
+	}
+}
+
+// Write parses every complete line of p
+func (c *Collector) Write(p []byte) (int, error) {
+	c.partial = append(c.partial, p...)
+	for {
+		i := bytes.IndexByte(c.partial, '\n')
+		if i < 0 {
+			break
+		}
+		c.line(string(c.partial[:i]))
+		c.partial = c.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Report parses what is left of the output once the command exited and
+// returns the results
+func (c *Collector) Report() Report {
+	if len(c.partial) > 0 {
+		c.line(string(c.partial))
+		c.partial = nil
+	}
+	c.report.Output = c.output.String()
+	c.report.Passed, c.report.Failed, c.report.Skipped = 0, 0, 0
+	for i := range c.report.Tests {
+		t := &c.report.Tests[i]
+		// a test still running when its command exits crashed or was killed
+		if t.Status == Running {
+			t.Status = Fail
+		}
+		switch t.Status {
+		case Pass:
+			c.report.Passed++
+		case Fail:
+			c.report.Failed++
+		case Skip:
+			c.report.Skipped++
+		}
+	}
+	if c.report.Tests == nil {
+		c.report.Tests = []Result{}
+	}
+	return c.report
+}
+
+func (c *Collector) line(line string) {
+	line = strings.TrimSuffix(line, "\r")
+	if strings.HasPrefix(line, "{") && c.goEvent(line) {
+		return
+	}
+	c.print(line + "\n")
+	if !c.libtest(line) {
+		c.tap(line)
+	}
+}
+
+// print adds text to the output of the run
+func (c *Collector) print(text string) {
+	if c.output.Len()+len(text) > maxOutput {
+		c.report.Truncated = true
+		return
+	}
+	c.output.WriteString(text)
+}
+
+// result returns the result of the test, adding it when new
+func (c *Collector) result(pkg, name string) *Result {
+	key := pkg + "\x00" + name
+	i, ok := c.results[key]
+	if !ok {
+		i = len(c.report.Tests)
+		c.results[key] = i
+		c.report.Tests = append(c.report.Tests, Result{Name: name, Package: pkg, Status: Running})
+	}
+	return &c.report.Tests[i]
+}
+
+// finish records the outcome of a test
+func (c *Collector) finish(pkg, name, status string, durationMS float64) {
+	t := c.result(pkg, name)
+	t.Status, t.DurationMS = status, durationMS
+	c.onEvent(Event{Action: status, Test: name, Package: pkg, DurationMS: durationMS})
+}
+
+func appendOutput(t *Result, text string) {
+	if len(t.Output)+len(text) <= maxTestOutput {
+		t.Output += text
+	}
+}
+
+// goTestEvent is one line of go test -json, as documented by go doc test2json
+type goTestEvent struct {
+	Action     string
+	Package    string
+	ImportPath string // of build-output events
+	Test       string
+	Elapsed    float64 // seconds
+	Output     string
+}
+
+// goEvent parses a line of go test -json
+func (c *Collector) goEvent(line string) bool {
+	var e goTestEvent
+	if err := json.Unmarshal([]byte(line), &e); err != nil || e.Action == "" {
+		return false
+	}
+	pkg := e.Package
+	if pkg == "" {
+		pkg = e.ImportPath
+	}
+	if e.Output != "" {
+		c.print(e.Output)
+		if e.Test != "" {
+			appendOutput(c.result(pkg, e.Test), e.Output)
+		} else {
+			out, ok := c.goOutput[pkg]
+			if !ok {
+				out = &strings.Builder{}
+				c.goOutput[pkg] = out
+			}
+			if out.Len()+len(e.Output) <= maxTestOutput {
+				out.WriteString(e.Output)
+			}
+		}
+		c.onEvent(Event{Action: "output", Test: e.Test, Package: pkg, Output: e.Output})
+	}
+
+	durationMS := e.Elapsed * 1000
+	switch e.Action {
+	case "run":
+		c.result(pkg, e.Test)
+		c.onEvent(Event{Action: Running, Test: e.Test, Package: pkg})
+	case Pass, Skip:
+		if e.Test != "" {
+			c.finish(pkg, e.Test, e.Action, durationMS)
+		}
+	case Fail:
+		if e.Test != "" {
+			c.goFailed[pkg] = true
+			c.finish(pkg, e.Test, Fail, durationMS)
+		} else if !c.goFailed[pkg] {
+			c.packageFailed(pkg, durationMS)
+		}
+	case "build-fail":
+		c.packageFailed(pkg, 0)
+	}
+	return true
+}
+
+// packageFailed reports a Go package failing outside its tests
+func (c *Collector) packageFailed(pkg string, durationMS float64) {
+	c.goFailed[pkg] = true
+	t := c.result(pkg, "")
+	if out, ok := c.goOutput[pkg]; ok {
+		t.Output = out.String()
+	}
+	c.finish(pkg, "", Fail, durationMS)
+}
+
+var (
+	//      Running unittests src/lib.rs (target/debug/deps/app-1f2e3d)
+	//    Doc-tests app
+	libtestBinary = regexp.MustCompile(`^\s*(?:Running(?: unittests)? (\S+)|Doc-tests (\S+))`)
+	// test tests::adds ... ok
+	// test tests::slow ... ignored, takes a minute
+	libtestResult = regexp.MustCompile(`^test (.+?) \.\.\. (ok|FAILED|ignored)(?:, .*)?$`)
+	// ---- tests::fails stdout ----
+	libtestCaptured = regexp.MustCompile(`^---- (.+?) std(?:out|err) ----$`)
+)
+
+// libtest parses a line of Rust's test harness
+func (c *Collector) libtest(line string) bool {
+	if m := libtestCaptured.FindStringSubmatch(line); m != nil {
+		c.captured = m[1]
+		return true
+	}
+	if c.captured != "" {
+		// the captured output of a failure runs to the next failure or
+		// the list of failures
+		if line == "failures:" {
+			c.captured = ""
+			return true
+		}
+		t := c.result(c.binary, c.captured)
+		appendOutput(t, line+"\n")
+		c.onEvent(Event{Action: "output", Test: c.captured, Package: c.binary, Output: line + "\n"})
+		return true
+	}
+
+	if m := libtestBinary.FindStringSubmatch(line); m != nil {
+		c.binary = m[1] + m[2]
+		return true
+	}
+	m := libtestResult.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	name := strings.TrimSuffix(m[1], " - should panic")
+	status := map[string]string{"ok": Pass, "FAILED": Fail, "ignored": Skip}[m[2]]
+	c.finish(c.binary, name, status, 0)
+	return true
+}
+
+// ok 1 - adds numbers
+// not ok 2 parses # TODO not written yet
+// ok 3 # SKIP needs a network
+var tapResult = regexp.MustCompile(`^(ok|not ok) (\d+)(?: -)?(?: ([^#]*?))?\s*(?:#\s*(\w+).*)?$`)
+
+// tap parses a line of the Test Anything Protocol. Only top-level results
+// count; indented ones are subtests summed up by their parent.
+func (c *Collector) tap(line string) {
+	m := tapResult.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	name := m[3]
+	if name == "" {
+		name = m[2]
+	}
+	status := Pass
+	if m[1] == "not ok" {
+		status = Fail
+	}
+	// failing TODO tests are expected to, and don't fail the run
+	switch directive := strings.ToUpper(m[4]); {
+	case directive == "SKIP", directive == "TODO" && status == Fail:
+		status = Skip
+	}
+	c.finish("", name, status, 0)
+}
@@ -0,0 +1,108 @@
+package testrun
+
+import (
+	"strings"
+	"testing"
+)
+
+// collect feeds output to a collector a few bytes at a time, as a command
+// prints it
+func collect(output string) (Report, []Event) {
+	var events []Event
+	c := NewCollector(func(e Event) { events = append(events, e) })
+	for len(output) > 0 {
+		n := min(7, len(output))
+		c.Write([]byte(output[:n]))
+		output = output[n:]
+	}
+	return c.Report(), events
+}
+
+func TestGoJSON(t *testing.T) {
+	report, events := collect(`{"Action":"start","Package":"example.com/app"}
+{"Action":"run","Package":"example.com/app","Test":"TestAdd"}
+{"Action":"output","Package":"example.com/app","Test":"TestAdd","Output":"=== RUN   TestAdd\n"}
+{"Action":"pass","Package":"example.com/app","Test":"TestAdd","Elapsed":0.25}
+{"Action":"run","Package":"example.com/app","Test":"TestDiv"}
+{"Action":"output","Package":"example.com/app","Test":"TestDiv","Output":"    app_test.go:9: division by zero\n"}
+{"Action":"fail","Package":"example.com/app","Test":"TestDiv","Elapsed":0}
+{"Action":"run","Package":"example.com/app","Test":"TestNet"}
+{"Action":"skip","Package":"example.com/app","Test":"TestNet","Elapsed":0}
+{"Action":"fail","Package":"example.com/app","Elapsed":0.3}
+# example.com/broken
+{"Action":"output","Package":"example.com/broken","Output":"broken.go:3:1: syntax error\n"}
+{"Action":"fail","Package":"example.com/broken","Elapsed":0}`)
+
+	if report.Passed != 1 || report.Failed != 2 || report.Skipped != 1 || len(report.Tests) != 4 {
+		t.Fatalf("got %+v", report)
+	}
+	if add := report.Tests[0]; add.Name != "TestAdd" || add.Status != Pass || add.DurationMS != 250 {
+		t.Errorf("TestAdd: got %+v", add)
+	}
+	if div := report.Tests[1]; div.Status != Fail || !strings.Contains(div.Output, "division by zero") {
+		t.Errorf("TestDiv: got %+v", div)
+	}
+	if broken := report.Tests[3]; broken.Name != "" || broken.Package != "example.com/broken" || broken.Output != "broken.go:3:1: syntax error\n" {
+		t.Errorf("package failure: got %+v", broken)
+	}
+	if !strings.Contains(report.Output, "=== RUN   TestAdd\n") || !strings.Contains(report.Output, "# example.com/broken\n") {
+		t.Errorf("output: got %q", report.Output)
+	}
+	if events[0].Action != Running || events[0].Test != "TestAdd" || events[1].Action != "output" {
+		t.Errorf("events: got %+v", events[:2])
+	}
+}
+
+func TestLibtest(t *testing.T) {
+	report, _ := collect(`   Compiling app v0.1.0 (/work)
+    Finished test [unoptimized + debuginfo] target(s) in 0.50s
+     Running unittests src/lib.rs (target/debug/deps/app-1f2e3d)
+
+running 3 tests
+test tests::adds ... ok
+test tests::divides ... FAILED
+test tests::slow ... ignored, takes a minute
+
+failures:
+
+---- tests::divides stdout ----
+thread 'tests::divides' panicked at src/lib.rs:12:9:
+attempt to divide by zero
+
+
+failures:
+    tests::divides
+
+test result: FAILED. 1 passed; 1 failed; 1 ignored; 0 measured; 0 filtered out; finished in 0.00s
+`)
+	if report.Passed != 1 || report.Failed != 1 || report.Skipped != 1 {
+		t.Fatalf("got %+v", report)
+	}
+	divides := report.Tests[1]
+	if divides.Name != "tests::divides" || divides.Package != "src/lib.rs" || !strings.HasPrefix(divides.Output, "thread 'tests::divides' panicked") {
+		t.Errorf("got %+v", divides)
+	}
+}
+
+func TestTAP(t *testing.T) {
+	report, _ := collect("TAP version 13\n1..4\nok 1 - adds\nnot ok 2 - divides\n  ---\n  ok 1 - nested\nok 3 # SKIP no network\nnot ok 4 parses # TODO\n")
+	if report.Passed != 1 || report.Failed != 1 || report.Skipped != 2 || report.Tests[2].Name != "3" || report.Tests[3].Name != "parses" {
+		t.Errorf("got %+v", report.Tests)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	for _, tc := range []struct{ language, configured, name, want string }{
+		{"go", "", "TestA/b c", `go test -json -run '^TestA$/^b c$' ./...`},
+		{"rust", "", "tests::adds", `cargo test 'tests::adds' -- --exact`},
+		{"c", "make test", "", "make test"},
+		{"c", "make test", "it's", `make test 'it'\''s'`},
+	} {
+		if got, err := Command(tc.language, tc.configured, tc.name); err != nil || got != tc.want {
+			t.Errorf("Command(%q, %q, %q) = %q, %v, want %q", tc.language, tc.configured, tc.name, got, err, tc.want)
+		}
+	}
+	if _, err := Command("c", "", ""); err != ErrNoCommand {
+		t.Errorf("got %v, want ErrNoCommand", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"xxx/projects"
+	"xxx/runnerservice"
+	"xxx/testrun"
+
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// maxTestCommand bounds a configured test command, in bytes
+const maxTestCommand = 4 << 10
+
+// TestCommand is a project's test command
+type TestCommand struct {
+	Command string `json:"command"`           // empty for the language's usual one
+	Default string `json:"default,omitempty"` // the language's usual command
+}
+
+// TestResponse is the outcome of a test run
+type TestResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Command string `json:"command,omitempty"`
+	testrun.Report
+}
+
+// testStreamEnd is the last line of a streamed test run
+type testStreamEnd struct {
+	Action string `json:"action"` // always done
+	TestResponse
+}
+
+// testsHandler shows and sets a project's test command, and runs the tests.
+// Like every project route it answers only the project's owner, and the
+// command runs in the project's container with nothing of the server's
+// environment, as builds do.
+func (s *Server) testsHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		command := TestCommand{Command: project.Test}
+		command.Default, _ = testrun.Command(project.Language, "", "")
+		s.jsonResponse(w, http.StatusOK, command)
+	case http.MethodPut:
+		var command TestCommand
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*maxTestCommand)).Decode(&command); err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: "Expected a JSON body",
+			})
+			return
+		}
+		if len(command.Command) > maxTestCommand || strings.ContainsAny(command.Command, "\x00\n\r") {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: "The test command must be one line of at most 4 KB",
+			})
+			return
+		}
+		updated, err := s.projects.SetTest(project.ID, command.Command)
+		metrics.ObserveFile("write", err)
+		if err != nil {
+			s.logger.ErrorContext(r.Context(), "error saving test command", "project", project.ID, "error", err)
+			s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+				Success: false,
+				Message: "Error saving test command",
+			})
+			return
+		}
+		command = TestCommand{Command: updated.Test}
+		command.Default, _ = testrun.Command(updated.Language, "", "")
+		s.jsonResponse(w, http.StatusOK, command)
+	case http.MethodPost:
+		s.runTests(w, r, project)
+	default:
+		s.jsonResponse(w, http.StatusMethodNotAllowed, FileResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+	}
+}
+
+// runTests runs the project's tests, or the one named by ?name=. With
+// ?stream=1 the answer is newline-delimited JSON: a testrun.Event per line as
+// the tests run, then the report with action done.
+func (s *Server) runTests(w http.ResponseWriter, r *http.Request, project *projects.Project) {
+	ctx := r.Context()
+	command, err := testrun.Command(project.Language, project.Test, r.URL.Query().Get("name"))
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: "Set a test command for " + project.Language + " projects",
+		})
+		return
+	}
+	dir, _ := s.projects.Dir(project.ID)
+
+	stream := r.URL.Query().Get("stream") == "1"
+	var onEvent func(testrun.Event)
+	if stream {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+		flusher := http.NewResponseController(w)
+		onEvent = func(e testrun.Event) {
+			if err := enc.Encode(e); err == nil {
+				flusher.Flush()
+			}
+		}
+	}
+	collector := testrun.NewCollector(onEvent)
+	stats, err := runnerservice.Stream(ctx, runnerservice.Job{Dir: dir, Language: project.Language, Command: command, Cache: s.toolchainCache(r), ReadOnly: projects.ReadOnly}, collector)
+	s.usage.Record(ctx, accounting.RunFor(r, jwtSecret(), project.ID, project.Language, metrics.ExitCode(err)), stats)
+
+	response := TestResponse{Success: err == nil, Command: command, Report: collector.Report()}
+	if err != nil {
+		s.logger.WarnContext(ctx, "tests failed", "project", project.ID, "error", err)
+		response.Message = err.Error()
+	}
+	if stream {
+		json.NewEncoder(w).Encode(testStreamEnd{Action: "done", TestResponse: response})
+		return
+	}
+	// failing tests are a result, not an error of the request
+	s.jsonResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,876 @@
+package wasm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Assemble compiles WAT source to a binary module. It takes the module
+// fields of the text format with their abbreviations (inline imports,
+// exports, type uses, data and elem segments) and both flat and folded
+// instructions. Functions are named after their $identifiers in the
+// module's name section. Errors are *SyntaxError, with the position.
+func Assemble(src string) ([]byte, error) {
+	m, err := assemble(src)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(m), nil
+}
+
+// space is one index space and the $identifiers bound in it
+type space struct {
+	what  string
+	names map[string]uint32
+	n     uint32
+}
+
+func newSpace(what string) *space {
+	return &space{what: what, names: map[string]uint32{}}
+}
+
+// add binds the next index to id, if given
+func (s *space) add(id string, at *sexp) (uint32, error) {
+	if id != "" {
+		if _, dup := s.names[id]; dup {
+			return 0, at.errorf("duplicate %s %s", s.what, id)
+		}
+		s.names[id] = s.n
+	}
+	s.n++
+	return s.n - 1, nil
+}
+
+// resolve reads an index given as a number or $identifier
+func (s *space) resolve(x *sexp) (uint32, error) {
+	if x == nil || !x.isIndex() {
+		if x == nil {
+			return 0, &SyntaxError{Line: 1, Col: 1, Msg: "missing " + s.what + " index"}
+		}
+		return 0, x.errorf("expected a %s index", s.what)
+	}
+	if x.isID() {
+		idx, ok := s.names[x.atom]
+		if !ok {
+			return 0, x.errorf("unknown %s %s", s.what, x.atom)
+		}
+		return idx, nil
+	}
+	v, err := parseUint(x.atom, 32)
+	if err != nil {
+		return 0, x.errorf("invalid %s index %s", s.what, x.atom)
+	}
+	return uint32(v), nil
+}
+
+type assembler struct {
+	m *Module
+
+	types, funcs, tables, memories, globals, elems, datas *space
+	// usesDataCount is set by memory.init and data.drop, which need the
+	// data count section
+	usesDataCount bool
+	// indexOf is the index bind gave each func, table, memory and global
+	// field, and each import description
+	indexOf map[*sexp]uint32
+}
+
+// importedKinds are the fields that can be imported inline
+var importedKinds = map[string]ExternKind{
+	"func":   KindFunc,
+	"table":  KindTable,
+	"memory": KindMemory,
+	"global": KindGlobal,
+}
+
+func assemble(src string) (*Module, error) {
+	top, err := parseSexps(src)
+	if err != nil {
+		return nil, err
+	}
+	fields := top
+	if len(top) == 1 && top[0].head() == "module" {
+		c := listCursor(top[0])
+		c.optionalID()
+		if s := c.peek(); s != nil && !s.isList {
+			return nil, s.errorf("only modules in the text format are supported")
+		}
+		fields = c.items[c.i:]
+	}
+	for _, field := range fields {
+		if !field.isList {
+			return nil, field.errorf("expected a module field")
+		}
+	}
+
+	a := &assembler{
+		m:        &Module{FuncNames: map[uint32]string{}},
+		types:    newSpace("type"),
+		funcs:    newSpace("function"),
+		tables:   newSpace("table"),
+		memories: newSpace("memory"),
+		globals:  newSpace("global"),
+		elems:    newSpace("elem segment"),
+		datas:    newSpace("data segment"),
+		indexOf:  map[*sexp]uint32{},
+	}
+
+	// explicit types come first, in order
+	for _, field := range fields {
+		if field.head() == "type" {
+			if err := a.typeField(field); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// then every index space numbers imports before definitions, so bind
+	// identifiers in two sweeps before reading any instructions
+	for _, imports := range []bool{true, false} {
+		for _, field := range fields {
+			if err := a.bind(field, imports); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, field := range fields {
+		if err := a.field(field); err != nil {
+			return nil, err
+		}
+	}
+	if a.usesDataCount {
+		n := uint32(len(a.m.Datas))
+		a.m.DataCount = &n
+	}
+	return a.m, nil
+}
+
+// spaceOf returns the index space of a field kind
+func (a *assembler) spaceOf(kind string) *space {
+	switch kind {
+	case "func":
+		return a.funcs
+	case "table":
+		return a.tables
+	case "memory":
+		return a.memories
+	case "global":
+		return a.globals
+	}
+	return nil
+}
+
+// bind assigns the indices of a field's imports, or of its definitions
+func (a *assembler) bind(field *sexp, imports bool) error {
+	kind := field.head()
+	c := listCursor(field)
+	switch kind {
+	case "import":
+		if !imports {
+			return nil
+		}
+		c.next()
+		c.next()
+		desc := c.next()
+		if desc == nil || a.spaceOf(desc.head()) == nil {
+			return field.errorf("expected an import description")
+		}
+		id := listCursor(desc).optionalID()
+		idx, err := a.spaceOf(desc.head()).add(id, desc)
+		if err != nil {
+			return err
+		}
+		a.indexOf[desc] = idx
+		if desc.head() == "func" && id != "" {
+			a.m.FuncNames[idx] = id[1:]
+		}
+		return nil
+	case "func", "table", "memory", "global":
+		id := c.optionalID()
+		for c.peekList("export") != nil {
+			c.next()
+		}
+		if (c.peekList("import") != nil) != imports {
+			return nil
+		}
+		idx, err := a.spaceOf(kind).add(id, field)
+		if err != nil {
+			return err
+		}
+		a.indexOf[field] = idx
+		if kind == "func" && id != "" {
+			a.m.FuncNames[idx] = id[1:]
+		}
+		if imports {
+			return nil
+		}
+		// inline segments take their index where they appear
+		if kind == "memory" && c.peekList("data") != nil {
+			_, err = a.datas.add("", field)
+		}
+		if kind == "table" && len(c.items) > c.i+1 && c.items[c.i+1].head() == "elem" {
+			_, err = a.elems.add("", field)
+		}
+		return err
+	case "elem":
+		if !imports {
+			_, err := a.elems.add(c.optionalID(), field)
+			return err
+		}
+	case "data":
+		if !imports {
+			_, err := a.datas.add(c.optionalID(), field)
+			return err
+		}
+	case "type", "export", "start":
+	default:
+		return field.errorf("unknown module field %q", kind)
+	}
+	return nil
+}
+
+func (a *assembler) field(field *sexp) error {
+	c := listCursor(field)
+	switch field.head() {
+	case "import":
+		return a.importField(field, c)
+	case "func":
+		return a.funcField(field, c)
+	case "table":
+		return a.tableField(field, c)
+	case "memory":
+		return a.memoryField(field, c)
+	case "global":
+		return a.globalField(field, c)
+	case "export":
+		name := c.next()
+		desc := c.next()
+		if name == nil || !name.isStr || desc == nil || a.spaceOf(desc.head()) == nil {
+			return field.errorf("expected (export \"name\" (func|table|memory|global index))")
+		}
+		idx, err := a.spaceOf(desc.head()).resolve(listCursor(desc).next())
+		if err != nil {
+			return err
+		}
+		a.m.Exports = append(a.m.Exports, Export{Name: name.atom, Kind: importedKinds[desc.head()], Index: idx})
+	case "start":
+		idx, err := a.funcs.resolve(c.next())
+		if err != nil {
+			return err
+		}
+		a.m.Start = &idx
+	case "elem":
+		return a.elemField(field, c)
+	case "data":
+		return a.dataField(field, c)
+	}
+	return nil
+}
+
+// exports reads inline (export "name") abbreviations of the field at index idx
+func (a *assembler) exports(c *cursor, kind ExternKind, idx uint32) error {
+	for {
+		e := c.peekList("export")
+		if e == nil {
+			return nil
+		}
+		c.next()
+		if len(e.list) != 2 || !e.list[1].isStr {
+			return e.errorf("expected (export \"name\")")
+		}
+		a.m.Exports = append(a.m.Exports, Export{Name: e.list[1].atom, Kind: kind, Index: idx})
+	}
+}
+
+// inlineImport reads an (import "module" "name") abbreviation
+func inlineImport(c *cursor) (*Import, error) {
+	imp := c.peekList("import")
+	if imp == nil {
+		return nil, nil
+	}
+	c.next()
+	if len(imp.list) != 3 || !imp.list[1].isStr || !imp.list[2].isStr {
+		return nil, imp.errorf("expected (import \"module\" \"name\")")
+	}
+	return &Import{Module: imp.list[1].atom, Name: imp.list[2].atom}, nil
+}
+
+func (a *assembler) typeField(field *sexp) error {
+	c := listCursor(field)
+	id := c.optionalID()
+	fn := c.next()
+	if fn == nil || fn.head() != "func" || !c.done() {
+		return field.errorf("expected (type (func ...))")
+	}
+	fc := listCursor(fn)
+	ft, _, err := a.signature(fc)
+	if err != nil {
+		return err
+	}
+	if !fc.done() {
+		return fc.errorf("unexpected %s in function type", describe(fc.peek()))
+	}
+	if _, err := a.types.add(id, field); err != nil {
+		return err
+	}
+	a.m.Types = append(a.m.Types, ft)
+	return nil
+}
+
+// signature reads (param ...) and (result ...) lists, returning the param
+// names, "" for unnamed ones
+func (a *assembler) signature(c *cursor) (FuncType, []string, error) {
+	var ft FuncType
+	var names []string
+	for p := c.peekList("param"); p != nil; p = c.peekList("param") {
+		c.next()
+		pc := listCursor(p)
+		if id := pc.optionalID(); id != "" {
+			t, err := valType(pc.next(), p)
+			if err != nil {
+				return ft, nil, err
+			}
+			if !pc.done() {
+				return ft, nil, pc.errorf("a named param has one type")
+			}
+			ft.Params = append(ft.Params, t)
+			names = append(names, id)
+			continue
+		}
+		for !pc.done() {
+			t, err := valType(pc.next(), p)
+			if err != nil {
+				return ft, nil, err
+			}
+			ft.Params = append(ft.Params, t)
+			names = append(names, "")
+		}
+	}
+	for r := c.peekList("result"); r != nil; r = c.peekList("result") {
+		c.next()
+		rc := listCursor(r)
+		for !rc.done() {
+			t, err := valType(rc.next(), r)
+			if err != nil {
+				return ft, nil, err
+			}
+			ft.Results = append(ft.Results, t)
+		}
+	}
+	return ft, names, nil
+}
+
+// typeUse reads an optional (type x) and inline signature, and returns the
+// type index, adding the signature to the types if it is not there yet
+func (a *assembler) typeUse(c *cursor) (uint32, FuncType, []string, error) {
+	var explicit *uint32
+	if t := c.peekList("type"); t != nil {
+		c.next()
+		if len(t.list) != 2 {
+			return 0, FuncType{}, nil, t.errorf("expected (type index)")
+		}
+		idx, err := a.types.resolve(t.list[1])
+		if err != nil {
+			return 0, FuncType{}, nil, err
+		}
+		if int(idx) >= len(a.m.Types) {
+			return 0, FuncType{}, nil, t.errorf("unknown type %d", idx)
+		}
+		explicit = &idx
+	}
+	start := c.peek()
+	ft, names, err := a.signature(c)
+	if err != nil {
+		return 0, FuncType{}, nil, err
+	}
+	if explicit != nil {
+		declared := a.m.Types[*explicit]
+		if len(ft.Params)+len(ft.Results) > 0 && !sameType(ft, declared) {
+			return 0, FuncType{}, nil, start.errorf("inline signature does not match type %d", *explicit)
+		}
+		if names == nil {
+			names = make([]string, len(declared.Params))
+		}
+		return *explicit, declared, names, nil
+	}
+	return a.findType(ft), ft, names, nil
+}
+
+// findType returns the index of ft, adding it if needed
+func (a *assembler) findType(ft FuncType) uint32 {
+	for i, t := range a.m.Types {
+		if sameType(t, ft) {
+			return uint32(i)
+		}
+	}
+	a.m.Types = append(a.m.Types, ft)
+	return uint32(len(a.m.Types) - 1)
+}
+
+func sameType(a, b FuncType) bool {
+	if len(a.Params) != len(b.Params) || len(a.Results) != len(b.Results) {
+		return false
+	}
+	for i := range a.Params {
+		if a.Params[i] != b.Params[i] {
+			return false
+		}
+	}
+	for i := range a.Results {
+		if a.Results[i] != b.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var valTypes = map[string]ValType{
+	"i32": I32, "i64": I64, "f32": F32, "f64": F64, "v128": V128,
+	"funcref": FuncRef, "externref": ExternRef,
+}
+
+func valType(s *sexp, parent *sexp) (ValType, error) {
+	if s == nil {
+		return 0, parent.errorf("expected a value type")
+	}
+	if t, ok := valTypes[s.atom]; ok && !s.isList && !s.isStr {
+		return t, nil
+	}
+	return 0, s.errorf("unknown value type %s", describe(s))
+}
+
+func refType(s *sexp, parent *sexp) (ValType, error) {
+	t, err := valType(s, parent)
+	if err == nil && t != FuncRef && t != ExternRef {
+		err = s.errorf("expected funcref or externref")
+	}
+	return t, err
+}
+
+// describe names an item for error messages
+func describe(s *sexp) string {
+	switch {
+	case s == nil:
+		return "end of list"
+	case s.isList:
+		if h := s.head(); h != "" {
+			return "(" + h + " ...)"
+		}
+		return "list"
+	case s.isStr:
+		return strconv.Quote(s.atom)
+	}
+	return s.atom
+}
+
+func limitsOf(c *cursor, parent *sexp) (Limits, error) {
+	var l Limits
+	if s := c.peek(); s != nil && s.atom == "i64" && !s.isStr {
+		c.next()
+		l.Is64 = true
+	}
+	s := c.next()
+	if s == nil || s.isList || s.isStr {
+		return l, parent.errorf("expected limits")
+	}
+	min, err := parseUint(s.atom, 64)
+	if err != nil {
+		return l, s.errorf("invalid limit %s", s.atom)
+	}
+	l.Min = min
+	if s := c.peek(); s != nil && s.isIndex() && !s.isID() {
+		c.next()
+		max, err := parseUint(s.atom, 64)
+		if err != nil {
+			return l, s.errorf("invalid limit %s", s.atom)
+		}
+		l.Max = &max
+	}
+	if s := c.peek(); s != nil && s.atom == "shared" && !s.isStr {
+		c.next()
+		l.Shared = true
+	}
+	return l, nil
+}
+
+func globalTypeOf(c *cursor, parent *sexp) (GlobalType, error) {
+	s := c.next()
+	if s != nil && s.head() == "mut" {
+		if len(s.list) != 2 {
+			return GlobalType{}, s.errorf("expected (mut type)")
+		}
+		t, err := valType(s.list[1], s)
+		return GlobalType{Type: t, Mutable: true}, err
+	}
+	t, err := valType(s, parent)
+	return GlobalType{Type: t}, err
+}
+
+func (a *assembler) importField(field *sexp, c *cursor) error {
+	module, name, desc := c.next(), c.next(), c.next()
+	if module == nil || !module.isStr || name == nil || !name.isStr || desc == nil || !c.done() {
+		return field.errorf("expected (import \"module\" \"name\" (kind ...))")
+	}
+	imp := &Import{Module: module.atom, Name: name.atom}
+	dc := listCursor(desc)
+	dc.optionalID()
+	return a.importDesc(imp, desc, dc)
+}
+
+// importDesc reads what is imported and adds the import
+func (a *assembler) importDesc(imp *Import, desc *sexp, c *cursor) error {
+	var err error
+	switch imp.Kind = importedKinds[desc.head()]; desc.head() {
+	case "func":
+		imp.Func, _, _, err = a.typeUse(c)
+	case "table":
+		if imp.Table.Limits, err = limitsOf(c, desc); err == nil {
+			imp.Table.Type, err = refType(c.next(), desc)
+		}
+	case "memory":
+		imp.Memory, err = limitsOf(c, desc)
+	case "global":
+		imp.Global, err = globalTypeOf(c, desc)
+	}
+	if err != nil {
+		return err
+	}
+	if !c.done() {
+		return c.errorf("unexpected %s in import", describe(c.peek()))
+	}
+	a.m.Imports = append(a.m.Imports, *imp)
+	return nil
+}
+
+func (a *assembler) funcField(field *sexp, c *cursor) error {
+	c.optionalID()
+	idx := a.indexOf[field]
+	if err := a.exports(c, KindFunc, idx); err != nil {
+		return err
+	}
+	imp, err := inlineImport(c)
+	if err != nil {
+		return err
+	}
+	if imp != nil {
+		imp.Kind = KindFunc
+		return a.importDesc(imp, field, c)
+	}
+
+	typeIdx, ft, names, err := a.typeUse(c)
+	if err != nil {
+		return err
+	}
+	b := newBody(a)
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		if _, dup := b.locals[name]; dup {
+			return field.errorf("duplicate local %s", name)
+		}
+		b.locals[name] = uint32(i)
+	}
+	n := uint32(len(ft.Params))
+	var locals []Local
+	for l := c.peekList("local"); l != nil; l = c.peekList("local") {
+		c.next()
+		lc := listCursor(l)
+		id := lc.optionalID()
+		if id != "" {
+			if _, dup := b.locals[id]; dup {
+				return l.errorf("duplicate local %s", id)
+			}
+			b.locals[id] = n
+		}
+		for !lc.done() {
+			t, err := valType(lc.next(), l)
+			if err != nil {
+				return err
+			}
+			if len(locals) > 0 && locals[len(locals)-1].Type == t {
+				locals[len(locals)-1].Count++
+			} else {
+				locals = append(locals, Local{Count: 1, Type: t})
+			}
+			n++
+		}
+		if id != "" && n != b.locals[id]+1 {
+			return l.errorf("a named local has one type")
+		}
+	}
+	if err := b.instrs(c); err != nil {
+		return err
+	}
+	if len(b.labels) > 0 {
+		return field.errorf("block without end")
+	}
+	b.enc.opcode(opEnd)
+	a.m.Funcs = append(a.m.Funcs, typeIdx)
+	a.m.Codes = append(a.m.Codes, Code{Locals: locals, Body: b.enc.Bytes()})
+	return nil
+}
+
+func (a *assembler) tableField(field *sexp, c *cursor) error {
+	c.optionalID()
+	idx := a.indexOf[field]
+	if err := a.exports(c, KindTable, idx); err != nil {
+		return err
+	}
+	imp, err := inlineImport(c)
+	if err != nil {
+		return err
+	}
+	if imp != nil {
+		imp.Kind = KindTable
+		return a.importDesc(imp, field, c)
+	}
+
+	// (table reftype (elem ...)) declares a table just big enough
+	if len(c.items) == c.i+2 && c.items[c.i+1].head() == "elem" {
+		t, err := refType(c.next(), field)
+		if err != nil {
+			return err
+		}
+		elem := c.next()
+		seg := Element{Mode: ModeActive, Table: idx, Type: t, Offset: []byte{byte(opI32Const), 0, byte(opEnd)}}
+		if err := a.elemList(listCursor(elem), &seg, elem, t); err != nil {
+			return err
+		}
+		n := uint64(len(seg.Funcs) + len(seg.Exprs))
+		a.m.Tables = append(a.m.Tables, Table{Type: t, Limits: Limits{Min: n, Max: &n}})
+		a.m.Elements = append(a.m.Elements, seg)
+		return nil
+	}
+	l, err := limitsOf(c, field)
+	if err != nil {
+		return err
+	}
+	t, err := refType(c.next(), field)
+	if err != nil {
+		return err
+	}
+	if !c.done() {
+		return c.errorf("unexpected %s in table", describe(c.peek()))
+	}
+	a.m.Tables = append(a.m.Tables, Table{Type: t, Limits: l})
+	return nil
+}
+
+func (a *assembler) memoryField(field *sexp, c *cursor) error {
+	c.optionalID()
+	idx := a.indexOf[field]
+	if err := a.exports(c, KindMemory, idx); err != nil {
+		return err
+	}
+	imp, err := inlineImport(c)
+	if err != nil {
+		return err
+	}
+	if imp != nil {
+		imp.Kind = KindMemory
+		return a.importDesc(imp, field, c)
+	}
+
+	// (memory (data ...)) declares a memory just big enough
+	if data := c.peekList("data"); data != nil {
+		c.next()
+		init, err := dataString(listCursor(data), data)
+		if err != nil {
+			return err
+		}
+		pages := (uint64(len(init)) + PageSize - 1) / PageSize
+		a.m.Memories = append(a.m.Memories, Limits{Min: pages, Max: &pages})
+		a.m.Datas = append(a.m.Datas, Data{
+			Mode: ModeActive, Memory: idx, Init: init,
+			Offset: []byte{byte(opI32Const), 0, byte(opEnd)},
+		})
+		return nil
+	}
+	l, err := limitsOf(c, field)
+	if err != nil {
+		return err
+	}
+	if !c.done() {
+		return c.errorf("unexpected %s in memory", describe(c.peek()))
+	}
+	a.m.Memories = append(a.m.Memories, l)
+	return nil
+}
+
+func (a *assembler) globalField(field *sexp, c *cursor) error {
+	c.optionalID()
+	idx := a.indexOf[field]
+	if err := a.exports(c, KindGlobal, idx); err != nil {
+		return err
+	}
+	imp, err := inlineImport(c)
+	if err != nil {
+		return err
+	}
+	if imp != nil {
+		imp.Kind = KindGlobal
+		return a.importDesc(imp, field, c)
+	}
+	gt, err := globalTypeOf(c, field)
+	if err != nil {
+		return err
+	}
+	init, err := a.constExpr(c)
+	if err != nil {
+		return err
+	}
+	a.m.Globals = append(a.m.Globals, Global{GlobalType: gt, Init: init})
+	return nil
+}
+
+// constExpr reads the rest of c as a constant expression
+func (a *assembler) constExpr(c *cursor) ([]byte, error) {
+	b := newBody(a)
+	if err := b.instrs(c); err != nil {
+		return nil, err
+	}
+	if len(b.labels) > 0 {
+		return nil, c.errorf("block in constant expression")
+	}
+	b.enc.opcode(opEnd)
+	return b.enc.Bytes(), nil
+}
+
+// offsetExpr reads an (offset ...) list or a single folded instruction
+func (a *assembler) offsetExpr(c *cursor) ([]byte, bool, error) {
+	s := c.peek()
+	if s == nil || !s.isList {
+		return nil, false, nil
+	}
+	if s.head() == "offset" {
+		c.next()
+		expr, err := a.constExpr(listCursor(s))
+		return expr, true, err
+	}
+	if _, ok := opByName[s.head()]; ok {
+		c.next()
+		expr, err := a.constExpr(&cursor{items: []*sexp{s}, parent: s})
+		return expr, true, err
+	}
+	return nil, false, nil
+}
+
+func (a *assembler) elemField(field *sexp, c *cursor) error {
+	c.optionalID()
+	seg := Element{Mode: ModePassive, Type: FuncRef}
+	if s := c.peek(); s != nil && s.atom == "declare" && !s.isStr {
+		c.next()
+		seg.Mode = ModeDeclarative
+	} else {
+		if t := c.peekList("table"); t != nil {
+			c.next()
+			idx, err := a.tables.resolve(listCursor(t).next())
+			if err != nil {
+				return err
+			}
+			seg.Table = idx
+			seg.Mode = ModeActive
+		}
+		offset, ok, err := a.offsetExpr(c)
+		if err != nil {
+			return err
+		}
+		if ok {
+			seg.Mode, seg.Offset = ModeActive, offset
+		} else if seg.Mode == ModeActive {
+			return c.errorf("expected an offset")
+		}
+	}
+
+	t := FuncRef
+	switch s := c.peek(); {
+	case s != nil && s.atom == "func" && !s.isStr:
+		c.next()
+	case s != nil && (s.atom == "funcref" || s.atom == "externref") && !s.isStr:
+		c.next()
+		t = valTypes[s.atom]
+		seg.Exprs = [][]byte{}
+	case seg.Mode != ModeActive && (s == nil || s.isIndex()):
+		return c.errorf("expected func or a reference type")
+	}
+	seg.Type = t
+	if err := a.elemList(c, &seg, field, t); err != nil {
+		return err
+	}
+	a.m.Elements = append(a.m.Elements, seg)
+	return nil
+}
+
+// elemList reads function indices, or element expressions when seg.Exprs
+// is set or the items are lists
+func (a *assembler) elemList(c *cursor, seg *Element, parent *sexp, t ValType) error {
+	if s := c.peek(); s != nil && s.isList {
+		seg.Exprs = [][]byte{}
+	}
+	for !c.done() {
+		s := c.next()
+		if seg.Exprs == nil {
+			idx, err := a.funcs.resolve(s)
+			if err != nil {
+				return err
+			}
+			seg.Funcs = append(seg.Funcs, idx)
+			continue
+		}
+		if !s.isList {
+			return s.errorf("expected an element expression")
+		}
+		items := &cursor{items: []*sexp{s}, parent: s}
+		if s.head() == "item" {
+			items = listCursor(s)
+		}
+		expr, err := a.constExpr(items)
+		if err != nil {
+			return err
+		}
+		seg.Exprs = append(seg.Exprs, expr)
+	}
+	seg.Type = t
+	return nil
+}
+
+func (a *assembler) dataField(field *sexp, c *cursor) error {
+	c.optionalID()
+	seg := Data{Mode: ModePassive}
+	if m := c.peekList("memory"); m != nil {
+		c.next()
+		idx, err := a.memories.resolve(listCursor(m).next())
+		if err != nil {
+			return err
+		}
+		seg.Memory = idx
+		seg.Mode = ModeActive
+	}
+	offset, ok, err := a.offsetExpr(c)
+	if err != nil {
+		return err
+	}
+	if ok {
+		seg.Mode, seg.Offset = ModeActive, offset
+	} else if seg.Mode == ModeActive {
+		return c.errorf("expected an offset")
+	}
+	if seg.Init, err = dataString(c, field); err != nil {
+		return err
+	}
+	a.m.Datas = append(a.m.Datas, seg)
+	return nil
+}
+
+// dataString concatenates the string literals that make up a data segment
+func dataString(c *cursor, parent *sexp) ([]byte, error) {
+	var b strings.Builder
+	for !c.done() {
+		s := c.next()
+		if !s.isStr {
+			return nil, s.errorf("expected a string, found %s", describe(s))
+		}
+		b.WriteString(s.atom)
+	}
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,576 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// body assembles the instructions of a function or constant expression
+type body struct {
+	a      *assembler
+	enc    *encoder
+	locals map[string]uint32
+	labels []string // of the enclosing blocks, innermost last
+}
+
+func newBody(a *assembler) *body {
+	return &body{a: a, enc: &encoder{}, locals: map[string]uint32{}}
+}
+
+// instrs assembles the rest of c, flat and folded instructions alike
+func (b *body) instrs(c *cursor) error {
+	for !c.done() {
+		s := c.next()
+		switch {
+		case s.isList:
+			if err := b.folded(s); err != nil {
+				return err
+			}
+		case s.isStr:
+			return s.errorf("unexpected string")
+		default:
+			if err := b.instr(s, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// folded assembles (op immediates operands...), where the operands come
+// first, and the folded forms of block, loop and if
+func (b *body) folded(s *sexp) error {
+	name := s.head()
+	c := listCursor(s)
+	switch name {
+	case "":
+		return s.errorf("expected an instruction")
+	case "block", "loop":
+		if err := b.blockStart(opByName[name], c); err != nil {
+			return err
+		}
+		if err := b.instrs(c); err != nil {
+			return err
+		}
+		return b.blockEnd(s)
+	case "if":
+		label := c.optionalID()
+		bt, err := b.a.blockType(c)
+		if err != nil {
+			return err
+		}
+		// the condition, then (then ...) and an optional (else ...)
+		for !c.done() && c.peekList("then") == nil {
+			cond := c.next()
+			if !cond.isList {
+				return cond.errorf("expected a folded condition or (then ...)")
+			}
+			if err := b.folded(cond); err != nil {
+				return err
+			}
+		}
+		then := c.next()
+		if then == nil {
+			return s.errorf("if without (then ...)")
+		}
+		b.enc.opcode(opIf)
+		b.enc.Write(bt)
+		b.labels = append(b.labels, label)
+		if err := b.instrs(listCursor(then)); err != nil {
+			return err
+		}
+		if els := c.peekList("else"); els != nil {
+			c.next()
+			b.enc.opcode(opElse)
+			if err := b.instrs(listCursor(els)); err != nil {
+				return err
+			}
+		}
+		if !c.done() {
+			return c.errorf("unexpected %s after if", describe(c.peek()))
+		}
+		return b.blockEnd(s)
+	}
+
+	// assemble the instruction aside, then its operands, then the instruction
+	outer := b.enc
+	b.enc = &encoder{}
+	err := b.instr(s.list[0], c)
+	op := b.enc.Bytes()
+	b.enc = outer
+	if err != nil {
+		return err
+	}
+	for !c.done() {
+		operand := c.next()
+		if !operand.isList {
+			return operand.errorf("unexpected %s in folded %s", describe(operand), name)
+		}
+		if err := b.folded(operand); err != nil {
+			return err
+		}
+	}
+	b.enc.Write(op)
+	return nil
+}
+
+func (b *body) blockStart(op opcode, c *cursor) error {
+	label := c.optionalID()
+	bt, err := b.a.blockType(c)
+	if err != nil {
+		return err
+	}
+	b.enc.opcode(op)
+	b.enc.Write(bt)
+	b.labels = append(b.labels, label)
+	return nil
+}
+
+func (b *body) blockEnd(at *sexp) error {
+	if len(b.labels) == 0 {
+		return at.errorf("end without a block")
+	}
+	b.labels = b.labels[:len(b.labels)-1]
+	b.enc.opcode(opEnd)
+	return nil
+}
+
+// closingLabel checks the optional label after else or end
+func (b *body) closingLabel(c *cursor, at *sexp) error {
+	id := c.optionalID()
+	if id != "" && (len(b.labels) == 0 || b.labels[len(b.labels)-1] != id) {
+		return at.errorf("label %s does not match its block", id)
+	}
+	return nil
+}
+
+// instr assembles one instruction, reading its immediates from c
+func (b *body) instr(kw *sexp, c *cursor) error {
+	switch kw.atom {
+	case "block", "loop", "if":
+		return b.blockStart(opByName[kw.atom], c)
+	case "else":
+		if err := b.closingLabel(c, kw); err != nil {
+			return err
+		}
+		if len(b.labels) == 0 {
+			return kw.errorf("else without if")
+		}
+		b.enc.opcode(opElse)
+		return nil
+	case "end":
+		if err := b.closingLabel(c, kw); err != nil {
+			return err
+		}
+		return b.blockEnd(kw)
+	case "select":
+		if c.peekList("result") == nil {
+			b.enc.WriteByte(0x1b)
+			return nil
+		}
+		ft, _, err := b.a.signature(c)
+		if err != nil {
+			return err
+		}
+		b.enc.opcode(opSelectTyped)
+		b.enc.u32(uint32(len(ft.Results)))
+		for _, t := range ft.Results {
+			b.enc.WriteByte(byte(t))
+		}
+		return nil
+	}
+
+	op, ok := opByName[kw.atom]
+	if !ok {
+		return kw.errorf("unknown instruction %s", describe(kw))
+	}
+	info := ops[op]
+	b.enc.opcode(op)
+
+	switch op {
+	case opI32Const, opI64Const:
+		bits := 32
+		if op == opI64Const {
+			bits = 64
+		}
+		arg := c.next()
+		if arg == nil || arg.isList || arg.isStr {
+			return kw.errorf("%s needs a value", kw.atom)
+		}
+		v, err := parseInt(arg.atom, bits)
+		if err != nil {
+			return arg.errorf("invalid %s value %s", kw.atom, arg.atom)
+		}
+		b.enc.sleb(v)
+		return nil
+	case opF32Const, opF64Const:
+		bits := 32
+		if op == opF64Const {
+			bits = 64
+		}
+		arg := c.next()
+		if arg == nil || arg.isList || arg.isStr {
+			return kw.errorf("%s needs a value", kw.atom)
+		}
+		v, err := parseFloat(arg.atom, bits)
+		if err != nil {
+			return arg.errorf("invalid %s value %s", kw.atom, arg.atom)
+		}
+		if bits == 32 {
+			b.enc.Write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+		} else {
+			b.enc.Write(binary.LittleEndian.AppendUint64(nil, v))
+		}
+		return nil
+	case opMemoryInit, prefixFC<<8 | 9: // memory.init, data.drop
+		b.a.usesDataCount = true
+	}
+
+	switch info.imm {
+	case immBlockType:
+		// handled above
+	case immLabel:
+		depth, err := b.label(c.next(), kw)
+		if err != nil {
+			return err
+		}
+		b.enc.u32(depth)
+	case immLabels:
+		var depths []uint32
+		for s := c.peek(); s != nil && s.isIndex(); s = c.peek() {
+			depth, err := b.label(c.next(), kw)
+			if err != nil {
+				return err
+			}
+			depths = append(depths, depth)
+		}
+		if len(depths) == 0 {
+			return kw.errorf("br_table needs at least a default label")
+		}
+		b.enc.u32(uint32(len(depths) - 1))
+		for _, d := range depths {
+			b.enc.u32(d)
+		}
+	case immIndex:
+		idx, err := b.index(op, kw, c)
+		if err != nil {
+			return err
+		}
+		b.enc.u32(idx)
+	case immIndex2:
+		return b.index2(op, kw, c)
+	case immMemory, immMemory2:
+		n := 1 + info.imm - immMemory
+		idxs, err := b.optionalIndices(b.a.memories, c, n)
+		if err != nil {
+			return err
+		}
+		for _, idx := range idxs {
+			b.enc.u32(idx)
+		}
+	case immMemarg:
+		return b.memarg(info.align, c)
+	case immRefType:
+		s := c.next()
+		switch {
+		case s != nil && s.atom == "func":
+			b.enc.WriteByte(byte(FuncRef))
+		case s != nil && s.atom == "extern":
+			b.enc.WriteByte(byte(ExternRef))
+		default:
+			return kw.errorf("ref.null needs func or extern")
+		}
+	case immCallIndirect:
+		table := uint32(0)
+		if s := c.peek(); s != nil && s.isIndex() {
+			idx, err := b.a.tables.resolve(c.next())
+			if err != nil {
+				return err
+			}
+			table = idx
+		}
+		typeIdx, _, _, err := b.a.typeUse(c)
+		if err != nil {
+			return err
+		}
+		b.enc.u32(typeIdx)
+		b.enc.u32(table)
+	}
+	return nil
+}
+
+// label resolves a branch target to its depth
+func (b *body) label(s *sexp, kw *sexp) (uint32, error) {
+	if s == nil || !s.isIndex() {
+		return 0, kw.errorf("%s needs a label", kw.atom)
+	}
+	if s.isID() {
+		for i := len(b.labels) - 1; i >= 0; i-- {
+			if b.labels[i] == s.atom {
+				return uint32(len(b.labels) - 1 - i), nil
+			}
+		}
+		return 0, s.errorf("unknown label %s", s.atom)
+	}
+	v, err := parseUint(s.atom, 32)
+	if err != nil {
+		return 0, s.errorf("invalid label %s", s.atom)
+	}
+	return uint32(v), nil
+}
+
+// index reads the single index immediate of op
+func (b *body) index(op opcode, kw *sexp, c *cursor) (uint32, error) {
+	var sp *space
+	optional := false
+	switch {
+	case strings.HasPrefix(kw.atom, "local."):
+		s := c.next()
+		if s != nil && s.isID() {
+			idx, ok := b.locals[s.atom]
+			if !ok {
+				return 0, s.errorf("unknown local %s", s.atom)
+			}
+			return idx, nil
+		}
+		return (&space{what: "local"}).resolve(orAt(s, kw))
+	case strings.HasPrefix(kw.atom, "global."):
+		sp = b.a.globals
+	case strings.HasPrefix(kw.atom, "table."):
+		sp, optional = b.a.tables, true
+	case op == opCall || op == opReturnCall || op == opRefFunc:
+		sp = b.a.funcs
+	case kw.atom == "data.drop":
+		sp = b.a.datas
+	case kw.atom == "elem.drop":
+		sp = b.a.elems
+	}
+	if optional {
+		if s := c.peek(); s == nil || !s.isIndex() {
+			return 0, nil
+		}
+	}
+	return sp.resolve(orAt(c.next(), kw))
+}
+
+// orAt substitutes the instruction for a missing immediate, so the error
+// points at it
+func orAt(s, kw *sexp) *sexp {
+	if s == nil {
+		return &sexp{atom: "", line: kw.line, col: kw.col}
+	}
+	return s
+}
+
+// index2 reads the immediates of memory.init, table.init and table.copy.
+// The text gives the memory or table first and lets it default to 0; the
+// binary gives the segment first.
+func (b *body) index2(op opcode, kw *sexp, c *cursor) error {
+	var first, second *space
+	switch op {
+	case opMemoryInit:
+		first, second = b.a.memories, b.a.datas
+	case opTableInit:
+		first, second = b.a.tables, b.a.elems
+	default: // table.copy
+		idxs, err := b.optionalIndices(b.a.tables, c, 2)
+		if err != nil {
+			return err
+		}
+		b.enc.u32(idxs[0])
+		b.enc.u32(idxs[1])
+		return nil
+	}
+	var args []*sexp
+	for s := c.peek(); s != nil && s.isIndex() && len(args) < 2; s = c.peek() {
+		args = append(args, c.next())
+	}
+	if len(args) == 0 {
+		return kw.errorf("%s needs a segment index", kw.atom)
+	}
+	target := uint32(0)
+	if len(args) == 2 {
+		idx, err := first.resolve(args[0])
+		if err != nil {
+			return err
+		}
+		target = idx
+	}
+	seg, err := second.resolve(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+	b.enc.u32(seg)
+	b.enc.u32(target)
+	return nil
+}
+
+// optionalIndices reads n indices that all default to 0
+func (b *body) optionalIndices(sp *space, c *cursor, n int) ([]uint32, error) {
+	idxs := make([]uint32, n)
+	for i := 0; i < n; i++ {
+		s := c.peek()
+		if s == nil || !s.isIndex() {
+			if i > 0 {
+				return nil, c.errorf("expected %d %s indices", n, sp.what)
+			}
+			break
+		}
+		idx, err := sp.resolve(c.next())
+		if err != nil {
+			return nil, err
+		}
+		idxs[i] = idx
+	}
+	return idxs, nil
+}
+
+// memarg reads a load or store's optional memory, offset= and align=
+func (b *body) memarg(natural uint32, c *cursor) error {
+	var mem uint32
+	if s := c.peek(); s != nil && s.isIndex() {
+		idx, err := b.a.memories.resolve(c.next())
+		if err != nil {
+			return err
+		}
+		mem = idx
+	}
+	var offset uint64
+	align := natural
+	for s := c.peek(); s != nil && !s.isList && !s.isStr; s = c.peek() {
+		if v, ok := strings.CutPrefix(s.atom, "offset="); ok {
+			n, err := parseUint(v, 64)
+			if err != nil {
+				return s.errorf("invalid offset %s", v)
+			}
+			offset = n
+		} else if v, ok := strings.CutPrefix(s.atom, "align="); ok {
+			n, err := parseUint(v, 32)
+			if err != nil || n == 0 || n&(n-1) != 0 {
+				return s.errorf("alignment must be a power of two")
+			}
+			align = 0
+			for n > 1 {
+				n >>= 1
+				align++
+			}
+		} else {
+			break
+		}
+		c.next()
+	}
+	if mem != 0 {
+		b.enc.u32(align | 0x40)
+		b.enc.u32(mem)
+	} else {
+		b.enc.u32(align)
+	}
+	b.enc.uleb(offset)
+	return nil
+}
+
+// blockType reads a block's optional type use and encodes it: empty, a
+// single result type, or the index of a function type
+func (a *assembler) blockType(c *cursor) ([]byte, error) {
+	if c.peekList("type") == nil && c.peekList("param") == nil {
+		ft, _, err := a.signature(c)
+		if err != nil {
+			return nil, err
+		}
+		switch len(ft.Results) {
+		case 0:
+			return []byte{0x40}, nil
+		case 1:
+			return []byte{byte(ft.Results[0])}, nil
+		}
+		var e encoder
+		e.sleb(int64(a.findType(ft)))
+		return e.Bytes(), nil
+	}
+	idx, _, _, err := a.typeUse(c)
+	if err != nil {
+		return nil, err
+	}
+	var e encoder
+	e.sleb(int64(idx))
+	return e.Bytes(), nil
+}
+
+// parseUint reads an unsigned integer literal of at most bits bits, in
+// decimal or 0x hexadecimal, with optional _ separators
+func parseUint(s string, bits int) (uint64, error) {
+	s = strings.ReplaceAll(s, "_", "")
+	base := 10
+	if rest, ok := strings.CutPrefix(s, "0x"); ok {
+		s, base = rest, 16
+	}
+	return strconv.ParseUint(s, base, bits)
+}
+
+// parseInt reads an integer literal for an iN.const: signed, or unsigned up
+// to 2^bits-1, which wraps
+func parseInt(s string, bits int) (int64, error) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	v, err := parseUint(s, bits)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		if v > 1<<(bits-1) {
+			return 0, strconv.ErrRange
+		}
+		v = -v
+	}
+	if bits == 32 {
+		return int64(int32(uint32(v))), nil
+	}
+	return int64(v), nil
+}
+
+// parseFloat reads a float literal: decimal or hexadecimal, inf, nan or
+// nan:0xpayload. It returns the value's bits.
+func parseFloat(s string, bits int) (uint64, error) {
+	neg := strings.HasPrefix(s, "-")
+	body := strings.TrimLeft(s, "+-")
+	mantissaBits := uint(23)
+	if bits == 64 {
+		mantissaBits = 52
+	}
+	sign := uint64(0)
+	if neg {
+		sign = 1 << (bits - 1)
+	}
+	exponent := (uint64(1)<<(bits-1) - 1) &^ (1<<mantissaBits - 1) // all ones
+
+	switch {
+	case body == "inf":
+		return sign | exponent, nil
+	case body == "nan":
+		return sign | exponent | 1<<(mantissaBits-1), nil
+	case strings.HasPrefix(body, "nan:"):
+		payload, err := parseUint(body[4:], 64)
+		if err != nil || payload == 0 || payload >= 1<<mantissaBits {
+			return 0, strconv.ErrSyntax
+		}
+		return sign | exponent | payload, nil
+	}
+
+	body = strings.ReplaceAll(body, "_", "")
+	if strings.HasPrefix(body, "0x") && !strings.ContainsAny(body, "pP") {
+		body += "p0"
+	}
+	v, err := strconv.ParseFloat(body, bits)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		v = -v
+	}
+	if bits == 32 {
+		return uint64(math.Float32bits(float32(v))), nil
+	}
+	return math.Float64bits(v), nil
+}
@@ -0,0 +1,203 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testWAT = `
+(module
+  (import "wasi_snapshot_preview1" "proc_exit" (func (param i32)))
+  (func $add (export "add") (param $a i32) (param $b i32) (result i32)
+    local.get $a
+    if (result i32)
+      (i32.add (local.get $a) (local.get 1))
+    else
+      i32.const -1
+    end)
+  (memory (export "memory") 1 2)
+  (global (mut i32) (i32.const 0x10_0000))
+  (data (i32.const 8) "hi\n"))
+`
+
+func TestAssemble(t *testing.T) {
+	bin, err := Assemble(testWAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testModule(); !bytes.Equal(bin, want) {
+		t.Errorf("got\n% x\nwant\n% x", bin, want)
+	}
+}
+
+// TestRoundTrip assembles the WAT template, disassembles the result and
+// checks that it assembles to the same module
+func TestRoundTrip(t *testing.T) {
+	sources := map[string]string{"control": `
+(module
+  (type $binop (func (param i32 i32) (result i32)))
+  (table 2 funcref)
+  (elem (i32.const 0) $fact $add)
+  (func $add (type $binop) (i32.add (local.get 0) (local.get 1)))
+  (func $fact (export "fact") (param $n i64) (result i64)
+    (local $acc i64)
+    (local.set $acc (i64.const 1))
+    (block $done
+      (loop $next
+        (br_if $done (i64.le_s (local.get $n) (i64.const 1)))
+        (local.set $acc (i64.mul (local.get $acc) (local.get $n)))
+        (local.set $n (i64.sub (local.get $n) (i64.const 1)))
+        (br $next)))
+    (local.get $acc))
+  (func (export "pick") (param i32) (result i32)
+    (block (block (block
+      (br_table 0 1 2 (local.get 0)))
+      (return (i32.const 10)))
+      (return (i32.const 20)))
+    (call_indirect (type $binop) (i32.const 1) (i32.const 2) (i32.const 1)))
+  (func (export "floats") (result f64)
+    f32.const -0x1.8p1
+    f64.promote_f32
+    f64.const nan:0x4
+    f64.max
+    f64.const inf
+    f64.min)
+  (func (export "memory") (param i32)
+    (memory.fill (local.get 0) (i32.const 0) (i32.const 16))
+    (i64.store offset=8 align=4 (local.get 0) (i64.load8_u offset=1 (local.get 0)))
+    (drop (memory.grow (i32.const 1)))
+    (memory.init $bytes (i32.const 0) (i32.const 0) (i32.const 2))
+    (data.drop $bytes))
+  (memory 1)
+  (data $bytes "\00\01\u{2603}"))
+`}
+	template, err := os.ReadFile("../templates/builtin/wat-hello/hello.wat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources["template"] = string(template)
+
+	for name, src := range sources {
+		bin, err := Assemble(src)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		m, err := Decode(bin)
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", name, err)
+		}
+		var wat bytes.Buffer
+		if err := Disassemble(&wat, m); err != nil {
+			t.Fatal(err)
+		}
+		again, err := Assemble(wat.String())
+		if err != nil {
+			t.Fatalf("%s: reassembling: %v\n%s", name, err, wat.String())
+		}
+		if !bytes.Equal(bin, again) {
+			t.Errorf("%s: round trip changed the module:\n%s", name, wat.String())
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"(module (func i32.bogus))":                     "1:15: unknown instruction i32.bogus",
+		"(module\n  (func (br $nowhere)))":              "2:13: unknown label $nowhere",
+		"(module (func (call $missing)))":               "1:21: unknown function $missing",
+		"(module (func (local.get $x)))":                "1:26: unknown local $x",
+		"(module (func (i32.const 4294967296)))":        "1:26: invalid i32.const value 4294967296",
+		"(module (func block))":                         "1:9: block without end",
+		"(module (memory 1)":                            "1:1: unclosed (",
+		"(module (data \"abc))":                         "1:15: unterminated string",
+		"(module (func $f) (func $f))":                  "1:19: duplicate function $f",
+		"(module (func (param $p i32) (local $p i32)))": "1:30: duplicate local $p",
+		"()":                 "1:1: empty list",
+		"(module (func ()))": "1:15: empty list",
+	} {
+		_, err := Assemble(src)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("%q: got %v, want a syntax error", src, err)
+			continue
+		}
+		if err.Error() != want {
+			t.Errorf("%q: got %q, want %q", src, err, want)
+		}
+	}
+}
+
+// FuzzAssemble checks that no source makes Assemble panic, and that what it
+// accepts decodes
+func FuzzAssemble(f *testing.F) {
+	for _, src := range []string{
+		"(module)",
+		"(module (func (export \"main\") (result i32) (i32.const 42)))",
+		"(module (memory 1) (data (i32.const 0) \"hi\"))",
+		"(module (func (param $p i32) (block (br_if 0 (local.get $p)))))",
+		"(module (table 1 funcref) (elem (i32.const 0) $f) (func $f))",
+		"()",
+		"(module ())",
+	} {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		bin, err := Assemble(src)
+		if err != nil {
+			return
+		}
+		if _, err := Decode(bin); err != nil {
+			t.Errorf("%q assembled to a module that doesn't decode: %v", src, err)
+		}
+	})
+}
+
+func TestLiterals(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		bits int
+		want int64
+	}{
+		{"0xffffffff", 32, -1},
+		{"-2147483648", 32, math.MinInt32},
+		{"1_000", 32, 1000},
+		{"18446744073709551615", 64, -1},
+	} {
+		if got, err := parseInt(tc.s, tc.bits); err != nil || got != tc.want {
+			t.Errorf("parseInt(%q) = %d, %v; want %d", tc.s, got, err, tc.want)
+		}
+	}
+	if _, err := parseInt("-2147483649", 32); err == nil {
+		t.Error("expected -2147483649 to be out of range for i32")
+	}
+
+	for _, tc := range []struct {
+		s    string
+		bits int
+		want uint64
+	}{
+		{"-0x1.8p1", 32, uint64(math.Float32bits(-3))},
+		{"0x10", 64, math.Float64bits(16)},
+		{"1e3", 64, math.Float64bits(1000)},
+		{"-inf", 32, uint64(math.Float32bits(float32(math.Inf(-1))))},
+		{"nan", 32, 0x7fc00000},
+		{"-nan:0x1", 64, 0xfff0000000000001},
+	} {
+		if got, err := parseFloat(tc.s, tc.bits); err != nil || got != tc.want {
+			t.Errorf("parseFloat(%q, %d) = %#x, %v; want %#x", tc.s, tc.bits, got, err, tc.want)
+		}
+	}
+}
+
+func TestDisassembleQuoting(t *testing.T) {
+	if got := quote([]byte("a\"\\\n\x00")); got != `"a\"\\\0a\00"` {
+		t.Errorf("quote = %s", got)
+	}
+	if !strings.HasPrefix(quote(nil), `"`) {
+		t.Error("quote(nil) is not a string literal")
+	}
+}
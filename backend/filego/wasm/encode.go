@@ -0,0 +1,249 @@
+package wasm
+
+import (
+	"bytes"
+	"sort"
+)
+
+// encoder writes the primitive encodings of the binary format
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) uleb(v uint64) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b |= 0x80
+		}
+		e.WriteByte(b)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func (e *encoder) sleb(v int64) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			e.WriteByte(b)
+			return
+		}
+		e.WriteByte(b | 0x80)
+	}
+}
+
+func (e *encoder) u32(v uint32) { e.uleb(uint64(v)) }
+
+// vec writes a length-prefixed byte string, as names and data are
+func (e *encoder) vec(b []byte) {
+	e.u32(uint32(len(b)))
+	e.Write(b)
+}
+
+// section writes a section with its ID and size
+func (e *encoder) section(id byte, contents []byte) {
+	e.WriteByte(id)
+	e.vec(contents)
+}
+
+// opcode writes op, with its prefix and LEB128 sub-opcode if it has one
+func (e *encoder) opcode(op opcode) {
+	if op > 0xff {
+		e.WriteByte(byte(op >> 8))
+		e.u32(uint32(op & 0xff))
+		return
+	}
+	e.WriteByte(byte(op))
+}
+
+// Encode writes m in the binary format. Custom sections are not kept, except
+// for function names, which are written to a name section.
+func Encode(m *Module) []byte {
+	var out encoder
+	out.Write(magic)
+	out.Write([]byte{1, 0, 0, 0})
+
+	vector := func(id byte, n int, item func(e *encoder, i int)) {
+		if n == 0 {
+			return
+		}
+		var e encoder
+		e.u32(uint32(n))
+		for i := 0; i < n; i++ {
+			item(&e, i)
+		}
+		out.section(id, e.Bytes())
+	}
+
+	vector(SectionType, len(m.Types), func(e *encoder, i int) {
+		e.WriteByte(0x60)
+		for _, list := range [][]ValType{m.Types[i].Params, m.Types[i].Results} {
+			e.u32(uint32(len(list)))
+			for _, t := range list {
+				e.WriteByte(byte(t))
+			}
+		}
+	})
+	vector(SectionImport, len(m.Imports), func(e *encoder, i int) {
+		imp := m.Imports[i]
+		e.vec([]byte(imp.Module))
+		e.vec([]byte(imp.Name))
+		e.WriteByte(byte(imp.Kind))
+		switch imp.Kind {
+		case KindFunc:
+			e.u32(imp.Func)
+		case KindTable:
+			e.table(imp.Table)
+		case KindMemory:
+			e.limits(imp.Memory)
+		case KindGlobal:
+			e.globalType(imp.Global)
+		}
+	})
+	vector(SectionFunction, len(m.Funcs), func(e *encoder, i int) { e.u32(m.Funcs[i]) })
+	vector(SectionTable, len(m.Tables), func(e *encoder, i int) { e.table(m.Tables[i]) })
+	vector(SectionMemory, len(m.Memories), func(e *encoder, i int) { e.limits(m.Memories[i]) })
+	vector(SectionGlobal, len(m.Globals), func(e *encoder, i int) {
+		e.globalType(m.Globals[i].GlobalType)
+		e.Write(m.Globals[i].Init)
+	})
+	vector(SectionExport, len(m.Exports), func(e *encoder, i int) {
+		e.vec([]byte(m.Exports[i].Name))
+		e.WriteByte(byte(m.Exports[i].Kind))
+		e.u32(m.Exports[i].Index)
+	})
+	if m.Start != nil {
+		var e encoder
+		e.u32(*m.Start)
+		out.section(SectionStart, e.Bytes())
+	}
+	vector(SectionElement, len(m.Elements), func(e *encoder, i int) { e.element(m.Elements[i]) })
+	if m.DataCount != nil {
+		var e encoder
+		e.u32(*m.DataCount)
+		out.section(SectionDataCount, e.Bytes())
+	}
+	vector(SectionCode, len(m.Codes), func(e *encoder, i int) {
+		var body encoder
+		body.u32(uint32(len(m.Codes[i].Locals)))
+		for _, l := range m.Codes[i].Locals {
+			body.u32(l.Count)
+			body.WriteByte(byte(l.Type))
+		}
+		body.Write(m.Codes[i].Body)
+		e.vec(body.Bytes())
+	})
+	vector(SectionData, len(m.Datas), func(e *encoder, i int) {
+		d := m.Datas[i]
+		switch {
+		case d.Mode == ModePassive:
+			e.u32(1)
+		case d.Memory != 0:
+			e.u32(2)
+			e.u32(d.Memory)
+		default:
+			e.u32(0)
+		}
+		e.Write(d.Offset)
+		e.vec(d.Init)
+	})
+
+	if len(m.FuncNames) > 0 {
+		indices := make([]uint32, 0, len(m.FuncNames))
+		for idx := range m.FuncNames {
+			indices = append(indices, idx)
+		}
+		sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+		var names encoder
+		names.u32(uint32(len(indices)))
+		for _, idx := range indices {
+			names.u32(idx)
+			names.vec([]byte(m.FuncNames[idx]))
+		}
+		var e encoder
+		e.vec([]byte("name"))
+		e.section(1, names.Bytes()) // function names subsection
+		out.section(SectionCustom, e.Bytes())
+	}
+	return out.Bytes()
+}
+
+func (e *encoder) limits(l Limits) {
+	var flags byte
+	if l.Max != nil {
+		flags |= 1
+	}
+	if l.Shared {
+		flags |= 2
+	}
+	if l.Is64 {
+		flags |= 4
+	}
+	e.WriteByte(flags)
+	e.uleb(l.Min)
+	if l.Max != nil {
+		e.uleb(*l.Max)
+	}
+}
+
+func (e *encoder) table(t Table) {
+	e.WriteByte(byte(t.Type))
+	e.limits(t.Limits)
+}
+
+func (e *encoder) globalType(g GlobalType) {
+	e.WriteByte(byte(g.Type))
+	if g.Mutable {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+// element picks the most compact of the eight segment encodings
+func (e *encoder) element(el Element) {
+	exprs := el.Exprs != nil
+	var flags uint32
+	switch el.Mode {
+	case ModePassive:
+		flags = 1
+	case ModeDeclarative:
+		flags = 3
+	default:
+		if el.Table != 0 || (exprs && el.Type != FuncRef) {
+			flags = 2
+		}
+	}
+	if exprs {
+		flags |= 4
+	}
+	e.u32(flags)
+	if flags&2 != 0 && flags&1 == 0 {
+		e.u32(el.Table)
+	}
+	if flags&1 == 0 {
+		e.Write(el.Offset)
+	}
+	if flags&3 != 0 {
+		if exprs {
+			e.WriteByte(byte(el.Type))
+		} else {
+			e.WriteByte(0x00) // elemkind funcref
+		}
+	}
+	if exprs {
+		e.u32(uint32(len(el.Exprs)))
+		for _, expr := range el.Exprs {
+			e.Write(expr)
+		}
+		return
+	}
+	e.u32(uint32(len(el.Funcs)))
+	for _, idx := range el.Funcs {
+		e.u32(idx)
+	}
+}
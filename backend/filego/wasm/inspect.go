@@ -0,0 +1,428 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PageSize is the size of a memory page
+const PageSize = 64 * 1024
+
+// Report summarises a module for display
+type Report struct {
+	Size      int              `json:"size"`
+	Sections  []SectionReport  `json:"sections"`
+	Types     []string         `json:"types"`
+	Imports   []ImportReport   `json:"imports"`
+	Exports   []ExportReport   `json:"exports"`
+	Memories  []MemoryReport   `json:"memories"`
+	Tables    []TableReport    `json:"tables"`
+	Globals   []GlobalReport   `json:"globals"`
+	Custom    []CustomReport   `json:"custom_sections"`
+	Functions FunctionsReport  `json:"functions"`
+	Start     *FunctionRef     `json:"start,omitempty"`
+	Data      []SegmentReport  `json:"data"`
+	Elements  []SegmentReport  `json:"elements"`
+	Features  []string         `json:"features,omitempty"`
+	Notes     []string         `json:"notes,omitempty"`
+	Breakdown []BreakdownEntry `json:"size_breakdown"`
+}
+
+// SectionReport is where a section is and how much of the module it takes
+type SectionReport struct {
+	ID      byte    `json:"id"`
+	Name    string  `json:"name"`
+	Offset  int     `json:"offset"`
+	Size    int     `json:"size"`
+	Percent float64 `json:"percent"`
+}
+
+// ImportReport is one import with its signature or type
+type ImportReport struct {
+	Module string `json:"module"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Type   string `json:"type"`
+}
+
+// ExportReport is one export with its signature or type
+type ExportReport struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"`
+	Index    uint32 `json:"index"`
+	Type     string `json:"type,omitempty"`
+	Function string `json:"function,omitempty"` // name section name of an exported function
+}
+
+// MemoryReport is a memory's limits, in pages and bytes
+type MemoryReport struct {
+	Index     int     `json:"index"`
+	Imported  bool    `json:"imported"`
+	MinPages  uint64  `json:"min_pages"`
+	MaxPages  *uint64 `json:"max_pages,omitempty"`
+	MinBytes  uint64  `json:"min_bytes"`
+	MaxBytes  *uint64 `json:"max_bytes,omitempty"`
+	Shared    bool    `json:"shared,omitempty"`
+	Memory64  bool    `json:"memory64,omitempty"`
+	ExportsAs string  `json:"exported_as,omitempty"`
+}
+
+// TableReport is a table's element type and limits
+type TableReport struct {
+	Index    int     `json:"index"`
+	Imported bool    `json:"imported"`
+	Type     string  `json:"type"`
+	Min      uint64  `json:"min"`
+	Max      *uint64 `json:"max,omitempty"`
+}
+
+// GlobalReport is one global
+type GlobalReport struct {
+	Index    int    `json:"index"`
+	Imported bool   `json:"imported"`
+	Type     string `json:"type"`
+	Mutable  bool   `json:"mutable"`
+	Init     string `json:"init,omitempty"`
+}
+
+// CustomReport is one custom section, such as name, producers or DWARF
+type CustomReport struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// FunctionsReport counts functions and lists the largest bodies
+type FunctionsReport struct {
+	Imported int            `json:"imported"`
+	Defined  int            `json:"defined"`
+	CodeSize int            `json:"code_size"`
+	Largest  []FunctionSize `json:"largest"`
+}
+
+// FunctionSize is the size of one function body
+type FunctionSize struct {
+	FunctionRef
+	Size int `json:"size"`
+}
+
+// FunctionRef identifies a function by index and, if known, name
+type FunctionRef struct {
+	Index     uint32 `json:"index"`
+	Name      string `json:"name,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// SegmentReport is a data or element segment
+type SegmentReport struct {
+	Index  int    `json:"index"`
+	Mode   string `json:"mode"`
+	Offset string `json:"offset,omitempty"`
+	Size   int    `json:"size"` // bytes for data, entries for elements
+}
+
+// BreakdownEntry is how many bytes one part of the module takes
+type BreakdownEntry struct {
+	Name    string  `json:"name"`
+	Size    int     `json:"size"`
+	Percent float64 `json:"percent"`
+}
+
+// largestFunctions is how many function sizes the report lists
+const largestFunctions = 20
+
+// Inspect builds the report of m
+func Inspect(m *Module) *Report {
+	p := &printer{m: m, names: funcIDs(m)}
+	report := &Report{
+		Size:      m.Size,
+		Sections:  []SectionReport{},
+		Types:     []string{},
+		Imports:   []ImportReport{},
+		Exports:   []ExportReport{},
+		Memories:  []MemoryReport{},
+		Tables:    []TableReport{},
+		Globals:   []GlobalReport{},
+		Custom:    []CustomReport{},
+		Data:      []SegmentReport{},
+		Elements:  []SegmentReport{},
+		Breakdown: []BreakdownEntry{},
+	}
+	percent := func(n int) float64 {
+		if m.Size == 0 {
+			return 0
+		}
+		return float64(int(float64(n)*10000/float64(m.Size))) / 100
+	}
+
+	for _, t := range m.Types {
+		report.Types = append(report.Types, "func"+signature(t))
+	}
+
+	exported := map[ExternKind]map[uint32]string{}
+	for _, e := range m.Exports {
+		if exported[e.Kind] == nil {
+			exported[e.Kind] = map[uint32]string{}
+		}
+		exported[e.Kind][e.Index] = e.Name
+	}
+
+	// the index spaces, imports first
+	var (
+		allTables   []Table
+		allMemories []Limits
+		allGlobals  []GlobalType
+	)
+	for _, imp := range m.Imports {
+		ir := ImportReport{Module: imp.Module, Name: imp.Name, Kind: imp.Kind.String()}
+		switch imp.Kind {
+		case KindFunc:
+			ir.Type = typeString(m, imp.Func)
+		case KindTable:
+			ir.Type = tableType(imp.Table)
+			report.Tables = append(report.Tables, tableReport(len(allTables), true, imp.Table))
+			allTables = append(allTables, imp.Table)
+		case KindMemory:
+			ir.Type = limits(imp.Memory)
+			report.Memories = append(report.Memories, memoryReport(len(allMemories), true, imp.Memory, exported))
+			allMemories = append(allMemories, imp.Memory)
+		case KindGlobal:
+			ir.Type = globalType(imp.Global)
+			report.Globals = append(report.Globals, GlobalReport{
+				Index: len(allGlobals), Imported: true, Type: imp.Global.Type.String(), Mutable: imp.Global.Mutable,
+			})
+			allGlobals = append(allGlobals, imp.Global)
+		}
+		report.Imports = append(report.Imports, ir)
+	}
+	for _, t := range m.Tables {
+		report.Tables = append(report.Tables, tableReport(len(allTables), false, t))
+		allTables = append(allTables, t)
+	}
+	for _, l := range m.Memories {
+		report.Memories = append(report.Memories, memoryReport(len(allMemories), false, l, exported))
+		allMemories = append(allMemories, l)
+	}
+	for _, g := range m.Globals {
+		report.Globals = append(report.Globals, GlobalReport{
+			Index: len(allGlobals), Type: g.Type.String(), Mutable: g.Mutable, Init: p.expr(g.Init, ""),
+		})
+		allGlobals = append(allGlobals, g.GlobalType)
+	}
+
+	for _, e := range m.Exports {
+		er := ExportReport{Name: e.Name, Kind: e.Kind.String(), Index: e.Index}
+		switch e.Kind {
+		case KindFunc:
+			if t, ok := m.FuncType(e.Index); ok {
+				er.Type = "func" + signature(t)
+			}
+			er.Function = m.FuncNames[e.Index]
+		case KindTable:
+			if int(e.Index) < len(allTables) {
+				er.Type = tableType(allTables[e.Index])
+			}
+		case KindMemory:
+			if int(e.Index) < len(allMemories) {
+				er.Type = limits(allMemories[e.Index])
+			}
+		case KindGlobal:
+			if int(e.Index) < len(allGlobals) {
+				er.Type = globalType(allGlobals[e.Index])
+			}
+		}
+		report.Exports = append(report.Exports, er)
+	}
+
+	imported := m.ImportedFuncs()
+	report.Functions = FunctionsReport{Imported: imported, Defined: len(m.Funcs), Largest: []FunctionSize{}}
+	var sizes []FunctionSize
+	for i, code := range m.Codes {
+		report.Functions.CodeSize += code.Size
+		sizes = append(sizes, FunctionSize{FunctionRef: funcRef(m, uint32(imported+i)), Size: code.Size})
+	}
+	sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].Size > sizes[j].Size })
+	if len(sizes) > largestFunctions {
+		sizes = sizes[:largestFunctions]
+	}
+	report.Functions.Largest = append(report.Functions.Largest, sizes...)
+	if m.Start != nil {
+		start := funcRef(m, *m.Start)
+		report.Start = &start
+	}
+
+	for i, d := range m.Datas {
+		report.Data = append(report.Data, SegmentReport{Index: i, Mode: d.Mode, Offset: offsetText(p, d.Offset), Size: len(d.Init)})
+	}
+	for i, e := range m.Elements {
+		report.Elements = append(report.Elements, SegmentReport{Index: i, Mode: e.Mode, Offset: offsetText(p, e.Offset), Size: len(e.Funcs) + len(e.Exprs)})
+	}
+
+	headers := 8 // magic and version
+	for _, s := range m.Sections {
+		report.Sections = append(report.Sections, SectionReport{ID: s.ID, Name: s.Name, Offset: s.Offset, Size: s.Size, Percent: percent(s.Size)})
+		if s.ID == SectionCustom {
+			report.Custom = append(report.Custom, CustomReport{Name: s.Name, Size: s.Size})
+		}
+	}
+	report.Breakdown = breakdown(m, headers, percent)
+	report.Features, report.Notes = features(m)
+	return report
+}
+
+// breakdown groups the module's bytes into code, data, debug information,
+// names and everything else
+func breakdown(m *Module, headers int, percent func(int) float64) []BreakdownEntry {
+	sizes := map[string]int{}
+	accounted := headers
+	for _, s := range m.Sections {
+		var group string
+		switch {
+		case s.ID == SectionCode:
+			group = "code"
+		case s.ID == SectionData:
+			group = "data"
+		case s.ID == SectionCustom && strings.HasPrefix(s.Name, ".debug_"):
+			group = "debug info"
+		case s.ID == SectionCustom && s.Name == "name":
+			group = "names"
+		case s.ID == SectionCustom:
+			group = "other custom sections"
+		default:
+			group = "declarations"
+		}
+		sizes[group] += s.Size
+		accounted += s.Size
+	}
+	// section IDs and sizes
+	sizes["section headers"] = headers + m.Size - accounted
+
+	var entries []BreakdownEntry
+	for name, size := range sizes {
+		entries = append(entries, BreakdownEntry{Name: name, Size: size, Percent: percent(size)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Size != entries[j].Size {
+			return entries[i].Size > entries[j].Size
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// features lists the WASI version and proposals the module appears to use
+func features(m *Module) (found []string, notes []string) {
+	seen := map[string]bool{}
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			found = append(found, f)
+		}
+	}
+	for _, imp := range m.Imports {
+		switch imp.Module {
+		case "wasi_snapshot_preview1":
+			add("wasi-preview1")
+		case "wasi_unstable":
+			add("wasi-unstable")
+		}
+		if imp.Kind == KindMemory && imp.Memory.Shared {
+			add("threads")
+		}
+	}
+	for _, l := range m.Memories {
+		if l.Shared {
+			add("threads")
+		}
+		if l.Is64 {
+			add("memory64")
+		}
+	}
+	if len(m.Memories)+m.importedOf(KindMemory) > 1 {
+		add("multi-memory")
+	}
+	for _, t := range m.Types {
+		if len(t.Results) > 1 {
+			add("multi-value")
+		}
+	}
+
+	for i, code := range m.Codes {
+		r := &reader{buf: code.Body, base: code.Offset}
+		for !r.done() {
+			in, err := decodeInstr(r)
+			if err != nil {
+				var unsupported *UnsupportedOpcodeError
+				if errors.As(err, &unsupported) && unsupported.Opcode == "0xfd" {
+					add("simd")
+				}
+				notes = append(notes, funcRef(m, uint32(m.ImportedFuncs()+i)).label()+": "+err.Error())
+				break
+			}
+			switch {
+			case in.Op == opReturnCall || in.Op == opReturnCallIndirect:
+				add("tail-call")
+			case in.Op >= prefixFC<<8 && in.Op < prefixFC<<8|8:
+				add("saturating-float-to-int")
+			case in.Op >= prefixFC<<8|8:
+				add("bulk-memory")
+			case in.Op >= 0xc0 && in.Op <= 0xc4:
+				add("sign-extension")
+			}
+		}
+	}
+	sort.Strings(found)
+	return found, notes
+}
+
+func funcRef(m *Module, idx uint32) FunctionRef {
+	ref := FunctionRef{Index: idx, Name: m.FuncNames[idx]}
+	if t, ok := m.FuncType(idx); ok {
+		ref.Signature = "func" + signature(t)
+	}
+	return ref
+}
+
+func (f FunctionRef) label() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return fmt.Sprintf("function %d", f.Index)
+}
+
+func typeString(m *Module, idx uint32) string {
+	if int(idx) < len(m.Types) {
+		return "func" + signature(m.Types[idx])
+	}
+	return ""
+}
+
+func tableReport(index int, imported bool, t Table) TableReport {
+	return TableReport{Index: index, Imported: imported, Type: t.Type.String(), Min: t.Min, Max: t.Max}
+}
+
+func memoryReport(index int, imported bool, l Limits, exported map[ExternKind]map[uint32]string) MemoryReport {
+	mem := MemoryReport{
+		Index:     index,
+		Imported:  imported,
+		MinPages:  l.Min,
+		MaxPages:  l.Max,
+		MinBytes:  l.Min * PageSize,
+		Shared:    l.Shared,
+		Memory64:  l.Is64,
+		ExportsAs: exported[KindMemory][uint32(index)],
+	}
+	if l.Max != nil {
+		max := *l.Max * PageSize
+		mem.MaxBytes = &max
+	}
+	return mem
+}
+
+func offsetText(p *printer, expr []byte) string {
+	if expr == nil {
+		return ""
+	}
+	return p.expr(expr, "")
+}
@@ -0,0 +1,385 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// opcode is an instruction's opcode; prefixed opcodes are prefix<<8|subop
+type opcode uint16
+
+const (
+	opBlock              opcode = 0x02
+	opLoop               opcode = 0x03
+	opIf                 opcode = 0x04
+	opElse               opcode = 0x05
+	opEnd                opcode = 0x0b
+	opBr                 opcode = 0x0c
+	opBrIf               opcode = 0x0d
+	opBrTable            opcode = 0x0e
+	opCall               opcode = 0x10
+	opCallIndirect       opcode = 0x11
+	opReturnCall         opcode = 0x12
+	opReturnCallIndirect opcode = 0x13
+	opSelectTyped        opcode = 0x1c
+	opI32Const           opcode = 0x41
+	opI64Const           opcode = 0x42
+	opF32Const           opcode = 0x43
+	opF64Const           opcode = 0x44
+	opRefNull            opcode = 0xd0
+	opRefFunc            opcode = 0xd2
+	prefixFC             opcode = 0xfc
+	opMemoryInit         opcode = prefixFC<<8 | 8
+	opTableInit          opcode = prefixFC<<8 | 12
+)
+
+// UnsupportedOpcodeError is returned for an instruction from a proposal the
+// decoder does not know, such as SIMD
+type UnsupportedOpcodeError struct {
+	Offset int
+	Opcode string
+}
+
+func (e *UnsupportedOpcodeError) Error() string {
+	return fmt.Sprintf("at offset 0x%x: unsupported opcode %s", e.Offset, e.Opcode)
+}
+
+// Instr is one decoded instruction
+type Instr struct {
+	Op   opcode
+	Name string
+	Imm  string // immediates in the text format
+	Func uint32 // callee of call, return_call and ref.func
+}
+
+// callsFunc reports whether Func is set
+func (i Instr) callsFunc() bool {
+	return i.Op == opCall || i.Op == opReturnCall || i.Op == opRefFunc
+}
+
+// Immediate layouts
+const (
+	immNone = iota
+	immBlockType
+	immLabel
+	immLabels
+	immIndex  // one index
+	immIndex2 // two indices
+	immMemarg
+	immMemory // memory index, printed only when not 0
+	immMemory2
+	immSelect
+	immRefType
+	immCallIndirect
+)
+
+type opInfo struct {
+	name string
+	imm  int
+	// align is the natural alignment of loads and stores, as a power of two
+	align uint32
+}
+
+var ops = map[opcode]opInfo{
+	0x00:                 {"unreachable", immNone, 0},
+	0x01:                 {"nop", immNone, 0},
+	opBlock:              {"block", immBlockType, 0},
+	opLoop:               {"loop", immBlockType, 0},
+	opIf:                 {"if", immBlockType, 0},
+	opElse:               {"else", immNone, 0},
+	opEnd:                {"end", immNone, 0},
+	opBr:                 {"br", immLabel, 0},
+	opBrIf:               {"br_if", immLabel, 0},
+	opBrTable:            {"br_table", immLabels, 0},
+	0x0f:                 {"return", immNone, 0},
+	opCall:               {"call", immIndex, 0},
+	opCallIndirect:       {"call_indirect", immCallIndirect, 0},
+	opReturnCall:         {"return_call", immIndex, 0},
+	opReturnCallIndirect: {"return_call_indirect", immCallIndirect, 0},
+	0x1a:                 {"drop", immNone, 0},
+	0x1b:                 {"select", immNone, 0},
+	opSelectTyped:        {"select", immSelect, 0},
+	0x20:                 {"local.get", immIndex, 0},
+	0x21:                 {"local.set", immIndex, 0},
+	0x22:                 {"local.tee", immIndex, 0},
+	0x23:                 {"global.get", immIndex, 0},
+	0x24:                 {"global.set", immIndex, 0},
+	0x25:                 {"table.get", immIndex, 0},
+	0x26:                 {"table.set", immIndex, 0},
+	0x3f:                 {"memory.size", immMemory, 0},
+	0x40:                 {"memory.grow", immMemory, 0},
+	opI32Const:           {"i32.const", immNone, 0},
+	opI64Const:           {"i64.const", immNone, 0},
+	opF32Const:           {"f32.const", immNone, 0},
+	opF64Const:           {"f64.const", immNone, 0},
+	opRefNull:            {"ref.null", immRefType, 0},
+	0xd1:                 {"ref.is_null", immNone, 0},
+	opRefFunc:            {"ref.func", immIndex, 0},
+
+	opMemoryInit:     {"memory.init", immIndex2, 0},
+	prefixFC<<8 | 9:  {"data.drop", immIndex, 0},
+	prefixFC<<8 | 10: {"memory.copy", immMemory2, 0},
+	prefixFC<<8 | 11: {"memory.fill", immMemory, 0},
+	opTableInit:      {"table.init", immIndex2, 0},
+	prefixFC<<8 | 13: {"elem.drop", immIndex, 0},
+	prefixFC<<8 | 14: {"table.copy", immIndex2, 0},
+	prefixFC<<8 | 15: {"table.grow", immIndex, 0},
+	prefixFC<<8 | 16: {"table.size", immIndex, 0},
+	prefixFC<<8 | 17: {"table.fill", immIndex, 0},
+}
+
+// loads and stores, 0x28 to 0x3e, with their natural alignment
+var memoryOps = []struct {
+	name  string
+	align uint32
+}{
+	{"i32.load", 2}, {"i64.load", 3}, {"f32.load", 2}, {"f64.load", 3},
+	{"i32.load8_s", 0}, {"i32.load8_u", 0}, {"i32.load16_s", 1}, {"i32.load16_u", 1},
+	{"i64.load8_s", 0}, {"i64.load8_u", 0}, {"i64.load16_s", 1}, {"i64.load16_u", 1},
+	{"i64.load32_s", 2}, {"i64.load32_u", 2},
+	{"i32.store", 2}, {"i64.store", 3}, {"f32.store", 2}, {"f64.store", 3},
+	{"i32.store8", 0}, {"i32.store16", 1}, {"i64.store8", 0}, {"i64.store16", 1}, {"i64.store32", 2},
+}
+
+// numericOps are the instructions without immediates from 0x45 to 0xc4
+const numericOps = `
+i32.eqz i32.eq i32.ne i32.lt_s i32.lt_u i32.gt_s i32.gt_u i32.le_s i32.le_u i32.ge_s i32.ge_u
+i64.eqz i64.eq i64.ne i64.lt_s i64.lt_u i64.gt_s i64.gt_u i64.le_s i64.le_u i64.ge_s i64.ge_u
+f32.eq f32.ne f32.lt f32.gt f32.le f32.ge
+f64.eq f64.ne f64.lt f64.gt f64.le f64.ge
+i32.clz i32.ctz i32.popcnt i32.add i32.sub i32.mul i32.div_s i32.div_u i32.rem_s i32.rem_u
+i32.and i32.or i32.xor i32.shl i32.shr_s i32.shr_u i32.rotl i32.rotr
+i64.clz i64.ctz i64.popcnt i64.add i64.sub i64.mul i64.div_s i64.div_u i64.rem_s i64.rem_u
+i64.and i64.or i64.xor i64.shl i64.shr_s i64.shr_u i64.rotl i64.rotr
+f32.abs f32.neg f32.ceil f32.floor f32.trunc f32.nearest f32.sqrt
+f32.add f32.sub f32.mul f32.div f32.min f32.max f32.copysign
+f64.abs f64.neg f64.ceil f64.floor f64.trunc f64.nearest f64.sqrt
+f64.add f64.sub f64.mul f64.div f64.min f64.max f64.copysign
+i32.wrap_i64 i32.trunc_f32_s i32.trunc_f32_u i32.trunc_f64_s i32.trunc_f64_u
+i64.extend_i32_s i64.extend_i32_u i64.trunc_f32_s i64.trunc_f32_u i64.trunc_f64_s i64.trunc_f64_u
+f32.convert_i32_s f32.convert_i32_u f32.convert_i64_s f32.convert_i64_u f32.demote_f64
+f64.convert_i32_s f64.convert_i32_u f64.convert_i64_s f64.convert_i64_u f64.promote_f32
+i32.reinterpret_f32 i64.reinterpret_f64 f32.reinterpret_i32 f64.reinterpret_i64
+i32.extend8_s i32.extend16_s i64.extend8_s i64.extend16_s i64.extend32_s`
+
+// satOps are the saturating truncations, 0xfc 0 to 7
+const satOps = `
+i32.trunc_sat_f32_s i32.trunc_sat_f32_u i32.trunc_sat_f64_s i32.trunc_sat_f64_u
+i64.trunc_sat_f32_s i64.trunc_sat_f32_u i64.trunc_sat_f64_s i64.trunc_sat_f64_u`
+
+// opByName maps instruction names to opcodes, for the assembler
+var opByName = map[string]opcode{}
+
+func init() {
+	for i, op := range memoryOps {
+		ops[opcode(0x28+i)] = opInfo{op.name, immMemarg, op.align}
+	}
+	names := strings.Fields(numericOps)
+	if len(names) != 0xc4-0x45+1 {
+		panic("wasm: numeric opcode table is incomplete")
+	}
+	for i, name := range names {
+		ops[opcode(0x45+i)] = opInfo{name, immNone, 0}
+	}
+	for i, name := range strings.Fields(satOps) {
+		ops[prefixFC<<8|opcode(i)] = opInfo{name, immNone, 0}
+	}
+	for op, info := range ops {
+		if op != opSelectTyped { // select picks its encoding by its immediates
+			opByName[info.name] = op
+		}
+	}
+}
+
+// decodeInstr reads one instruction
+func decodeInstr(r *reader) (Instr, error) {
+	start := r.pos
+	b, err := r.byte()
+	if err != nil {
+		return Instr{}, err
+	}
+	op := opcode(b)
+	if op == prefixFC {
+		sub, err := r.u32()
+		if err != nil {
+			return Instr{}, err
+		}
+		if sub > 0xff {
+			return Instr{}, &UnsupportedOpcodeError{r.base + start, fmt.Sprintf("0xfc %d", sub)}
+		}
+		op = prefixFC<<8 | opcode(sub)
+	}
+	info, ok := ops[op]
+	if !ok {
+		name := fmt.Sprintf("0x%02x", b)
+		if op > 0xff {
+			name = fmt.Sprintf("0xfc %d", op&0xff)
+		}
+		return Instr{}, &UnsupportedOpcodeError{r.base + start, name}
+	}
+
+	in := Instr{Op: op, Name: info.name}
+	switch op {
+	case opI32Const:
+		v, err := r.sleb(32)
+		in.Imm = strconv.FormatInt(int64(int32(v)), 10)
+		return in, err
+	case opI64Const:
+		v, err := r.sleb(64)
+		in.Imm = strconv.FormatInt(v, 10)
+		return in, err
+	case opF32Const:
+		v, err := r.f32()
+		in.Imm = formatFloat(float64(v), math.IsNaN(float64(v)), uint64(math.Float32bits(v)&0x7fffff), 32)
+		return in, err
+	case opF64Const:
+		v, err := r.f64()
+		in.Imm = formatFloat(v, math.IsNaN(v), math.Float64bits(v)&(1<<52-1), 64)
+		return in, err
+	}
+
+	var imm []string
+	index := func() (uint32, error) {
+		v, err := r.u32()
+		imm = append(imm, strconv.FormatUint(uint64(v), 10))
+		return v, err
+	}
+	switch info.imm {
+	case immBlockType:
+		imm, err = blockType(r)
+	case immLabel, immIndex:
+		in.Func, err = index()
+	case immIndex2:
+		if _, err = index(); err == nil {
+			_, err = index()
+		}
+		// the binary gives the segment first, the text the memory or table
+		if err == nil && (op == opMemoryInit || op == opTableInit) {
+			imm[0], imm[1] = imm[1], imm[0]
+		}
+	case immLabels:
+		var n int
+		if n, err = r.count(); err == nil {
+			for i := 0; i <= n && err == nil; i++ { // n targets and the default
+				_, err = index()
+			}
+		}
+	case immMemory, immMemory2:
+		for i := 0; i < 1+info.imm-immMemory && err == nil; i++ {
+			var mem uint32
+			if mem, err = r.u32(); err == nil && mem != 0 {
+				imm = append(imm, strconv.FormatUint(uint64(mem), 10))
+			}
+		}
+	case immMemarg:
+		imm, err = memarg(r, info.align)
+	case immSelect:
+		var n int
+		if n, err = r.count(); err == nil {
+			var types []string
+			for i := 0; i < n && err == nil; i++ {
+				var t byte
+				if t, err = r.byte(); err == nil {
+					types = append(types, ValType(t).String())
+				}
+			}
+			imm = append(imm, "(result "+strings.Join(types, " ")+")")
+		}
+	case immRefType:
+		var t byte
+		if t, err = r.byte(); err == nil {
+			imm = append(imm, strings.TrimSuffix(ValType(t).String(), "ref"))
+		}
+	case immCallIndirect:
+		var typeIdx, table uint32
+		if typeIdx, err = r.u32(); err == nil {
+			if table, err = r.u32(); err == nil {
+				if table != 0 {
+					imm = append(imm, strconv.FormatUint(uint64(table), 10))
+				}
+				imm = append(imm, fmt.Sprintf("(type %d)", typeIdx))
+			}
+		}
+	}
+	in.Imm = strings.Join(imm, " ")
+	return in, err
+}
+
+func blockType(r *reader) ([]string, error) {
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if b == 0x40 {
+		return nil, nil
+	}
+	if validValType(b) {
+		return []string{"(result " + ValType(b).String() + ")"}, nil
+	}
+	// otherwise a type index, as a signed 33-bit integer
+	r.pos--
+	idx, err := r.sleb(33)
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 {
+		return nil, r.errorf("invalid block type %d", idx)
+	}
+	return []string{fmt.Sprintf("(type %d)", idx)}, nil
+}
+
+// memarg reads a load or store's alignment, memory and offset. Bit 6 of the
+// alignment says a memory index follows, for multiple memories.
+func memarg(r *reader, natural uint32) ([]string, error) {
+	align, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	var imm []string
+	if align&0x40 != 0 {
+		mem, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		align &^= 0x40
+		imm = append(imm, strconv.FormatUint(uint64(mem), 10))
+	}
+	offset, err := r.uleb(64)
+	if err != nil {
+		return nil, err
+	}
+	if offset != 0 {
+		imm = append(imm, fmt.Sprintf("offset=%d", offset))
+	}
+	if align != natural {
+		if align > 16 {
+			return nil, r.errorf("invalid alignment 2**%d", align)
+		}
+		imm = append(imm, fmt.Sprintf("align=%d", uint64(1)<<align))
+	}
+	return imm, nil
+}
+
+// formatFloat writes a float constant so that it reads back to the same bits
+func formatFloat(v float64, nan bool, payload uint64, bits int) string {
+	sign := ""
+	if math.Signbit(v) {
+		sign = "-"
+	}
+	switch {
+	case nan:
+		canonical := uint64(1) << 22
+		if bits == 64 {
+			canonical = 1 << 51
+		}
+		if payload == canonical {
+			return sign + "nan"
+		}
+		return fmt.Sprintf("%snan:0x%x", sign, payload)
+	case math.IsInf(v, 0):
+		return sign + "inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, bits)
+}
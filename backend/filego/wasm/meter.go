@@ -0,0 +1,132 @@
+package wasm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Names Meter exports its fuel counter and the module's start function under
+const (
+	FuelExport  = "__wasmide_fuel"
+	StartExport = "__wasmide_start"
+)
+
+// Meter rewrites a module so that it runs on fuel. It adds a mutable i64
+// global, exported as FuelExport and starting at fuel, and charges it on
+// entry to every function and at the top of every loop iteration with the
+// number of instructions up to the next loop. When fuel runs out the
+// module traps with unreachable and the global is left negative.
+//
+// A start function would run while the module is instantiated, before the
+// caller can read the fuel, so it is exported as StartExport instead and
+// the caller must call it first.
+//
+// Custom sections other than function names are dropped. Functions using
+// instructions the decoder does not support cannot be metered.
+func Meter(bin []byte, fuel int64) ([]byte, error) {
+	m, err := Decode(bin)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range m.Exports {
+		if e.Name == FuelExport || e.Name == StartExport {
+			return nil, fmt.Errorf("module already exports %s", e.Name)
+		}
+	}
+
+	global := uint32(m.importedOf(KindGlobal) + len(m.Globals))
+	var init encoder
+	init.opcode(opI64Const)
+	init.sleb(fuel)
+	init.opcode(opEnd)
+	m.Globals = append(m.Globals, Global{GlobalType: GlobalType{Type: I64, Mutable: true}, Init: init.Bytes()})
+	m.Exports = append(m.Exports, Export{Name: FuelExport, Kind: KindGlobal, Index: global})
+	if m.Start != nil {
+		m.Exports = append(m.Exports, Export{Name: StartExport, Kind: KindFunc, Index: *m.Start})
+		m.Start = nil
+	}
+
+	for i := range m.Codes {
+		body, err := meterBody(m.Codes[i], global)
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", m.ImportedFuncs()+i, err)
+		}
+		m.Codes[i].Body = body
+	}
+	return Encode(m), nil
+}
+
+// meterBody inserts a fuel charge at the start of a function body and of
+// each loop in it
+func meterBody(code Code, global uint32) ([]byte, error) {
+	type frame struct {
+		metered bool // the function itself, or a loop
+		at      int  // where its charge goes
+		cost    int64
+	}
+	type charge struct {
+		at   int
+		cost int64
+	}
+
+	r := &reader{buf: code.Body, base: code.Offset}
+	frames := []*frame{{metered: true}}
+	var charges []charge
+	for !r.done() {
+		in, err := decodeInstr(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(frames) == 0 {
+			return nil, r.errorf("instructions after the end of the function")
+		}
+		for i := len(frames) - 1; i >= 0; i-- {
+			if frames[i].metered {
+				frames[i].cost++
+				break
+			}
+		}
+		switch in.Op {
+		case opBlock, opIf:
+			frames = append(frames, &frame{})
+		case opLoop:
+			frames = append(frames, &frame{metered: true, at: r.pos})
+		case opEnd:
+			top := frames[len(frames)-1]
+			frames = frames[:len(frames)-1]
+			if top.metered {
+				charges = append(charges, charge{top.at, top.cost})
+			}
+		}
+	}
+	if len(frames) != 0 {
+		return nil, r.errorf("function body is missing its end")
+	}
+
+	sort.Slice(charges, func(i, j int) bool { return charges[i].at < charges[j].at })
+	var out encoder
+	last := 0
+	for _, c := range charges {
+		out.Write(code.Body[last:c.at])
+		last = c.at
+		// fuel -= cost; if fuel < 0 { unreachable }
+		out.WriteByte(0x23) // global.get
+		out.u32(global)
+		out.opcode(opI64Const)
+		out.sleb(c.cost)
+		out.WriteByte(0x7d) // i64.sub
+		out.WriteByte(0x24) // global.set
+		out.u32(global)
+		out.WriteByte(0x23)
+		out.u32(global)
+		out.opcode(opI64Const)
+		out.sleb(0)
+		out.WriteByte(0x53) // i64.lt_s
+		out.opcode(opIf)
+		out.WriteByte(0x40)
+		out.WriteByte(0x00) // unreachable
+		out.opcode(opEnd)
+	}
+	out.Write(code.Body[last:])
+	return out.Bytes(), nil
+}
@@ -0,0 +1,710 @@
+// Package wasm decodes WebAssembly binary modules for inspection: their
+// sections, imports, exports, limits and a WAT disassembly. It reads the
+// MVP format plus the reference types, bulk memory, sign extension,
+// saturating conversion and multi-memory extensions; functions using other
+// proposals, such as SIMD, decode but are not disassembled.
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+)
+
+var magic = []byte{0x00, 0x61, 0x73, 0x6d}
+
+// Section IDs
+const (
+	SectionCustom    = 0
+	SectionType      = 1
+	SectionImport    = 2
+	SectionFunction  = 3
+	SectionTable     = 4
+	SectionMemory    = 5
+	SectionGlobal    = 6
+	SectionExport    = 7
+	SectionStart     = 8
+	SectionElement   = 9
+	SectionCode      = 10
+	SectionData      = 11
+	SectionDataCount = 12
+)
+
+var sectionNames = map[byte]string{
+	SectionCustom:    "custom",
+	SectionType:      "type",
+	SectionImport:    "import",
+	SectionFunction:  "function",
+	SectionTable:     "table",
+	SectionMemory:    "memory",
+	SectionGlobal:    "global",
+	SectionExport:    "export",
+	SectionStart:     "start",
+	SectionElement:   "element",
+	SectionCode:      "code",
+	SectionData:      "data",
+	SectionDataCount: "datacount",
+}
+
+// ValType is a value or reference type
+type ValType byte
+
+// Value types
+const (
+	I32       ValType = 0x7f
+	I64       ValType = 0x7e
+	F32       ValType = 0x7d
+	F64       ValType = 0x7c
+	V128      ValType = 0x7b
+	FuncRef   ValType = 0x70
+	ExternRef ValType = 0x6f
+)
+
+func (t ValType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case V128:
+		return "v128"
+	case FuncRef:
+		return "funcref"
+	case ExternRef:
+		return "externref"
+	}
+	return fmt.Sprintf("<0x%02x>", byte(t))
+}
+
+func validValType(b byte) bool {
+	switch ValType(b) {
+	case I32, I64, F32, F64, V128, FuncRef, ExternRef:
+		return true
+	}
+	return false
+}
+
+// ExternKind is what an import or export refers to
+type ExternKind byte
+
+// Extern kinds
+const (
+	KindFunc   ExternKind = 0
+	KindTable  ExternKind = 1
+	KindMemory ExternKind = 2
+	KindGlobal ExternKind = 3
+)
+
+func (k ExternKind) String() string {
+	switch k {
+	case KindFunc:
+		return "func"
+	case KindTable:
+		return "table"
+	case KindMemory:
+		return "memory"
+	case KindGlobal:
+		return "global"
+	}
+	return fmt.Sprintf("<kind %d>", byte(k))
+}
+
+// FuncType is a function signature
+type FuncType struct {
+	Params  []ValType
+	Results []ValType
+}
+
+// Limits bound a memory, in 64KiB pages, or a table, in elements
+type Limits struct {
+	Min    uint64
+	Max    *uint64
+	Shared bool
+	Is64   bool
+}
+
+// Table is a table's element type and size
+type Table struct {
+	Type ValType
+	Limits
+}
+
+// GlobalType is a global's value type and mutability
+type GlobalType struct {
+	Type    ValType
+	Mutable bool
+}
+
+// Import is one import; only the field matching Kind is set
+type Import struct {
+	Module string
+	Name   string
+	Kind   ExternKind
+	Func   uint32 // type index
+	Table  Table
+	Memory Limits
+	Global GlobalType
+}
+
+// Export is one export
+type Export struct {
+	Name  string
+	Kind  ExternKind
+	Index uint32
+}
+
+// Global is a global defined by the module
+type Global struct {
+	GlobalType
+	Init []byte // constant expression, including the final end
+}
+
+// Local is a run of locals of one type
+type Local struct {
+	Count uint32
+	Type  ValType
+}
+
+// Code is a function body
+type Code struct {
+	Locals []Local
+	Body   []byte // instructions, including the final end
+	Offset int    // of the body within the module
+	Size   int    // of the whole entry, locals included
+}
+
+// Segment modes
+const (
+	ModeActive      = "active"
+	ModePassive     = "passive"
+	ModeDeclarative = "declarative"
+)
+
+// Element is an element segment
+type Element struct {
+	Mode   string
+	Table  uint32
+	Offset []byte  // constant expression of an active segment
+	Type   ValType // FuncRef unless given as expressions of another type
+	Funcs  []uint32
+	Exprs  [][]byte // when given as expressions rather than function indices
+}
+
+// Data is a data segment
+type Data struct {
+	Mode   string
+	Memory uint32
+	Offset []byte
+	Init   []byte
+}
+
+// Section locates one section in the binary
+type Section struct {
+	ID     byte
+	Name   string // the custom section's own name for custom sections
+	Offset int    // of the section's contents
+	Size   int
+}
+
+// Module is a decoded module
+type Module struct {
+	Size      int
+	Sections  []Section
+	Types     []FuncType
+	Imports   []Import
+	Funcs     []uint32 // type index of each function defined by the module
+	Tables    []Table
+	Memories  []Limits
+	Globals   []Global
+	Exports   []Export
+	Start     *uint32
+	Elements  []Element
+	Codes     []Code
+	Datas     []Data
+	DataCount *uint32
+	// FuncNames are the names from the "name" custom section, by function index
+	FuncNames map[uint32]string
+}
+
+// ImportedFuncs counts the function imports, which come before the
+// module's own functions in the function index space
+func (m *Module) ImportedFuncs() int {
+	return m.importedOf(KindFunc)
+}
+
+func (m *Module) importedOf(kind ExternKind) int {
+	n := 0
+	for _, imp := range m.Imports {
+		if imp.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// FuncType returns the signature of function index idx, counting imports first
+func (m *Module) FuncType(idx uint32) (FuncType, bool) {
+	var typeIdx uint32
+	imported := uint32(0)
+	found := false
+	for _, imp := range m.Imports {
+		if imp.Kind != KindFunc {
+			continue
+		}
+		if imported == idx {
+			typeIdx, found = imp.Func, true
+			break
+		}
+		imported++
+	}
+	if !found {
+		local := idx - uint32(m.ImportedFuncs())
+		if idx < uint32(m.ImportedFuncs()) || int(local) >= len(m.Funcs) {
+			return FuncType{}, false
+		}
+		typeIdx = m.Funcs[local]
+	}
+	if int(typeIdx) >= len(m.Types) {
+		return FuncType{}, false
+	}
+	return m.Types[typeIdx], true
+}
+
+// Decode parses a binary module
+func Decode(bin []byte) (*Module, error) {
+	if len(bin) < 8 || !bytes.Equal(bin[:4], magic) {
+		return nil, fmt.Errorf("not a WebAssembly module")
+	}
+	if version := bin[4:8]; !bytes.Equal(version, []byte{1, 0, 0, 0}) {
+		return nil, fmt.Errorf("unsupported binary version %v", version)
+	}
+
+	m := &Module{Size: len(bin), FuncNames: map[uint32]string{}}
+	r := &reader{buf: bin, pos: 8}
+	lastID := byte(0)
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		start := r.pos
+		contents, err := r.bytes(int(size))
+		if err != nil {
+			return nil, r.errorf("section %d runs past the end of the module", id)
+		}
+		name, ok := sectionNames[id]
+		if !ok {
+			return nil, fmt.Errorf("at offset 0x%x: unknown section id %d", start, id)
+		}
+		// non-custom sections appear at most once, in order, with datacount
+		// placed between element and code
+		if id != SectionCustom {
+			if order(id) <= order(lastID) {
+				return nil, fmt.Errorf("at offset 0x%x: %s section out of order", start, name)
+			}
+			lastID = id
+		}
+
+		sr := &reader{buf: contents, base: start}
+		section := Section{ID: id, Name: name, Offset: start, Size: int(size)}
+		if id == SectionCustom {
+			if section.Name, err = sr.name(); err != nil {
+				return nil, err
+			}
+			if section.Name == "name" {
+				// a malformed name section is ignored, as engines do
+				m.decodeNames(&reader{buf: contents[sr.pos:], base: start + sr.pos})
+			}
+		} else if err := m.decodeSection(id, sr); err != nil {
+			return nil, fmt.Errorf("%s section: %w", name, err)
+		}
+		if id != SectionCustom && !sr.done() {
+			return nil, fmt.Errorf("%s section: %d trailing bytes", name, len(sr.buf)-sr.pos)
+		}
+		m.Sections = append(m.Sections, section)
+	}
+
+	if len(m.Funcs) != len(m.Codes) {
+		return nil, fmt.Errorf("%d functions declared but %d bodies", len(m.Funcs), len(m.Codes))
+	}
+	return m, nil
+}
+
+// order places datacount between element and code
+func order(id byte) int {
+	if id == SectionDataCount {
+		return SectionElement*2 + 1
+	}
+	return int(id) * 2
+}
+
+func (m *Module) decodeSection(id byte, r *reader) error {
+	if id == SectionStart {
+		idx, err := r.u32()
+		m.Start = &idx
+		return err
+	}
+	if id == SectionDataCount {
+		n, err := r.u32()
+		m.DataCount = &n
+		return err
+	}
+
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		var err error
+		switch id {
+		case SectionType:
+			err = m.decodeType(r)
+		case SectionImport:
+			err = m.decodeImport(r)
+		case SectionFunction:
+			var idx uint32
+			idx, err = r.u32()
+			m.Funcs = append(m.Funcs, idx)
+		case SectionTable:
+			var t Table
+			t, err = decodeTable(r)
+			m.Tables = append(m.Tables, t)
+		case SectionMemory:
+			var l Limits
+			l, err = decodeLimits(r)
+			m.Memories = append(m.Memories, l)
+		case SectionGlobal:
+			var g Global
+			if g.GlobalType, err = decodeGlobalType(r); err == nil {
+				g.Init, err = readExpr(r)
+			}
+			m.Globals = append(m.Globals, g)
+		case SectionExport:
+			var e Export
+			if e.Name, err = r.name(); err == nil {
+				var kind byte
+				if kind, err = r.byte(); err == nil {
+					e.Kind = ExternKind(kind)
+					e.Index, err = r.u32()
+				}
+			}
+			m.Exports = append(m.Exports, e)
+		case SectionElement:
+			err = m.decodeElement(r)
+		case SectionCode:
+			err = m.decodeCode(r)
+		case SectionData:
+			err = m.decodeData(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Module) decodeType(r *reader) error {
+	form, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if form != 0x60 {
+		return r.errorf("unsupported type form 0x%02x", form)
+	}
+	var t FuncType
+	for _, list := range []*[]ValType{&t.Params, &t.Results} {
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		for j := 0; j < n; j++ {
+			b, err := r.byte()
+			if err != nil {
+				return err
+			}
+			if !validValType(b) {
+				return r.errorf("invalid value type 0x%02x", b)
+			}
+			*list = append(*list, ValType(b))
+		}
+	}
+	m.Types = append(m.Types, t)
+	return nil
+}
+
+func (m *Module) decodeImport(r *reader) error {
+	var imp Import
+	var err error
+	if imp.Module, err = r.name(); err != nil {
+		return err
+	}
+	if imp.Name, err = r.name(); err != nil {
+		return err
+	}
+	kind, err := r.byte()
+	if err != nil {
+		return err
+	}
+	imp.Kind = ExternKind(kind)
+	switch imp.Kind {
+	case KindFunc:
+		imp.Func, err = r.u32()
+	case KindTable:
+		imp.Table, err = decodeTable(r)
+	case KindMemory:
+		imp.Memory, err = decodeLimits(r)
+	case KindGlobal:
+		imp.Global, err = decodeGlobalType(r)
+	default:
+		return r.errorf("invalid import kind %d", kind)
+	}
+	m.Imports = append(m.Imports, imp)
+	return err
+}
+
+func decodeLimits(r *reader) (Limits, error) {
+	var l Limits
+	flags, err := r.byte()
+	if err != nil {
+		return l, err
+	}
+	if flags > 7 {
+		return l, r.errorf("invalid limits flags 0x%02x", flags)
+	}
+	l.Shared, l.Is64 = flags&2 != 0, flags&4 != 0
+	if l.Min, err = r.uleb(64); err != nil {
+		return l, err
+	}
+	if flags&1 != 0 {
+		max, err := r.uleb(64)
+		if err != nil {
+			return l, err
+		}
+		l.Max = &max
+	}
+	return l, nil
+}
+
+func decodeTable(r *reader) (Table, error) {
+	b, err := r.byte()
+	if err != nil {
+		return Table{}, err
+	}
+	if ValType(b) != FuncRef && ValType(b) != ExternRef {
+		return Table{}, r.errorf("invalid table element type 0x%02x", b)
+	}
+	l, err := decodeLimits(r)
+	return Table{Type: ValType(b), Limits: l}, err
+}
+
+func decodeGlobalType(r *reader) (GlobalType, error) {
+	b, err := r.byte()
+	if err != nil {
+		return GlobalType{}, err
+	}
+	if !validValType(b) {
+		return GlobalType{}, r.errorf("invalid value type 0x%02x", b)
+	}
+	mut, err := r.byte()
+	if err != nil {
+		return GlobalType{}, err
+	}
+	if mut > 1 {
+		return GlobalType{}, r.errorf("invalid mutability %d", mut)
+	}
+	return GlobalType{Type: ValType(b), Mutable: mut == 1}, nil
+}
+
+// readExpr reads a constant expression up to and including its end
+func readExpr(r *reader) ([]byte, error) {
+	start := r.pos
+	for {
+		instr, err := decodeInstr(r)
+		if err != nil {
+			return nil, err
+		}
+		if instr.Op == opEnd {
+			return r.buf[start:r.pos], nil
+		}
+	}
+}
+
+func (m *Module) decodeElement(r *reader) error {
+	flags, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if flags > 7 {
+		return r.errorf("invalid element segment flags %d", flags)
+	}
+	e := Element{Mode: ModeActive, Type: FuncRef}
+	switch {
+	case flags&1 == 0:
+	case flags&2 == 0:
+		e.Mode = ModePassive
+	default:
+		e.Mode = ModeDeclarative
+	}
+	if flags&1 == 0 {
+		if flags&2 != 0 {
+			if e.Table, err = r.u32(); err != nil {
+				return err
+			}
+		}
+		if e.Offset, err = readExpr(r); err != nil {
+			return err
+		}
+	}
+	// an element kind or reference type follows unless flags are 0 or 4
+	if flags&3 != 0 {
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if flags&4 != 0 {
+			e.Type = ValType(kind)
+		}
+	}
+
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if flags&4 != 0 {
+			expr, err := readExpr(r)
+			if err != nil {
+				return err
+			}
+			e.Exprs = append(e.Exprs, expr)
+			continue
+		}
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		e.Funcs = append(e.Funcs, idx)
+	}
+	m.Elements = append(m.Elements, e)
+	return nil
+}
+
+func (m *Module) decodeCode(r *reader) error {
+	size, err := r.u32()
+	if err != nil {
+		return err
+	}
+	start := r.pos
+	body, err := r.bytes(int(size))
+	if err != nil {
+		return err
+	}
+	br := &reader{buf: body, base: r.base + start}
+	n, err := br.count()
+	if err != nil {
+		return err
+	}
+	code := Code{Size: int(size)}
+	var total uint64
+	for i := 0; i < n; i++ {
+		count, err := br.u32()
+		if err != nil {
+			return err
+		}
+		t, err := br.byte()
+		if err != nil {
+			return err
+		}
+		if !validValType(t) {
+			return br.errorf("invalid local type 0x%02x", t)
+		}
+		if total += uint64(count); total > 50000 {
+			return br.errorf("too many locals")
+		}
+		code.Locals = append(code.Locals, Local{Count: count, Type: ValType(t)})
+	}
+	code.Body = body[br.pos:]
+	code.Offset = r.base + start + br.pos
+	if len(code.Body) == 0 || code.Body[len(code.Body)-1] != byte(opEnd) {
+		return br.errorf("function body does not end with end")
+	}
+	m.Codes = append(m.Codes, code)
+	return nil
+}
+
+func (m *Module) decodeData(r *reader) error {
+	flags, err := r.u32()
+	if err != nil {
+		return err
+	}
+	d := Data{Mode: ModeActive}
+	switch flags {
+	case 0:
+	case 1:
+		d.Mode = ModePassive
+	case 2:
+		if d.Memory, err = r.u32(); err != nil {
+			return err
+		}
+	default:
+		return r.errorf("invalid data segment flags %d", flags)
+	}
+	if d.Mode == ModeActive {
+		if d.Offset, err = readExpr(r); err != nil {
+			return err
+		}
+	}
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if d.Init, err = r.bytes(int(n)); err != nil {
+		return err
+	}
+	m.Datas = append(m.Datas, d)
+	return nil
+}
+
+// decodeNames reads function names from the "name" custom section
+func (m *Module) decodeNames(r *reader) {
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return
+		}
+		size, err := r.u32()
+		if err != nil {
+			return
+		}
+		sub, err := r.bytes(int(size))
+		if err != nil {
+			return
+		}
+		if id != 1 { // function names
+			continue
+		}
+		sr := &reader{buf: sub}
+		n, err := sr.count()
+		if err != nil {
+			return
+		}
+		for i := 0; i < n; i++ {
+			idx, err := sr.u32()
+			if err != nil {
+				return
+			}
+			name, err := sr.name()
+			if err != nil {
+				return
+			}
+			m.FuncNames[idx] = name
+		}
+	}
+}
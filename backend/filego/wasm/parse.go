@@ -0,0 +1,293 @@
+package wasm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError is an error in WAT source, with its position
+type SyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// sexp is a parenthesised list or a single token of WAT source
+type sexp struct {
+	list   []*sexp
+	isList bool
+	atom   string // the token; the decoded bytes for strings
+	isStr  bool
+	line   int
+	col    int
+}
+
+func (s *sexp) errorf(format string, args ...any) error {
+	return &SyntaxError{Line: s.line, Col: s.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// head returns the keyword a list starts with
+func (s *sexp) head() string {
+	if !s.isList || len(s.list) == 0 || s.list[0].isList || s.list[0].isStr {
+		return ""
+	}
+	return s.list[0].atom
+}
+
+// isID reports whether s is a $identifier
+func (s *sexp) isID() bool {
+	return !s.isList && !s.isStr && strings.HasPrefix(s.atom, "$")
+}
+
+// isIndex reports whether s is an identifier or a number
+func (s *sexp) isIndex() bool {
+	if s.isList || s.isStr || s.atom == "" {
+		return false
+	}
+	return s.isID() || (s.atom[0] >= '0' && s.atom[0] <= '9')
+}
+
+// lexer splits WAT source into tokens, skipping whitespace and comments
+type lexer struct {
+	src       string
+	pos       int
+	line, col int
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return &SyntaxError{Line: l.line, Col: l.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) advance(n int) {
+	for _, c := range l.src[l.pos : l.pos+n] {
+		if c == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+	}
+	l.pos += n
+}
+
+// skip passes over whitespace and comments; block comments nest
+func (l *lexer) skip() error {
+	for l.pos < len(l.src) {
+		rest := l.src[l.pos:]
+		switch {
+		case rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n' || rest[0] == '\r':
+			l.advance(1)
+		case strings.HasPrefix(rest, ";;"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			l.advance(end)
+		case strings.HasPrefix(rest, "(;"):
+			line, col := l.line, l.col
+			depth := 0
+			for {
+				rest = l.src[l.pos:]
+				switch {
+				case rest == "":
+					return &SyntaxError{Line: line, Col: col, Msg: "unterminated block comment"}
+				case strings.HasPrefix(rest, "(;"):
+					depth++
+					l.advance(2)
+				case strings.HasPrefix(rest, ";)"):
+					depth--
+					l.advance(2)
+				default:
+					l.advance(1)
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseSexps reads all the top-level expressions of src
+func parseSexps(src string) ([]*sexp, error) {
+	if !utf8.ValidString(src) {
+		return nil, &SyntaxError{Line: 1, Col: 1, Msg: "source is not valid UTF-8"}
+	}
+	l := &lexer{src: src, line: 1, col: 1}
+	root := &sexp{isList: true}
+	stack := []*sexp{root}
+	for {
+		if err := l.skip(); err != nil {
+			return nil, err
+		}
+		if l.pos >= len(l.src) {
+			break
+		}
+		top := stack[len(stack)-1]
+		c := l.src[l.pos]
+		switch c {
+		case '(':
+			list := &sexp{isList: true, line: l.line, col: l.col}
+			top.list = append(top.list, list)
+			stack = append(stack, list)
+			l.advance(1)
+		case ')':
+			if len(stack) == 1 {
+				return nil, l.errorf("unexpected )")
+			}
+			if len(top.list) == 0 {
+				return nil, top.errorf("empty list")
+			}
+			stack = stack[:len(stack)-1]
+			l.advance(1)
+		case '"':
+			s, err := l.string()
+			if err != nil {
+				return nil, err
+			}
+			top.list = append(top.list, s)
+		default:
+			end := l.pos
+			for end < len(l.src) && !strings.ContainsRune(" \t\n\r()\";", rune(l.src[end])) {
+				end++
+			}
+			if end == l.pos { // a lone ;
+				return nil, l.errorf("unexpected ;")
+			}
+			top.list = append(top.list, &sexp{atom: l.src[l.pos:end], line: l.line, col: l.col})
+			l.advance(end - l.pos)
+		}
+	}
+	if len(stack) > 1 {
+		open := stack[len(stack)-1]
+		return nil, open.errorf("unclosed (")
+	}
+	return root.list, nil
+}
+
+// string reads a string literal and decodes its escapes
+func (l *lexer) string() (*sexp, error) {
+	s := &sexp{isStr: true, line: l.line, col: l.col}
+	var b strings.Builder
+	i := l.pos + 1
+	for {
+		if i >= len(l.src) || l.src[i] == '\n' {
+			return nil, s.errorf("unterminated string")
+		}
+		c := l.src[i]
+		if c == '"' {
+			i++
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if i+1 >= len(l.src) {
+			return nil, s.errorf("unterminated string")
+		}
+		switch e := l.src[i+1]; e {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\'', '\\':
+			b.WriteByte(e)
+		case 'u':
+			end := strings.IndexByte(l.src[i:], '}')
+			if !strings.HasPrefix(l.src[i+2:], "{") || end < 0 {
+				return nil, s.errorf("invalid \\u escape")
+			}
+			r, err := strconv.ParseUint(strings.ReplaceAll(l.src[i+3:i+end], "_", ""), 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return nil, s.errorf("invalid \\u escape")
+			}
+			b.WriteRune(rune(r))
+			i += end - 1
+		default:
+			if i+2 >= len(l.src) {
+				return nil, s.errorf("unterminated string")
+			}
+			v, err := strconv.ParseUint(l.src[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, s.errorf("invalid escape \\%s", l.src[i+1:i+3])
+			}
+			b.WriteByte(byte(v))
+			i++
+		}
+		i += 2
+	}
+	l.advance(i - l.pos)
+	s.atom = b.String()
+	return s, nil
+}
+
+// cursor walks the items of a list
+type cursor struct {
+	items []*sexp
+	i     int
+	// parent is reported when items run out
+	parent *sexp
+}
+
+func (c *cursor) done() bool { return c.i >= len(c.items) }
+
+func (c *cursor) peek() *sexp {
+	if c.done() {
+		return nil
+	}
+	return c.items[c.i]
+}
+
+func (c *cursor) next() *sexp {
+	s := c.peek()
+	c.i++
+	return s
+}
+
+// peekList returns the next item if it is a list starting with keyword
+func (c *cursor) peekList(keyword string) *sexp {
+	if s := c.peek(); s != nil && s.head() == keyword {
+		return s
+	}
+	return nil
+}
+
+// optionalID consumes a $identifier if one is next
+func (c *cursor) optionalID() string {
+	if s := c.peek(); s != nil && s.isID() {
+		c.i++
+		return s.atom
+	}
+	return ""
+}
+
+// errorf reports an error at the next item, or the end of the list
+func (c *cursor) errorf(format string, args ...any) error {
+	if s := c.peek(); s != nil {
+		return s.errorf(format, args...)
+	}
+	if c.parent != nil {
+		return c.parent.errorf(format, args...)
+	}
+	return &SyntaxError{Line: 1, Col: 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// listCursor walks a list's items after its keyword; an atom has none
+func listCursor(s *sexp) *cursor {
+	if len(s.list) == 0 {
+		return &cursor{parent: s}
+	}
+	return &cursor{items: s.list[1:], parent: s}
+}
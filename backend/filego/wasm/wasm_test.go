@@ -0,0 +1,189 @@
+package wasm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func section(id byte, contents ...byte) []byte {
+	return append([]byte{id, byte(len(contents))}, contents...)
+}
+
+func str(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// testModule is:
+//
+//	(module
+//	  (import "wasi_snapshot_preview1" "proc_exit" (func (param i32)))
+//	  (func $add (param i32 i32) (result i32)
+//	    local.get 0
+//	    if (result i32)
+//	      local.get 0
+//	      local.get 1
+//	      i32.add
+//	    else
+//	      i32.const -1
+//	    end)
+//	  (memory 1 2)
+//	  (global (mut i32) (i32.const 1048576))
+//	  (export "add" (func 1))
+//	  (export "memory" (memory 0))
+//	  (data (i32.const 8) "hi\n"))
+func testModule() []byte {
+	body := []byte{
+		0x00,       // no locals
+		0x20, 0x00, // local.get 0
+		0x04, 0x7f, // if (result i32)
+		0x20, 0x00, 0x20, 0x01, 0x6a, // local.get 0, local.get 1, i32.add
+		0x05,       // else
+		0x41, 0x7f, // i32.const -1
+		0x0b, // end
+		0x0b, // end of function
+	}
+	return concat(
+		[]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+		section(SectionType, 2,
+			0x60, 1, 0x7f, 0,
+			0x60, 2, 0x7f, 0x7f, 1, 0x7f),
+		section(SectionImport, concat([]byte{1}, str("wasi_snapshot_preview1"), str("proc_exit"), []byte{0x00, 0})...),
+		section(SectionFunction, 1, 1),
+		section(SectionMemory, 1, 0x01, 1, 2),
+		section(SectionGlobal, 1, 0x7f, 1, 0x41, 0x80, 0x80, 0xc0, 0x00, 0x0b),
+		section(SectionExport, concat([]byte{2}, str("add"), []byte{0x00, 1}, str("memory"), []byte{0x02, 0})...),
+		section(SectionCode, concat([]byte{1, byte(len(body))}, body)...),
+		section(SectionData, concat([]byte{1, 0, 0x41, 8, 0x0b}, str("hi\n"))...),
+		section(SectionCustom, concat(str("name"), []byte{1, 6, 1, 1}, str("add"))...),
+	)
+}
+
+func TestDecodeAndInspect(t *testing.T) {
+	bin := testModule()
+	m, err := Decode(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Imports) != 1 || len(m.Funcs) != 1 || len(m.Exports) != 2 || len(m.Datas) != 1 {
+		t.Fatalf("unexpected module %+v", m)
+	}
+	if m.FuncNames[1] != "add" {
+		t.Errorf("function names = %v", m.FuncNames)
+	}
+
+	report := Inspect(m)
+	if report.Size != len(bin) {
+		t.Errorf("size = %d, want %d", report.Size, len(bin))
+	}
+	if got := report.Imports[0].Type; got != "func (param i32)" {
+		t.Errorf("import type = %q", got)
+	}
+	add := report.Exports[0]
+	if add.Type != "func (param i32 i32) (result i32)" || add.Function != "add" {
+		t.Errorf("export = %+v", add)
+	}
+	mem := report.Memories[0]
+	if mem.MinBytes != PageSize || mem.MaxBytes == nil || *mem.MaxBytes != 2*PageSize || mem.ExportsAs != "memory" {
+		t.Errorf("memory = %+v", mem)
+	}
+	if report.Globals[0].Init != "(i32.const 1048576)" {
+		t.Errorf("global init = %q", report.Globals[0].Init)
+	}
+	if len(report.Custom) != 1 || report.Custom[0].Name != "name" {
+		t.Errorf("custom sections = %+v", report.Custom)
+	}
+	total := 0
+	for _, entry := range report.Breakdown {
+		total += entry.Size
+	}
+	if total != len(bin) {
+		t.Errorf("breakdown adds up to %d, want %d", total, len(bin))
+	}
+	if len(report.Features) != 1 || report.Features[0] != "wasi-preview1" {
+		t.Errorf("features = %v", report.Features)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	m, err := Decode(testModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Disassemble(&out, m); err != nil {
+		t.Fatal(err)
+	}
+	want := `(module
+  (type (;0;) (func (param i32)))
+  (type (;1;) (func (param i32 i32) (result i32)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func (;0;) (type 0)))
+  (func $add (;1;) (type 1) (param i32 i32) (result i32)
+    local.get 0
+    if (result i32)
+      local.get 0
+      local.get 1
+      i32.add
+    else
+      i32.const -1
+    end
+  )
+  (memory (;0;) 1 2)
+  (global (;0;) (mut i32) (i32.const 1048576))
+  (export "add" (func 1))
+  (export "memory" (memory 0))
+  (data (;0;) (i32.const 8) "hi\0a")
+)
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	bin := testModule()
+	for name, input := range map[string][]byte{
+		"empty":     nil,
+		"not wasm":  []byte("\x7fELF\x02\x01\x01\x00"),
+		"truncated": bin[:len(bin)-5],
+		"out of order": concat(bin[:8],
+			section(SectionFunction, 0),
+			section(SectionType, 0)),
+	} {
+		if _, err := Decode(input); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestUnsupportedOpcode(t *testing.T) {
+	body := []byte{0x00, 0xfd, 0x0c, 0x0b} // v128.const, cut short
+	bin := concat(
+		[]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+		section(SectionType, 1, 0x60, 0, 0),
+		section(SectionFunction, 1, 0),
+		section(SectionCode, concat([]byte{1, byte(len(body))}, body)...),
+	)
+	m, err := Decode(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Disassemble(&out, m); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), ";; at offset") || !strings.Contains(out.String(), "unsupported opcode 0xfd") {
+		t.Errorf("expected a note about the unsupported opcode, got:\n%s", out.String())
+	}
+	if report := Inspect(m); len(report.Notes) != 1 || report.Features[0] != "simd" {
+		t.Errorf("report features = %v, notes = %v", report.Features, report.Notes)
+	}
+}
@@ -0,0 +1,311 @@
+package wasm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Disassemble writes m in the WebAssembly text format. Functions are named
+// from the name section when it has them. A function that uses an
+// instruction the decoder does not support is printed up to that point,
+// followed by a comment.
+func Disassemble(w io.Writer, m *Module) error {
+	p := &printer{w: bufio.NewWriter(w), m: m, names: funcIDs(m)}
+	p.module()
+	return p.w.Flush()
+}
+
+type printer struct {
+	w     *bufio.Writer
+	m     *Module
+	names map[uint32]string
+}
+
+func (p *printer) line(indent int, format string, args ...any) {
+	p.w.WriteString(strings.Repeat("  ", indent))
+	fmt.Fprintf(p.w, format, args...)
+	p.w.WriteByte('\n')
+}
+
+func (p *printer) module() {
+	m := p.m
+	p.line(0, "(module")
+	for i, t := range m.Types {
+		p.line(1, "(type (;%d;) (func%s))", i, signature(t))
+	}
+
+	var funcs, tables, memories, globals int
+	for _, imp := range m.Imports {
+		var desc string
+		switch imp.Kind {
+		case KindFunc:
+			desc = fmt.Sprintf("(func %s(;%d;) (type %d))", p.funcID(uint32(funcs)), funcs, imp.Func)
+			funcs++
+		case KindTable:
+			desc = fmt.Sprintf("(table (;%d;) %s)", tables, tableType(imp.Table))
+			tables++
+		case KindMemory:
+			desc = fmt.Sprintf("(memory (;%d;) %s)", memories, limits(imp.Memory))
+			memories++
+		case KindGlobal:
+			desc = fmt.Sprintf("(global (;%d;) %s)", globals, globalType(imp.Global))
+			globals++
+		}
+		p.line(1, "(import %s %s %s)", quote([]byte(imp.Module)), quote([]byte(imp.Name)), desc)
+	}
+
+	for i, typeIdx := range m.Funcs {
+		idx := uint32(funcs + i)
+		header := fmt.Sprintf("(func %s(;%d;) (type %d)", p.funcID(idx), idx, typeIdx)
+		if int(typeIdx) < len(m.Types) {
+			header += signature(m.Types[typeIdx])
+		}
+		p.line(1, "%s", header)
+		p.body(m.Codes[i])
+	}
+
+	for i, t := range m.Tables {
+		p.line(1, "(table (;%d;) %s)", tables+i, tableType(t))
+	}
+	for i, l := range m.Memories {
+		p.line(1, "(memory (;%d;) %s)", memories+i, limits(l))
+	}
+	for i, g := range m.Globals {
+		p.line(1, "(global (;%d;) %s %s)", globals+i, globalType(g.GlobalType), p.expr(g.Init, ""))
+	}
+	for _, e := range m.Exports {
+		p.line(1, "(export %s (%s %d))", quote([]byte(e.Name)), e.Kind, e.Index)
+	}
+	if m.Start != nil {
+		p.line(1, "(start %d)", *m.Start)
+	}
+	for i, e := range m.Elements {
+		p.element(i, e)
+	}
+	for i, d := range m.Datas {
+		var mode string
+		if d.Mode == ModeActive {
+			if d.Memory != 0 {
+				mode = fmt.Sprintf(" (memory %d)", d.Memory)
+			}
+			mode += " " + p.expr(d.Offset, "offset")
+		}
+		p.line(1, "(data (;%d;)%s %s)", i, mode, quote(d.Init))
+	}
+	p.line(0, ")")
+}
+
+func (p *printer) element(i int, e Element) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "(elem (;%d;)", i)
+	switch e.Mode {
+	case ModeDeclarative:
+		b.WriteString(" declare")
+	case ModeActive:
+		if e.Table != 0 {
+			fmt.Fprintf(&b, " (table %d)", e.Table)
+		}
+		b.WriteString(" " + p.expr(e.Offset, "offset"))
+	}
+	if e.Exprs != nil {
+		b.WriteString(" " + e.Type.String())
+		for _, expr := range e.Exprs {
+			b.WriteString(" " + p.expr(expr, "item"))
+		}
+	} else {
+		b.WriteString(" func")
+		for _, idx := range e.Funcs {
+			b.WriteString(" " + p.funcRef(idx))
+		}
+	}
+	b.WriteString(")")
+	p.line(1, "%s", b.String())
+}
+
+// body prints a function's locals and instructions, then closes it
+func (p *printer) body(code Code) {
+	var locals []string
+	for _, l := range code.Locals {
+		for j := uint32(0); j < l.Count; j++ {
+			locals = append(locals, l.Type.String())
+		}
+	}
+	if len(locals) > 0 {
+		p.line(2, "(local %s)", strings.Join(locals, " "))
+	}
+
+	r := &reader{buf: code.Body, base: code.Offset}
+	depth := 2
+	for {
+		in, err := decodeInstr(r)
+		var unsupported *UnsupportedOpcodeError
+		if errors.As(err, &unsupported) {
+			p.line(depth, ";; %s, remaining %d bytes not shown", unsupported.Error(), len(r.buf)-r.pos)
+			break
+		}
+		if err != nil {
+			p.line(depth, ";; %s", err)
+			break
+		}
+		if in.Op == opEnd && depth == 2 {
+			break // the function's own end
+		}
+		if in.Op == opEnd || in.Op == opElse {
+			depth--
+		}
+		p.line(depth, "%s", p.instr(in))
+		switch in.Op {
+		case opBlock, opLoop, opIf, opElse:
+			depth++
+		}
+	}
+	p.line(1, ")")
+}
+
+func (p *printer) instr(in Instr) string {
+	imm := in.Imm
+	if in.callsFunc() {
+		imm = p.funcRef(in.Func)
+	}
+	if imm == "" {
+		return in.Name
+	}
+	return in.Name + " " + imm
+}
+
+// expr prints a constant expression, folded when it is a single
+// instruction and otherwise wrapped in keyword, if any
+func (p *printer) expr(expr []byte, keyword string) string {
+	r := &reader{buf: expr}
+	var instrs []string
+	for !r.done() {
+		in, err := decodeInstr(r)
+		if err != nil {
+			return fmt.Sprintf("(;%s;)", err)
+		}
+		if in.Op == opEnd {
+			break
+		}
+		instrs = append(instrs, p.instr(in))
+	}
+	if len(instrs) == 1 {
+		return "(" + instrs[0] + ")"
+	}
+	text := strings.Join(instrs, " ")
+	if keyword == "" {
+		return text
+	}
+	return "(" + keyword + " " + text + ")"
+}
+
+// funcID returns "$name " for a named function, or nothing
+func (p *printer) funcID(idx uint32) string {
+	if name, ok := p.names[idx]; ok {
+		return "$" + name + " "
+	}
+	return ""
+}
+
+// funcRef refers to a function by name when it has one
+func (p *printer) funcRef(idx uint32) string {
+	if name, ok := p.names[idx]; ok {
+		return "$" + name
+	}
+	return strconv.FormatUint(uint64(idx), 10)
+}
+
+// funcIDs turns the name section's function names into unique identifiers;
+// mangled names often contain characters identifiers may not
+func funcIDs(m *Module) map[uint32]string {
+	indices := make([]uint32, 0, len(m.FuncNames))
+	for idx := range m.FuncNames {
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	ids := make(map[uint32]string, len(m.FuncNames))
+	used := map[string]bool{}
+	for _, idx := range indices {
+		name := m.FuncNames[idx]
+		id := strings.Map(func(r rune) rune {
+			if r > ' ' && r < 0x7f && !strings.ContainsRune(`"(),;[]{}`, r) {
+				return r
+			}
+			return '_'
+		}, name)
+		if id == "" || used[id] {
+			id = fmt.Sprintf("%s#%d", id, idx)
+		}
+		used[id] = true
+		ids[idx] = id
+	}
+	return ids
+}
+
+func signature(t FuncType) string {
+	var b strings.Builder
+	for _, list := range []struct {
+		keyword string
+		types   []ValType
+	}{{"param", t.Params}, {"result", t.Results}} {
+		if len(list.types) == 0 {
+			continue
+		}
+		b.WriteString(" (" + list.keyword)
+		for _, v := range list.types {
+			b.WriteString(" " + v.String())
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func limits(l Limits) string {
+	s := ""
+	if l.Is64 {
+		s = "i64 "
+	}
+	s += strconv.FormatUint(l.Min, 10)
+	if l.Max != nil {
+		s += " " + strconv.FormatUint(*l.Max, 10)
+	}
+	if l.Shared {
+		s += " shared"
+	}
+	return s
+}
+
+func tableType(t Table) string {
+	return limits(t.Limits) + " " + t.Type.String()
+}
+
+func globalType(g GlobalType) string {
+	if g.Mutable {
+		return "(mut " + g.Type.String() + ")"
+	}
+	return g.Type.String()
+}
+
+// quote writes b as a string literal, escaping anything but printable ASCII
+func quote(b []byte) string {
+	var s strings.Builder
+	s.WriteByte('"')
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			s.WriteByte('\\')
+			s.WriteByte(c)
+		case c >= 0x20 && c < 0x7f:
+			s.WriteByte(c)
+		default:
+			fmt.Fprintf(&s, "\\%02x", c)
+		}
+	}
+	s.WriteByte('"')
+	return s.String()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"xxx/diagnostics"
+	"xxx/projects"
+	"xxx/sandbox"
+	"xxx/wasm"
+
+	"muhammadyasir-dev/cmd/accounting"
+	"muhammadyasir-dev/cmd/metrics"
+)
+
+// Languages /runcode runs in process rather than in a container
+const (
+	langWAT  = "wat"
+	langWasm = "wasm"
+)
+
+// Defaults for RUN_WASM_FUEL, RUN_WASM_MAX_PAGES, RUN_WASM_TIMEOUT_MS and
+// RUN_WASM_MAX_OUTPUT_BYTES
+const (
+	defaultWasmFuel        = 1_000_000_000
+	defaultWasmMaxPages    = 256 // 16 MiB
+	defaultWasmTimeoutMS   = 5000
+	defaultWasmOutputBytes = 1 << 20
+)
+
+// isDirectLanguage reports whether lang is run in process
+func isDirectLanguage(lang string) bool {
+	return lang == langWAT || lang == langWasm
+}
+
+// wasmLimits reads the limits of in-process runs from the environment
+func wasmLimits() sandbox.Limits {
+	return sandbox.Limits{
+		Fuel:        envInt64("RUN_WASM_FUEL", defaultWasmFuel),
+		MemoryPages: uint32(min(envInt64("RUN_WASM_MAX_PAGES", defaultWasmMaxPages), 65536)),
+		Timeout:     time.Duration(envInt64("RUN_WASM_TIMEOUT_MS", defaultWasmTimeoutMS)) * time.Millisecond,
+		MaxOutput:   int(envInt64("RUN_WASM_MAX_OUTPUT_BYTES", defaultWasmOutputBytes)),
+	}
+}
+
+// runDirect assembles and runs WAT, or runs a wasm module, in process with
+// the run configuration of the request, see requestRunConfig; by default
+// dir is mounted read-only as the module's root directory. files are the
+// candidates under dir, relative and slash-separated: ?file= picks one,
+// otherwise the first with the language's extension is used. A wasm module
+// may instead be POSTed as the request body.
+func (s *Server) runDirect(w http.ResponseWriter, r *http.Request, lang, dir string, project *projects.Project, files []string) {
+	ctx := r.Context()
+	start := time.Now()
+	config, ok := s.requestRunConfig(w, r, project)
+	if !ok {
+		return
+	}
+	cfg, err := sandboxConfig(config, dir)
+	if err != nil {
+		s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	var bin []byte
+	if lang == langWasm && r.Method == http.MethodPost && r.ContentLength != 0 && !isJSON(r) {
+		bin, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxFileSizes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.jsonResponse(w, http.StatusRequestEntityTooLarge, FileResponse{
+				Success: false,
+				Message: "Module is larger than " + strconv.Itoa(maxFileSizes) + " bytes",
+			})
+			return
+		}
+		if err != nil {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: "Error reading request body",
+			})
+			return
+		}
+	} else {
+		name, ok := pickSource(r.URL.Query().Get("file"), "."+lang, files)
+		if !ok {
+			s.jsonResponse(w, http.StatusNotFound, FileResponse{
+				Success: false,
+				Message: "No ." + lang + " file to run",
+			})
+			return
+		}
+		src, err := readProjectFile(dir, name)
+		metrics.ObserveFile("read", err)
+		if errors.Is(err, errNotRegular) || errors.Is(err, errOutsideProject) {
+			s.jsonResponse(w, http.StatusBadRequest, FileResponse{
+				Success: false,
+				Message: name + " is not a regular file in the project",
+			})
+			return
+		}
+		if err != nil {
+			s.logger.ErrorContext(ctx, "error reading source", "file", name, "error", err)
+			s.jsonResponse(w, http.StatusInternalServerError, FileResponse{
+				Success: false,
+				Message: "Error reading " + name,
+			})
+			return
+		}
+		bin = src
+		if lang == langWAT {
+			if bin, err = wasm.Assemble(string(src)); err != nil {
+				s.jsonResponse(w, http.StatusUnprocessableEntity, RunResponse{
+					Success:     false,
+					Message:     name + ":" + err.Error(),
+					Diagnostics: syntaxDiagnostics(name, err),
+				})
+				return
+			}
+		}
+	}
+
+	result, err := sandbox.Run(ctx, bin, cfg)
+	var exit *sandbox.ExitError
+	exitCode := 0
+	if errors.As(err, &exit) {
+		exitCode = exit.ExitCode()
+		if config.IsSuccess(exit.Code) {
+			err = nil
+		}
+	}
+	metrics.ObserveExec(lang, start, err)
+	s.usage.Record(ctx, accounting.RunFor(r, jwtSecret(), project.ID, lang, metrics.ExitCode(err)), accounting.Stats{
+		MemoryPeakBytes: result.MemoryBytes,
+		Wall:            result.Duration,
+		OutputBytes:     int64(len(result.Output)),
+	})
+
+	response := RunResponse{
+		Success:   err == nil,
+		ExitCode:  exitCode,
+		Content:   result.Output,
+		Results:   result.Results,
+		FuelUsed:  result.FuelUsed,
+		Truncated: result.Truncated,
+	}
+	if errors.Is(err, sandbox.ErrInvalid) {
+		response.Message = err.Error()
+		s.jsonResponse(w, http.StatusUnprocessableEntity, response)
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error running module", "language", lang, "project", project.ID, "error", err)
+		response.Message = err.Error()
+		s.jsonResponse(w, http.StatusInternalServerError, response)
+		return
+	}
+	s.jsonResponse(w, http.StatusOK, response)
+}
+
+// syntaxDiagnostics reports an error assembling the WAT file name as a
+// diagnostic, when it has a position
+func syntaxDiagnostics(name string, err error) []diagnostics.Diagnostic {
+	var syntax *wasm.SyntaxError
+	if !errors.As(err, &syntax) {
+		return nil
+	}
+	return []diagnostics.Diagnostic{{
+		File:     name,
+		Line:     syntax.Line,
+		Column:   syntax.Col,
+		Severity: diagnostics.Error,
+		Message:  syntax.Msg,
+	}}
+}
+
+// pickSource returns file if it is one of files, or else the first of files
+// with extension ext
+func pickSource(file, ext string, files []string) (string, bool) {
+	if file != "" {
+		file = path.Clean(strings.TrimPrefix(file, "/"))
+		for _, f := range files {
+			if f == file {
+				return f, true
+			}
+		}
+		return "", false
+	}
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	for _, f := range sorted {
+		if strings.HasSuffix(f, ext) {
+			return f, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"xxx/projects"
+	"xxx/watch"
+
+	httpserver "muhammadyasir-dev/cmd/server"
+)
+
+// defaultWatchDebounceMS is how long a directory must be quiet before its
+// changes are sent
+const defaultWatchDebounceMS = 200
+
+// WatchMessage is a batch of file changes sent to a watching client
+type WatchMessage struct {
+	Events []watch.Event `json:"events"`
+}
+
+// skipWatched leaves the git repository and the project metadata out of
+// file change events
+func skipWatched(rel string) bool {
+	return rel == projects.MetaFile || rel == ".git" || strings.HasPrefix(rel, ".git/")
+}
+
+// watchHandler sends the changes to the project's files over a WebSocket
+func (s *Server) watchHandler(w http.ResponseWriter, r *http.Request) {
+	project, ok := s.loadProject(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	dir, _ := s.projects.Dir(project.ID)
+	s.watchDir(w, r, dir)
+}
+
+// watchFilesHandler sends the changes to the legacy files directory
+func (s *Server) watchFilesHandler(w http.ResponseWriter, r *http.Request) {
+	s.watchDir(w, r, fileDir)
+}
+
+// watchDir sends a WatchMessage for each debounced batch of changes under
+// dir until the client goes away. A batch with an overflow event means
+// changes were lost and the client should list the files again.
+func (s *Server) watchDir(w http.ResponseWriter, r *http.Request, dir string) {
+	ctx := r.Context()
+	events, stop, err := s.watchers.Subscribe(dir)
+	if errors.Is(err, watch.ErrTooManyDirs) {
+		s.logger.WarnContext(ctx, "not watching directory", "dir", dir, "error", err)
+		s.jsonResponse(w, http.StatusServiceUnavailable, FileResponse{
+			Success: false,
+			Message: "Too many projects are being watched, try again later",
+		})
+		return
+	}
+	if err != nil {
+		s.logger.ErrorContext(ctx, "error watching directory", "dir", dir, "error", err)
+		s.jsonResponse(w, http.StatusServiceUnavailable, FileResponse{
+			Success: false,
+			Message: "Error watching files",
+		})
+		return
+	}
+	defer stop()
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has answered the request
+		s.logger.WarnContext(ctx, "watch not opened", "dir", dir, "error", err)
+		return
+	}
+	defer httpserver.Track(ctx)()
+	defer ws.Close()
+	// the client sends nothing; reading notices when it leaves
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case batch := <-events:
+			if err := ws.WriteJSON(WatchMessage{Events: batch}); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-httpserver.ShuttingDown(ctx):
+			return
+		}
+	}
+}
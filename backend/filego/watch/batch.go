@@ -0,0 +1,80 @@
+package watch
+
+// batch coalesces the events of one debounce window into at most one per
+// path, in the order paths first changed
+type batch struct {
+	ops   map[string]string
+	order []string
+	// renamed maps paths created by a rename to their old path
+	renamed map[string]string
+	// moved is the path just renamed away; the kernel reports its new name,
+	// if inside the directory, as the very next create
+	moved    string
+	overflow bool
+}
+
+func newBatch() *batch {
+	return &batch{ops: map[string]string{}, renamed: map[string]string{}}
+}
+
+// add records op on path, merged with what already happened to it
+func (b *batch) add(path, op string) {
+	if b.moved != "" && op == Create {
+		b.renamed[path] = b.moved
+	}
+	b.moved = ""
+
+	prev, seen := b.ops[path]
+	if !seen {
+		b.order = append(b.order, path)
+	}
+	switch {
+	case !seen || prev == "":
+		b.ops[path] = op
+	case prev == Create && op == Delete:
+		// came and went within the window
+		b.ops[path] = ""
+	case prev == Create:
+		// still new, whatever was written
+	case prev == Delete && op == Create:
+		// replaced, as editors save
+		b.ops[path] = Modify
+	default:
+		b.ops[path] = op
+	}
+}
+
+// movedAway records path renamed, to a name the next create gives
+func (b *batch) movedAway(path string) {
+	b.add(path, Delete)
+	b.moved = path
+}
+
+// events returns the batch's events
+func (b *batch) events() []Event {
+	if b.overflow || len(b.order) > maxBatch {
+		return []Event{{Op: Overflow}}
+	}
+	// a rename is reported where its new path is, if both ends still stand
+	renamedAway := map[string]bool{}
+	for to, from := range b.renamed {
+		if b.ops[to] == Create && b.ops[from] == Delete {
+			renamedAway[from] = true
+		} else {
+			delete(b.renamed, to)
+		}
+	}
+
+	var events []Event
+	for _, path := range b.order {
+		op := b.ops[path]
+		switch {
+		case op == "", renamedAway[path]:
+		case b.renamed[path] != "":
+			events = append(events, Event{Op: Rename, Path: path, OldPath: b.renamed[path]})
+		default:
+			events = append(events, Event{Op: op, Path: path})
+		}
+	}
+	return events
+}
@@ -0,0 +1,289 @@
+// Package watch reports changes to the files under a directory, such as
+// those a build or a terminal command makes, in debounced batches. One
+// recursive watch per directory is shared by all its subscribers.
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Operations of an event
+const (
+	Create = "create"
+	Modify = "modify"
+	Delete = "delete"
+	Rename = "rename"
+	// Overflow means events were lost, and subscribers should list the
+	// directory again
+	Overflow = "overflow"
+)
+
+// maxBatch bounds the events of one batch; larger batches, such as from a
+// build writing a whole tree, are sent as an overflow
+const maxBatch = 1000
+
+// Defaults of a Hub's limits
+const (
+	defaultMaxWaitDebounces = 10
+	defaultMaxDirs          = 128
+)
+
+// ErrTooManyDirs is returned when subscribing to a new directory would watch
+// more than the hub's MaxDirs
+var ErrTooManyDirs = errors.New("too many directories are being watched")
+
+// Event is a change to the file or directory at Path, slash-separated and
+// relative to the watched directory. Renames within the directory carry the
+// old path; renames in or out of it are a create or a delete.
+type Event struct {
+	Op      string `json:"op"`
+	Path    string `json:"path,omitempty"`
+	OldPath string `json:"old_path,omitempty"`
+}
+
+// Hub shares the watches of directories between subscribers
+type Hub struct {
+	// MaxWait bounds how long a batch waits for its directory to be quiet,
+	// so one that never is still gets its changes sent
+	MaxWait time.Duration
+	// MaxDirs bounds the directories watched at once, each of which holds
+	// an inotify instance; zero means no limit
+	MaxDirs int
+
+	debounce time.Duration
+	skip     func(rel string) bool
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	roots map[string]*root
+}
+
+// NewHub returns a hub batching the events of each directory until it has
+// been quiet for debounce, or for at most ten times that since the batch's
+// first event. skip, which may be nil, names the paths neither reported nor
+// watched, such as a git repository.
+func NewHub(debounce time.Duration, skip func(rel string) bool, logger *slog.Logger) *Hub {
+	if skip == nil {
+		skip = func(string) bool { return false }
+	}
+	return &Hub{
+		MaxWait:  defaultMaxWaitDebounces * debounce,
+		MaxDirs:  defaultMaxDirs,
+		debounce: debounce,
+		skip:     skip,
+		logger:   logger,
+		roots:    map[string]*root{},
+	}
+}
+
+// Subscribe returns the batches of events under dir until stop is called
+func (h *Hub) Subscribe(dir string) (events <-chan []Event, stop func(), err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.roots[dir]
+	if !ok {
+		if h.MaxDirs > 0 && len(h.roots) >= h.MaxDirs {
+			return nil, nil, ErrTooManyDirs
+		}
+		if r, err = h.watch(dir); err != nil {
+			return nil, nil, err
+		}
+		h.roots[dir] = r
+	}
+	sub := &subscriber{ch: make(chan []Event, 16)}
+	r.mu.Lock()
+	r.subs[sub] = true
+	r.mu.Unlock()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			r.mu.Lock()
+			delete(r.subs, sub)
+			last := len(r.subs) == 0
+			r.mu.Unlock()
+			if last {
+				delete(h.roots, dir)
+				r.watcher.Close()
+			}
+		})
+	}
+	return sub.ch, stop, nil
+}
+
+// subscriber receives the batches of a root. A subscriber too slow to take
+// a batch loses it and gets an overflow with the next.
+type subscriber struct {
+	ch   chan []Event
+	lost bool
+}
+
+// root is the watch of one directory
+type root struct {
+	dir     string
+	hub     *Hub
+	watcher *fsnotify.Watcher
+
+	mu   sync.Mutex
+	subs map[*subscriber]bool
+}
+
+// watch starts watching dir and everything under it
+func (h *Hub) watch(dir string) (*root, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	r := &root{dir: dir, hub: h, watcher: watcher, subs: map[*subscriber]bool{}}
+	if err := r.addTree(dir, nil); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go r.run()
+	return r, nil
+}
+
+// addTree watches dir and the directories under it. Files found are passed
+// to found, for directories created since the last batch whose content was
+// written before the watch began.
+func (r *root) addTree(dir string, found func(rel string)) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// gone already, or unreadable
+			return nil
+		}
+		rel := r.rel(path)
+		if rel != "" && r.hub.skip(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path != dir && found != nil {
+			found(rel)
+		}
+		if d.IsDir() {
+			if err := r.watcher.Add(path); err != nil {
+				if path == dir {
+					return err
+				}
+				r.hub.logger.Warn("cannot watch directory", "dir", path, "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *root) rel(path string) string {
+	rel, err := filepath.Rel(r.dir, path)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// run batches the watcher's events until it is closed
+func (r *root) run() {
+	var (
+		batch = newBatch()
+		timer = time.NewTimer(0)
+		armed bool
+		first time.Time // of the batch's first event
+	)
+	<-timer.C
+	arm := func() {
+		if !armed {
+			first = time.Now()
+		}
+		wait := r.hub.debounce
+		if left := r.hub.MaxWait - time.Since(first); r.hub.MaxWait > 0 && left < wait {
+			wait = max(left, 0)
+		}
+		timer.Reset(wait)
+		armed = true
+	}
+	for {
+		select {
+		case e, ok := <-r.watcher.Events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			rel := r.rel(e.Name)
+			if rel == "" || r.hub.skip(rel) {
+				continue
+			}
+			switch {
+			case e.Has(fsnotify.Create):
+				batch.add(rel, Create)
+				if info, err := os.Lstat(e.Name); err == nil && info.IsDir() {
+					r.addTree(e.Name, func(rel string) { batch.add(rel, Create) })
+				}
+			case e.Has(fsnotify.Write):
+				batch.add(rel, Modify)
+			case e.Has(fsnotify.Remove):
+				batch.add(rel, Delete)
+			case e.Has(fsnotify.Rename):
+				batch.movedAway(rel)
+			default:
+				continue
+			}
+			arm()
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				batch.overflow = true
+				arm()
+				continue
+			}
+			r.hub.logger.Warn("file watch error", "dir", r.dir, "error", err)
+		case <-timer.C:
+			if armed {
+				armed = false
+				r.send(batch.events())
+				batch = newBatch()
+			}
+		}
+	}
+}
+
+// send gives events to every subscriber that can take them
+func (r *root) send(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for sub := range r.subs {
+		out := events
+		if sub.lost {
+			out = []Event{{Op: Overflow}}
+		}
+		select {
+		case sub.ch <- out:
+			sub.lost = false
+		default:
+			sub.lost = true
+		}
+	}
+}
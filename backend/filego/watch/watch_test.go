@@ -0,0 +1,125 @@
+package watch
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	b := newBatch()
+	b.add("new.txt", Create)
+	b.add("new.txt", Modify)
+	b.add("tmp", Create)
+	b.add("tmp", Delete)
+	b.add("main.go", Delete)
+	b.add("main.go", Create)
+	b.movedAway("old.rs")
+	b.add("renamed.rs", Create)
+	b.add("gone.c", Delete)
+	want := []Event{
+		{Op: Create, Path: "new.txt"},
+		{Op: Modify, Path: "main.go"},
+		{Op: Rename, Path: "renamed.rs", OldPath: "old.rs"},
+		{Op: Delete, Path: "gone.c"},
+	}
+	if got := b.events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestHub(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	hub := NewHub(50*time.Millisecond, func(rel string) bool { return rel == ".git" }, slog.Default())
+	events, stop, err := hub.Subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	os.Mkdir(filepath.Join(dir, ".git"), 0755)
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("x"), 0644)
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.WriteFile(filepath.Join(dir, "src", "lib.rs"), []byte("fn x() {}"), 0644)
+	os.Rename(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"))
+
+	got := map[string]Event{}
+	deadline := time.After(5 * time.Second)
+	for len(got) < 3 {
+		select {
+		case batch := <-events:
+			for _, e := range batch {
+				got[e.Path] = e
+			}
+		case <-deadline:
+			t.Fatalf("timed out with %+v", got)
+		}
+	}
+	if got["src"].Op != Create || got["src/lib.rs"].Op != Create || got["b.txt"] != (Event{Op: Rename, Path: "b.txt", OldPath: "a.txt"}) {
+		t.Errorf("got %+v", got)
+	}
+	if _, ok := got[".git"]; ok {
+		t.Errorf("reported a skipped path: %+v", got)
+	}
+}
+
+func TestHubMaxWait(t *testing.T) {
+	dir := t.TempDir()
+	hub := NewHub(100*time.Millisecond, nil, slog.Default())
+	hub.MaxWait = 300 * time.Millisecond
+	events, stop, err := hub.Subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// writes more often than the debounce never leave the directory quiet
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-ticker.C:
+				os.WriteFile(filepath.Join(dir, "busy.txt"), []byte{byte(i)}, 0644)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	select {
+	case batch := <-events:
+		if len(batch) == 0 || batch[0].Path != "busy.txt" {
+			t.Errorf("got %+v", batch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no batch while the directory kept changing")
+	}
+}
+
+func TestHubMaxDirs(t *testing.T) {
+	hub := NewHub(50*time.Millisecond, nil, slog.Default())
+	hub.MaxDirs = 1
+	dir := t.TempDir()
+	_, stop, err := hub.Subscribe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// more subscribers of a watched directory share its watch
+	_, stopAgain, err := hub.Subscribe(dir)
+	if err != nil {
+		t.Fatalf("subscribing to a watched directory: %v", err)
+	}
+	defer stopAgain()
+	if _, _, err := hub.Subscribe(t.TempDir()); !errors.Is(err, ErrTooManyDirs) {
+		t.Fatalf("got %v, want ErrTooManyDirs", err)
+	}
+	stop()
+}
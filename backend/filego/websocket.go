@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultIDEOrigins is where the IDE is served in development
+const defaultIDEOrigins = "http://localhost:5173"
+
+// upgrader opens the debug and watch WebSockets. Browsers send cookies with
+// WebSocket handshakes from any site, so only the IDE's pages may open them.
+var upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
+
+// checkOrigin accepts handshakes from this server's own origin and from the
+// origins listed in IDE_ORIGINS, comma separated. Clients other than
+// browsers send no Origin and are let through, since they can't be made to
+// use someone else's cookies.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	allowed := os.Getenv("IDE_ORIGINS")
+	if allowed == "" {
+		allowed = defaultIDEOrigins
+	}
+	for _, o := range strings.Split(allowed, ",") {
+		if o = strings.TrimSpace(o); o != "" && strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,4 +1,4 @@
-import React, { useEffect, useState } from "react";
+import React, { useCallback, useEffect, useRef, useState } from "react";
 import { fileserver } from "../libs/Url";
 import { colors } from "@mui/material";
 const styles = {
@@ -84,27 +84,77 @@ const FileTree: React.FC = () => {
   const [fileContent, setFileContent] = useState<string>("");
   const [isLoading, setIsLoading] = useState<boolean>(false);
   const [error, setError] = useState<string | null>(null);
+  // binary files come base64 encoded and are downloaded, not edited
+  const [isBinary, setIsBinary] = useState<boolean>(false);
+
+  // What the editor last saved, to tell its own writes from others'
+  const lastSaved = useRef<string | null>(null);
+  const selected = useRef<string | null>(null);
+  selected.current = selectedFile;
+
+  const fetchFiles = useCallback(async () => {
+    try {
+      const response = await fetch(
+        `${fileserver}/list-files?project=myproject`,
+      );
+      if (!response.ok) {
+        throw new Error("Failed to fetch files");
+      }
+      const fileList = await response.json();
+      setFiles(fileList);
+    } catch (error) {
+      setError("No files availible");
+      console.error("Error:", error);
+    }
+  }, []);
 
   // Fetch the list of files when the component mounts
   useEffect(() => {
-    const fetchFiles = async () => {
+    fetchFiles();
+  }, [fetchFiles]);
+
+  // Follow changes made outside the editor, such as by builds
+  useEffect(() => {
+    const reloadOpenFile = async (fileName: string) => {
       try {
         const response = await fetch(
-          `${fileserver}/list-files?project=myproject`,
+          `${fileserver}/files/${fileName}?project=myproject`,
         );
-        if (!response.ok) {
-          throw new Error("Failed to fetch files");
+        if (!response.ok) return;
+        const result = await response.json();
+        const content = result.content || "";
+        if (selected.current === fileName && content !== lastSaved.current) {
+          setIsBinary(result.encoding === "base64");
+          setFileContent(content);
+          lastSaved.current = content;
         }
-        const fileList = await response.json();
-        setFiles(fileList);
       } catch (error) {
-        setError("No files availible");
         console.error("Error:", error);
       }
     };
 
-    fetchFiles();
-  }, []);
+    const socket = new WebSocket(
+      `${fileserver.replace(/^http/, "ws")}/watch`,
+    );
+    socket.onmessage = (message) => {
+      const { events } = JSON.parse(message.data) as {
+        events: { op: string; path?: string; old_path?: string }[];
+      };
+      fetchFiles();
+      const open = selected.current;
+      for (const event of events) {
+        if (event.op === "rename" && event.old_path === open) {
+          setSelectedFile(event.path ?? null);
+        } else if (event.op === "delete" && event.path === open) {
+          setSelectedFile(null);
+        } else if (open && (event.op === "overflow" || event.path === open)) {
+          reloadOpenFile(open);
+        }
+      }
+    };
+    socket.onerror = (error) => console.error("Error:", error);
+    return () => socket.close();
+  }, [fetchFiles]);
 
   // Load the content of the selected file
   useEffect(() => {
@@ -122,7 +172,9 @@ const FileTree: React.FC = () => {
           throw new Error(`Failed to fetch ${selectedFile}`);
         }
         const result = await response.json();
+        setIsBinary(result.encoding === "base64");
         setFileContent(result.content || "");
+        lastSaved.current = result.content || "";
       } catch (error) {
         setError("Failed to load file content");
         console.error("Error:", error);
@@ -144,6 +196,7 @@ const FileTree: React.FC = () => {
   const handleChange = async (e: React.ChangeEvent<HTMLTextAreaElement>) => {
     const newContent = e.target.value;
     setFileContent(newContent);
+    lastSaved.current = newContent;
 
     try {
       const response = await fetch(
@@ -188,13 +241,7 @@ const FileTree: React.FC = () => {
       }
 
       // Refresh the file list after creating a new file
-      const listResponse = await fetch(
-        `${fileserver}/list-files?project=myproject`,
-      );
-      if (listResponse.ok) {
-        const fileList = await listResponse.json();
-        setFiles(fileList);
-      }
+      await fetchFiles();
     } catch (error) {
       setError("Failed to create file");
       console.error("Error:", error);
@@ -236,12 +283,22 @@ const FileTree: React.FC = () => {
           ? (
             <div>
               <h3 style={styles.title}>{selectedFile}</h3>
-              <textarea
-                value={fileContent}
-                onChange={handleChange}
-                style={styles.textarea}
-                placeholder=""
-              />
+              {isBinary
+                ? (
+                  <a
+                    href={`${fileserver}/files/${selectedFile}?raw=1&download=1`}
+                  >
+                    Download
+                  </a>
+                )
+                : (
+                  <textarea
+                    value={fileContent}
+                    onChange={handleChange}
+                    style={styles.textarea}
+                    placeholder=""
+                  />
+                )}
             </div>
           )
           : (
@@ -1,7 +1,6 @@
 import React from "react";
 import { AppBar, Box, Button, Toolbar } from "@mui/material";
 import { styled } from "@mui/system";
-import { remoteserverurl } from "./../libs/Url";
 
 const IframeContainer = styled(Box)(({ theme }) => ({
   width: "100vw",
@@ -35,7 +34,14 @@ const Dot = styled(Box)(({ color }) => ({
   backgroundColor: color,
 }));
 
-const Iframe = () => {
+type IframeProps = {
+  // preview URL returned by a browser build (/runcode?project=<id>&target=browser),
+  // served from its own origin; defaults to ?preview= in the page URL
+  src?: string;
+};
+
+const Iframe = ({ src }: IframeProps) => {
+  const preview = src ?? new URLSearchParams(window.location.search).get("preview");
   const handleGoBack = () => {
   };
 
@@ -53,10 +59,16 @@ const Iframe = () => {
           </Button>
         </Toolbar>
       </Header>
-      <iframe
-        src={"remoteserverurl"}
-        style={{ flex: 1, width: "100%", border: "none" }}
-      />
+      {preview ? (
+        <iframe
+          src={preview}
+          title="Browser preview"
+          allow="cross-origin-isolated"
+          style={{ flex: 1, width: "100%", border: "none" }}
+        />
+      ) : (
+        <Box sx={{ flex: 1, p: 2 }}>Build a project for the browser to preview it.</Box>
+      )}
     </IframeContainer>
   );
 };